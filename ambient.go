@@ -0,0 +1,256 @@
+package player
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/pkg/errors"
+)
+
+var errEmptyAmbient = errors.New("ambient source has no frames")
+
+// default ratio of the ambient gain applied while items are playing, about -12dB
+const defaultAmbientDuck = 0.25
+
+// SetAmbient plays a looping background source under the queue, e.g. a lo-fi bed.
+// The ambient source is mixed into every item at gain times the AmbientDuck ratio
+// and is played by itself at gain while the player is waiting for the next item, using the device of the most recent item.
+// It stops once the player goes idle, so the IdleFunc can disconnect the device, and resumes with the next item.
+// The ambient source is reopened every time it ends and is removed if it fails to open.
+// Mixing requires that the ambient source and the queued items produce interleaved 16-bit little-endian PCM
+// with the same sample rate and channels, e.g. sources from the mp3 package,
+// so the ambient source is only mixed into items whose source and device are PCM, see PCMSource and DeviceInfo.
+// SetAmbient returns ErrNotPCM if the device of the most recent item does not take PCM.
+// Pass a nil openSrc to remove the ambient source.
+func (p *Player) SetAmbient(openSrc SourceOpenerFunc, gain float64) error {
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	p.ctrlMu.Lock()
+	notPCM := p.deviceOpened && !p.devicePCM
+	p.ctrlMu.Unlock()
+	if openSrc != nil && notPCM {
+		return ErrNotPCM
+	}
+	p.ambient.set(openSrc, gain)
+	return nil
+}
+
+// ambient is a background loop mixed under the queue.
+type ambient struct {
+	mu      sync.Mutex
+	openSrc SourceOpenerFunc
+	gain    float64
+	duck    float64
+	src     Source
+	// samples read from src that have not been mixed or played yet
+	buf      []byte
+	frameLen int
+	frameDur time.Duration
+	// decoded samples reused by every mix
+	samples []int16
+	changed chan struct{}
+	clock   clock.Clock
+
+	// only touched by the playback goroutine
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAmbient(duck float64, clk clock.Clock) *ambient {
+	return &ambient{
+		duck:    duck,
+		clock:   clk,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (a *ambient) set(openSrc SourceOpenerFunc, gain float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closeSource()
+	a.openSrc = openSrc
+	a.gain = gain
+	select {
+	case a.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (a *ambient) closeSource() {
+	if c, ok := a.src.(io.Closer); ok {
+		c.Close()
+	}
+	a.src = nil
+	a.buf = nil
+	a.frameLen = 0
+	a.frameDur = 0
+}
+
+// idle plays the ambient source by itself to dst until busy is called, if dst takes PCM.
+func (a *ambient) idle(dst io.Writer) {
+	if !pcmDevice(dst) || a.stop != nil {
+		return
+	}
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	go a.loop(dst, a.stop, a.done)
+}
+
+// busy stops playing the ambient source by itself and waits until it is no longer writing to the device.
+func (a *ambient) busy() {
+	if a.stop == nil {
+		return
+	}
+	close(a.stop)
+	<-a.done
+	a.stop = nil
+	a.done = nil
+}
+
+func (a *ambient) loop(dst io.Writer, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	var pc *pacer
+	defer func() {
+		if pc != nil {
+			pc.stop()
+		}
+	}()
+
+	for {
+		frame, frameDur := a.next()
+		if frame == nil {
+			select {
+			case <-stop:
+				return
+			case <-a.changed:
+				continue
+			}
+		}
+
+		// the ambient source is always played in real time no matter what device it is played to
+		if pc == nil || pc.frameDur != frameDur {
+			if pc != nil {
+				pc.stop()
+			}
+			pc = newPacer(true, frameDur, a.clock)
+		}
+		select {
+		case <-stop:
+			FreeFrame(frame)
+			return
+		case <-pc.C():
+		}
+
+		_, err := dst.Write(frame)
+		FreeFrame(frame)
+		if err != nil {
+			return
+		}
+		pc.next()
+	}
+}
+
+// next reads the next frame of the ambient source at full gain.
+// next returns a nil frame if there is no ambient source.
+func (a *ambient) next() ([]byte, time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.openSrc == nil {
+		return nil, 0
+	}
+	if a.frameLen == 0 {
+		if err := a.fill(1); err != nil {
+			a.remove()
+			return nil, 0
+		}
+	}
+	frame := AllocFrame(a.frameLen)
+	for i := range frame {
+		frame[i] = 0
+	}
+	n, err := a.mix(frame, a.gain)
+	if err != nil && n == 0 {
+		FreeFrame(frame)
+		a.remove()
+		return nil, 0
+	}
+	return frame, a.frameDur
+}
+
+// mixDucked mixes the ducked ambient source into a frame of the playing item.
+func (a *ambient) mixDucked(frame []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.openSrc == nil {
+		return
+	}
+	if _, err := a.mix(frame, a.gain*a.duck); err != nil {
+		a.remove()
+	}
+}
+
+func (a *ambient) active() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.openSrc != nil
+}
+
+// mix adds samples of the ambient source into the frame, returning how many bytes were mixed.
+func (a *ambient) mix(frame []byte, gain float64) (int, error) {
+	err := a.fill(len(frame))
+	n := len(frame)
+	if n > len(a.buf) {
+		n = len(a.buf)
+	}
+	a.samples = mixPCM(frame[:n], a.buf[:n], gain, a.samples)
+	a.buf = a.buf[n:]
+	return n, err
+}
+
+// fill reads from the ambient source until at least n bytes are buffered, reopening the source when it ends.
+func (a *ambient) fill(n int) error {
+	opened := false
+	for len(a.buf) < n {
+		if a.src == nil {
+			// a source that ends without any frames would reopen forever
+			if opened {
+				return errEmptyAmbient
+			}
+			src, err := a.openSrc()
+			if err != nil {
+				return errors.Wrap(err, "failed to open ambient source")
+			}
+			a.src = src
+			opened = true
+		}
+		frame, err := a.src.ReadFrame()
+		if len(frame) > 0 {
+			opened = false
+			if a.frameLen == 0 {
+				a.frameLen = len(frame)
+				a.frameDur = a.src.FrameDuration()
+			}
+			a.buf = append(a.buf, frame...)
+		}
+		if isPooled(a.src) {
+			FreeFrame(frame)
+		}
+		if err != nil {
+			if c, ok := a.src.(io.Closer); ok {
+				c.Close()
+			}
+			a.src = nil
+		}
+	}
+	return nil
+}
+
+func (a *ambient) remove() {
+	a.closeSource()
+	a.openSrc = nil
+}
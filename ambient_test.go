@@ -0,0 +1,212 @@
+package player_test
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pcmSource produces n frames of two identical 16-bit samples
+type pcmSource struct {
+	sample int16
+	n      int
+}
+
+func (s *pcmSource) ReadFrame() ([]byte, error) {
+	if s.n == 0 {
+		return nil, io.EOF
+	}
+	s.n--
+	frame := make([]byte, 4)
+	binary.LittleEndian.PutUint16(frame, uint16(s.sample))
+	binary.LittleEndian.PutUint16(frame[2:], uint16(s.sample))
+	return frame, nil
+}
+
+func (s *pcmSource) FrameDuration() time.Duration {
+	return 1 * time.Millisecond
+}
+
+// sampleRecorder records the first sample of each frame written to it, and passes it on to writes if it is set
+type sampleRecorder struct {
+	mu      sync.Mutex
+	samples []int16
+	writes  chan int16
+}
+
+func (w *sampleRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sample := int16(binary.LittleEndian.Uint16(p))
+	w.samples = append(w.samples, sample)
+	if w.writes != nil {
+		w.writes <- sample
+	}
+	return len(p), nil
+}
+
+// DeviceInfo reports a PCM device that plays in real time, so the ambient source and overlays are mixed into it.
+func (w *sampleRecorder) DeviceInfo() player.DeviceCaps {
+	return player.DeviceCaps{PCM: true, Realtime: true}
+}
+
+func (w *sampleRecorder) Samples() []int16 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]int16(nil), w.samples...)
+}
+
+func TestAmbient(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	idle := make(chan struct{}, 1)
+	p := player.New(player.Clock(clk), player.AmbientDuck(0.5), player.IdleFunc(func() { idle <- struct{}{} }, 10))
+	defer p.Close()
+	<-idle
+
+	err := p.SetAmbient(func() (player.Source, error) {
+		return &pcmSource{sample: 1000, n: 3}, nil
+	}, 1)
+	require.NoError(t, err)
+
+	dst := &sampleRecorder{writes: make(chan int16, 100)}
+	play := func(sample int16, n int) {
+		end := make(chan struct{})
+		_, err := p.Enqueue("",
+			func() (player.Source, error) {
+				return &pcmSource{sample: sample, n: n}, nil
+			},
+			func() (io.Writer, error) {
+				return dst, nil
+			},
+			player.OnEnd(func(time.Duration, error) { close(end) }),
+		)
+		require.NoError(t, err)
+		<-end
+	}
+	next := func() int16 {
+		select {
+		case sample := <-dst.writes:
+			return sample
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "expected a frame to be written")
+			return 0
+		}
+	}
+
+	play(2000, 5)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, int16(2500), next(), "expected ducked ambient source to be mixed into the item")
+	}
+
+	// ambient source plays by itself in real time while waiting for the next item
+	assert.Equal(t, int16(1000), next(), "expected ambient source to play after the item ended")
+	// wait for the ambient source's next frame and the idle timeout to be scheduled
+	clk.BlockUntil(2)
+	for i := 0; i < 9; i++ {
+		clk.Advance(time.Millisecond)
+		assert.Equal(t, int16(1000), next(), "expected one frame of the ambient source every frame duration")
+	}
+	select {
+	case <-idle:
+		require.FailNow(t, "expected the player to go idle only after its timeout")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the player to go idle after its timeout")
+	}
+
+	// and stops once the player is idle, so the device can disconnect
+	for len(dst.writes) > 0 {
+		<-dst.writes
+	}
+	clk.Advance(5 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, dst.writes, "expected ambient source to stop while the player is idle")
+
+	// until the next item plays
+	play(2000, 3)
+	for {
+		sample := next()
+		if sample == 1000 {
+			continue
+		}
+		assert.Equal(t, int16(2500), sample, "expected ambient source to stop playing by itself for the item")
+		assert.Equal(t, int16(2500), next())
+		assert.Equal(t, int16(2500), next())
+		break
+	}
+	assert.Equal(t, int16(1000), next(), "expected ambient source to play after the next item")
+
+	// items only play once the ambient source is no longer writing to the device,
+	// so nothing but the ambient source that was removed can be written before the first item
+	p.SetAmbient(nil, 0)
+	clk.Advance(5 * time.Millisecond)
+	play(3000, 1)
+	clk.Advance(5 * time.Millisecond)
+	play(3000, 1)
+	var after []int16
+	for len(dst.writes) > 0 {
+		after = append(after, <-dst.writes)
+	}
+	for len(after) > 0 && after[0] == 1000 {
+		after = after[1:]
+	}
+	assert.Equal(t, []int16{3000, 3000}, after, "expected ambient source to stop after it was removed")
+}
+
+// opusRecorder records frames written to a device that takes opus.
+type opusRecorder struct {
+	sampleRecorder
+}
+
+func (w *opusRecorder) DeviceInfo() player.DeviceCaps {
+	return player.DeviceCaps{Opus: true, Realtime: true}
+}
+
+func TestAmbientNotPCM(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := player.New(player.Clock(clk))
+	defer p.Close()
+
+	err := p.SetAmbient(func() (player.Source, error) {
+		return &pcmSource{sample: 1000, n: 100}, nil
+	}, 1)
+	require.NoError(t, err)
+
+	dst := &opusRecorder{sampleRecorder{writes: make(chan int16, 100)}}
+	end := make(chan struct{})
+	_, err = p.Enqueue("",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 2000, n: 3}, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	<-end
+
+	// frames written to an opus device are left alone, and the ambient source does not play by itself to it
+	clk.Advance(5 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []int16{2000, 2000, 2000}, dst.Samples(), "expected no ambient source mixed into frames for an opus device")
+
+	err = p.SetAmbient(func() (player.Source, error) {
+		return &pcmSource{sample: 1000, n: 100}, nil
+	}, 1)
+	assert.Equal(t, player.ErrNotPCM, err, "expected an ambient source to be refused for an opus device")
+	assert.NoError(t, p.SetAmbient(nil, 0), "expected the ambient source to be removable")
+}
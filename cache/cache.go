@@ -0,0 +1,300 @@
+// Package cache keeps the frames of played items on disk,
+// so items that play again are read back from a file instead of downloaded and encoded again.
+// Frames are cached as DCA1 files that the dca package reads, which also lets cached items seek.
+// Short clips can be kept in memory instead, see Clips.
+package cache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/dca"
+	"github.com/pkg/errors"
+)
+
+// opus frames are timed at 48kHz no matter the sample rate of the original audio
+const opusRate = 48000
+
+const ext = ".dca"
+
+// prefix of the files being written while their items play
+const partPrefix = "part-"
+
+type config struct {
+	MaxSize int64
+	TTL     time.Duration
+}
+
+// Option functions configure a Cache.
+// Pass Options to the New function.
+type Option func(*config)
+
+// MaxSize sets how many bytes the cached files may take up, 1GiB by default.
+// The files that were played least recently are removed first to make room.
+func MaxSize(n int64) Option {
+	return func(cfg *config) {
+		if n > 0 {
+			cfg.MaxSize = n
+		}
+	}
+}
+
+// TTL sets how long a cached file is kept after it was last played.
+// Files are kept until they are removed to make room by default.
+func TTL(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.TTL = d
+		}
+	}
+}
+
+// Cache is a directory of cached items.
+// Cache is safe to use from multiple goroutines, and by one process at a time.
+type Cache struct {
+	dir string
+	cfg config
+	// serializes eviction
+	mu sync.Mutex
+}
+
+// New produces a Cache of the items in dir, creating dir if it does not exist.
+// Items cached by an earlier Cache in the same dir are kept.
+func New(dir string, opts ...Option) (*Cache, error) {
+	cfg := config{
+		MaxSize: 1 << 30,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create cache directory")
+	}
+	c := &Cache{dir: dir, cfg: cfg}
+	// leftovers of items that were playing when the last process exited
+	parts, _ := filepath.Glob(filepath.Join(dir, partPrefix+"*"))
+	for _, part := range parts {
+		os.Remove(part)
+	}
+	c.evict()
+	return c, nil
+}
+
+// Opener returns a player.SourceOpenerFunc that plays the item cached by key, e.g. its URL,
+// or else plays the source opened by open and caches it once it has played to its end.
+// Items that are skipped or fail are not cached.
+// The cached item is the frames of the source as they were played, so open should not depend on when it is called.
+func (c *Cache) Opener(key string, open player.SourceOpenerFunc) player.SourceOpenerFunc {
+	return func() (player.Source, error) {
+		path := c.path(key)
+		if c.fresh(path) {
+			src, err := dca.Open(path)
+			if err == nil {
+				// the modification time is the last time the item played, for eviction
+				now := time.Now()
+				os.Chtimes(path, now, now)
+				return src, nil
+			}
+			os.Remove(path)
+		}
+		src, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return c.tee(path, src), nil
+	}
+}
+
+// Contains reports whether the item cached by key is on disk.
+func (c *Cache) Contains(key string) bool {
+	return c.fresh(c.path(key))
+}
+
+// Remove removes the item cached by key, e.g. because the media it was cached from changed.
+func (c *Cache) Remove(key string) error {
+	err := os.Remove(c.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+ext)
+}
+
+func (c *Cache) fresh(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return c.cfg.TTL == 0 || time.Since(info.ModTime()) < c.cfg.TTL
+}
+
+// evict removes expired files, and then the files played least recently until the rest fit in MaxSize.
+func (c *Cache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	var files []os.FileInfo
+	var size int64
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ext) {
+			continue
+		}
+		if c.cfg.TTL > 0 && time.Since(info.ModTime()) >= c.cfg.TTL {
+			os.Remove(filepath.Join(c.dir, info.Name()))
+			continue
+		}
+		files = append(files, info)
+		size += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, info := range files {
+		if size <= c.cfg.MaxSize {
+			return
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err == nil {
+			size -= info.Size()
+		}
+	}
+}
+
+// tee plays src while writing its frames to a part file that replaces the cached file once src ends.
+func (c *Cache) tee(path string, src player.Source) player.Source {
+	f, err := ioutil.TempFile(c.dir, partPrefix)
+	if err != nil {
+		return src
+	}
+	t := &teeSource{Source: src, c: c, path: path, f: f, w: bufio.NewWriter(f)}
+	if err := t.writeHeader(); err != nil {
+		t.abandon()
+	}
+	return t
+}
+
+// teeSource is a source whose frames are also written to a cache file.
+type teeSource struct {
+	player.Source
+	c    *Cache
+	path string
+	// part file being written, nil once it is abandoned or complete
+	f *os.File
+	w *bufio.Writer
+}
+
+func (t *teeSource) writeHeader() error {
+	var md dca.Metadata
+	md.DCA.Version = 1
+	md.DCA.Tool.Name = "discordvoice"
+	md.DCA.Tool.URL = "https://github.com/jeffreymkabot/discordvoice"
+	md.Opus.SampleRate = opusRate
+	md.Opus.FrameSize = int(t.FrameDuration() * opusRate / time.Second)
+	md.Opus.Channels = 2
+	data, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	if _, err := t.w.WriteString("DCA1"); err != nil {
+		return err
+	}
+	if err := binary.Write(t.w, binary.LittleEndian, int32(len(data))); err != nil {
+		return err
+	}
+	_, err = t.w.Write(data)
+	return err
+}
+
+// ReadFrame implements player.Source.
+func (t *teeSource) ReadFrame() ([]byte, error) {
+	frame, err := t.Source.ReadFrame()
+	if t.f == nil {
+		return frame, err
+	}
+	if len(frame) > 0 {
+		if werr := t.writeFrame(frame); werr != nil {
+			t.abandon()
+			return frame, err
+		}
+	}
+	switch {
+	case err == io.EOF:
+		t.commit()
+	case err != nil:
+		t.abandon()
+	}
+	return frame, err
+}
+
+func (t *teeSource) writeFrame(frame []byte) error {
+	if len(frame) > 1<<15-1 {
+		return errors.Errorf("frame of %v bytes is too long", len(frame))
+	}
+	if err := binary.Write(t.w, binary.LittleEndian, int16(len(frame))); err != nil {
+		return err
+	}
+	_, err := t.w.Write(frame)
+	return err
+}
+
+// PooledFrames implements player.PooledSource, the frames are copied to the file before they are returned.
+func (t *teeSource) PooledFrames() bool {
+	ps, ok := t.Source.(player.PooledSource)
+	return ok && ps.PooledFrames()
+}
+
+// Close implements player.SourceCloser, discarding the part file of a source that did not play to its end.
+func (t *teeSource) Close() error {
+	if t.f != nil {
+		t.abandon()
+	}
+	if c, ok := t.Source.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// commit replaces the cached file with the complete part file.
+func (t *teeSource) commit() {
+	f := t.f
+	t.f = nil
+	err := t.w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), t.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	go t.c.evict()
+}
+
+func (t *teeSource) abandon() {
+	t.f.Close()
+	os.Remove(t.f.Name())
+	t.f = nil
+}
+
+var _ player.SourceCloser = &teeSource{}
+var _ player.PooledSource = &teeSource{}
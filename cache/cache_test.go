@@ -0,0 +1,331 @@
+package cache_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/cache"
+	"github.com/jeffreymkabot/discordvoice/dca"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// frameSource plays its frames and then ends with err, or io.EOF if err is nil.
+type frameSource struct {
+	frames [][]byte
+	err    error
+	closed bool
+}
+
+func (s *frameSource) ReadFrame() ([]byte, error) {
+	if len(s.frames) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame, nil
+}
+
+func (s *frameSource) FrameDuration() time.Duration {
+	return 20 * time.Millisecond
+}
+
+func (s *frameSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+// frames are n frames of size bytes, each filled with its index.
+func frames(n int, size int) [][]byte {
+	f := make([][]byte, n)
+	for i := range f {
+		f[i] = bytes.Repeat([]byte{byte(i)}, size)
+	}
+	return f
+}
+
+// opener counts how many times it opens a source of the frames.
+type opener struct {
+	mu     sync.Mutex
+	frames [][]byte
+	opened int
+}
+
+func (o *opener) open() (player.Source, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.opened++
+	return &frameSource{frames: o.frames}, nil
+}
+
+func (o *opener) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.opened
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	return dir
+}
+
+// readAll reads the source opened by open to its end.
+func readAll(open player.SourceOpenerFunc) ([][]byte, error) {
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	var played [][]byte
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			return played, nil
+		}
+		if err != nil {
+			return played, err
+		}
+		played = append(played, frame)
+	}
+}
+
+func play(t *testing.T, open player.SourceOpenerFunc) [][]byte {
+	played, err := readAll(open)
+	require.NoError(t, err)
+	return played
+}
+
+func parts(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "part-*"))
+	require.NoError(t, err)
+	return matches
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			require.FailNow(t, msg)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c, err := cache.New(dir)
+	require.NoError(t, err)
+
+	o := &opener{frames: frames(3, 10)}
+	assert.Equal(t, o.frames, play(t, c.Opener("a", o.open)))
+	assert.True(t, c.Contains("a"))
+	assert.Empty(t, parts(t, dir))
+
+	// the item plays again from its file, which the dca package reads
+	src, err := c.Opener("a", o.open)()
+	require.NoError(t, err)
+	require.IsType(t, &dca.SourceCloser{}, src)
+	cached := src.(*dca.SourceCloser)
+	assert.Equal(t, 960, cached.Metadata().Opus.FrameSize)
+	duration, ok := cached.Duration()
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Millisecond, duration)
+	require.NoError(t, cached.Close())
+	assert.Equal(t, o.frames, play(t, c.Opener("a", o.open)))
+	assert.Equal(t, 1, o.count(), "expected a cached item not to be opened again")
+
+	// items cached by an earlier Cache are kept
+	c, err = cache.New(dir)
+	require.NoError(t, err)
+	assert.True(t, c.Contains("a"))
+	assert.False(t, c.Contains("b"))
+	require.NoError(t, c.Remove("a"))
+	require.NoError(t, c.Remove("a"))
+	assert.False(t, c.Contains("a"))
+	play(t, c.Opener("a", o.open))
+	assert.Equal(t, 2, o.count())
+}
+
+func TestCachePartFiles(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// leftovers of an earlier process are removed
+	leftover, err := ioutil.TempFile(dir, "part-")
+	require.NoError(t, err)
+	leftover.Close()
+	c, err := cache.New(dir)
+	require.NoError(t, err)
+	assert.Empty(t, parts(t, dir))
+
+	// an item that is skipped is not cached
+	src, err := c.Opener("skipped", func() (player.Source, error) {
+		return &frameSource{frames: frames(3, 10)}, nil
+	})()
+	require.NoError(t, err)
+	_, err = src.ReadFrame()
+	require.NoError(t, err)
+	assert.Len(t, parts(t, dir), 1, "expected the item to be written to a part file while it plays")
+	require.NoError(t, src.(io.Closer).Close())
+	assert.Empty(t, parts(t, dir))
+	assert.False(t, c.Contains("skipped"))
+
+	// nor is an item that fails
+	failing := &frameSource{frames: frames(3, 10), err: errors.New("failed")}
+	src, err = c.Opener("failed", func() (player.Source, error) {
+		return failing, nil
+	})()
+	require.NoError(t, err)
+	for err == nil {
+		_, err = src.ReadFrame()
+	}
+	assert.Equal(t, failing.err, err)
+	assert.Empty(t, parts(t, dir))
+	assert.False(t, c.Contains("failed"))
+	require.NoError(t, src.(io.Closer).Close())
+	assert.True(t, failing.closed)
+
+	// nor is an item with a frame too long for a dca file, which still plays
+	long := [][]byte{make([]byte, 10), make([]byte, 1<<15)}
+	played := play(t, c.Opener("long", func() (player.Source, error) {
+		return &frameSource{frames: long}, nil
+	}))
+	assert.Equal(t, long, played)
+	assert.Empty(t, parts(t, dir))
+	assert.False(t, c.Contains("long"))
+
+	_, err = c.Opener("unopened", func() (player.Source, error) {
+		return nil, errors.New("failed")
+	})()
+	assert.Error(t, err)
+	assert.Empty(t, parts(t, dir))
+}
+
+func TestCacheEviction(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// room for two items of 20kB
+	c, err := cache.New(dir, cache.MaxSize(50000))
+	require.NoError(t, err)
+	a := &opener{frames: frames(20, 1000)}
+	b := &opener{frames: frames(20, 1000)}
+	play(t, c.Opener("a", a.open))
+	play(t, c.Opener("b", b.open))
+	require.True(t, c.Contains("a"))
+	require.True(t, c.Contains("b"))
+
+	// a was cached first, but played again last
+	old := time.Now().Add(-time.Minute)
+	files, err := filepath.Glob(filepath.Join(dir, "*.dca"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	for _, f := range files {
+		require.NoError(t, os.Chtimes(f, old, old))
+	}
+	play(t, c.Opener("a", a.open))
+	assert.Equal(t, 1, a.count())
+
+	play(t, c.Opener("c", (&opener{frames: frames(20, 1000)}).open))
+	waitFor(t, func() bool { return !c.Contains("b") }, "expected the item played least recently to be evicted")
+	assert.True(t, c.Contains("a"))
+	assert.True(t, c.Contains("c"))
+}
+
+func TestCacheTTL(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c, err := cache.New(dir, cache.TTL(time.Hour))
+	require.NoError(t, err)
+	o := &opener{frames: frames(3, 10)}
+	play(t, c.Opener("a", o.open))
+	play(t, c.Opener("b", o.open))
+	require.True(t, c.Contains("a"))
+
+	expired := time.Now().Add(-2 * time.Hour)
+	files, err := filepath.Glob(filepath.Join(dir, "*.dca"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	for _, f := range files {
+		require.NoError(t, os.Chtimes(f, expired, expired))
+	}
+	assert.False(t, c.Contains("a"))
+	play(t, c.Opener("a", o.open))
+	assert.Equal(t, 3, o.count(), "expected an expired item to be opened again")
+
+	// expired files are removed
+	_, err = cache.New(dir, cache.TTL(time.Hour))
+	require.NoError(t, err)
+	files, err = filepath.Glob(filepath.Join(dir, "*.dca"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestCacheConcurrentFills(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c, err := cache.New(dir)
+	require.NoError(t, err)
+	o := &opener{frames: frames(10, 100)}
+
+	// an item plays in two guilds at once, one frame at a time
+	first, err := c.Opener("a", o.open)()
+	require.NoError(t, err)
+	second, err := c.Opener("a", o.open)()
+	require.NoError(t, err)
+	assert.Len(t, parts(t, dir), 2)
+	for _, frame := range o.frames {
+		for _, src := range []player.Source{first, second} {
+			played, err := src.ReadFrame()
+			require.NoError(t, err)
+			assert.Equal(t, frame, played)
+		}
+	}
+	for _, src := range []player.Source{first, second} {
+		_, err := src.ReadFrame()
+		assert.Equal(t, io.EOF, err)
+	}
+	assert.Empty(t, parts(t, dir))
+	assert.Equal(t, o.frames, play(t, c.Opener("a", o.open)))
+	assert.Equal(t, 2, o.count())
+
+	// many items fill and play from the cache at once
+	keys := []string{"a", "b", "c", "d"}
+	var wg sync.WaitGroup
+	for i := 0; i < 2*len(keys); i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				played, err := readAll(c.Opener(key, o.open))
+				assert.NoError(t, err)
+				assert.Equal(t, o.frames, played)
+			}
+		}(keys[i%len(keys)])
+	}
+	wg.Wait()
+	for _, key := range keys {
+		assert.True(t, c.Contains(key))
+	}
+	assert.Empty(t, parts(t, dir))
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+)
+
+// Clips keeps the frames of short clips in memory, e.g. soundboard sounds and intro jingles,
+// so they start playing at once without opening a file or running an encoder.
+// Clips is safe to use from multiple goroutines.
+type Clips struct {
+	max int64
+
+	mu   sync.Mutex
+	size int64
+	// clips from the most to the least recently played
+	lru   *list.List
+	clips map[string]*list.Element
+}
+
+// clip is the frames of a source that played to its end.
+type clip struct {
+	key      string
+	frames   [][]byte
+	frameDur time.Duration
+	size     int64
+}
+
+// NewClips produces an empty Clips that keeps up to maxSize bytes of frames,
+// forgetting the clips played least recently to make room.
+func NewClips(maxSize int64) *Clips {
+	return &Clips{
+		max:   maxSize,
+		lru:   list.New(),
+		clips: make(map[string]*list.Element),
+	}
+}
+
+// Load reads the whole source opened by open into memory as the clip of key, e.g. to warm up a soundboard.
+// Clips larger than the maximum size of the Clips are not kept.
+func (c *Clips) Load(key string, open player.SourceOpenerFunc) error {
+	cl, err := read(key, open)
+	if err != nil {
+		return err
+	}
+	c.add(cl)
+	return nil
+}
+
+// Opener returns a player.SourceOpenerFunc that plays the clip of key from memory,
+// or else loads the clip from the source opened by open first.
+func (c *Clips) Opener(key string, open player.SourceOpenerFunc) player.SourceOpenerFunc {
+	return func() (player.Source, error) {
+		if cl, ok := c.get(key); ok {
+			return &clipSource{clip: cl}, nil
+		}
+		cl, err := read(key, open)
+		if err != nil {
+			return nil, err
+		}
+		c.add(cl)
+		return &clipSource{clip: cl}, nil
+	}
+}
+
+// Contains reports whether the clip of key is in memory.
+func (c *Clips) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.clips[key]
+	return ok
+}
+
+// Remove forgets the clip of key.
+func (c *Clips) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.clips[key]; ok {
+		c.remove(e)
+	}
+}
+
+func (c *Clips) get(key string) (*clip, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.clips[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*clip), true
+}
+
+func (c *Clips) add(cl *clip) {
+	if cl.size > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.clips[cl.key]; ok {
+		c.remove(e)
+	}
+	c.clips[cl.key] = c.lru.PushFront(cl)
+	c.size += cl.size
+	for c.size > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Clips) remove(e *list.Element) {
+	cl := c.lru.Remove(e).(*clip)
+	delete(c.clips, cl.key)
+	c.size -= cl.size
+}
+
+// read reads a source to its end, copying frames out of the player's frame pool.
+func read(key string, open player.SourceOpenerFunc) (*clip, error) {
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	pooled := false
+	if ps, ok := src.(player.PooledSource); ok {
+		pooled = ps.PooledFrames()
+	}
+	cl := &clip{key: key, frameDur: src.FrameDuration()}
+	for {
+		frame, err := src.ReadFrame()
+		if len(frame) > 0 {
+			cl.frames = append(cl.frames, append([]byte(nil), frame...))
+			cl.size += int64(len(frame))
+		}
+		if pooled {
+			player.FreeFrame(frame)
+		}
+		if err == io.EOF {
+			return cl, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// clipSource plays the frames of a clip, which are shared by every clipSource of the clip and must not be modified.
+type clipSource struct {
+	clip *clip
+	next int
+}
+
+// ReadFrame implements player.Source.
+func (s *clipSource) ReadFrame() ([]byte, error) {
+	if s.next >= len(s.clip.frames) {
+		return nil, io.EOF
+	}
+	frame := s.clip.frames[s.next]
+	s.next++
+	return frame, nil
+}
+
+// FrameDuration implements player.Source.
+func (s *clipSource) FrameDuration() time.Duration {
+	return s.clip.frameDur
+}
+
+// PooledFrames implements player.PooledSource, the frames belong to the clip.
+func (s *clipSource) PooledFrames() bool {
+	return false
+}
+
+// SeekTo implements player.SeekableSource.
+func (s *clipSource) SeekTo(d time.Duration) (time.Duration, error) {
+	n := 0
+	if d > 0 && s.clip.frameDur > 0 {
+		n = int(d / s.clip.frameDur)
+	}
+	if n > len(s.clip.frames) {
+		n = len(s.clip.frames)
+	}
+	s.next = n
+	return time.Duration(n) * s.clip.frameDur, nil
+}
+
+var _ player.PooledSource = &clipSource{}
+var _ player.SeekableSource = &clipSource{}
@@ -0,0 +1,77 @@
+package cache_test
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/cache"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClips(t *testing.T) {
+	t.Parallel()
+	// room for two clips of 30 bytes
+	c := cache.NewClips(70)
+	a := &opener{frames: frames(3, 10)}
+	b := &opener{frames: frames(3, 10)}
+	assert.Equal(t, a.frames, play(t, c.Opener("a", a.open)))
+	require.NoError(t, c.Load("b", b.open))
+	assert.Equal(t, b.frames, play(t, c.Opener("b", b.open)))
+	assert.Equal(t, 1, b.count(), "expected a clip in memory not to be opened again")
+
+	// clips seek by frame
+	src, err := c.Opener("a", a.open)()
+	require.NoError(t, err)
+	pos, err := src.(player.SeekableSource).SeekTo(50 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 40*time.Millisecond, pos)
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, a.frames[2], frame)
+	_, err = src.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+
+	// a played last, so b is forgotten
+	require.NoError(t, c.Load("c", (&opener{frames: frames(3, 10)}).open))
+	assert.True(t, c.Contains("a"))
+	assert.False(t, c.Contains("b"))
+	assert.True(t, c.Contains("c"))
+	c.Remove("a")
+	assert.False(t, c.Contains("a"))
+
+	// clips too large are played but not kept
+	large := &opener{frames: frames(8, 10)}
+	assert.Equal(t, large.frames, play(t, c.Opener("large", large.open)))
+	assert.False(t, c.Contains("large"))
+	assert.True(t, c.Contains("c"))
+
+	// nor are clips that fail
+	err = c.Load("failed", func() (player.Source, error) {
+		return &frameSource{frames: frames(1, 10), err: errors.New("failed")}, nil
+	})
+	assert.Error(t, err)
+	assert.False(t, c.Contains("failed"))
+}
+
+func TestClipsConcurrent(t *testing.T) {
+	t.Parallel()
+	c := cache.NewClips(1 << 20)
+	o := &opener{frames: frames(10, 100)}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			played, err := readAll(c.Opener("a", o.open))
+			assert.NoError(t, err)
+			assert.Equal(t, o.frames, played)
+		}()
+	}
+	wg.Wait()
+	assert.True(t, c.Contains("a"))
+}
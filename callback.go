@@ -0,0 +1,56 @@
+package player
+
+import "time"
+
+// bound wraps an item's callbacks so each is abandoned after the CallbackTimeout, if there is one.
+func (p *Player) bound(cb *callbacks) {
+	if p.cfg.CallbackTimeout <= 0 {
+		return
+	}
+	onStart, onPause, onResume := cb.onStart, cb.onPause, cb.onResume
+	onProgress, onEnd := cb.onProgress, cb.onEnd
+	onProgressInterval, onDurationCorrected := cb.onProgressInterval, cb.onDurationCorrected
+	onLevels := cb.onLevels
+
+	cb.onStart = func() {
+		p.bounded("OnStart", onStart)
+	}
+	cb.onPause = func(elapsed time.Duration) {
+		p.bounded("OnPause", func() { onPause(elapsed) })
+	}
+	cb.onResume = func(elapsed time.Duration) {
+		p.bounded("OnResume", func() { onResume(elapsed) })
+	}
+	cb.onProgress = func(elapsed time.Duration, frameTimes []time.Duration) {
+		p.bounded("OnProgress", func() { onProgress(elapsed, frameTimes) })
+	}
+	cb.onEnd = func(elapsed time.Duration, err error) {
+		p.bounded("OnEnd", func() { onEnd(elapsed, err) })
+	}
+	cb.onProgressInterval = func(effective time.Duration) {
+		p.bounded("ProgressQuantize", func() { onProgressInterval(effective) })
+	}
+	cb.onDurationCorrected = func(declared time.Duration, actual time.Duration) {
+		p.bounded("OnDurationCorrected", func() { onDurationCorrected(declared, actual) })
+	}
+	cb.onLevels = func(elapsed time.Duration, levels Levels) {
+		p.bounded("OnLevels", func() { onLevels(elapsed, levels) })
+	}
+}
+
+// bounded runs a callback in its own goroutine and waits until it returns or the CallbackTimeout passes.
+func (p *Player) bounded(name string, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	timer := p.cfg.Clock.NewTimer(p.cfg.CallbackTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C():
+		p.cfg.Logger.Errorf("abandoned %v callback after %v", name, p.cfg.CallbackTimeout)
+		p.cfg.OnCallbackTimeout(name)
+	}
+}
@@ -0,0 +1,177 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only passes when it is told to, so tests can run timeouts instantly and deterministically.
+// Timers and tickers fire in order of their deadlines as Advance moves the time past them,
+// and timers set to fire in 0 or less fire right away like they do with the time package.
+type Fake struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+}
+
+// NewFake creates a Fake clock that starts at now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now reports the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the fake time forward by d, firing every timer and ticker that is due along the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for {
+		next := f.next(end)
+		if next == nil {
+			break
+		}
+		f.now = next.at
+		f.fire(next)
+	}
+	f.now = end
+}
+
+// Waiters reports how many timers and tickers are waiting to fire.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil waits until at least n timers and tickers are waiting to fire,
+// e.g. so a test advances the clock only once the code under test has started its timeout.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) < n {
+		f.changed.Wait()
+	}
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	f.schedule(t, d)
+	return t
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1), period: d}
+	f.schedule(t, d)
+	return fakeTicker{t}
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	f.schedule(t, d)
+	return t
+}
+
+// schedule sets the timer to fire after d, reporting whether it was waiting to fire already.
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	active := f.remove(t)
+	t.at = f.now.Add(d)
+	if d <= 0 {
+		f.fire(t)
+		return active
+	}
+	f.timers = append(f.timers, t)
+	f.changed.Broadcast()
+	return active
+}
+
+// next removes and returns the earliest timer due by end, nil if there is none.
+// The caller must hold mu.
+func (f *Fake) next(end time.Time) *fakeTimer {
+	var next *fakeTimer
+	for _, t := range f.timers {
+		if !t.at.After(end) && (next == nil || t.at.Before(next.at)) {
+			next = t
+		}
+	}
+	if next != nil {
+		f.remove(next)
+	}
+	return next
+}
+
+// remove reports whether the timer was waiting to fire, the caller must hold mu.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fire sends the fake time on the timer's channel, dropping it if the last one was not received like the time package does,
+// or calls the timer's func. A ticker is scheduled again. The caller must hold mu.
+func (f *Fake) fire(t *fakeTimer) {
+	if t.fn != nil {
+		go t.fn()
+	} else {
+		select {
+		case t.c <- f.now:
+		default:
+		}
+	}
+	if t.period > 0 {
+		t.at = t.at.Add(t.period)
+		f.timers = append(f.timers, t)
+		f.changed.Broadcast()
+	}
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	c      chan time.Time
+	fn     func()
+	at     time.Time
+	period time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	return t.clock.schedule(t, d)
+}
+
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.t.c
+}
+
+func (t fakeTicker) Stop() {
+	t.t.Stop()
+}
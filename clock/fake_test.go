@@ -0,0 +1,191 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Unix(0, 0)
+
+// fired reports the time sent on c, if any was sent.
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeNow(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	assert.Equal(t, epoch, clk.Now())
+	clk.Advance(time.Second)
+	assert.Equal(t, epoch.Add(time.Second), clk.Now())
+}
+
+func TestFakeTimer(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	timer := clk.NewTimer(time.Second)
+	assert.Equal(t, 1, clk.Waiters())
+
+	clk.Advance(999 * time.Millisecond)
+	_, ok := fired(timer.C())
+	assert.False(t, ok, "expected timer not to fire before its deadline")
+	clk.Advance(time.Millisecond)
+	at, ok := fired(timer.C())
+	require.True(t, ok, "expected timer to fire at its deadline")
+	assert.Equal(t, epoch.Add(time.Second), at, "expected timer to send the fake time it fired at")
+	assert.Equal(t, 0, clk.Waiters())
+	assert.False(t, timer.Stop(), "expected a timer that fired not to be stopped")
+
+	// reset
+	assert.False(t, timer.Reset(time.Second), "expected a timer that fired not to be active")
+	assert.True(t, timer.Reset(2*time.Second), "expected a timer that was reset to be active")
+	clk.Advance(time.Second)
+	_, ok = fired(timer.C())
+	assert.False(t, ok, "expected timer to fire at the deadline it was last reset to")
+	clk.Advance(time.Second)
+	at, ok = fired(timer.C())
+	require.True(t, ok)
+	assert.Equal(t, epoch.Add(3*time.Second), at)
+
+	// stop
+	timer.Reset(time.Second)
+	assert.True(t, timer.Stop())
+	clk.Advance(time.Hour)
+	_, ok = fired(timer.C())
+	assert.False(t, ok, "expected a stopped timer not to fire")
+
+	// timers that are due right away fire without advancing the clock
+	timer = clk.NewTimer(0)
+	_, ok = fired(timer.C())
+	assert.True(t, ok)
+	timer.Reset(-time.Second)
+	_, ok = fired(timer.C())
+	assert.True(t, ok)
+}
+
+func TestFakeTimerOrder(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	var timers []clock.Timer
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		timers = append(timers, clk.NewTimer(d))
+	}
+	// advancing past every deadline in one step fires each timer at its own deadline
+	clk.Advance(time.Minute)
+	assert.Equal(t, epoch.Add(time.Minute), clk.Now(), "expected the clock to end where it was advanced to")
+	assert.Equal(t, 0, clk.Waiters())
+	for i, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		at, ok := fired(timers[i].C())
+		require.True(t, ok)
+		assert.Equal(t, epoch.Add(d), at)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	ticker := clk.NewTicker(time.Second)
+
+	for i := 1; i <= 3; i++ {
+		clk.Advance(time.Second)
+		at, ok := fired(ticker.C())
+		require.True(t, ok, "expected ticker to tick every interval")
+		assert.Equal(t, epoch.Add(time.Duration(i)*time.Second), at)
+	}
+	assert.Equal(t, 1, clk.Waiters(), "expected ticker to be scheduled again after it ticks")
+
+	// ticks that are not received are dropped like they are by the time package
+	clk.Advance(5 * time.Second)
+	at, ok := fired(ticker.C())
+	require.True(t, ok)
+	assert.Equal(t, epoch.Add(4*time.Second), at, "expected only the first missed tick to be kept")
+	_, ok = fired(ticker.C())
+	assert.False(t, ok)
+
+	ticker.Stop()
+	assert.Equal(t, 0, clk.Waiters())
+	clk.Advance(time.Hour)
+	_, ok = fired(ticker.C())
+	assert.False(t, ok, "expected a stopped ticker not to tick")
+
+	assert.Panics(t, func() { clk.NewTicker(0) })
+}
+
+func TestFakeAfterFunc(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	done := make(chan time.Time, 1)
+	timer := clk.AfterFunc(time.Second, func() {
+		done <- clk.Now()
+	})
+
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case <-done:
+		require.FailNow(t, "expected func not to be called before its deadline")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	select {
+	case at := <-done:
+		assert.Equal(t, epoch.Add(time.Second), at)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected func to be called at its deadline")
+	}
+	_, ok := fired(timer.C())
+	assert.False(t, ok, "expected the channel of an AfterFunc timer never to fire")
+
+	// stop
+	calls := make(chan struct{}, 2)
+	timer = clk.AfterFunc(time.Second, func() {
+		calls <- struct{}{}
+	})
+	assert.True(t, timer.Stop())
+	clk.Advance(time.Hour)
+
+	// reset
+	timer.Reset(time.Second)
+	timer.Reset(2 * time.Second)
+	clk.Advance(time.Second)
+	assert.Equal(t, 1, clk.Waiters(), "expected func to wait for the deadline it was last reset to")
+	clk.Advance(time.Second)
+	assert.Equal(t, 0, clk.Waiters())
+	select {
+	case <-calls:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected func to be called after it was reset")
+	}
+	assert.Len(t, calls, 0, "expected func to be called once")
+
+	// funcs that are due right away are called without advancing the clock
+	called := make(chan struct{})
+	clk.AfterFunc(0, func() { close(called) })
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected func to be called right away")
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		clk.NewTimer(time.Second)
+		clk.NewTimer(time.Second)
+	}()
+	<-started
+	clk.BlockUntil(2)
+	assert.Equal(t, 2, clk.Waiters())
+}
@@ -0,0 +1,264 @@
+// Package dca provides a source of the opus frames stored in a DCA file,
+// the format of github.com/jonas747/dca, so media converted ahead of time plays without an encoder.
+// Both DCA0, frames prefixed with their length, and DCA1, the same preceded by json metadata, are supported.
+package dca
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/pkg/errors"
+)
+
+// opus frames are timed at 48kHz no matter the sample rate of the original audio
+const opusRate = 48000
+
+// duration of the frames of files without metadata, the frames the dca encoder produces by default
+const defaultFrameDuration = 20 * time.Millisecond
+
+// ErrNotSeekable is returned by SeekTo if the source was not created from an io.Seeker.
+var ErrNotSeekable = errors.New("dca source is not seekable")
+
+// Metadata is the json metadata of a DCA1 file.
+type Metadata struct {
+	DCA struct {
+		Version int `json:"version"`
+		Tool    struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			URL     string `json:"url"`
+			Author  string `json:"author"`
+		} `json:"tool"`
+	} `json:"dca"`
+	Opus struct {
+		Mode       string `json:"mode"`
+		SampleRate int    `json:"sample_rate"`
+		// FrameSize is the number of samples of each channel in a frame.
+		FrameSize int  `json:"frame_size"`
+		Bitrate   int  `json:"abr"`
+		VBR       bool `json:"vbr"`
+		Channels  int  `json:"channels"`
+	} `json:"opus"`
+	Info struct {
+		Title    string `json:"title"`
+		Artist   string `json:"artist"`
+		Album    string `json:"album"`
+		Genre    string `json:"genre"`
+		Comments string `json:"comments"`
+		Cover    string `json:"cover"`
+	} `json:"info"`
+	Origin struct {
+		Source   string `json:"source"`
+		Bitrate  int    `json:"abr"`
+		Channels int    `json:"channels"`
+		Encoding string `json:"encoding"`
+		URL      string `json:"url"`
+	} `json:"origin"`
+	// Extra is any metadata added by the tool that wrote the file.
+	Extra json.RawMessage `json:"extra"`
+}
+
+// SourceCloser provides the opus frames of a DCA file.
+type SourceCloser struct {
+	r        io.Reader
+	br       *bufio.Reader
+	md       *Metadata
+	frameDur time.Duration
+	// offset of the first frame and of the frame that is read next
+	start  int64
+	offset int64
+	// offsets of every frame and of the end of the last, found the first time the source seeks or reports its duration
+	frames []int64
+	end    int64
+}
+
+// Open opens a DCA file as a seekable source.
+func Open(path string) (*SourceCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := NewSource(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+// NewSource produces a source of the opus frames of a DCA file, reading its metadata right away.
+// The source can seek and report its duration if r implements io.Seeker.
+// If r implements io.Closer it is closed when the source is closed.
+func NewSource(r io.Reader) (*SourceCloser, error) {
+	src := &SourceCloser{r: r, br: bufio.NewReader(r), frameDur: defaultFrameDuration}
+	magic, err := src.br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read dca header")
+	}
+	switch {
+	case string(magic) == "DCA1":
+		if err := src.readMetadata(); err != nil {
+			return nil, err
+		}
+	case len(magic) == 4 && string(magic[:3]) == "DCA":
+		return nil, errors.Errorf("unsupported dca version %q", magic)
+	}
+	return src, nil
+}
+
+func (src *SourceCloser) readMetadata() error {
+	var header struct {
+		Magic [4]byte
+		Len   int32
+	}
+	if err := binary.Read(src.br, binary.LittleEndian, &header); err != nil {
+		return errors.Wrap(err, "failed to read dca header")
+	}
+	if header.Len < 0 {
+		return errors.Errorf("dca metadata of %v bytes is invalid", header.Len)
+	}
+	// the buffer grows as the metadata is read instead of trusting the size in the header up front
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, src.br, int64(header.Len)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.Wrap(err, "failed to read dca metadata")
+	}
+	data := buf.Bytes()
+	var md Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return errors.Wrap(err, "failed to parse dca metadata")
+	}
+	src.md = &md
+	if md.Opus.FrameSize > 0 {
+		src.frameDur = time.Duration(md.Opus.FrameSize) * time.Second / opusRate
+	}
+	src.start = int64(8 + len(data))
+	src.offset = src.start
+	return nil
+}
+
+// Metadata reports the metadata of a DCA1 file, or nil for a DCA0 file.
+func (src *SourceCloser) Metadata() *Metadata {
+	return src.md
+}
+
+// Duration reports how long the frames of the file play, or false if the source cannot seek.
+// The file is scanned for its frames the first time, without reading them.
+func (src *SourceCloser) Duration() (time.Duration, bool) {
+	if err := src.index(); err != nil {
+		return 0, false
+	}
+	return time.Duration(len(src.frames)) * src.frameDur, true
+}
+
+// ReadFrame implements player.SourceCloser.
+func (src *SourceCloser) ReadFrame() ([]byte, error) {
+	var n int16
+	if err := binary.Read(src.br, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, errors.Errorf("dca frame of %v bytes is invalid", n)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(src.br, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	src.offset += 2 + int64(n)
+	return frame, nil
+}
+
+// FrameDuration implements player.SourceCloser.
+func (src *SourceCloser) FrameDuration() time.Duration {
+	return src.frameDur
+}
+
+// PCM implements player.PCMSource, the source produces opus frames.
+func (src *SourceCloser) PCM() bool {
+	return false
+}
+
+// SeekTo implements player.SeekableSource, moving to the frame that plays at d.
+// SeekTo returns ErrNotSeekable if the source was not created from an io.Seeker.
+func (src *SourceCloser) SeekTo(d time.Duration) (time.Duration, error) {
+	if err := src.index(); err != nil {
+		return 0, err
+	}
+	i := int(d / src.frameDur)
+	if i > len(src.frames) {
+		i = len(src.frames)
+	}
+	offset := src.end
+	if i < len(src.frames) {
+		offset = src.frames[i]
+	}
+	if err := src.seek(offset); err != nil {
+		return 0, err
+	}
+	return time.Duration(i) * src.frameDur, nil
+}
+
+// index finds the offset of every frame, returning to the frame that is read next.
+func (src *SourceCloser) index() error {
+	if src.frames != nil {
+		return nil
+	}
+	rs, ok := src.r.(io.Seeker)
+	if !ok {
+		return ErrNotSeekable
+	}
+	resume := src.offset
+	frames := []int64{}
+	offset := src.start
+	for {
+		if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		var n int16
+		if err := binary.Read(src.r, binary.LittleEndian, &n); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to index dca frames")
+		}
+		if n < 0 {
+			return errors.Errorf("dca frame of %v bytes is invalid", n)
+		}
+		frames = append(frames, offset)
+		offset += 2 + int64(n)
+	}
+	src.frames = frames
+	src.end = offset
+	return src.seek(resume)
+}
+
+func (src *SourceCloser) seek(offset int64) error {
+	if _, err := src.r.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	src.br.Reset(src.r)
+	src.offset = offset
+	return nil
+}
+
+// Close implements player.SourceCloser.
+func (src *SourceCloser) Close() error {
+	if c, ok := src.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// do not compile unless SourceCloser implements player.SourceCloser and player.SeekableSource
+var _ player.SourceCloser = &SourceCloser{}
+var _ player.SeekableSource = &SourceCloser{}
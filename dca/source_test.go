@@ -0,0 +1,219 @@
+package dca_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/dca"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encode writes frames prefixed with their length after the header.
+func encode(header []byte, frames ...string) []byte {
+	var buf bytes.Buffer
+	buf.Write(header)
+	for _, frame := range frames {
+		binary.Write(&buf, binary.LittleEndian, int16(len(frame)))
+		buf.WriteString(frame)
+	}
+	return buf.Bytes()
+}
+
+// dca1 is the header of a DCA1 file with the json metadata.
+func dca1(metadata string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("DCA1")
+	binary.Write(&buf, binary.LittleEndian, int32(len(metadata)))
+	buf.WriteString(metadata)
+	return buf.Bytes()
+}
+
+const metadata = `{"dca":{"version":1,"tool":{"name":"dca-rs"}},"opus":{"sample_rate":48000,"frame_size":1920,"channels":2},"info":{"title":"Title"}}`
+
+func readAll(src *dca.SourceCloser) ([]string, error) {
+	var frames []string
+	for {
+		frame, err := src.ReadFrame()
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, string(frame))
+	}
+}
+
+type closeRecorder struct {
+	io.ReadSeeker
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	r := &closeRecorder{ReadSeeker: bytes.NewReader(encode(dca1(metadata), "a", "bb", "ccc", "d"))}
+	src, err := dca.NewSource(r)
+	require.NoError(t, err)
+	md := src.Metadata()
+	require.NotNil(t, md)
+	assert.Equal(t, 1, md.DCA.Version)
+	assert.Equal(t, "dca-rs", md.DCA.Tool.Name)
+	assert.Equal(t, "Title", md.Info.Title)
+	assert.Equal(t, 40*time.Millisecond, src.FrameDuration(), "expected frames as long as the metadata's frame size")
+
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(frame))
+	// the duration is found without moving the source
+	duration, ok := src.Duration()
+	assert.True(t, ok)
+	assert.Equal(t, 160*time.Millisecond, duration)
+	frames, err := readAll(src)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"bb", "ccc", "d"}, frames)
+
+	require.NoError(t, src.Close())
+	assert.True(t, r.closed)
+}
+
+func TestSourceSeek(t *testing.T) {
+	t.Parallel()
+	src, err := dca.NewSource(bytes.NewReader(encode(dca1(metadata), "a", "bb", "ccc", "d")))
+	require.NoError(t, err)
+	cases := []struct {
+		to      time.Duration
+		reached time.Duration
+		frames  []string
+	}{
+		{to: 80 * time.Millisecond, reached: 80 * time.Millisecond, frames: []string{"ccc", "d"}},
+		{to: 50 * time.Millisecond, reached: 40 * time.Millisecond, frames: []string{"bb", "ccc", "d"}},
+		{to: 0, reached: 0, frames: []string{"a", "bb", "ccc", "d"}},
+		{to: time.Second, reached: 160 * time.Millisecond, frames: nil},
+	}
+	for _, c := range cases {
+		reached, err := src.SeekTo(c.to)
+		require.NoError(t, err)
+		assert.Equal(t, c.reached, reached)
+		frames, err := readAll(src)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, c.frames, frames, "expected the frames after seeking to %v", c.to)
+	}
+}
+
+func TestSourceDCA0(t *testing.T) {
+	t.Parallel()
+	src, err := dca.NewSource(bytes.NewReader(encode(nil, "a", "bb")))
+	require.NoError(t, err)
+	assert.Nil(t, src.Metadata())
+	assert.Equal(t, 20*time.Millisecond, src.FrameDuration())
+	duration, ok := src.Duration()
+	assert.True(t, ok)
+	assert.Equal(t, 40*time.Millisecond, duration)
+	frames, err := readAll(src)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"a", "bb"}, frames)
+
+	// an empty file has no frames
+	src, err = dca.NewSource(bytes.NewReader(nil))
+	require.NoError(t, err)
+	_, err = src.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSourceNotSeekable(t *testing.T) {
+	t.Parallel()
+	// e.g. a file piped from the dca encoder
+	src, err := dca.NewSource(bytes.NewBufferString(string(encode(dca1(metadata), "a", "bb"))))
+	require.NoError(t, err)
+	_, ok := src.Duration()
+	assert.False(t, ok)
+	_, err = src.SeekTo(0)
+	assert.Equal(t, dca.ErrNotSeekable, err)
+	frames, err := readAll(src)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"a", "bb"}, frames)
+	assert.NoError(t, src.Close())
+}
+
+func TestSourceMalformed(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		file []byte
+	}{
+		{name: "unsupported version", file: encode([]byte("DCA2"), "a")},
+		{name: "truncated header", file: []byte("DCA1\x10")},
+		{name: "negative metadata size", file: []byte("DCA1\xFF\xFF\xFF\xFF")},
+		{name: "truncated metadata", file: dca1(metadata)[:20]},
+		{name: "invalid metadata", file: dca1("{")},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := dca.NewSource(bytes.NewReader(c.file))
+			assert.Error(t, err)
+		})
+	}
+
+	// frames fail to read instead of ending the file early
+	file := encode(dca1(metadata), "a", "bb")
+	src, err := dca.NewSource(bytes.NewReader(file[:len(file)-1]))
+	require.NoError(t, err)
+	frames, err := readAll(src)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, []string{"a"}, frames)
+
+	src, err = dca.NewSource(bytes.NewReader(append(encode(nil, "a"), 0xFF, 0xFF)))
+	require.NoError(t, err)
+	frames, err = readAll(src)
+	assert.Error(t, err, "expected a negative frame size to fail")
+	assert.Equal(t, []string{"a"}, frames)
+
+	// indexing fails on a negative frame size instead of scanning the same offset forever
+	for _, size := range []int16{-1, -2, -3} {
+		file := append(encode(nil, "a"), 0, 0)
+		binary.LittleEndian.PutUint16(file[len(file)-2:], uint16(size))
+		src, err := dca.NewSource(bytes.NewReader(file))
+		require.NoError(t, err)
+		_, ok := src.Duration()
+		assert.False(t, ok, "expected no duration with a frame of %v bytes", size)
+		_, err = src.SeekTo(0)
+		assert.Error(t, err, "expected seeking to fail with a frame of %v bytes", size)
+	}
+
+	// the size of the metadata is not allocated up front
+	src, err = dca.NewSource(bytes.NewReader([]byte("DCA1000Z")))
+	assert.Error(t, err)
+	assert.Nil(t, src)
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+	f, err := ioutil.TempFile("", "dca")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(encode(dca1(metadata), "a", "bb"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	src, err := dca.Open(f.Name())
+	require.NoError(t, err)
+	_, err = src.SeekTo(40 * time.Millisecond)
+	require.NoError(t, err)
+	frames, err := readAll(src)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"bb"}, frames)
+	require.NoError(t, src.Close())
+
+	_, err = dca.Open(f.Name() + ".missing")
+	assert.Error(t, err)
+}
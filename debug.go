@@ -0,0 +1,135 @@
+package player
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// how many of the most recent item errors and frame latencies DebugHandler shows
+	debugErrors    = 10
+	debugLatencies = 50
+)
+
+// debugLog keeps the recent item errors and frame latencies shown by DebugHandler.
+type debugLog struct {
+	mu        sync.Mutex
+	errors    []debugError
+	latencies [debugLatencies]time.Duration
+	// number of latencies recorded, the next is recorded at writes % debugLatencies
+	writes int
+}
+
+type debugError struct {
+	Time  time.Time `json:"time"`
+	ID    uint64    `json:"id"`
+	Title string    `json:"title"`
+	Error string    `json:"error"`
+}
+
+// itemEnded records why an item ended unless it played to its end or was skipped.
+func (d *debugLog) itemEnded(song *songItem, err error, now time.Time) {
+	if cause := errors.Cause(err); cause == nil || cause == io.EOF || cause == io.ErrUnexpectedEOF || cause == ErrSkipped {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errors = append(d.errors, debugError{Time: now, ID: song.seq, Title: song.title, Error: err.Error()})
+	if len(d.errors) > debugErrors {
+		d.errors = d.errors[len(d.errors)-debugErrors:]
+	}
+}
+
+// frameWritten records the time since the previous frame was written.
+func (d *debugLog) frameWritten(latency time.Duration) {
+	d.mu.Lock()
+	d.latencies[d.writes%debugLatencies] = latency
+	d.writes++
+	d.mu.Unlock()
+}
+
+// recent returns the recent errors and latencies, oldest first.
+func (d *debugLog) recent() ([]debugError, []time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	errs := make([]debugError, len(d.errors))
+	copy(errs, d.errors)
+	n := d.writes
+	if n > debugLatencies {
+		n = debugLatencies
+	}
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		latencies[i] = d.latencies[(d.writes-n+i)%debugLatencies]
+	}
+	return errs, latencies
+}
+
+type debugInfo struct {
+	State        string       `json:"state"`
+	Current      *QueueEntry  `json:"current,omitempty"`
+	Elapsed      float64      `json:"elapsed_seconds"`
+	Queue        []QueueEntry `json:"queue"`
+	RecentErrors []debugError `json:"recent_errors"`
+	// milliseconds between the most recent frame writes
+	Latencies []float64 `json:"frame_latencies_ms"`
+}
+
+func (p *Player) debugInfo() debugInfo {
+	info := debugInfo{State: p.State().String()}
+	if current, ok := p.Current(); ok {
+		info.Current = &current
+	}
+	elapsed, _ := p.Elapsed()
+	info.Elapsed = elapsed.Seconds()
+	info.Queue = p.Queue()
+	var latencies []time.Duration
+	info.RecentErrors, latencies = p.debug.recent()
+	info.Latencies = make([]float64, len(latencies))
+	for i, l := range latencies {
+		info.Latencies[i] = l.Seconds() * 1000
+	}
+	return info
+}
+
+// DebugHandler serves the state of the player, e.g. to mount on a bot's debug mux:
+// its state, the current item and how long it has played, the queue,
+// the most recent items that ended with an error, and the time between the most recent frame writes.
+// It responds with JSON, or with an HTML page to requests that accept text/html, e.g. from a browser.
+func DebugHandler(p *Player) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := p.debugInfo()
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			debugTemplate.Execute(w, info)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+	})
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>player</title></head>
+<body>
+<h1>player is {{.State}}</h1>
+{{with .Current}}<p>playing {{.ID}} {{.Title}} for {{printf "%.1f" $.Elapsed}}s</p>{{end}}
+<h2>queue</h2>
+<ol>{{range .Queue}}<li>{{.ID}} {{.Title}}{{if .SubQueue}} ({{.SubQueue}}){{end}}</li>{{else}}empty{{end}}</ol>
+<h2>recent errors</h2>
+<ul>{{range .RecentErrors}}<li>{{.Time.Format "2006-01-02 15:04:05"}} {{.ID}} {{.Title}}: {{.Error}}</li>{{else}}none{{end}}</ul>
+<h2>frame latencies (ms)</h2>
+<p>{{range .Latencies}}{{printf "%.1f" .}} {{end}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,95 @@
+package discordvoice
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how a Writer retries joining its voice channel after the connection drops or a send times out.
+type Backoff struct {
+	// Attempts is the most joins attempted each time the Writer reconnects, values less than 1 attempt once.
+	Attempts int
+	// Initial is the delay before the second attempt,
+	// each following delay is Multiplier times longer than the last up to Max, if Max is not 0.
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter randomizes each delay by up to the fraction of the delay, e.g. 0.2 for up to 20% shorter or longer,
+	// so the Writers of many guilds do not retry in lockstep after an outage.
+	Jitter float64
+	// OnAttempt, if not nil, is called before each attempt with its number counting from 1
+	// and the error of the previous attempt, nil before the first.
+	OnAttempt func(attempt int, err error)
+}
+
+// DefaultBackoff attempts to join three times, a second and then two seconds apart.
+var DefaultBackoff = Backoff{
+	Attempts:   3,
+	Initial:    1 * time.Second,
+	Max:        10 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// ReconnectBackoff sets how the Device's Writers retry joining their voice channel, DefaultBackoff by default.
+func ReconnectBackoff(b Backoff) Option {
+	return func(d *Device) {
+		d.backoff = b
+	}
+}
+
+// delay is how long to wait after the attempt failed.
+func (b Backoff) delay(attempt int) time.Duration {
+	d := float64(b.Initial)
+	for i := 1; i < attempt && b.Multiplier > 0; i++ {
+		d *= b.Multiplier
+		if b.Max > 0 && d > float64(b.Max) {
+			break
+		}
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// join attempts to join the Writer's voice channel until it succeeds, the Backoff runs out of attempts,
+// or the Writer's context is done or the Writer is closed in between attempts.
+func (w *Writer) join() (VoiceConn, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-w.ctx.Done():
+			return nil, w.ctx.Err()
+		case <-w.done:
+			return nil, ErrWriterClosed
+		default:
+		}
+		if w.backoff.OnAttempt != nil {
+			w.backoff.OnAttempt(attempt, err)
+		}
+		var vconn VoiceConn
+		vconn, err = w.joiner.ChannelVoiceJoin(w.guildID, w.channelID, false, true)
+		if err == nil {
+			return vconn, nil
+		}
+		if attempt >= w.backoff.Attempts {
+			return nil, err
+		}
+		delay := w.backoff.delay(attempt)
+		w.log.Debugf("guild %v: failed to join channel %v, attempting again in %v: %v", w.guildID, w.channelID, delay, err)
+		timer := w.clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-w.ctx.Done():
+			timer.Stop()
+			return nil, w.ctx.Err()
+		case <-w.done:
+			timer.Stop()
+			return nil, ErrWriterClosed
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package discordvoice
+
+import "github.com/jonas747/dca"
+
+// bitrates of voice channels in kb/s, from the lowest discord allows to the highest of boosted servers
+const (
+	minBitrate = 8
+	maxBitrate = 384
+)
+
+// ChannelBitrater is implemented by VoiceJoiners that can look up the bitrate voice channels are configured with.
+type ChannelBitrater interface {
+	// ChannelBitrate reports the bitrate of the voice channel in bits per second.
+	ChannelBitrate(channelID string) (int, error)
+}
+
+// Bitrate reports the bitrate of a voice channel in kb/s,
+// or false if it cannot be looked up, e.g. if the Device's VoiceJoiner does not implement ChannelBitrater.
+func (d *Device) Bitrate(channelID string) (int, bool) {
+	br, ok := d.joiner.(ChannelBitrater)
+	if !ok {
+		return 0, false
+	}
+	bps, err := br.ChannelBitrate(channelID)
+	if err != nil || bps <= 0 {
+		return 0, false
+	}
+	kbps := bps / 1000
+	if kbps < minBitrate {
+		kbps = minBitrate
+	}
+	if kbps > maxBitrate {
+		kbps = maxBitrate
+	}
+	return kbps, true
+}
+
+// EncodeOptions returns a copy of opts, or of dca.StdEncodeOptions if opts is nil,
+// whose bitrate matches the bitrate of the voice channel,
+// so items sound as good as boosted channels allow without wasting bandwidth on channels with a lower bitrate.
+// The bitrate of opts is kept if the bitrate of the channel cannot be looked up.
+// The packet loss is set to the Device's PacketLoss, if any.
+func (d *Device) EncodeOptions(channelID string, opts *dca.EncodeOptions) *dca.EncodeOptions {
+	if opts == nil {
+		opts = dca.StdEncodeOptions
+	}
+	tuned := *opts
+	if kbps, ok := d.Bitrate(channelID); ok {
+		tuned.Bitrate = kbps
+	}
+	if d.packetLoss >= 0 {
+		tuned.PacketLoss = d.packetLoss
+	}
+	return &tuned
+}
+
+func (s sessionJoiner) ChannelBitrate(channelID string) (int, error) {
+	channel, err := s.discord.State.Channel(channelID)
+	if err != nil {
+		channel, err = s.discord.Channel(channelID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return channel.Bitrate, nil
+}
+
+// SetBitrate sets the bitrate of a voice channel in bits per second, so FakeJoiner implements ChannelBitrater.
+func (j *FakeJoiner) SetBitrate(channelID string, bps int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.bitrates == nil {
+		j.bitrates = make(map[string]int)
+	}
+	j.bitrates[channelID] = bps
+}
+
+func (j *FakeJoiner) ChannelBitrate(channelID string) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.channels[channelID] {
+		return 0, ErrInvalidVoiceChannel
+	}
+	return j.bitrates[channelID], nil
+}
+
+// do not compile unless the VoiceJoiners implement ChannelBitrater
+var _ ChannelBitrater = sessionJoiner{}
+var _ ChannelBitrater = &FakeJoiner{}
@@ -1,117 +1,705 @@
 package discordvoice
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/clock"
 	"github.com/pkg/errors"
 )
 
 var ErrInvalidVoiceChannel = errors.New("invalid voice channel")
 
+// ErrWriterClosed is returned by a Writer that was closed while it was rejoining its voice channel.
+var ErrWriterClosed = errors.New("writer is closed")
+
+// ErrDisconnected is returned by Writes after someone else disconnected the bot from its voice channel.
+var ErrDisconnected = errors.New("disconnected from voice channel")
+
+// discord expects 20ms opus frames
+const frameDuration = 20 * time.Millisecond
+
+// discord expects 5 frames of silence whenever a client stops sending audio
+const silenceFrames = 5
+
+// opus frame of silence
+var silenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+// how long a Writer keeps speaking after its last frame by default
+const defaultQuietPeriod = 500 * time.Millisecond
+
 // Device
 type Device struct {
 	guildID     string
 	sendTimeout time.Duration
-	discord     *discordgo.Session
+	joiner      VoiceJoiner
 	mu          sync.Mutex
 	writer      *Writer
+	onReconnect func(ReconnectEvent)
+	onMoved     func(MoveEvent)
+	log         player.Logger
+	metrics     player.Metrics
+	clock       clock.Clock
+	quiet       time.Duration
+	backoff     Backoff
+	// how long Writers wait without a Write before they leave their channel for idleChannelID, if at all
+	idleAfter     time.Duration
+	idleChannelID string
+	// packet loss percentage the encoder expects, -1 to keep the encode options' own
+	packetLoss int
+	dtx        bool
+	// encodes sources created with Device.NewSource
+	ffmpeg FFmpeg
+	// remove the handlers of discord session events
+	removeHandlers []func()
+	// user whose voice channel the Device plays in, if any, and the channel they are in
+	followed      string
+	followChannel string
+	// looks up the voice channel a user of the guild is in, if the Device has a discord session
+	voiceState func(userID string) string
+	// encrypts packets for PacketConns, if at all
+	sealer *Sealer
+}
+
+// Option functions configure a Device.
+// Pass Options to the New or NewDevice functions.
+type Option func(*Device)
+
+// Log sets where the Device reports what it is doing, e.g. joining channels and recovering from reconnects.
+// Messages are discarded by default.
+func Log(l player.Logger) Option {
+	return func(d *Device) {
+		if l != nil {
+			d.log = l
+		}
+	}
+}
+
+// Measure sets where the Device reports send timeouts of its Writers.
+// Frames written and the rest of the measurements of playback are reported by the player.
+func Measure(m player.Metrics) Option {
+	return func(d *Device) {
+		if m != nil {
+			d.metrics = m
+		}
+	}
+}
+
+// Clock sets the clock that times out sends of the Device's Writers, clock.Real by default.
+// Tests can pass a clock.Fake to time out sends without waiting.
+func Clock(c clock.Clock) Option {
+	return func(d *Device) {
+		if c != nil {
+			d.clock = c
+		}
+	}
 }
 
-func New(discord *discordgo.Session, guildID string, sendTimeout time.Duration) *Device {
-	return &Device{
+// QuietPeriod sets how long a Writer keeps its speaking indicator on after its last frame, 500ms by default,
+// so it stays on in between items that follow each other closely but not while playback is paused or idle.
+// Values less than or equal to 0 are ignored.
+func QuietPeriod(quiet time.Duration) Option {
+	return func(d *Device) {
+		if quiet > 0 {
+			d.quiet = quiet
+		}
+	}
+}
+
+// ReconnectEvent describes how a Device recovered after its discord session reconnected.
+type ReconnectEvent struct {
+	// Resumed is true if the gateway resumed the session and false if the session was established anew.
+	Resumed bool
+	// ChannelID is the channel of the Device's open Writer, empty if there is none.
+	ChannelID string
+	// Rejoined is true if the Writer had to rejoin the voice channel.
+	Rejoined bool
+	// Err is why the Writer could not recover, e.g. ErrInvalidVoiceChannel if the channel was deleted during the outage.
+	Err error
+}
+
+// New creates a Device for a guild of a discordgo session.
+// The Device watches the discord session for reconnects, call Close to stop watching.
+func New(discord *discordgo.Session, guildID string, sendTimeout time.Duration, opts ...Option) *Device {
+	d := NewDevice(Session(discord), guildID, sendTimeout, opts...)
+	d.voiceState = func(userID string) string {
+		vs, err := discord.State.VoiceState(guildID, userID)
+		if err != nil {
+			return ""
+		}
+		return vs.ChannelID
+	}
+	d.removeHandlers = []func(){
+		discord.AddHandler(func(_ *discordgo.Session, _ *discordgo.Resumed) {
+			go d.Reconnected(true)
+		}),
+		discord.AddHandler(func(_ *discordgo.Session, _ *discordgo.Connect) {
+			go d.Reconnected(false)
+		}),
+		discord.AddHandler(func(s *discordgo.Session, vs *discordgo.VoiceStateUpdate) {
+			if vs.GuildID != guildID {
+				return
+			}
+			if s.State.User != nil && vs.UserID == s.State.User.ID {
+				d.Moved(vs.ChannelID)
+			}
+			d.UserMoved(vs.UserID, vs.ChannelID)
+		}),
+	}
+	return d
+}
+
+// NewDevice creates a Device for a guild that joins voice channels with any discord library.
+// The Device does not watch for reconnects by itself, call Reconnected when the library's gateway reconnects.
+func NewDevice(joiner VoiceJoiner, guildID string, sendTimeout time.Duration, opts ...Option) *Device {
+	d := &Device{
 		guildID:     guildID,
 		sendTimeout: sendTimeout,
-		discord:     discord,
+		joiner:      joiner,
+		log:         player.NopLogger,
+		metrics:     player.NopMetrics,
+		clock:       clock.Real,
+		quiet:       defaultQuietPeriod,
+		backoff:     DefaultBackoff,
+		packetLoss:  -1,
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	return d
+}
+
+// OnReconnect sets a function called after the discord session reconnects
+// and the Device has checked that its open Writer is still in its voice channel, rejoining the channel if needed.
+// Long running bots can use it to notice when the Writer could not recover, e.g. to move to another channel.
+func (d *Device) OnReconnect(f func(ReconnectEvent)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onReconnect = f
+}
+
+// Reconnected makes sure the open Writer, if any, still sends to its voice channel after the session reconnected,
+// resumed reports whether the gateway resumed the session rather than establishing it anew.
+// A gateway resume can leave the voice connection stale without any error, so Writes would silently go nowhere.
+// Devices created with New call Reconnected by themselves.
+func (d *Device) Reconnected(resumed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ev := ReconnectEvent{Resumed: resumed}
+	if w := d.writer; w != nil {
+		ev.ChannelID = w.channelID
+		if !d.joiner.IsVoiceChannel(w.channelID) {
+			ev.Err = ErrInvalidVoiceChannel
+		} else if !w.Ready() {
+			ev.Err = w.rejoin()
+			ev.Rejoined = ev.Err == nil
+		}
+	}
+	if ev.Err != nil {
+		d.log.Errorf("guild %v: channel %v did not recover after reconnect: %v", d.guildID, ev.ChannelID, ev.Err)
+	} else if ev.Rejoined {
+		d.log.Infof("guild %v: rejoined channel %v after reconnect", d.guildID, ev.ChannelID)
+	}
+	if d.onReconnect != nil {
+		d.onReconnect(ev)
+	}
+}
+
+// Close stops watching the discord session and closes the open Writer, if any.
+func (d *Device) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, remove := range d.removeHandlers {
+		remove()
+	}
+	d.removeHandlers = nil
+	if d.writer == nil {
+		return nil
+	}
+	err := d.writer.Close()
+	d.writer = nil
+	return err
 }
 
 // Open produces an io.Writer interface for sending audio frames to a discord voice channel.
 // Open will recycle the previous Writer if it is still open to the same channel.
+// Open uses the channel of the user the Device follows instead of channelID, if the user is in a voice channel.
 func (d *Device) Open(channelID string) (io.Writer, error) {
-	if !ValidVoiceChannel(d.discord, channelID) {
+	return d.OpenContext(context.Background(), channelID)
+}
+
+// OpenContext is like Open, but gives up joining the channel when ctx is done,
+// and the Writer gives up rejoining the channel after its connection drops once ctx is done,
+// e.g. with a context that is canceled when the player is closed.
+// The Writer uses the context of the latest call to Open or OpenContext that recycled it.
+func (d *Device) OpenContext(ctx context.Context, channelID string) (io.Writer, error) {
+	channelID = d.following(channelID)
+	if !d.joiner.IsVoiceChannel(channelID) {
 		return nil, ErrInvalidVoiceChannel
 	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	if d.writer == nil || d.writer.channelID != channelID || !d.writer.Ready() {
-		vconn, err := d.discord.ChannelVoiceJoin(d.guildID, channelID, false, true)
+		if d.writer != nil {
+			d.writer.retire()
+		}
+		d.log.Debugf("guild %v: joining channel %v", d.guildID, channelID)
+		vconn, err := d.joinContext(ctx, channelID)
 		if err != nil {
 			d.writer = nil
-			return nil, errors.Wrap(err, "failed to join discord channel")
+			err = errors.Wrap(err, "failed to join discord channel")
+			d.log.Errorf("guild %v: %v", d.guildID, err)
+			return nil, err
 		}
 		d.writer = &Writer{
 			guildID:     d.guildID,
 			channelID:   channelID,
 			sendTimeout: d.sendTimeout,
-			discord:     d.discord,
-			vconn:       vconn,
+			joiner:      d.joiner,
+			log:         d.log,
+			metrics:     d.metrics,
+			clock:       d.clock,
+			quiet:       d.quiet,
+			backoff:     d.backoff,
+			done:        make(chan struct{}),
+
+			idleAfter:     d.idleAfter,
+			idleChannelID: d.idleChannelID,
+			dtx:           d.dtx,
+			sealer:        d.sealer,
 		}
+		d.writer.connect(vconn)
 	}
-	d.writer.vconn.Speaking(true)
+	d.writer.mu.Lock()
+	d.writer.ctx = ctx
+	d.writer.mu.Unlock()
 	return d.writer, nil
 }
 
-// Writer
+// Prejoin starts joining a voice channel in the background and returns right away,
+// so the voice connection is ready by the time the player opens the Device to play an item,
+// e.g. while the item is still resolving or downloading, instead of the first frame waiting on the join.
+// The returned channel receives the error joining the channel, nil once the channel is joined.
+// Opening the Device for the channel waits for the join to finish and recycles its Writer.
+func (d *Device) Prejoin(channelID string) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := d.Open(channelID)
+		errc <- err
+	}()
+	return errc
+}
+
+// joinContext joins a voice channel unless ctx is done first,
+// in which case the connection is disconnected once the join finishes in the background.
+func (d *Device) joinContext(ctx context.Context, channelID string) (VoiceConn, error) {
+	type joined struct {
+		vconn VoiceConn
+		err   error
+	}
+	ch := make(chan joined, 1)
+	go func() {
+		vconn, err := d.joiner.ChannelVoiceJoin(d.guildID, channelID, false, true)
+		ch <- joined{vconn, err}
+	}()
+	select {
+	case j := <-ch:
+		return j.vconn, j.err
+	case <-ctx.Done():
+		go func() {
+			if j := <-ch; j.err == nil {
+				j.vconn.Disconnect()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Writer sends opus frames to a discord voice channel.
+// Frames are handed to the voice connection, whose own send loop encrypts and paces them,
+// unless the Device pre-encrypts packets for connections that implement PacketConn.
 type Writer struct {
 	guildID     string
 	channelID   string
 	sendTimeout time.Duration
-	discord     *discordgo.Session
+	joiner      VoiceJoiner
 	mu          sync.Mutex
-	vconn       *discordgo.VoiceConnection
+	vconn       VoiceConn
+	log         player.Logger
+	metrics     player.Metrics
+	clock       clock.Clock
+	// the most recent frames handed to the voice connection, one more than can wait to be sent,
+	// so the ones still queued when the connection drops can be sent again after it is replaced
+	sent [][]byte
+	// when the Writer last finished replacing its own voice connection
+	leftAt time.Time
+	// whether someone else disconnected the bot from the channel
+	disconnected bool
+	// whether the speaking indicator is on, it is turned off once quiet has passed since the last frame
+	speaking   bool
+	quiet      time.Duration
+	quietTimer clock.Timer
+	// send timeout reused by every write
+	sendTimer clock.Timer
+	stats     writerStats
+	backoff   Backoff
+	// rejoining the channel gives up once ctx is done or the Writer is closed
+	ctx       context.Context
+	done      chan struct{}
+	closeOnce sync.Once
+	// whether the Device replaced the Writer, whose voice connection may now belong to the one that replaced it
+	retired bool
+	// whether the Writer left its channel after idleAfter without a Write
+	idle          bool
+	idleAfter     time.Duration
+	idleChannelID string
+	idleTimer     clock.Timer
+	// whether frames of silence are not sent
+	dtx bool
+	// encrypts packets for PacketConns, if at all, numbering them with seq and timestamp,
+	// and hands them to the voice connection if it is one
+	sealer    *Sealer
+	sealed    *sealQueue
+	seq       uint16
+	timestamp uint32
 }
 
 func (w *Writer) Ready() bool {
-	w.vconn.RWMutex.RLock()
-	defer w.vconn.RWMutex.RUnlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.ready()
 }
 
 // check that the channel hasn't changed under our nose
 // e.g. websocket error or a user dragging us into a different channel?
 func (w *Writer) ready() bool {
-	return w.vconn.ChannelID == w.channelID && w.vconn.Ready
+	return !w.disconnected && w.vconn.ChannelID() == w.channelID && w.vconn.Ready()
 }
 
-// TODO writer intelligently calls vconn.Speaking(true/false) before/after writing
+// Write sends an opus frame to the voice channel.
+// If the voice connection dropped, e.g. when discord moved the guild to another voice server,
+// Write rejoins the channel and playback carries on from the same frame instead of the item ending.
+// The speaking indicator is turned on by the first frame after the Writer has been quiet.
 func (w *Writer) Write(p []byte) (n int, err error) {
-	if !w.Ready() {
-		// TODO attempt reconnect, could just skip checking ready and let the channel send timeout
-		err = errors.New("voice connection closed")
-		return
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.disconnected {
+		return 0, ErrDisconnected
+	}
+	if w.idle {
+		w.log.Infof("guild %v: rejoining channel %v after being idle", w.guildID, w.channelID)
+		if err = w.resume(); err != nil {
+			w.log.Errorf("guild %v: failed to rejoin channel %v: %v", w.guildID, w.channelID, err)
+			return 0, errors.Wrap(err, "failed to rejoin discord channel")
+		}
+	} else if !w.ready() {
+		w.log.Infof("guild %v: voice connection dropped, rejoining channel %v", w.guildID, w.channelID)
+		if err = w.resume(); err != nil {
+			w.log.Errorf("guild %v: failed to rejoin channel %v: %v", w.guildID, w.channelID, err)
+			return 0, errors.Wrap(err, "voice connection closed")
+		}
+	}
+	w.active()
+	if w.silent(p) {
+		// the RTP timestamp counts the frames that are not sent, so the gap plays as silence in time
+		w.timestamp += frameSamples
+		return len(p), nil
+	}
+	w.speak()
+	// the voice connection keeps frames queued after Write returns
+	// so it gets its own copy in case the caller reuses p, e.g. pooled frames,
+	// which keep returns to the pool once the connection is done with it
+	frame := player.AllocFrame(len(p))
+	copy(frame, p)
+	if n, err = w.write(frame, true); err != nil {
+		player.FreeFrame(frame)
+	}
+	return n, err
+}
+
+// speak turns on the speaking indicator if it is off and pushes back turning it off until the Writer has been quiet.
+// The caller must hold mu.
+func (w *Writer) speak() {
+	if !w.speaking {
+		w.speaking = true
+		w.stats.since = w.clock.Now()
+		w.vconn.Speaking(true)
+	}
+	if w.quietTimer == nil {
+		w.quietTimer = w.clock.AfterFunc(w.quiet, w.hush)
+	} else {
+		w.quietTimer.Reset(w.quiet)
 	}
+}
+
+// retire stops the timers, packet queue, and rejoining of a Writer the Device replaces with a new one,
+// without disconnecting it or turning off its speaking indicator,
+// since discordgo shares one voice connection per guild between the Writer and the one that replaces it.
+func (w *Writer) retire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.quietTimer != nil {
+		w.quietTimer.Stop()
+	}
+	// a hush that was already due finds the Writer quiet
+	w.speaking = false
+	w.retired = true
+	if w.sealed != nil {
+		w.sealed.close()
+	}
+	if w.idleTimer != nil {
+		w.idleTimer.Stop()
+	}
+	// a leave that was already due finds the Writer closed
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+// hush turns off the speaking indicator once the Writer has been quiet.
+func (w *Writer) hush() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.write(p, true)
+	if w.speaking {
+		w.speaking = false
+		w.stats.expected += w.stats.stretch()
+		w.vconn.Speaking(false)
+	}
 }
 
 func (w *Writer) write(p []byte, retryOnTimeout bool) (n int, err error) {
-	select {
-	case w.vconn.OpusSend <- p:
+	start := w.clock.Now()
+	if w.sendTimer == nil {
+		w.sendTimer = w.clock.NewTimer(w.sendTimeout)
+	} else {
+		w.sendTimer.Reset(w.sendTimeout)
+	}
+	timeout, fired := w.sendTimer, false
+	defer func() {
+		// drain the timer for the next write if it fired and nobody received from it
+		if !timeout.Stop() && !fired {
+			<-timeout.C()
+		}
+	}()
+	if w.send(p, timeout.C()) {
+		w.seq++
+		w.timestamp += frameSamples
+		now := w.clock.Now()
+		w.stats.sent++
+		w.stats.sendWait += now.Sub(start)
+		w.stats.lastSent = now
+		w.keep(p)
 		return len(p), nil
-	case <-time.After(w.sendTimeout):
-		if !retryOnTimeout {
-			err = errors.Errorf("send timeout on voice connection after %v", w.sendTimeout)
-			return 0, err
+	}
+	fired = true
+	w.stats.sendTimeouts++
+	w.metrics.SendTimeout()
+	if !retryOnTimeout {
+		err = errors.Errorf("send timeout on voice connection after %v", w.sendTimeout)
+		return 0, err
+	}
+	w.log.Infof("guild %v: send timeout after %v, reconnecting to channel %v", w.guildID, w.sendTimeout, w.channelID)
+	if err := w.resume(); err != nil {
+		w.log.Errorf("guild %v: failed to reconnect to channel %v: %v", w.guildID, w.channelID, err)
+		return 0, err
+	}
+	return w.write(p, false)
+}
+
+// send hands a frame to the voice connection, or its encrypted RTP packet if the Writer pre-encrypts packets for the connection,
+// reporting false if the connection had no room for it before timeout fired.
+func (w *Writer) send(p []byte, timeout <-chan time.Time) bool {
+	if w.sealed != nil {
+		return w.sealed.push(rtpHeader(w.seq, w.timestamp, w.sealed.conn.SSRC()), p, timeout)
+	}
+	select {
+	case w.vconn.OpusSend() <- p:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+// connect makes vconn the Writer's voice connection,
+// handing packets to it through a new sealQueue if the Writer pre-encrypts packets for it.
+// The caller must hold mu.
+func (w *Writer) connect(vconn VoiceConn) {
+	if w.sealed != nil {
+		w.sealed.close()
+		w.sealed = nil
+	}
+	w.vconn = vconn
+	if pc, ok := vconn.(PacketConn); ok && w.sealer != nil {
+		w.sealed = newSealQueue(w.sealer, pc)
+	}
+}
+
+// queue is the channel of the voice connection that frames wait in until they are sent.
+func (w *Writer) queue() chan<- []byte {
+	if w.sealed != nil {
+		return w.sealed.conn.PacketSend()
+	}
+	return w.vconn.OpusSend()
+}
+
+// queued is how many frames wait to be sent, including frames waiting to be encrypted.
+func (w *Writer) queued() int {
+	n := len(w.queue())
+	if w.sealed != nil {
+		n += w.sealed.len()
+	}
+	return n
+}
+
+// capacity is how many frames can wait to be sent, including frames waiting to be encrypted
+// and the frame whose packet waits for room in the voice connection.
+func (w *Writer) capacity() int {
+	n := cap(w.queue())
+	if w.sealed != nil {
+		n += cap(w.sealed.pending) + 1
+	}
+	return n
+}
+
+// keep remembers a frame handed to the voice connection, forgetting frames it can no longer have queued.
+// A forgotten frame is returned to the frame pool once more frames than can wait to be sent were handed over after it,
+// so the connection has taken a later frame off its queue and is done with it.
+func (w *Writer) keep(p []byte) {
+	w.sent = append(w.sent, p)
+	if max := w.capacity() + 1; len(w.sent) > max {
+		for _, frame := range w.sent[:len(w.sent)-max] {
+			player.FreeFrame(frame)
 		}
-		vconn, err := w.reconnect()
-		if err != nil {
-			return 0, err
+		w.sent = append(w.sent[:0], w.sent[len(w.sent)-max:]...)
+	}
+}
+
+// resume replaces the voice connection with a new one to the same channel
+// and sends the frames that were still queued in the old connection again,
+// so the listeners do not miss the audio that was waiting when the connection dropped.
+func (w *Writer) resume() error {
+	if w.disconnected {
+		return ErrDisconnected
+	}
+	queued := w.queued()
+	if queued > len(w.sent) {
+		queued = len(w.sent)
+	}
+	unsent := make([][]byte, queued)
+	copy(unsent, w.sent[len(w.sent)-queued:])
+	w.sent = w.sent[:len(w.sent)-queued]
+
+	vconn, err := w.reconnect()
+	if err != nil {
+		return err
+	}
+	w.connect(vconn)
+	w.idle = false
+	if w.speaking {
+		vconn.Speaking(true)
+	}
+	for _, frame := range unsent {
+		if _, err := w.write(frame, false); err != nil {
+			return err
 		}
-		w.vconn = vconn
-		return w.write(p, false)
 	}
+	return nil
 }
 
-func (w *Writer) reconnect() (*discordgo.VoiceConnection, error) {
+// Silence implements player.SilencingDevice.
+// Discord clients interpolate the last frames they received when audio stops abruptly,
+// so the Writer sends frames of silence whenever the player pauses or finishes an item.
+func (w *Writer) Silence() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.ready() {
+		return nil
+	}
+	w.speak()
+	for i := 0; i < silenceFrames; i++ {
+		frame := player.AllocFrame(len(silenceFrame))
+		copy(frame, silenceFrame)
+		if _, err := w.write(frame, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buffered implements player.BufferedDevice.
+// Frames wait in the voice connection's send channel until they are sent,
+// the target keeps the channel one frame short of full so Write does not block.
+func (w *Writer) Buffered() (queued time.Duration, target time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	send := w.queue()
+	queued = time.Duration(w.queued()) * frameDuration
+	target = time.Duration(cap(send)-1) * frameDuration
+	if target < frameDuration {
+		target = frameDuration
+	}
+	return
+}
+
+// DeviceInfo implements player.DeviceInfo.
+func (w *Writer) DeviceInfo() player.DeviceCaps {
+	return player.DeviceCaps{
+		Opus:          true,
+		FrameDuration: frameDuration,
+		Realtime:      true,
+	}
+}
+
+// rejoin replaces the voice connection with a new one to the same channel.
+func (w *Writer) rejoin() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// an idle Writer rejoins when it is written to
+	if w.idle {
+		return nil
+	}
+	if err := w.resume(); err != nil {
+		return errors.Wrap(err, "failed to rejoin discord channel")
+	}
+	return nil
+}
+
+func (w *Writer) reconnect() (VoiceConn, error) {
+	w.stats.reconnects++
 	w.vconn.Disconnect()
-	return w.discord.ChannelVoiceJoin(w.guildID, w.channelID, false, true)
+	vconn, err := w.join()
+	// joining may take a while, the echo of disconnecting arrives any time in between
+	w.leftAt = w.clock.Now()
+	return vconn, err
 }
 
+// Close turns off the speaking indicator and disconnects from the voice channel.
+// Closing a Writer the Device has replaced with a new one leaves the voice connection alone,
+// e.g. a player closing the device of an item whose open it gave up on.
 func (w *Writer) Close() error {
+	// interrupt rejoining the channel, which holds mu
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.retired {
+		return nil
+	}
+	if w.quietTimer != nil {
+		w.quietTimer.Stop()
+	}
+	if w.idleTimer != nil {
+		w.idleTimer.Stop()
+	}
+	w.speaking = false
+	if w.sealed != nil {
+		w.sealed.close()
+	}
 	w.vconn.Speaking(false)
 	return w.vconn.Disconnect()
 }
@@ -128,3 +716,8 @@ func ValidVoiceChannel(discord *discordgo.Session, channelID string) bool {
 	discord.State.ChannelAdd(channel)
 	return channel.Type == discordgo.ChannelTypeGuildVoice
 }
+
+// do not compile unless Writer implements player.BufferedDevice and player.DeviceInfo
+var _ player.BufferedDevice = &Writer{}
+var _ player.DeviceInfo = &Writer{}
+var _ player.SilencingDevice = &Writer{}
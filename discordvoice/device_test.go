@@ -0,0 +1,938 @@
+package discordvoice
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Unix(0, 0)
+
+// joins fail right away instead of waiting to attempt again
+var onceBackoff = ReconnectBackoff(Backoff{Attempts: 1})
+
+// receive takes the next frame sent to a connection, failing the test if none arrives.
+func receive(t *testing.T, vconn *FakeVoiceConn) []byte {
+	select {
+	case frame := <-vconn.Frames():
+		return frame
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected a frame to be sent")
+		return nil
+	}
+}
+
+// waitFor polls cond, failing the test if it is not true soon,
+// for changes made by AfterFunc timers, which run in their own goroutine.
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			require.FailNow(t, msg)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriterSendTimeout(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(1, "channel")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), QuietPeriod(time.Hour), onceBackoff)
+	defer d.Close()
+
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	n, err := w.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// nobody drains the first connection, so the next frame does not fit
+	type written struct {
+		n   int
+		err error
+	}
+	done := make(chan written, 1)
+	go func() {
+		n, err := w.Write([]byte("b"))
+		done <- written{n, err}
+	}()
+	// the quiet timer and the send timeout
+	clk.BlockUntil(2)
+	clk.Advance(999 * time.Millisecond)
+	assert.Len(t, joiner.Joined(), 1, "expected no reconnect before the send timeout")
+	clk.Advance(time.Millisecond)
+
+	waitFor(t, func() bool { return len(joiner.Joined()) == 2 }, "expected the Writer to reconnect after the send timeout")
+	joined := joiner.Joined()
+	// the frame that was still queued in the stale connection is sent again first
+	assert.Equal(t, []byte("a"), receive(t, joined[1]))
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, 1, res.n)
+	assert.Equal(t, []byte("b"), receive(t, joined[1]))
+	assert.True(t, joined[0].Disconnected(), "expected the stale connection to be disconnected")
+	assert.True(t, joined[1].IsSpeaking(), "expected the new connection to keep speaking")
+}
+
+func TestWriterReconnect(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), onceBackoff)
+	defer d.Close()
+	events := make(chan ReconnectEvent, 1)
+	d.OnReconnect(func(ev ReconnectEvent) {
+		events <- ev
+	})
+
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+	assert.Equal(t, []byte("a"), receive(t, conn))
+
+	// the connection drops in between frames
+	conn.SetReady(false)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	joined := joiner.Joined()
+	require.Len(t, joined, 2, "expected Write to rejoin the channel")
+	assert.True(t, conn.Disconnected())
+	assert.Equal(t, []byte("b"), receive(t, joined[1]))
+
+	// the connection drops while a frame is still queued, e.g. during a gateway resume
+	_, err = w.Write([]byte("c"))
+	require.NoError(t, err)
+	joined[1].SetReady(false)
+	d.Reconnected(true)
+	assert.Equal(t, ReconnectEvent{Resumed: true, ChannelID: "channel", Rejoined: true}, <-events)
+	joined = joiner.Joined()
+	require.Len(t, joined, 3, "expected the Device to rejoin the channel after reconnecting")
+	assert.Equal(t, []byte("c"), receive(t, joined[2]), "expected the queued frame to be sent again")
+
+	// a connection that is still ready is kept
+	d.Reconnected(false)
+	assert.Equal(t, ReconnectEvent{ChannelID: "channel"}, <-events)
+	assert.Len(t, joiner.Joined(), 3)
+
+	// opening the same channel recycles the Writer
+	w2, err := d.Open("channel")
+	require.NoError(t, err)
+	assert.Equal(t, w, w2)
+	assert.Len(t, joiner.Joined(), 3)
+}
+
+func TestWriterMoved(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel", "other", "third")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), onceBackoff)
+	defer d.Close()
+	events := make(chan MoveEvent, 1)
+	d.OnMoved(func(ev MoveEvent) {
+		events <- ev
+	})
+
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+
+	// someone drags the bot into another channel
+	conn.Move("other")
+	d.Moved("other")
+	assert.Equal(t, MoveEvent{From: "channel", To: "other"}, <-events)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), receive(t, conn))
+	assert.Len(t, joiner.Joined(), 1, "expected the Writer to keep playing in the channel it was moved to")
+
+	// moving on purpose does not report a move
+	require.NoError(t, d.Move("third"))
+	joined := joiner.Joined()
+	require.Len(t, joined, 2)
+	assert.Equal(t, "third", joined[1].ChannelID())
+	assert.True(t, conn.Disconnected())
+	d.Moved("")
+	assert.Len(t, events, 0, "expected the echo of leaving the old channel to be ignored")
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b"), receive(t, joined[1]))
+
+	assert.Equal(t, ErrInvalidVoiceChannel, d.Move("missing"))
+
+	// someone disconnects the bot
+	clk.Advance(leaveGrace)
+	d.Moved("")
+	assert.Equal(t, MoveEvent{From: "third"}, <-events)
+	_, err = w.Write([]byte("c"))
+	assert.Equal(t, ErrDisconnected, err)
+	assert.Len(t, joiner.Joined(), 2, "expected the Writer not to rejoin after being disconnected")
+}
+
+func TestWriterSpeaking(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(10, "channel")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), onceBackoff)
+
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+	assert.False(t, conn.IsSpeaking())
+
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.True(t, conn.IsSpeaking(), "expected the first frame to turn on the speaking indicator")
+
+	clk.Advance(400 * time.Millisecond)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	clk.Advance(400 * time.Millisecond)
+	assert.True(t, conn.IsSpeaking(), "expected each frame to push back turning off the speaking indicator")
+	clk.Advance(100 * time.Millisecond)
+	waitFor(t, func() bool { return !conn.IsSpeaking() }, "expected the speaking indicator to turn off once quiet")
+
+	_, err = w.Write([]byte("c"))
+	require.NoError(t, err)
+	assert.True(t, conn.IsSpeaking(), "expected the speaking indicator to turn on again")
+
+	require.NoError(t, d.Close())
+	assert.False(t, conn.IsSpeaking(), "expected Close to turn off the speaking indicator")
+	assert.True(t, conn.Disconnected())
+}
+
+// sharedJoiner joins every channel of the guild with the same connection, moving it between channels, like discordgo.
+type sharedJoiner struct {
+	*FakeJoiner
+	mu    sync.Mutex
+	vconn *FakeVoiceConn
+}
+
+func (j *sharedJoiner) ChannelVoiceJoin(guildID string, channelID string, mute bool, deaf bool) (VoiceConn, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.vconn == nil {
+		vconn, err := j.FakeJoiner.ChannelVoiceJoin(guildID, channelID, mute, deaf)
+		if err != nil {
+			return nil, err
+		}
+		j.vconn = vconn.(*FakeVoiceConn)
+		return j.vconn, nil
+	}
+	if !j.IsVoiceChannel(channelID) {
+		return nil, ErrInvalidVoiceChannel
+	}
+	j.vconn.Move(channelID)
+	return j.vconn, nil
+}
+
+func TestWriterSwitchChannel(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := &sharedJoiner{FakeJoiner: NewFakeJoiner(10, "channel", "other")}
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), onceBackoff)
+	defer d.Close()
+
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	conn := joiner.vconn
+	assert.True(t, conn.IsSpeaking())
+
+	// the item moves to another channel while the frame is spoken
+	clk.Advance(400 * time.Millisecond)
+	w, err = d.Open("other")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, "other", conn.ChannelID())
+	assert.Equal(t, 1, clk.Waiters(), "expected the replaced Writer's quiet timer to be stopped")
+	clk.Advance(100 * time.Millisecond)
+	assert.True(t, conn.IsSpeaking(), "expected the replaced Writer not to turn off the shared speaking indicator")
+	clk.Advance(400 * time.Millisecond)
+	waitFor(t, func() bool { return !conn.IsSpeaking() }, "expected the speaking indicator to turn off once the new Writer is quiet")
+}
+
+func TestWriterCloseReplaced(t *testing.T) {
+	t.Parallel()
+	joiner := &sharedJoiner{FakeJoiner: NewFakeJoiner(10, "channel", "other")}
+	d := NewDevice(joiner, "guild", time.Second)
+	defer d.Close()
+
+	old, err := d.Open("channel")
+	require.NoError(t, err)
+	w, err := d.Open("other")
+	require.NoError(t, err)
+
+	// e.g. a player closing the device of an item whose open timed out after the next item opened the Device
+	require.NoError(t, old.(io.Closer).Close())
+	assert.True(t, w.(*Writer).Ready(), "expected closing the replaced Writer to leave the shared connection alone")
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), receive(t, joiner.vconn))
+}
+
+func TestWriterAllocs(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(4, "channel")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), QuietPeriod(time.Hour))
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+
+	// the copy of each frame reuses the buffers of frames the connection is done with
+	frame := append([]byte{0xFC}, make([]byte, 99)...)
+	var received []byte
+	allocs := testing.AllocsPerRun(1000, func() {
+		if _, err := w.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		received = <-conn.Frames()
+	})
+	assert.Equal(t, frame, received)
+	assert.True(t, allocs < 1, "expected writes not to allocate a copy of every frame, %v allocations per write", allocs)
+}
+
+func TestWriterFailJoins(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), onceBackoff)
+	defer d.Close()
+	events := make(chan ReconnectEvent, 1)
+	d.OnReconnect(func(ev ReconnectEvent) {
+		events <- ev
+	})
+
+	outage := errors.New("outage")
+	joiner.FailJoins(outage)
+	_, err := d.Open("channel")
+	require.Error(t, err)
+	assert.Equal(t, outage, errors.Cause(err))
+
+	joiner.FailJoins(nil)
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+
+	// the channel cannot be rejoined after reconnecting
+	joiner.FailJoins(outage)
+	conn.SetReady(false)
+	d.Reconnected(false)
+	ev := <-events
+	assert.Equal(t, "channel", ev.ChannelID)
+	assert.False(t, ev.Rejoined)
+	assert.Equal(t, outage, errors.Cause(ev.Err))
+	_, err = w.Write([]byte("a"))
+	assert.Equal(t, outage, errors.Cause(err))
+
+	// the Writer rejoins once joins succeed again
+	joiner.FailJoins(nil)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	joined := joiner.Joined()
+	require.Len(t, joined, 2)
+	assert.Equal(t, []byte("b"), receive(t, joined[1]))
+
+	// the channel was deleted during the outage
+	joiner.RemoveChannel("channel")
+	d.Reconnected(false)
+	assert.Equal(t, ReconnectEvent{ChannelID: "channel", Err: ErrInvalidVoiceChannel}, <-events)
+}
+
+func TestDeviceFollow(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel", "other", "third")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), onceBackoff)
+	defer d.Close()
+	moves := make(chan MoveEvent, 1)
+	d.OnMoved(func(ev MoveEvent) {
+		moves <- ev
+	})
+
+	// the followed user is not in voice, so the Writer opens in its own channel
+	d.Follow("dj")
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+	assert.Equal(t, "channel", conn.ChannelID())
+	d.UserMoved("listener", "other")
+	assert.Len(t, joiner.Joined(), 1, "expected the Writer not to follow other users")
+
+	// the Writer follows the user mid-playback, sending the frame still queued in the old channel again
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	d.UserMoved("dj", "other")
+	joined := joiner.Joined()
+	require.Len(t, joined, 2)
+	assert.Equal(t, "other", joined[1].ChannelID())
+	assert.True(t, conn.Disconnected())
+	assert.Equal(t, []byte("a"), receive(t, joined[1]))
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b"), receive(t, joined[1]))
+	d.Moved("")
+	assert.Len(t, moves, 0, "expected following a user not to report a move")
+
+	// the Writer stays when the user leaves voice, and opening the Device uses the user's channel while they are in one
+	d.UserMoved("dj", "")
+	assert.Len(t, joiner.Joined(), 2)
+	d.UserMoved("dj", "third")
+	joined = joiner.Joined()
+	require.Len(t, joined, 3)
+	assert.Equal(t, "third", joined[2].ChannelID())
+	w2, err := d.Open("channel")
+	require.NoError(t, err)
+	assert.Equal(t, w, w2, "expected Open to recycle the Writer in the user's channel")
+	assert.Len(t, joiner.Joined(), 3)
+
+	// stop following
+	d.Follow("")
+	d.UserMoved("dj", "other")
+	assert.Len(t, joiner.Joined(), 3)
+	_, err = d.Open("channel")
+	require.NoError(t, err)
+	joined = joiner.Joined()
+	require.Len(t, joined, 4)
+	assert.Equal(t, "channel", joined[3].ChannelID())
+}
+
+func TestDeviceFollowVoiceState(t *testing.T) {
+	t.Parallel()
+	joiner := NewFakeJoiner(2, "channel", "other")
+	d := NewDevice(joiner, "guild", time.Second, onceBackoff)
+	defer d.Close()
+	// where users are, as a discord session's state would report it
+	d.voiceState = func(userID string) string {
+		if userID == "dj" {
+			return "other"
+		}
+		return ""
+	}
+
+	// a user who is already in voice is found by Follow, before the Device hears of them moving
+	d.Follow("dj")
+	_, err := d.Open("channel")
+	require.NoError(t, err)
+	joined := joiner.Joined()
+	require.Len(t, joined, 1)
+	assert.Equal(t, "other", joined[0].ChannelID())
+
+	d.Follow("listener")
+	_, err = d.Open("channel")
+	require.NoError(t, err)
+	joined = joiner.Joined()
+	require.Len(t, joined, 2)
+	assert.Equal(t, "channel", joined[1].ChannelID())
+}
+
+func TestWriterDisconnected(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(1, "channel", "other")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), QuietPeriod(time.Hour), onceBackoff)
+	defer d.Close()
+	events := make(chan MoveEvent, 1)
+	d.OnMoved(func(ev MoveEvent) {
+		events <- ev
+	})
+	// without a Writer there is nothing to report
+	d.Moved("")
+	assert.Len(t, events, 0)
+
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+
+	// the Writer reconnects after a send timeout, and leaving its stale connection is echoed back
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("b"))
+		done <- err
+	}()
+	clk.BlockUntil(2)
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return len(joiner.Joined()) == 2 }, "expected the Writer to reconnect after the send timeout")
+	joined := joiner.Joined()
+	assert.Equal(t, []byte("a"), receive(t, joined[1]))
+	require.NoError(t, <-done)
+	assert.Equal(t, []byte("b"), receive(t, joined[1]))
+	clk.Advance(leaveGrace - time.Millisecond)
+	d.Moved("")
+	assert.Len(t, events, 0, "expected the echo of the reconnect to be ignored")
+	_, err = w.Write([]byte("c"))
+	require.NoError(t, err, "expected the Writer to keep playing after the echo")
+	assert.Equal(t, []byte("c"), receive(t, joined[1]))
+
+	// after the grace period the same voice state means someone else disconnected the bot
+	clk.Advance(time.Millisecond)
+	d.Moved("")
+	assert.Equal(t, MoveEvent{From: "channel"}, <-events)
+	d.Moved("")
+	assert.Len(t, events, 0, "expected a disconnect to be reported once")
+	_, err = w.Write([]byte("d"))
+	assert.Equal(t, ErrDisconnected, err)
+	assert.False(t, w.(*Writer).Ready())
+	d.Reconnected(true)
+	assert.Len(t, joiner.Joined(), 2, "expected a disconnected Writer not to rejoin after a reconnect")
+
+	// moving on purpose plays again
+	require.NoError(t, d.Move("other"))
+	joined = joiner.Joined()
+	require.Len(t, joined, 3)
+	assert.Equal(t, "other", joined[2].ChannelID())
+	_, err = w.Write([]byte("e"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("e"), receive(t, joined[2]))
+
+	// as does opening the Device again after another disconnect
+	clk.Advance(leaveGrace)
+	d.Moved("")
+	assert.Equal(t, MoveEvent{From: "other"}, <-events)
+	w2, err := d.Open("channel")
+	require.NoError(t, err)
+	assert.NotEqual(t, w, w2, "expected a new Writer")
+	_, err = w2.Write([]byte("f"))
+	require.NoError(t, err)
+	joined = joiner.Joined()
+	require.Len(t, joined, 4)
+	assert.Equal(t, []byte("f"), receive(t, joined[3]))
+}
+
+func TestDeviceBitrate(t *testing.T) {
+	t.Parallel()
+	joiner := NewFakeJoiner(2, "channel", "boosted", "low", "unset")
+	joiner.SetBitrate("channel", 96000)
+	joiner.SetBitrate("boosted", 512000)
+	joiner.SetBitrate("low", 1000)
+	d := NewDevice(joiner, "guild", time.Second)
+	defer d.Close()
+
+	// bitrates are clamped to the range discord allows
+	for channelID, want := range map[string]int{"channel": 96, "boosted": maxBitrate, "low": minBitrate} {
+		kbps, ok := d.Bitrate(channelID)
+		assert.True(t, ok)
+		assert.Equal(t, want, kbps, "expected the bitrate of %v", channelID)
+	}
+	for _, channelID := range []string{"unset", "missing"} {
+		_, ok := d.Bitrate(channelID)
+		assert.False(t, ok, "expected the bitrate of %v to be unknown", channelID)
+	}
+	// the VoiceJoiner does not look up bitrates
+	plain := NewDevice(struct{ VoiceJoiner }{joiner}, "guild", time.Second)
+	_, ok := plain.Bitrate("channel")
+	assert.False(t, ok)
+
+	// the encoder gets a copy of the options with the bitrate of the channel
+	std := *dca.StdEncodeOptions
+	opts := d.EncodeOptions("channel", nil)
+	assert.Equal(t, 96, opts.Bitrate)
+	assert.Equal(t, std, *dca.StdEncodeOptions, "expected the standard options to be left alone")
+	assert.Equal(t, std.FrameRate, opts.FrameRate)
+	assert.Contains(t, strings.Join(fakeFFmpeg.args("pipe:0", opts), " "), "-b:a 96000")
+	custom := &dca.EncodeOptions{Bitrate: 32, Volume: 256}
+	assert.Equal(t, maxBitrate, d.EncodeOptions("boosted", custom).Bitrate)
+	assert.Equal(t, 32, custom.Bitrate)
+	assert.Equal(t, 32, d.EncodeOptions("unset", custom).Bitrate, "expected the bitrate of the options when the channel's is unknown")
+	assert.Equal(t, 32, plain.EncodeOptions("channel", custom).Bitrate)
+}
+
+func TestDevicePacketLoss(t *testing.T) {
+	t.Parallel()
+	joiner := NewFakeJoiner(2, "channel")
+	custom := &dca.EncodeOptions{Bitrate: 64, PacketLoss: 5}
+
+	// the packet loss of the options is kept by default
+	d := NewDevice(joiner, "guild", time.Second)
+	assert.Equal(t, dca.StdEncodeOptions.PacketLoss, d.EncodeOptions("channel", nil).PacketLoss)
+	assert.Equal(t, 5, d.EncodeOptions("channel", custom).PacketLoss)
+	// and so are out of range percentages
+	d = NewDevice(joiner, "guild", time.Second, PacketLoss(101))
+	assert.Equal(t, 5, d.EncodeOptions("channel", custom).PacketLoss)
+
+	d = NewDevice(joiner, "guild", time.Second, PacketLoss(15))
+	opts := d.EncodeOptions("channel", custom)
+	assert.Equal(t, 15, opts.PacketLoss)
+	assert.Equal(t, 5, custom.PacketLoss, "expected the options to be left alone")
+	assert.Contains(t, strings.Join(fakeFFmpeg.args("pipe:0", opts), " "), "-packet_loss 15")
+	d = NewDevice(joiner, "guild", time.Second, PacketLoss(0))
+	assert.Equal(t, 0, d.EncodeOptions("channel", custom).PacketLoss)
+}
+
+// latencyJoiner is a FakeJoiner that measures the round trip time of its gateway connection.
+type latencyJoiner struct {
+	*FakeJoiner
+	rtt time.Duration
+}
+
+func (j latencyJoiner) Latency() time.Duration {
+	return j.rtt
+}
+
+func TestWriterStats(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel")
+	d := NewDevice(latencyJoiner{joiner, 42 * time.Millisecond}, "guild", time.Second, Clock(clk), QuietPeriod(100*time.Millisecond), onceBackoff)
+	defer d.Close()
+	open, err := d.Open("channel")
+	require.NoError(t, err)
+	w := open.(*Writer)
+	assert.Equal(t, WriterStats{GatewayLatency: 42 * time.Millisecond}, w.Stats())
+	write := func(frame string) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := w.Write([]byte(frame))
+			done <- err
+		}()
+		return done
+	}
+
+	// the third frame waits 20ms for room in the connection
+	require.NoError(t, <-write("a"))
+	require.NoError(t, <-write("b"))
+	done := write("c")
+	clk.BlockUntil(2)
+	clk.Advance(20 * time.Millisecond)
+	conn := joiner.Joined()[0]
+	assert.Equal(t, []byte("a"), receive(t, conn))
+	require.NoError(t, <-done)
+	assert.Equal(t, WriterStats{
+		FramesSent:     3,
+		FramesExpected: 2,
+		SendWait:       20 * time.Millisecond / 3,
+		GatewayLatency: 42 * time.Millisecond,
+	}, w.Stats())
+
+	// a frame is written 20ms late, the frames expected count from when the Writer started speaking until it stopped
+	clk.Advance(40 * time.Millisecond)
+	assert.Equal(t, []byte("b"), receive(t, conn))
+	require.NoError(t, <-write("d"))
+	stats := w.Stats()
+	assert.Equal(t, int64(4), stats.FramesSent)
+	assert.Equal(t, int64(4), stats.FramesExpected)
+	clk.Advance(100 * time.Millisecond)
+	waitFor(t, func() bool { return !conn.IsSpeaking() }, "expected the speaking indicator to turn off once quiet")
+	clk.Advance(time.Minute)
+	assert.Equal(t, int64(4), w.Stats().FramesExpected, "expected no frames while the Writer is quiet")
+
+	// a send times out, so the Writer reconnects and sends the two queued frames again
+	done = write("e")
+	clk.BlockUntil(2)
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return len(joiner.Joined()) == 2 }, "expected the Writer to reconnect after the send timeout")
+	assert.Equal(t, []byte("c"), receive(t, joiner.Joined()[1]))
+	require.NoError(t, <-done)
+	stats = w.Stats()
+	assert.Equal(t, int64(7), stats.FramesSent)
+	assert.Equal(t, int64(1), stats.SendTimeouts)
+	assert.Equal(t, int64(1), stats.Reconnects)
+
+	// the plain FakeJoiner does not measure the round trip
+	plain := NewDevice(joiner, "guild", time.Second)
+	defer plain.Close()
+	open, err = plain.Open("channel")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), open.(*Writer).Stats().GatewayLatency)
+}
+
+func TestBackoffDelay(t *testing.T) {
+	t.Parallel()
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 2}
+	var delays []time.Duration
+	for attempt := 1; attempt <= 5; attempt++ {
+		delays = append(delays, b.delay(attempt))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	// a constant delay without a multiplier, and no limit without a max
+	assert.Equal(t, time.Second, Backoff{Initial: time.Second}.delay(10))
+	assert.Equal(t, 1024*time.Second, Backoff{Initial: time.Second, Multiplier: 2}.delay(11))
+
+	b.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		d := b.delay(2)
+		assert.True(t, d >= 1600*time.Millisecond && d <= 2400*time.Millisecond, "expected 2s within 20%%, not %v", d)
+	}
+}
+
+func TestWriterBackoff(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel")
+	type attempt struct {
+		n   int
+		err error
+	}
+	attempts := make(chan attempt, 10)
+	backoff := Backoff{Attempts: 3, Initial: time.Second, Multiplier: 2, OnAttempt: func(n int, err error) {
+		attempts <- attempt{n, err}
+	}}
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), QuietPeriod(time.Hour), ReconnectBackoff(backoff))
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	write := func(frame string) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := w.Write([]byte(frame))
+			done <- err
+		}()
+		return done
+	}
+
+	// the channel is joined on the third attempt, a second and then two seconds after the first
+	outage := errors.New("outage")
+	joiner.FailJoins(outage)
+	joiner.Joined()[0].SetReady(false)
+	done := write("a")
+	clk.BlockUntil(1)
+	assert.Equal(t, attempt{1, nil}, <-attempts)
+	clk.Advance(999 * time.Millisecond)
+	assert.Len(t, attempts, 0)
+	clk.Advance(time.Millisecond)
+	clk.BlockUntil(1)
+	assert.Equal(t, attempt{2, outage}, <-attempts)
+	joiner.FailJoins(nil)
+	clk.Advance(1999 * time.Millisecond)
+	assert.Len(t, attempts, 0)
+	clk.Advance(time.Millisecond)
+	assert.Equal(t, attempt{3, outage}, <-attempts)
+	require.NoError(t, <-done)
+	joined := joiner.Joined()
+	require.Len(t, joined, 2)
+	assert.Equal(t, []byte("a"), receive(t, joined[1]))
+
+	// the Writer gives up after the last attempt, the quiet timer waits alongside the backoff from now on
+	joiner.FailJoins(outage)
+	joined[1].SetReady(false)
+	done = write("b")
+	for i := 0; i < 2; i++ {
+		clk.BlockUntil(2)
+		clk.Advance(time.Minute)
+	}
+	assert.Equal(t, outage, errors.Cause(<-done))
+	assert.Len(t, attempts, 3)
+
+	// closing the Writer interrupts the backoff
+	done = write("c")
+	clk.BlockUntil(2)
+	require.NoError(t, d.Close())
+	assert.Equal(t, ErrWriterClosed, errors.Cause(<-done))
+}
+
+func TestWriterIdle(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel", "other")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), QuietPeriod(time.Hour), IdleDisconnect(time.Minute, ""), onceBackoff)
+	defer d.Close()
+	events := make(chan MoveEvent, 1)
+	d.OnMoved(func(ev MoveEvent) {
+		events <- ev
+	})
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+
+	// each Write pushes back leaving the channel
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	clk.Advance(59 * time.Second)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	clk.Advance(59 * time.Second)
+	assert.False(t, conn.Disconnected())
+	assert.Equal(t, []byte("a"), receive(t, conn))
+	assert.Equal(t, []byte("b"), receive(t, conn))
+	clk.Advance(time.Second)
+	waitFor(t, conn.Disconnected, "expected the Writer to leave its channel once idle")
+
+	// leaving is not mistaken for being disconnected, and an idle Writer stays out of its channel after a reconnect
+	clk.Advance(leaveGrace)
+	d.Moved("")
+	assert.Len(t, events, 0)
+	d.Reconnected(true)
+	assert.Len(t, joiner.Joined(), 1)
+
+	// the next Write rejoins the channel
+	_, err = w.Write([]byte("c"))
+	require.NoError(t, err)
+	joined := joiner.Joined()
+	require.Len(t, joined, 2)
+	assert.Equal(t, "channel", joined[1].ChannelID())
+	assert.Equal(t, []byte("c"), receive(t, joined[1]))
+
+	// the next Open joins the channel it is given
+	clk.Advance(time.Minute)
+	waitFor(t, joined[1].Disconnected, "expected the Writer to leave its channel once idle")
+	_, err = d.Open("other")
+	require.NoError(t, err)
+	joined = joiner.Joined()
+	require.Len(t, joined, 3)
+	assert.Equal(t, "other", joined[2].ChannelID())
+}
+
+func TestWriterIdleChannel(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := NewFakeJoiner(2, "channel", "afk")
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), QuietPeriod(time.Hour), IdleDisconnect(time.Minute, "afk"), onceBackoff)
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	conn := joiner.Joined()[0]
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), receive(t, conn))
+
+	// the idle Writer moves to the idle channel, and back when it is written to
+	clk.Advance(time.Minute)
+	waitFor(t, func() bool { return len(joiner.Joined()) == 2 }, "expected the Writer to move to the idle channel")
+	joined := joiner.Joined()
+	assert.Equal(t, "afk", joined[1].ChannelID())
+	assert.False(t, conn.Disconnected(), "expected the idle channel to be joined in place of the channel")
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	joined = joiner.Joined()
+	require.Len(t, joined, 3)
+	assert.Equal(t, "channel", joined[2].ChannelID())
+	assert.True(t, joined[1].Disconnected())
+	assert.Equal(t, []byte("b"), receive(t, joined[2]))
+
+	// the Writer disconnects if it cannot join the idle channel
+	joiner.FailJoins(errors.New("outage"))
+	clk.Advance(time.Minute)
+	waitFor(t, joined[2].Disconnected, "expected the Writer to leave its channel once idle")
+	assert.Len(t, joiner.Joined(), 3)
+}
+
+func TestWriterIdleSwitchChannel(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(epoch)
+	joiner := &sharedJoiner{FakeJoiner: NewFakeJoiner(10, "channel", "other")}
+	d := NewDevice(joiner, "guild", time.Second, Clock(clk), QuietPeriod(time.Hour), IdleDisconnect(time.Minute, ""), onceBackoff)
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	conn := joiner.vconn
+
+	// the replaced Writer does not leave the connection the new Writer plays in
+	clk.Advance(30 * time.Second)
+	w, err = d.Open("other")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, clk.Waiters(), "expected the replaced Writer's idle timer to be stopped")
+	clk.Advance(30 * time.Second)
+	assert.False(t, conn.Disconnected())
+	clk.Advance(30 * time.Second)
+	waitFor(t, conn.Disconnected, "expected the new Writer to leave its channel once idle")
+}
+
+// slowJoiner is a FakeJoiner whose joins wait until release is closed, like joins waiting on the voice gateway.
+type slowJoiner struct {
+	*FakeJoiner
+	joining chan string
+	release chan struct{}
+}
+
+func (j *slowJoiner) ChannelVoiceJoin(guildID string, channelID string, mute bool, deaf bool) (VoiceConn, error) {
+	j.joining <- channelID
+	<-j.release
+	return j.FakeJoiner.ChannelVoiceJoin(guildID, channelID, mute, deaf)
+}
+
+func TestDeviceOpenContext(t *testing.T) {
+	t.Parallel()
+	joiner := &slowJoiner{FakeJoiner: NewFakeJoiner(2, "channel"), joining: make(chan string, 1), release: make(chan struct{})}
+	d := NewDevice(joiner, "guild", time.Second, onceBackoff)
+	defer d.Close()
+
+	// canceling the context gives up on a pending join
+	ctx, cancel := context.WithCancel(context.Background())
+	type opened struct {
+		w   io.Writer
+		err error
+	}
+	done := make(chan opened, 1)
+	go func() {
+		w, err := d.OpenContext(ctx, "channel")
+		done <- opened{w, err}
+	}()
+	assert.Equal(t, "channel", <-joiner.joining)
+	cancel()
+	res := <-done
+	assert.Equal(t, context.Canceled, errors.Cause(res.err))
+	assert.Nil(t, res.w)
+
+	// the connection is disconnected once the join finishes in the background
+	close(joiner.release)
+	waitFor(t, func() bool { return len(joiner.Joined()) == 1 }, "expected the pending join to finish")
+	waitFor(t, joiner.Joined()[0].Disconnected, "expected the late connection to be disconnected")
+
+	// the Writer gives up rejoining once the context of its Open is done
+	ctx, cancel = context.WithCancel(context.Background())
+	w, err := d.OpenContext(ctx, "channel")
+	require.NoError(t, err)
+	<-joiner.joining
+	joined := joiner.Joined()
+	require.Len(t, joined, 2)
+	cancel()
+	joined[1].SetReady(false)
+	_, err = w.Write([]byte("a"))
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Len(t, joiner.Joined(), 2)
+}
+
+func TestDevicePrejoin(t *testing.T) {
+	t.Parallel()
+	joiner := &slowJoiner{FakeJoiner: NewFakeJoiner(2, "channel"), joining: make(chan string, 1), release: make(chan struct{})}
+	d := NewDevice(joiner, "guild", time.Second, onceBackoff)
+	defer d.Close()
+
+	// Prejoin returns before the channel is joined and a later Open waits for the join to reuse its Writer
+	errc := d.Prejoin("channel")
+	assert.Equal(t, "channel", <-joiner.joining)
+	opened := make(chan io.Writer, 1)
+	go func() {
+		w, err := d.Open("channel")
+		assert.NoError(t, err)
+		opened <- w
+	}()
+	close(joiner.release)
+	require.NoError(t, <-errc)
+	w := <-opened
+	require.Len(t, joiner.Joined(), 1, "expected Open to reuse the prejoined connection")
+	_, err := w.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), receive(t, joiner.Joined()[0]))
+	w2, err := d.Open("channel")
+	require.NoError(t, err)
+	assert.Equal(t, w, w2)
+
+	// the channel reports why the channel could not be joined
+	assert.Equal(t, ErrInvalidVoiceChannel, <-d.Prejoin("missing"))
+	joiner.FailJoins(errors.New("outage"))
+	d.Close()
+	err = <-d.Prejoin("channel")
+	<-joiner.joining
+	assert.Equal(t, "outage", errors.Cause(err).Error())
+}
@@ -0,0 +1,96 @@
+package discordvoice
+
+import (
+	"io"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/pkg/errors"
+)
+
+// Encoder encodes frames of standard PCM to opus packets, see the pcm package.
+type Encoder interface {
+	// Encode encodes a frame of pcm.FrameLen interleaved samples to an opus packet.
+	Encode(samples []int16) ([]byte, error)
+}
+
+// EncodeFunc creates an Encoder for a source with the bitrate in kb/s, e.g. from Device.Bitrate.
+// Build with the libopus tag for Libopus, an EncodeFunc backed by libopus.
+type EncodeFunc func(kbps int) (Encoder, error)
+
+// EncodedSource provides opus frames suitable for a discord voice channel from a source of standard PCM,
+// e.g. from the mp3 package resampled to 48kHz, encoded in process instead of by an ffmpeg process per item.
+type EncodedSource struct {
+	src    player.Source
+	enc    Encoder
+	framer *pcm.Framer
+	// the last byte of a frame of src that ended in the middle of a sample
+	odd   []byte
+	ended bool
+}
+
+// NewEncodedSource produces a source of opus frames by encoding the frames of src, which must produce standard PCM.
+// The frames of src need not be 20ms, or even whole samples, they are cut into 20ms frames before they are encoded.
+// If src or the Encoder implement io.Closer they will be closed when the source is closed.
+func NewEncodedSource(src player.Source, encode EncodeFunc, kbps int) (*EncodedSource, error) {
+	enc, err := encode(kbps)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create opus encoder")
+	}
+	return &EncodedSource{src: src, enc: enc, framer: pcm.NewFramer(pcm.FrameLen)}, nil
+}
+
+// ReadFrame implements player.SourceCloser.
+func (s *EncodedSource) ReadFrame() ([]byte, error) {
+	samples, ok := s.framer.Next()
+	for !ok && !s.ended {
+		frame, err := s.src.ReadFrame()
+		b := frame
+		if len(s.odd) > 0 {
+			b = append(s.odd, frame...)
+		}
+		s.framer.Write(pcm.Decode(make([]int16, 0, len(b)/2), b))
+		s.odd = append(s.odd[:0], b[len(b)&^1:]...)
+		if ps, pooled := s.src.(player.PooledSource); pooled && ps.PooledFrames() {
+			player.FreeFrame(frame)
+		}
+		if err == io.EOF {
+			s.ended = true
+		} else if err != nil {
+			return nil, err
+		}
+		samples, ok = s.framer.Next()
+	}
+	if !ok {
+		// the last frame is padded with silence
+		if samples, ok = s.framer.Flush(); !ok {
+			return nil, io.EOF
+		}
+	}
+	return s.enc.Encode(samples)
+}
+
+// FrameDuration implements player.SourceCloser.
+func (s *EncodedSource) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+// PCM implements player.PCMSource, the source produces opus frames.
+func (s *EncodedSource) PCM() bool {
+	return false
+}
+
+// Close implements player.SourceCloser.
+func (s *EncodedSource) Close() error {
+	if c, ok := s.enc.(io.Closer); ok {
+		c.Close()
+	}
+	if c, ok := s.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// do not compile unless EncodedSource implements player.SourceCloser
+var _ player.SourceCloser = &EncodedSource{}
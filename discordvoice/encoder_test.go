@@ -0,0 +1,120 @@
+package discordvoice
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkSource plays PCM in chunks of any size, then fails with err, io.EOF if nil.
+type chunkSource struct {
+	chunks [][]byte
+	err    error
+	closed bool
+}
+
+func (s *chunkSource) ReadFrame() ([]byte, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *chunkSource) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+func (s *chunkSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+// firstEncoder "encodes" each frame to its first and last samples and the number of samples.
+type firstEncoder struct {
+	closed bool
+}
+
+func (e *firstEncoder) Encode(samples []int16) ([]byte, error) {
+	return pcm.Encode(nil, []int16{samples[0], samples[len(samples)-1], int16(len(samples))}), nil
+}
+
+func (e *firstEncoder) Close() error {
+	e.closed = true
+	return nil
+}
+
+// ramp is n samples counting up from first.
+func ramp(first int16, n int) []int16 {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = first + int16(i)
+	}
+	return samples
+}
+
+func TestEncodedSource(t *testing.T) {
+	t.Parallel()
+	// 2.5 frames of PCM cut at odd byte counts, in the middle of samples
+	b := pcm.Encode(nil, ramp(1, pcm.FrameLen*5/2))
+	src := &chunkSource{chunks: [][]byte{b[:1001], b[1001:1002], b[1002:5001], b[5001:]}}
+	enc := &firstEncoder{}
+	var kbps int
+	encode := func(bitrate int) (Encoder, error) {
+		kbps = bitrate
+		return enc, nil
+	}
+	es, err := NewEncodedSource(src, encode, 96)
+	require.NoError(t, err)
+	assert.Equal(t, 96, kbps)
+	assert.Equal(t, pcm.FrameDuration, es.FrameDuration())
+	assert.False(t, es.PCM())
+
+	n := int16(pcm.FrameLen)
+	for i, want := range [][]int16{
+		{1, n, n},
+		{n + 1, 2 * n, n},
+		// the partial final frame is padded with silence
+		{2*n + 1, 0, n},
+	} {
+		frame, err := es.ReadFrame()
+		require.NoError(t, err)
+		assert.Equal(t, want, pcm.Decode(nil, frame), "expected frame %v to keep every sample in place", i)
+	}
+	_, err = es.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+
+	require.NoError(t, es.Close())
+	assert.True(t, src.closed)
+	assert.True(t, enc.closed)
+}
+
+func TestEncodedSourceErrors(t *testing.T) {
+	t.Parallel()
+	_, err := NewEncodedSource(&chunkSource{}, func(int) (Encoder, error) {
+		return nil, errors.New("no libopus")
+	}, 64)
+	assert.Error(t, err)
+
+	// errors of the source are not mistaken for its end
+	src := &chunkSource{chunks: [][]byte{make([]byte, 100)}, err: errors.New("broken")}
+	es, err := NewEncodedSource(src, func(int) (Encoder, error) { return &firstEncoder{}, nil }, 64)
+	require.NoError(t, err)
+	_, err = es.ReadFrame()
+	assert.EqualError(t, err, "broken")
+
+	// a source without samples has no frames
+	es, err = NewEncodedSource(&chunkSource{}, func(int) (Encoder, error) { return &firstEncoder{}, nil }, 64)
+	require.NoError(t, err)
+	_, err = es.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
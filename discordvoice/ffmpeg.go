@@ -0,0 +1,261 @@
+package discordvoice
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/webm"
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+)
+
+// FFmpeg locates and configures the ffmpeg process that encodes sources for a voice channel,
+// e.g. for containers and Windows hosts where ffmpeg is not in the PATH.
+// The zero FFmpeg encodes through dca, which runs whatever ffmpeg is in the PATH.
+type FFmpeg struct {
+	// Path is the ffmpeg executable, "ffmpeg" if empty.
+	Path string
+	// InputArgs go before the input, e.g. "-reconnect", "1", "-reconnect_streamed", "1" for HTTP input.
+	InputArgs []string
+	// Env is added to the environment of the process, e.g. "LD_LIBRARY_PATH=/opt/ffmpeg/lib".
+	Env []string
+
+	// Nice lowers the scheduling priority of the process by the nice increment, from 1 to 19,
+	// so a heavy transcode cannot starve the goroutines that send audio. Nice is ignored on Windows.
+	Nice int
+	// Threads limits the threads ffmpeg decodes, filters, and encodes with, overriding the Threads of the encode options.
+	Threads int
+	// OnStart, if not nil, is called with the process ID as soon as the process starts,
+	// e.g. to move the process into a cgroup that limits its CPU and memory.
+	// The process is killed if OnStart returns an error.
+	OnStart func(pid int) error
+}
+
+// Transcoder sets the FFmpeg that Device.NewSource encodes sources with.
+func Transcoder(f FFmpeg) Option {
+	return func(d *Device) {
+		d.ffmpeg = f
+	}
+}
+
+// NewSource is like the NewSource function, but encodes with the Device's Transcoder.
+func (d *Device) NewSource(r io.Reader, opts *dca.EncodeOptions) (*SourceCloser, error) {
+	return d.ffmpeg.NewSource(r, opts)
+}
+
+func (f FFmpeg) zero() bool {
+	return f.Path == "" && len(f.InputArgs) == 0 && len(f.Env) == 0 && f.Nice == 0 && f.Threads == 0 && f.OnStart == nil
+}
+
+// command prepares ffmpeg with the arguments.
+func (f FFmpeg) command(args []string) *exec.Cmd {
+	path := f.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+	cmd := exec.Command(path, args...)
+	if len(f.Env) > 0 {
+		cmd.Env = append(os.Environ(), f.Env...)
+	}
+	return cmd
+}
+
+// run starts ffmpeg and applies the resource limits to it.
+func (f FFmpeg) run(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to start ffmpeg")
+	}
+	err := setNice(cmd.Process.Pid, f.Nice)
+	if err == nil && f.OnStart != nil {
+		err = f.OnStart(cmd.Process.Pid)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.Wrap(err, "failed to limit ffmpeg")
+	}
+	return nil
+}
+
+// inputArgs are the arguments that go before the input.
+func (f FFmpeg) inputArgs() []string {
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, f.InputArgs...)
+	if f.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(f.Threads), "-filter_threads", strconv.Itoa(f.Threads))
+	}
+	return args
+}
+
+// withThreads returns opts encoding with the FFmpeg's Threads, if any.
+func (f FFmpeg) withThreads(opts *dca.EncodeOptions) *dca.EncodeOptions {
+	if f.Threads <= 0 {
+		return opts
+	}
+	limited := *opts
+	limited.Threads = f.Threads
+	return &limited
+}
+
+// NewSource is like the NewSource function, but runs the configured ffmpeg.
+// NewSource waits for the header of the encoder's output, unlike the zero FFmpeg.
+func (f FFmpeg) NewSource(r io.Reader, opts *dca.EncodeOptions) (*SourceCloser, error) {
+	if f.zero() {
+		enc, err := dca.EncodeMem(r, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &SourceCloser{r: r, enc: enc}, nil
+	}
+	enc, err := f.start(r, "pipe:0", opts)
+	if err != nil {
+		return nil, err
+	}
+	return &SourceCloser{r: r, enc: enc}, nil
+}
+
+// Open produces a source of opus frames of a file or a URL that ffmpeg reads by itself,
+// so that InputArgs such as -reconnect apply to it.
+func (f FFmpeg) Open(input string, opts *dca.EncodeOptions) (*SourceCloser, error) {
+	if f.zero() {
+		enc, err := dca.EncodeFile(input, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &SourceCloser{enc: enc}, nil
+	}
+	enc, err := f.start(nil, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &SourceCloser{enc: enc}, nil
+}
+
+// encoder is the encode session of a SourceCloser, a *dca.EncodeSession or an ffmpeg process of a configured FFmpeg.
+type encoder interface {
+	OpusFrame() ([]byte, error)
+	FrameDuration() time.Duration
+	Cleanup()
+}
+
+// ffmpegEncoder reads the opus frames of an ffmpeg process that encodes to webm.
+type ffmpegEncoder struct {
+	cmd    *exec.Cmd
+	src    *webm.SourceCloser
+	stderr bytes.Buffer
+	once   sync.Once
+	err    error
+}
+
+func (f FFmpeg) start(r io.Reader, input string, opts *dca.EncodeOptions) (*ffmpegEncoder, error) {
+	if opts == nil {
+		opts = dca.StdEncodeOptions
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	e := &ffmpegEncoder{cmd: f.command(f.args(input, opts))}
+	e.cmd.Stdin = r
+	e.cmd.Stderr = &e.stderr
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.run(e.cmd); err != nil {
+		return nil, err
+	}
+	e.src, err = webm.NewSource(stdout)
+	if err != nil {
+		e.cmd.Process.Kill()
+		if werr := e.wait(); werr != nil {
+			return nil, werr
+		}
+		return nil, errors.Wrap(err, "failed to read ffmpeg output")
+	}
+	return e, nil
+}
+
+func (f FFmpeg) args(input string, opts *dca.EncodeOptions) []string {
+	args := f.inputArgs()
+	if opts.StartTime > 0 {
+		args = append(args, "-ss", strconv.Itoa(opts.StartTime))
+	}
+	args = append(args, "-i", input)
+	args = append(args, encodeArgs(f.withThreads(opts))...)
+	return append(args, "-f", "webm", "pipe:1")
+}
+
+// encodeArgs are the arguments that encode the first audio stream of the input to opus like dca does.
+func encodeArgs(opts *dca.EncodeOptions) []string {
+	vbr := "on"
+	if !opts.VBR {
+		vbr = "off"
+	}
+	args := []string{
+		"-map", "0:a:0",
+		"-vn",
+		"-acodec", "libopus",
+		"-vbr", vbr,
+		"-compression_level", strconv.Itoa(opts.CompressionLevel),
+		"-ar", strconv.Itoa(opts.FrameRate),
+		"-ac", strconv.Itoa(opts.Channels),
+		"-b:a", strconv.Itoa(opts.Bitrate * 1000),
+		"-application", string(opts.Application),
+		"-frame_duration", strconv.Itoa(opts.FrameDuration),
+		"-packet_loss", strconv.Itoa(opts.PacketLoss),
+		"-threads", strconv.Itoa(opts.Threads),
+	}
+	// ffmpeg no longer has the -vol option dca passes, 256 is unity gain
+	af := opts.AudioFilter
+	if opts.Volume != 256 && opts.Volume > 0 {
+		vol := fmt.Sprintf("volume=%v", float64(opts.Volume)/256)
+		if af != "" {
+			vol += "," + af
+		}
+		af = vol
+	}
+	if af != "" {
+		args = append(args, "-af", af)
+	}
+	return args
+}
+
+// OpusFrame returns the next frame, or the error ffmpeg exited with once there are none.
+func (e *ffmpegEncoder) OpusFrame() ([]byte, error) {
+	frame, err := e.src.ReadFrame()
+	if err == io.EOF {
+		if werr := e.wait(); werr != nil {
+			return nil, werr
+		}
+	}
+	return frame, err
+}
+
+func (e *ffmpegEncoder) FrameDuration() time.Duration {
+	return e.src.FrameDuration()
+}
+
+func (e *ffmpegEncoder) Cleanup() {
+	e.cmd.Process.Kill()
+	e.src.Close()
+	e.wait()
+}
+
+// wait waits for ffmpeg to exit, once.
+func (e *ffmpegEncoder) wait() error {
+	e.once.Do(func() {
+		if err := e.cmd.Wait(); err != nil {
+			e.err = errors.Wrapf(err, "ffmpeg failed: %s", bytes.TrimSpace(e.stderr.Bytes()))
+		}
+	})
+	return e.err
+}
+
+// do not compile unless the encode sessions implement encoder
+var _ encoder = &dca.EncodeSession{}
+var _ encoder = &ffmpegEncoder{}
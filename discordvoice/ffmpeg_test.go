@@ -0,0 +1,182 @@
+package discordvoice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFFmpegEnv makes the test binary act as ffmpeg, see TestMain.
+const fakeFFmpegEnv = "DISCORDVOICE_FAKE_FFMPEG"
+
+// fakeFFmpeg runs the test binary as an ffmpeg that "encodes" each frame of PCM of its input
+// to the opus packet 0xFC followed by the first byte of the frame, one frame behind like a real encoder.
+var fakeFFmpeg = FFmpeg{Path: os.Args[0], Env: []string{fakeFFmpegEnv + "=1"}}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeFFmpegEnv) == "1" {
+		os.Exit(runFakeFFmpeg(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// ebml encodes an element with an 8 byte size.
+func ebml(id []byte, data []byte) []byte {
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	size[0] = 0x01
+	return append(append(append([]byte{}, id...), size...), data...)
+}
+
+// unknownSize starts an element that lasts until the end of the stream, like ffmpeg's live webm output.
+func unknownSize(id []byte) []byte {
+	return append(append([]byte{}, id...), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+}
+
+func runFakeFFmpeg(args []string) int {
+	var input io.Reader = os.Stdin
+	for i, arg := range args {
+		// a file or URL that ffmpeg opens by itself
+		if arg == "-i" && i+1 < len(args) && args[i+1] != "pipe:0" {
+			f, err := os.Open(args[i+1])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			input = f
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	w.Write(unknownSize([]byte{0x18, 0x53, 0x80, 0x67}))
+	entry := append(append(ebml([]byte{0xD7}, []byte{1}), ebml([]byte{0x83}, []byte{2})...), ebml([]byte{0x86}, []byte("A_OPUS"))...)
+	w.Write(ebml([]byte{0x16, 0x54, 0xAE, 0x6B}, ebml([]byte{0xAE}, entry)))
+	w.Write(unknownSize([]byte{0x1F, 0x43, 0xB6, 0x75}))
+	w.Write(ebml([]byte{0xE7}, []byte{0}))
+	w.Flush()
+
+	block := func(first byte) {
+		w.Write(ebml([]byte{0xA3}, []byte{0x81, 0, 0, 0x80, 0xFC, first}))
+		w.Flush()
+	}
+	frame := make([]byte, pcm.FrameBytes)
+	held := -1
+	for {
+		n, err := io.ReadFull(input, frame)
+		if n == 0 {
+			break
+		}
+		if held >= 0 {
+			block(byte(held))
+		}
+		held = int(frame[0])
+		if err != nil {
+			break
+		}
+	}
+	if held >= 0 {
+		block(byte(held))
+	}
+	return 0
+}
+
+// pcmFrames is PCM whose frames start with each of the bytes.
+func pcmFrames(firsts ...byte) []byte {
+	var b []byte
+	for _, first := range firsts {
+		frame := make([]byte, pcm.FrameBytes)
+		frame[0] = first
+		b = append(b, frame...)
+	}
+	return b
+}
+
+// readAll reads every frame of a source until it ends.
+func readAll(t *testing.T, src player.Source) [][]byte {
+	var frames [][]byte
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			return frames
+		}
+		require.NoError(t, err)
+		frames = append(frames, frame)
+	}
+}
+
+func TestFFmpegNewSource(t *testing.T) {
+	t.Parallel()
+	src, err := fakeFFmpeg.NewSource(bytes.NewReader(pcmFrames(1, 2, 3)), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	assert.Equal(t, [][]byte{{0xFC, 1}, {0xFC, 2}, {0xFC, 3}}, readAll(t, src))
+	assert.Equal(t, 20*time.Millisecond, src.FrameDuration())
+	assert.True(t, src.PooledFrames(), "expected frames from the player's frame pool")
+}
+
+func TestFFmpegNewSeekOpener(t *testing.T) {
+	t.Parallel()
+	open := func() (io.Reader, error) {
+		return bytes.NewReader(pcmFrames(1, 2, 3, 4)), nil
+	}
+	// nil options are the standard options, as for NewSource
+	seek := fakeFFmpeg.NewSeekOpener(open, nil)
+	src, err := seek(40 * time.Millisecond)
+	require.NoError(t, err)
+	defer src.(io.Closer).Close()
+	assert.Equal(t, [][]byte{{0xFC, 3}, {0xFC, 4}}, readAll(t, src), "expected the frames before the offset to be skipped")
+}
+
+func TestFFmpegLimits(t *testing.T) {
+	t.Parallel()
+	f := fakeFFmpeg
+	f.Threads = 2
+	opts := *dca.StdEncodeOptions
+	opts.Threads = 8
+	args := strings.Join(f.args("pipe:0", &opts), " ")
+	assert.Contains(t, args, "-threads 2 -filter_threads 2 -i pipe:0", "expected decoding and filtering to be limited")
+	assert.Contains(t, args, "-packet_loss "+strconv.Itoa(opts.PacketLoss)+" -threads 2", "expected encoding to be limited")
+	assert.Equal(t, 8, opts.Threads, "expected the options to be left alone")
+	assert.Equal(t, []string{"-hide_banner", "-loglevel", "error"}, fakeFFmpeg.inputArgs())
+	assert.False(t, f.zero(), "expected limits to run the configured ffmpeg")
+
+	// OnStart gets the process as soon as it starts
+	var pids []int
+	f.OnStart = func(pid int) error {
+		pids = append(pids, pid)
+		return nil
+	}
+	src, err := f.NewSource(bytes.NewReader(pcmFrames(1)), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{0xFC, 1}}, readAll(t, src))
+	require.NoError(t, src.Close())
+	require.Len(t, pids, 1)
+	assert.Equal(t, src.enc.(*ffmpegEncoder).cmd.Process.Pid, pids[0])
+
+	// the process is killed if it cannot be limited
+	f.OnStart = func(int) error {
+		return errors.New("no cgroup")
+	}
+	cmd := f.command(f.args("pipe:0", dca.StdEncodeOptions))
+	cmd.Stdin = bytes.NewReader(pcmFrames(1))
+	err = f.run(cmd)
+	assert.Equal(t, "no cgroup", errors.Cause(err).Error())
+	assert.NotNil(t, cmd.ProcessState, "expected the process to be waited for")
+	_, err = f.NewSource(bytes.NewReader(pcmFrames(1)), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package discordvoice
+
+import "github.com/pkg/errors"
+
+// Follow makes the Device play in whatever voice channel a user is in, e.g. the DJ of a music bot.
+// The open Writer moves with the user mid-playback, and Open ignores its channelID in favor of the user's channel
+// while the user is in a voice channel of the guild.
+// Follow with an empty userID stops following.
+// Devices created with New learn where the user is from the discord session,
+// other Devices must be told with UserMoved.
+func (d *Device) Follow(userID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.followed = userID
+	d.followChannel = ""
+	if userID != "" && d.voiceState != nil {
+		d.followChannel = d.voiceState(userID)
+	}
+}
+
+// UserMoved tells the Device that a user of the guild joined a voice channel, or left voice if channelID is empty.
+// If the Device follows the user the open Writer moves to the channel.
+func (d *Device) UserMoved(userID string, channelID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if userID == "" || userID != d.followed || channelID == d.followChannel {
+		return
+	}
+	d.followChannel = channelID
+	if channelID == "" || d.writer == nil {
+		return
+	}
+	d.log.Infof("guild %v: following user %v to channel %v", d.guildID, userID, channelID)
+	if err := d.move(channelID); err != nil {
+		d.log.Errorf("guild %v: failed to follow user %v to channel %v: %v", d.guildID, userID, channelID, err)
+	}
+}
+
+// Move moves the open Writer to another voice channel of the guild without interrupting playback.
+// Move fails if there is no open Writer.
+func (d *Device) Move(channelID string) error {
+	if !d.joiner.IsVoiceChannel(channelID) {
+		return ErrInvalidVoiceChannel
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writer == nil {
+		return errors.New("no open writer to move")
+	}
+	return d.move(channelID)
+}
+
+// move moves the open Writer, the caller must hold mu.
+func (d *Device) move(channelID string) error {
+	w := d.writer
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.channelID == channelID && w.ready() {
+		return nil
+	}
+	w.channelID = channelID
+	// moving on purpose overrides being disconnected by someone else
+	w.disconnected = false
+	if err := w.resume(); err != nil {
+		return errors.Wrap(err, "failed to move to discord channel")
+	}
+	return nil
+}
+
+// following returns the channel of the followed user, or channelID if the Device does not follow a user in a voice channel.
+func (d *Device) following(channelID string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.followChannel != "" {
+		return d.followChannel
+	}
+	return channelID
+}
@@ -0,0 +1,57 @@
+package discordvoice
+
+import "time"
+
+// IdleDisconnect makes the Device's Writers leave their voice channel once after has passed without a Write,
+// moving to idleChannelID if it is not empty, e.g. an AFK channel, or disconnecting from voice otherwise.
+// The next Write rejoins the Writer's channel and the next Open joins the channel it is given,
+// so the player does not need to know the Writer left.
+// By default Writers stay in their channel until they are closed.
+func IdleDisconnect(after time.Duration, idleChannelID string) Option {
+	return func(d *Device) {
+		d.idleAfter = after
+		d.idleChannelID = idleChannelID
+	}
+}
+
+// active pushes back leaving the channel until the Writer has been idle, the caller must hold mu.
+func (w *Writer) active() {
+	w.idle = false
+	if w.idleAfter <= 0 {
+		return
+	}
+	if w.idleTimer == nil {
+		w.idleTimer = w.clock.AfterFunc(w.idleAfter, w.leave)
+	} else {
+		w.idleTimer.Reset(w.idleAfter)
+	}
+}
+
+// leave moves the Writer to the idle channel or disconnects it once the Writer has been idle.
+func (w *Writer) leave() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.idle || w.disconnected || !w.ready() {
+		return
+	}
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	w.idle = true
+	w.leftAt = w.clock.Now()
+	if w.idleChannelID == "" || w.idleChannelID == w.channelID {
+		w.log.Infof("guild %v: leaving channel %v after %v idle", w.guildID, w.channelID, w.idleAfter)
+		w.vconn.Disconnect()
+		return
+	}
+	w.log.Infof("guild %v: moving from channel %v to idle channel %v after %v idle", w.guildID, w.channelID, w.idleChannelID, w.idleAfter)
+	vconn, err := w.joiner.ChannelVoiceJoin(w.guildID, w.idleChannelID, false, true)
+	if err != nil {
+		w.log.Errorf("guild %v: failed to move to idle channel %v: %v", w.guildID, w.idleChannelID, err)
+		w.vconn.Disconnect()
+		return
+	}
+	w.connect(vconn)
+}
@@ -0,0 +1,106 @@
+package discordvoice
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// VoiceJoiner joins voice channels and looks them up for a Device.
+// Wrap a discordgo session with Session, or adapt another discord library's client,
+// e.g. disgord or arikawa, to use the same Device with it.
+type VoiceJoiner interface {
+	ChannelVoiceJoin(guildID string, channelID string, mute bool, deaf bool) (VoiceConn, error)
+	// IsVoiceChannel reports whether the channel exists and is a voice channel.
+	IsVoiceChannel(channelID string) bool
+}
+
+// Session adapts a discordgo session to a VoiceJoiner.
+func Session(discord *discordgo.Session) VoiceJoiner {
+	return sessionJoiner{discord}
+}
+
+type sessionJoiner struct {
+	discord *discordgo.Session
+}
+
+func (s sessionJoiner) ChannelVoiceJoin(guildID string, channelID string, mute bool, deaf bool) (VoiceConn, error) {
+	vconn, err := s.discord.ChannelVoiceJoin(guildID, channelID, mute, deaf)
+	if err != nil {
+		return nil, err
+	}
+	return discordgoVoiceConn{vconn}, nil
+}
+
+func (s sessionJoiner) IsVoiceChannel(channelID string) bool {
+	return ValidVoiceChannel(s.discord, channelID)
+}
+
+// FakeJoiner is a VoiceJoiner whose connections are FakeVoiceConns, for testing Devices without a discord gateway.
+type FakeJoiner struct {
+	mu       sync.Mutex
+	channels map[string]bool
+	buffer   int
+	joined   []*FakeVoiceConn
+	err      error
+	bitrates map[string]int
+}
+
+// NewFakeJoiner creates a FakeJoiner with voice channels that can be joined,
+// the connections to them buffer up to buffer frames.
+func NewFakeJoiner(buffer int, channelIDs ...string) *FakeJoiner {
+	j := &FakeJoiner{
+		channels: make(map[string]bool),
+		buffer:   buffer,
+	}
+	for _, channelID := range channelIDs {
+		j.channels[channelID] = true
+	}
+	return j
+}
+
+func (j *FakeJoiner) ChannelVoiceJoin(guildID string, channelID string, mute bool, deaf bool) (VoiceConn, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return nil, j.err
+	}
+	if !j.channels[channelID] {
+		return nil, ErrInvalidVoiceChannel
+	}
+	vconn := NewFakeVoiceConn(channelID, j.buffer)
+	j.joined = append(j.joined, vconn)
+	return vconn, nil
+}
+
+func (j *FakeJoiner) IsVoiceChannel(channelID string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.channels[channelID]
+}
+
+// Joined returns every connection that was joined, oldest first.
+func (j *FakeJoiner) Joined() []*FakeVoiceConn {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	joined := make([]*FakeVoiceConn, len(j.joined))
+	copy(joined, j.joined)
+	return joined
+}
+
+// FailJoins makes joins fail with err, or succeed again if err is nil.
+func (j *FakeJoiner) FailJoins(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.err = err
+}
+
+// RemoveChannel simulates a voice channel being deleted.
+func (j *FakeJoiner) RemoveChannel(channelID string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.channels, channelID)
+}
+
+// do not compile unless FakeJoiner implements VoiceJoiner
+var _ VoiceJoiner = &FakeJoiner{}
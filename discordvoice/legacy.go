@@ -0,0 +1,100 @@
+package discordvoice
+
+import (
+	"io"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jonas747/dca"
+)
+
+// QueueConfig configures a Player.
+//
+// Deprecated: QueueConfig exists to ease migration from discordvoice v0.3,
+// use the options of the player package instead.
+type QueueConfig struct {
+	// maximum number of queued items, values less than 1 allow an unbounded queue
+	QueueLength int
+	// milliseconds to wait on the voice connection before giving up on a frame
+	SendTimeout int
+	// channel to move to after IdleTimeout milliseconds without playback, if any
+	IdleChannelID string
+	IdleTimeout   int
+	// options for encoding queued items,
+	// dca.StdEncodeOptions with the bitrate of the item's voice channel if nil
+	EncodeOptions *dca.EncodeOptions
+}
+
+// SongOpenerFunc opens the media of a queued item, it is encoded to opus for the voice channel.
+//
+// Deprecated: SongOpenerFunc exists to ease migration from discordvoice v0.3,
+// use player.SourceOpenerFunc and NewSource instead.
+type SongOpenerFunc func() (io.ReadCloser, error)
+
+// Player provides the discordvoice v0.3 API on top of the player package,
+// queueing items with the voice channel they should play in.
+// The underlying player.Player is embedded so callers can migrate incrementally.
+//
+// Deprecated: Player exists to ease migration from discordvoice v0.3,
+// use player.New with a Device instead.
+type Player struct {
+	*player.Player
+	device *Device
+	opts   *dca.EncodeOptions
+}
+
+// NewPlayer creates a Player for a guild.
+// Be sure to call Player.Close to clean up any resources.
+//
+// Deprecated: use player.New with a Device instead.
+func NewPlayer(discord *discordgo.Session, guildID string, cfg QueueConfig) *Player {
+	return newPlayer(New(discord, guildID, time.Duration(cfg.SendTimeout)*time.Millisecond), cfg)
+}
+
+// newPlayer creates a Player that plays on the device.
+func newPlayer(device *Device, cfg QueueConfig) *Player {
+	playerOpts := []player.Option{player.QueueLength(cfg.QueueLength)}
+	if cfg.IdleChannelID != "" {
+		playerOpts = append(playerOpts, player.IdleFunc(func() {
+			device.Open(cfg.IdleChannelID)
+		}, cfg.IdleTimeout))
+	}
+
+	return &Player{
+		Player: player.New(playerOpts...),
+		device: device,
+		opts:   cfg.EncodeOptions,
+	}
+}
+
+// Enqueue puts an item at the end of the queue that plays in the voice channel.
+//
+// Deprecated: use player.Player.Enqueue with a Device and NewSource instead.
+func (p *Player) Enqueue(channelID string, title string, open SongOpenerFunc, opts ...player.SongOption) error {
+	openSrc := func() (player.Source, error) {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		opts := p.opts
+		if opts == nil {
+			opts = p.device.EncodeOptions(channelID, nil)
+		}
+		return p.device.NewSource(r, opts)
+	}
+	openDst := func() (io.Writer, error) {
+		return p.device.Open(channelID)
+	}
+	_, err := p.Player.Enqueue(title, openSrc, openDst, opts...)
+	return err
+}
+
+// Close closes the underlying player.Player and stops watching the discord session.
+//
+// Deprecated: use player.Player.Close and Device.Close instead.
+func (p *Player) Close() error {
+	err := p.Player.Close()
+	p.device.Close()
+	return err
+}
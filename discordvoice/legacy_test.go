@@ -0,0 +1,58 @@
+package discordvoice
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegacyPlayer(t *testing.T) {
+	t.Parallel()
+	joiner := NewFakeJoiner(20, "voice", "idle")
+	device := NewDevice(joiner, "guild", time.Second, Transcoder(fakeFFmpeg))
+	p := newPlayer(device, QueueConfig{
+		QueueLength:   10,
+		SendTimeout:   1000,
+		IdleChannelID: "idle",
+		IdleTimeout:   10,
+	})
+	defer p.Close()
+
+	// the player starts out idle
+	joined := joiner.Joined()
+	require.Len(t, joined, 1)
+	assert.Equal(t, "idle", joined[0].ChannelID())
+
+	ended := make(chan error, 1)
+	open := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(pcmFrames(1, 2, 3))), nil
+	}
+	err := p.Enqueue("voice", "title", open, player.OnEnd(func(_ time.Duration, err error) {
+		ended <- err
+	}))
+	require.NoError(t, err)
+
+	select {
+	case err := <-ended:
+		assert.Equal(t, io.EOF, errors.Cause(err), "expected the item to play to its end")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the item to end")
+	}
+	joined = joiner.Joined()
+	require.True(t, len(joined) >= 2)
+	assert.Equal(t, "voice", joined[1].ChannelID(), "expected the item to play in its channel")
+	for i := byte(1); i <= 3; i++ {
+		assert.Equal(t, []byte{0xFC, i}, receive(t, joined[1]))
+	}
+
+	// the player moves back to the idle channel after playback
+	waitFor(t, func() bool { return len(joiner.Joined()) == 3 }, "expected the player to move to the idle channel")
+	assert.Equal(t, "idle", joiner.Joined()[2].ChannelID())
+}
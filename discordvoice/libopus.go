@@ -0,0 +1,42 @@
+//go:build libopus
+// +build libopus
+
+package discordvoice
+
+import (
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"gopkg.in/hraban/opus.v2"
+)
+
+// largest opus packet, from the opus_encode documentation
+const maxPacket = 4000
+
+type libopusEncoder struct {
+	enc *opus.Encoder
+	buf []byte
+}
+
+// Libopus is an EncodeFunc backed by libopus through cgo, requiring libopus and the libopus tag to build.
+func Libopus(kbps int) (Encoder, error) {
+	enc, err := opus.NewEncoder(pcm.SampleRate, pcm.Channels, opus.AppAudio)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.SetBitrate(kbps * 1000); err != nil {
+		return nil, err
+	}
+	return &libopusEncoder{enc: enc, buf: make([]byte, maxPacket)}, nil
+}
+
+func (e *libopusEncoder) Encode(samples []int16) ([]byte, error) {
+	n, err := e.enc.Encode(samples, e.buf)
+	if err != nil {
+		return nil, err
+	}
+	packet := make([]byte, n)
+	copy(packet, e.buf)
+	return packet, nil
+}
+
+// do not compile unless Libopus is an EncodeFunc
+var _ EncodeFunc = Libopus
@@ -0,0 +1,155 @@
+package discordvoice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+)
+
+// Loudnorm normalizes loudness with ffmpeg's loudnorm filter per EBU R128.
+type Loudnorm struct {
+	// Target is the integrated loudness in LUFS.
+	Target float64
+	// TruePeak is the maximum true peak in dBTP.
+	TruePeak float64
+	// Range is the loudness range in LU.
+	Range float64
+}
+
+// DefaultLoudnorm are the defaults of ffmpeg's loudnorm filter.
+var DefaultLoudnorm = Loudnorm{Target: -24, TruePeak: -2, Range: 7}
+
+// Filter returns the audio filter that normalizes loudness in a single pass,
+// which adjusts the gain dynamically as the media plays and so is less accurate than two passes.
+func (l Loudnorm) Filter() string {
+	return fmt.Sprintf("loudnorm=I=%v:TP=%v:LRA=%v", l.Target, l.TruePeak, l.Range)
+}
+
+// LoudnormMeasurement is the loudness of media measured by the first pass of loudnorm.
+type LoudnormMeasurement struct {
+	I      float64
+	TP     float64
+	LRA    float64
+	Thresh float64
+	Offset float64
+}
+
+// TwoPassFilter returns the audio filter that normalizes loudness with the measurement of the media,
+// applying a constant gain if the media's loudness range allows it.
+func (l Loudnorm) TwoPassFilter(m LoudnormMeasurement) string {
+	return fmt.Sprintf("%v:measured_I=%v:measured_TP=%v:measured_LRA=%v:measured_thresh=%v:offset=%v:linear=true",
+		l.Filter(), m.I, m.TP, m.LRA, m.Thresh, m.Offset)
+}
+
+// Measure runs the first pass of loudnorm over the media read from r, requiring ffmpeg available in the PATH.
+// Measure reads r to its end as fast as ffmpeg decodes it.
+func (l Loudnorm) Measure(r io.Reader) (LoudnormMeasurement, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-nostats", "-i", "pipe:0",
+		"-af", l.Filter()+":print_format=json", "-f", "null", "-")
+	cmd.Stdin = r
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return LoudnormMeasurement{}, errors.Wrapf(err, "failed to measure loudness: %s", bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parseLoudnorm(stderr.Bytes())
+}
+
+// parseLoudnorm finds the measurement that loudnorm prints as json at the end of ffmpeg's output.
+func parseLoudnorm(output []byte) (LoudnormMeasurement, error) {
+	start, end := bytes.LastIndexByte(output, '{'), bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return LoudnormMeasurement{}, errors.New("no loudness measurement in ffmpeg output")
+	}
+	var printed struct {
+		I      string `json:"input_i"`
+		TP     string `json:"input_tp"`
+		LRA    string `json:"input_lra"`
+		Thresh string `json:"input_thresh"`
+		Offset string `json:"target_offset"`
+	}
+	if err := json.Unmarshal(output[start:end+1], &printed); err != nil {
+		return LoudnormMeasurement{}, errors.Wrap(err, "failed to parse loudness measurement")
+	}
+	var m LoudnormMeasurement
+	for _, f := range []struct {
+		dst *float64
+		s   string
+	}{{&m.I, printed.I}, {&m.TP, printed.TP}, {&m.LRA, printed.LRA}, {&m.Thresh, printed.Thresh}, {&m.Offset, printed.Offset}} {
+		v, err := strconv.ParseFloat(f.s, 64)
+		if err != nil {
+			return LoudnormMeasurement{}, errors.Wrap(err, "failed to parse loudness measurement")
+		}
+		*f.dst = v
+	}
+	return m, nil
+}
+
+// LoudnormCache normalizes loudness in two passes, remembering the measurement of each media by a key,
+// e.g. its URL, so media that plays again only needs the playback pass.
+type LoudnormCache struct {
+	Loudnorm
+	mu           sync.Mutex
+	measurements map[string]LoudnormMeasurement
+}
+
+// NewLoudnormCache creates a LoudnormCache that normalizes to l.
+func NewLoudnormCache(l Loudnorm) *LoudnormCache {
+	return &LoudnormCache{Loudnorm: l, measurements: make(map[string]LoudnormMeasurement)}
+}
+
+// Measurement reports the cached measurement of the media with the key, if any.
+func (c *LoudnormCache) Measurement(key string) (LoudnormMeasurement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.measurements[key]
+	return m, ok
+}
+
+// SetMeasurement caches the measurement of the media with the key, e.g. one stored with the media's metadata.
+func (c *LoudnormCache) SetMeasurement(key string, m LoudnormMeasurement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.measurements[key] = m
+}
+
+// EncodeOptions returns a copy of opts, or of dca.StdEncodeOptions if opts is nil,
+// whose audio filter normalizes the loudness of the media with the key.
+// If the media has not been measured yet, it is measured from the reader that open returns,
+// so open is called once more than the media is played.
+// The loudnorm filter is appended to any audio filter of opts.
+func (c *LoudnormCache) EncodeOptions(key string, open func() (io.Reader, error), opts *dca.EncodeOptions) (*dca.EncodeOptions, error) {
+	m, ok := c.Measurement(key)
+	if !ok {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		m, err = c.Measure(r)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.SetMeasurement(key, m)
+	}
+
+	if opts == nil {
+		opts = dca.StdEncodeOptions
+	}
+	normalized := *opts
+	filter := c.TwoPassFilter(m)
+	if normalized.AudioFilter != "" {
+		filter = normalized.AudioFilter + "," + filter
+	}
+	normalized.AudioFilter = filter
+	return &normalized, nil
+}
@@ -0,0 +1,103 @@
+package discordvoice
+
+import (
+	"io"
+	"testing"
+
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loudnormOutput is the end of ffmpeg's output for the first pass of loudnorm over a quiet, dynamic track.
+const loudnormOutput = `Output #0, null, to 'pipe:':
+  Metadata:
+    encoder         : Lavf58.29.100
+    Stream #0:0: Audio: pcm_s16le, 192000 Hz, stereo, s16, 6144 kb/s
+size=N/A time=00:03:12.02 bitrate=N/A speed= 215x
+video:0kB audio:144014kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: unknown
+[Parsed_loudnorm_0 @ 0x55d5c1c3c4c0]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-24.58",
+	"output_tp" : "-2.00",
+	"output_lra" : "7.00",
+	"output_thresh" : "-36.06",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+`
+
+var quietTrack = LoudnormMeasurement{I: -27.61, TP: -4.47, LRA: 18.06, Thresh: -39.20, Offset: 0.58}
+
+func TestParseLoudnorm(t *testing.T) {
+	t.Parallel()
+	m, err := parseLoudnorm([]byte(loudnormOutput))
+	require.NoError(t, err)
+	assert.Equal(t, quietTrack, m)
+
+	for _, output := range []string{
+		"",
+		"pipe:0: Invalid data found when processing input",
+		`{"input_i" : "-27.61"`,
+		`{"input_i" : -27.61}`,
+		`{"input_i" : "-27.61", "input_tp" : "-4.47", "input_lra" : "18.06", "input_thresh" : "-39.20"}`,
+		`{"input_i" : "-inf dB", "input_tp" : "-4.47", "input_lra" : "18.06", "input_thresh" : "-39.20", "target_offset" : "0.58"}`,
+	} {
+		_, err := parseLoudnorm([]byte(output))
+		assert.Error(t, err, "expected no measurement in %q", output)
+	}
+}
+
+func TestLoudnormFilter(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "loudnorm=I=-24:TP=-2:LRA=7", DefaultLoudnorm.Filter())
+	l := Loudnorm{Target: -14, TruePeak: -1, Range: 11}
+	assert.Equal(t, "loudnorm=I=-14:TP=-1:LRA=11", l.Filter())
+	assert.Equal(t,
+		"loudnorm=I=-14:TP=-1:LRA=11:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.2:offset=0.58:linear=true",
+		l.TwoPassFilter(quietTrack))
+}
+
+func TestLoudnormCache(t *testing.T) {
+	t.Parallel()
+	c := NewLoudnormCache(DefaultLoudnorm)
+	_, ok := c.Measurement("track")
+	assert.False(t, ok)
+	c.SetMeasurement("track", quietTrack)
+	m, ok := c.Measurement("track")
+	require.True(t, ok)
+	assert.Equal(t, quietTrack, m)
+
+	// a measured track only needs the playback pass
+	notOpened := func() (io.Reader, error) {
+		require.FailNow(t, "expected the measured track not to be opened")
+		return nil, nil
+	}
+	opts, err := c.EncodeOptions("track", notOpened, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLoudnorm.TwoPassFilter(quietTrack), opts.AudioFilter)
+	assert.Equal(t, dca.StdEncodeOptions.Bitrate, opts.Bitrate)
+	assert.Equal(t, "", dca.StdEncodeOptions.AudioFilter, "expected the standard options to be left alone")
+
+	// the filter follows the caller's filters, and the caller's options are left alone
+	custom := *dca.StdEncodeOptions
+	custom.AudioFilter = "atempo=1.25"
+	opts, err = c.EncodeOptions("track", notOpened, &custom)
+	require.NoError(t, err)
+	assert.Equal(t, "atempo=1.25,"+DefaultLoudnorm.TwoPassFilter(quietTrack), opts.AudioFilter)
+	assert.Equal(t, "atempo=1.25", custom.AudioFilter)
+
+	// a track that fails to open is not measured
+	failure := errors.New("not found")
+	_, err = c.EncodeOptions("other", func() (io.Reader, error) {
+		return nil, failure
+	}, nil)
+	assert.Equal(t, failure, errors.Cause(err))
+	_, ok = c.Measurement("other")
+	assert.False(t, ok)
+}
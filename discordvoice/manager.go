@@ -0,0 +1,175 @@
+package discordvoice
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+)
+
+// ErrEncoderLimit is returned by Manager.NewSource and EncoderPool.TryNewSource while every encoder they allow is in use.
+var ErrEncoderLimit = errors.New("too many encoders")
+
+// Guild is the Player and Device of a guild managed by a Manager.
+type Guild struct {
+	ID     string
+	Player *player.Player
+	Device *Device
+}
+
+func (g *Guild) close() {
+	g.Player.Close()
+	g.Device.Close()
+}
+
+// Manager keeps a Player and a Device for each guild of a discord session,
+// creating them the first time a guild is requested and closing them when the guild becomes unavailable or the bot leaves it.
+type Manager struct {
+	discord     *discordgo.Session
+	sendTimeout time.Duration
+	playerOpts  []player.Option
+	deviceOpts  []Option
+	// encodes the Manager's sources, set by ManagerTranscoder
+	ffmpeg FFmpeg
+	// limits the running encoders once options are parsed, nil if there is no limit
+	maxEncoders int
+	encoders    *EncoderPool
+
+	mu            sync.Mutex
+	guilds        map[string]*Guild
+	closed        bool
+	removeHandler func()
+}
+
+// ManagerOption functions configure a Manager.
+// Pass ManagerOptions to the NewManager function.
+type ManagerOption func(*Manager)
+
+// PlayerOptions are passed to player.New for every guild's Player.
+func PlayerOptions(opts ...player.Option) ManagerOption {
+	return func(m *Manager) {
+		m.playerOpts = append(m.playerOpts, opts...)
+	}
+}
+
+// DeviceOptions are passed to New for every guild's Device.
+// A Transcoder passed in DeviceOptions only encodes the sources of Device.NewSource, use ManagerTranscoder for Manager.NewSource.
+func DeviceOptions(opts ...Option) ManagerOption {
+	return func(m *Manager) {
+		m.deviceOpts = append(m.deviceOpts, opts...)
+	}
+}
+
+// ManagerTranscoder sets the FFmpeg that Manager.NewSource encodes sources with,
+// and passes it to every guild's Device as its Transcoder.
+func ManagerTranscoder(f FFmpeg) ManagerOption {
+	return func(m *Manager) {
+		m.ffmpeg = f
+		m.deviceOpts = append(m.deviceOpts, Transcoder(f))
+	}
+}
+
+// MaxEncoders limits how many sources created with Manager.NewSource can be open at once across every guild,
+// since each runs its own ffmpeg process. The Manager keeps an EncoderPool of n slots that encodes with its Transcoder.
+// Values less than 1 allow any number of encoders.
+// Use an EncoderPool's NewSource instead for sources to wait for an encoder rather than fail.
+func MaxEncoders(n int) ManagerOption {
+	return func(m *Manager) {
+		m.maxEncoders = n
+	}
+}
+
+// NewManager creates a Manager for the guilds of a discord session.
+// Be sure to call Manager.Close to close every guild's Player and Device.
+func NewManager(discord *discordgo.Session, sendTimeout time.Duration, opts ...ManagerOption) *Manager {
+	m := &Manager{
+		discord:     discord,
+		sendTimeout: sendTimeout,
+		guilds:      make(map[string]*Guild),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.maxEncoders > 0 {
+		m.encoders = NewEncoderPool(m.maxEncoders, m.ffmpeg)
+	}
+	m.removeHandler = discord.AddHandler(func(_ *discordgo.Session, g *discordgo.GuildDelete) {
+		m.Remove(g.ID)
+	})
+	return m
+}
+
+// Guild returns the Player and Device of a guild, creating them if needed.
+// Guild returns nil once the Manager is closed.
+func (m *Manager) Guild(guildID string) *Guild {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	g, ok := m.guilds[guildID]
+	if !ok {
+		g = &Guild{
+			ID:     guildID,
+			Player: player.New(m.playerOpts...),
+			Device: New(m.discord, guildID, m.sendTimeout, m.deviceOpts...),
+		}
+		m.guilds[guildID] = g
+	}
+	return g
+}
+
+// Guilds returns the IDs of the guilds that have a Player and Device.
+func (m *Manager) Guilds() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.guilds))
+	for id := range m.guilds {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Remove closes the Player and Device of a guild, if it has them.
+// The Manager removes guilds by itself when they become unavailable or the bot leaves them.
+func (m *Manager) Remove(guildID string) {
+	m.mu.Lock()
+	g, ok := m.guilds[guildID]
+	delete(m.guilds, guildID)
+	m.mu.Unlock()
+	if ok {
+		g.close()
+	}
+}
+
+// Close stops watching the discord session and closes the Player and Device of every guild.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.removeHandler()
+	guilds := m.guilds
+	m.guilds = nil
+	m.mu.Unlock()
+	for _, g := range guilds {
+		g.close()
+	}
+	return nil
+}
+
+// NewSource is like the NewSource function, but fails with ErrEncoderLimit instead of starting another encoder
+// while the Manager's MaxEncoders are in use. The encoder's slot is freed when the source is closed.
+// Sources are encoded with the FFmpeg passed in ManagerTranscoder, if any.
+func (m *Manager) NewSource(r io.Reader, opts *dca.EncodeOptions) (*SourceCloser, error) {
+	if m.encoders == nil {
+		return m.ffmpeg.NewSource(r, opts)
+	}
+	return m.encoders.TryNewSource(r, opts)
+}
@@ -0,0 +1,77 @@
+package discordvoice
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerTranscoder(t *testing.T) {
+	t.Parallel()
+	// device options are only applied to the Devices the Manager creates
+	applied := 0
+	m := NewManager(&discordgo.Session{}, time.Second, ManagerTranscoder(fakeFFmpeg), DeviceOptions(func(*Device) { applied++ }))
+	defer m.Close()
+	assert.Equal(t, 0, applied, "expected no device options to be applied before a guild's Device is created")
+
+	src, err := m.NewSource(bytes.NewReader(pcmFrames(1, 2)), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	assert.Equal(t, [][]byte{{0xFC, 1}, {0xFC, 2}}, readAll(t, src), "expected the source to be encoded with the Transcoder")
+
+	src, err = m.Guild("guild").Device.NewSource(bytes.NewReader(pcmFrames(3)), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	assert.Equal(t, [][]byte{{0xFC, 3}}, readAll(t, src), "expected the guild's Device to encode with the Transcoder")
+	assert.Equal(t, 1, applied)
+}
+
+func TestManagerMaxEncoders(t *testing.T) {
+	t.Parallel()
+	m := NewManager(&discordgo.Session{}, time.Second, MaxEncoders(2), ManagerTranscoder(fakeFFmpeg))
+	defer m.Close()
+
+	// each guild's Player plays a source that stays open after its first frames until its input ends
+	opened := make(chan error, 1)
+	play := func(guildID string) *io.PipeWriter {
+		r, w := io.Pipe()
+		openSrc := func() (player.Source, error) {
+			src, err := m.NewSource(r, nil)
+			opened <- err
+			if err != nil {
+				return nil, err
+			}
+			return src, nil
+		}
+		openDst := func() (io.Writer, error) {
+			return ioutil.Discard, nil
+		}
+		_, err := m.Guild(guildID).Player.Enqueue(guildID, openSrc, openDst)
+		require.NoError(t, err)
+		go w.Write(pcmFrames(1, 2))
+		return w
+	}
+	a := play("a")
+	require.NoError(t, <-opened)
+	b := play("b")
+	defer b.Close()
+	require.NoError(t, <-opened)
+	c := play("c")
+	defer c.Close()
+	assert.Equal(t, ErrEncoderLimit, <-opened, "expected the limit to hold across guilds")
+
+	// a guild's item ending frees its encoder for another guild
+	a.Close()
+	waitFor(t, func() bool { return m.encoders.InUse() == 1 }, "expected the slot of the ended source to be freed")
+	d := play("d")
+	defer d.Close()
+	require.NoError(t, <-opened)
+	assert.Equal(t, 2, m.encoders.InUse())
+}
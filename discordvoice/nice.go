@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package discordvoice
+
+import "syscall"
+
+// setNice lowers the scheduling priority of a process by the nice increment.
+func setNice(pid, nice int) error {
+	if nice <= 0 {
+		return nil
+	}
+	if nice > 19 {
+		nice = 19
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package discordvoice
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFFmpegNice(t *testing.T) {
+	t.Parallel()
+	for nice, want := range map[int]int{5: 5, 40: 19} {
+		f := fakeFFmpeg
+		f.Nice = nice
+		var priority int
+		f.OnStart = func(pid int) error {
+			// linux reports 20 less the nice value
+			p, err := syscall.Getpriority(syscall.PRIO_PROCESS, pid)
+			priority = 20 - p
+			return err
+		}
+		src, err := f.NewSource(bytes.NewReader(pcmFrames(1)), nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, priority, "expected the process to be niced before OnStart with Nice %v", nice)
+		require.NoError(t, src.Close())
+	}
+}
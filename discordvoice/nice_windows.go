@@ -0,0 +1,6 @@
+package discordvoice
+
+// setNice does nothing, windows has priority classes instead of nice increments.
+func setNice(pid, nice int) error {
+	return nil
+}
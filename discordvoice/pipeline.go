@@ -0,0 +1,335 @@
+package discordvoice
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/jeffreymkabot/discordvoice/webm"
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+)
+
+// most frames of silence written after a source ends to push its last frames through the encoder
+const flushFrames = 50
+
+var (
+	// ErrPipelineBusy is returned by Pipeline.Source while the source of another item is still open.
+	ErrPipelineBusy = errors.New("pipeline is encoding another source")
+	// ErrPipelineClosed is returned by Pipeline.Source once the Pipeline is closed.
+	ErrPipelineClosed = errors.New("pipeline is closed")
+)
+
+// Pipeline is a long-lived ffmpeg process that encodes the PCM of one item after another,
+// so items decoded in process, e.g. by the mp3 and resample packages, start playing without spawning ffmpeg.
+// A Player plays one item at a time, so it can share one Pipeline across all of its items.
+type Pipeline struct {
+	ffmpeg FFmpeg
+	opts   *dca.EncodeOptions
+
+	mu     sync.Mutex
+	proc   *process
+	busy   bool
+	closed bool
+}
+
+// process is one run of ffmpeg, restarted by the Pipeline if it fails.
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr bytes.Buffer
+	// demuxes stdout, once the first frame is read
+	out *webm.SourceCloser
+	// frames written to ffmpeg and read from it
+	in   int64
+	read int64
+	// closed once the pump of the last source has stopped writing
+	pumped chan struct{}
+	err    error
+}
+
+// NewPipeline starts an ffmpeg process that encodes PCM with opts, dca.StdEncodeOptions if nil.
+// The PCM must be 48kHz stereo, see the pcm package.
+// Be sure to call Pipeline.Close to end the process.
+func NewPipeline(f FFmpeg, opts *dca.EncodeOptions) (*Pipeline, error) {
+	if opts == nil {
+		opts = dca.StdEncodeOptions
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Pipeline{ffmpeg: f, opts: opts}
+	proc, err := p.start()
+	if err != nil {
+		return nil, err
+	}
+	p.proc = proc
+	return p, nil
+}
+
+func (p *Pipeline) start() (*process, error) {
+	args := append(p.ffmpeg.inputArgs(), "-f", "s16le", "-ar", "48000", "-ac", "2", "-i", "pipe:0")
+	args = append(args, encodeArgs(p.ffmpeg.withThreads(p.opts))...)
+	// write each packet as it is encoded instead of buffering clusters
+	args = append(args, "-flush_packets", "1", "-f", "webm", "-live", "1", "-cluster_time_limit", "20", "pipe:1")
+
+	proc := &process{cmd: p.ffmpeg.command(args), pumped: make(chan struct{})}
+	close(proc.pumped)
+	proc.cmd.Stderr = &proc.stderr
+	var err error
+	if proc.stdin, err = proc.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if proc.stdout, err = proc.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := p.ffmpeg.run(proc.cmd); err != nil {
+		return nil, err
+	}
+	return proc, nil
+}
+
+// Source encodes the PCM frames of src, which must last pcm.FrameDuration, until the returned source is closed.
+// Source fails with ErrPipelineBusy until the source it returned before is closed.
+// src is closed after the returned source is, if it implements io.Closer.
+func (p *Pipeline) Source(src player.Source) (player.SourceCloser, error) {
+	if src.FrameDuration() != pcm.FrameDuration {
+		return nil, errors.Errorf("pipeline requires frames of %v, not %v", pcm.FrameDuration, src.FrameDuration())
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.closed:
+		return nil, ErrPipelineClosed
+	case p.busy:
+		return nil, ErrPipelineBusy
+	}
+	if p.proc.err != nil {
+		// the last source saw ffmpeg fail, start over
+		p.proc.kill()
+		proc, err := p.start()
+		if err != nil {
+			return nil, err
+		}
+		p.proc = proc
+	}
+	p.busy = true
+	s := &pipelineSource{
+		p:      p,
+		proc:   p.proc,
+		src:    src,
+		starts: make(chan int64, 1),
+		ends:   make(chan pumpEnd, 1),
+		done:   make(chan struct{}),
+		pumped: make(chan struct{}),
+	}
+	go s.pump(p.proc.pumped)
+	p.proc.pumped = s.pumped
+	return s, nil
+}
+
+// Close ends the ffmpeg process.
+func (p *Pipeline) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.proc.kill()
+	return nil
+}
+
+func (proc *process) kill() {
+	proc.stdin.Close()
+	proc.cmd.Process.Kill()
+	proc.cmd.Wait()
+}
+
+// readFrame reads the next opus frame ffmpeg encoded.
+func (proc *process) readFrame() ([]byte, error) {
+	if proc.err != nil {
+		return nil, proc.err
+	}
+	if proc.out == nil {
+		out, err := webm.NewSource(proc.stdout)
+		if err != nil {
+			proc.err = errors.Wrapf(err, "ffmpeg failed: %s", bytes.TrimSpace(proc.stderr.Bytes()))
+			return nil, proc.err
+		}
+		proc.out = out
+	}
+	frame, err := proc.out.ReadFrame()
+	if err != nil {
+		proc.err = errors.Wrapf(err, "ffmpeg failed: %s", bytes.TrimSpace(proc.stderr.Bytes()))
+		return nil, proc.err
+	}
+	atomic.AddInt64(&proc.read, 1)
+	return frame, nil
+}
+
+// pumpEnd is the number of frames written to ffmpeg once the source ended, and how it ended.
+type pumpEnd struct {
+	in  int64
+	err error
+}
+
+// pipelineSource is the source of an item encoded by a Pipeline.
+// The frames ffmpeg encodes are numbered in the order they were written,
+// frames numbered before the source's first frame are the silence that flushed the last source and are discarded.
+type pipelineSource struct {
+	p    *Pipeline
+	proc *process
+	src  player.Source
+	// number of the first and one past the last frame of the source, received from the pump
+	starts chan int64
+	ends   chan pumpEnd
+	start  int64
+	end    pumpEnd
+	// whether start and end were received
+	started bool
+	ended   bool
+	// closed when the source is closed and once the pump stops
+	done   chan struct{}
+	pumped chan struct{}
+	once   sync.Once
+}
+
+// pump writes the frames of the source to ffmpeg once the pump of the last source stopped,
+// followed by enough silence to push the source's last frames through.
+// The pump closes the source when it stops, since it may be reading the source when the item ends.
+func (s *pipelineSource) pump(last chan struct{}) {
+	defer close(s.pumped)
+	if c, ok := s.src.(io.Closer); ok {
+		defer c.Close()
+	}
+	select {
+	case <-last:
+	case <-s.done:
+		return
+	}
+	s.starts <- s.proc.in
+
+	pooled := false
+	if ps, ok := s.src.(player.PooledSource); ok {
+		pooled = ps.PooledFrames()
+	}
+	buf := make([]byte, pcm.FrameBytes)
+	var err error
+	for err == nil {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		var frame []byte
+		frame, err = s.src.ReadFrame()
+		if len(frame) > 0 {
+			// pad short frames so every frame written is one frame encoded
+			n := copy(buf, frame)
+			for i := n; i < len(buf); i++ {
+				buf[i] = 0
+			}
+			if pooled {
+				player.FreeFrame(frame)
+			}
+			if _, werr := s.proc.stdin.Write(buf); werr != nil {
+				err = errors.Wrap(werr, "failed to write to ffmpeg")
+				break
+			}
+			s.proc.in++
+		}
+	}
+	end := s.proc.in
+	s.ends <- pumpEnd{in: end, err: err}
+	if err != io.EOF {
+		return
+	}
+
+	silence := make([]byte, pcm.FrameBytes)
+	for i := 0; i < flushFrames && atomic.LoadInt64(&s.proc.read) < end; i++ {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if _, err := s.proc.stdin.Write(silence); err != nil {
+			return
+		}
+		s.proc.in++
+	}
+}
+
+// ReadFrame implements player.SourceCloser.
+func (s *pipelineSource) ReadFrame() ([]byte, error) {
+	for {
+		if !s.ended {
+			select {
+			case s.end = <-s.ends:
+				s.ended = true
+			default:
+			}
+		}
+		if s.ended {
+			if s.end.err != io.EOF {
+				return nil, s.end.err
+			}
+			if atomic.LoadInt64(&s.proc.read) >= s.end.in {
+				return nil, io.EOF
+			}
+		}
+		n := atomic.LoadInt64(&s.proc.read)
+		frame, err := s.proc.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		// the pump sends the start before it writes the first frame,
+		// so until the start is known every frame is silence left over from the last source
+		if !s.started {
+			select {
+			case s.start = <-s.starts:
+				s.started = true
+			default:
+			}
+		}
+		if s.started && n >= s.start {
+			return frame, nil
+		}
+		player.FreeFrame(frame)
+	}
+}
+
+// PooledFrames implements player.PooledSource, ffmpeg's frames are read into buffers from the player's frame pool.
+func (s *pipelineSource) PooledFrames() bool {
+	return true
+}
+
+// FrameDuration implements player.SourceCloser.
+func (s *pipelineSource) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+// PCM implements player.PCMSource, the source produces opus frames.
+func (s *pipelineSource) PCM() bool {
+	return false
+}
+
+// Close implements player.SourceCloser, freeing the Pipeline for the next source.
+func (s *pipelineSource) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.p.mu.Lock()
+		s.p.busy = false
+		s.p.mu.Unlock()
+	})
+	return nil
+}
+
+var _ player.SourceCloser = &pipelineSource{}
+var _ player.PooledSource = &pipelineSource{}
@@ -0,0 +1,120 @@
+package discordvoice
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pcmSource is a source of PCM frames that start with each of its bytes.
+type pcmSource struct {
+	frames [][]byte
+	closed chan struct{}
+}
+
+func newPCMSource(firsts ...byte) *pcmSource {
+	s := &pcmSource{closed: make(chan struct{})}
+	b := pcmFrames(firsts...)
+	for len(b) > 0 {
+		s.frames = append(s.frames, b[:pcm.FrameBytes])
+		b = b[pcm.FrameBytes:]
+	}
+	return s
+}
+
+func (s *pcmSource) ReadFrame() ([]byte, error) {
+	if len(s.frames) == 0 {
+		return nil, io.EOF
+	}
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame, nil
+}
+
+func (s *pcmSource) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+func (s *pcmSource) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	t.Parallel()
+	p, err := NewPipeline(fakeFFmpeg, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	src := newPCMSource(1, 2, 3)
+	enc, err := p.Source(src)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{0xFC, 1}, {0xFC, 2}, {0xFC, 3}}, readAll(t, enc))
+	assert.Equal(t, pcm.FrameDuration, enc.FrameDuration())
+
+	_, err = p.Source(newPCMSource(4))
+	assert.Equal(t, ErrPipelineBusy, err, "expected the pipeline to encode one source at a time")
+
+	require.NoError(t, enc.Close())
+	select {
+	case <-src.closed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the source to be closed after the pipeline's source")
+	}
+
+	// the silence that flushed the last source through the encoder is not played
+	enc, err = p.Source(newPCMSource(4, 5))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{0xFC, 4}, {0xFC, 5}}, readAll(t, enc))
+	require.NoError(t, enc.Close())
+}
+
+func TestPipelineCloseEarly(t *testing.T) {
+	t.Parallel()
+	p, err := NewPipeline(fakeFFmpeg, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	// the item ends before its source does, e.g. it was skipped
+	enc, err := p.Source(newPCMSource(1, 2, 3, 4))
+	require.NoError(t, err)
+	frame, err := enc.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xFC, 1}, frame)
+	require.NoError(t, enc.Close())
+
+	// frames of the last source still in the encoder are not played
+	enc, err = p.Source(newPCMSource(7, 8))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{0xFC, 7}, {0xFC, 8}}, readAll(t, enc))
+	require.NoError(t, enc.Close())
+}
+
+func TestPipelineErrors(t *testing.T) {
+	t.Parallel()
+	p, err := NewPipeline(fakeFFmpeg, nil)
+	require.NoError(t, err)
+
+	_, err = p.Source(newPCMSource())
+	require.NoError(t, err)
+	_, err = p.Source(&stretchedSource{*newPCMSource()})
+	assert.Error(t, err, "expected the pipeline to refuse frames that are not 20ms")
+
+	require.NoError(t, p.Close())
+	require.NoError(t, p.Close(), "expected closing the pipeline again to do nothing")
+	_, err = p.Source(newPCMSource(1))
+	assert.Equal(t, ErrPipelineClosed, err)
+}
+
+// stretchedSource is a source of 40ms frames.
+type stretchedSource struct {
+	pcmSource
+}
+
+func (s *stretchedSource) FrameDuration() time.Duration {
+	return 2 * pcm.FrameDuration
+}
@@ -0,0 +1,90 @@
+package discordvoice
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+)
+
+// EncoderPool limits how many encoders run at once across every Player of a process that shares it,
+// so a bot in many guilds does not start more ffmpeg processes than its host can run.
+// Sources made with NewSource wait for a slot, those made with TryNewSource fail if there is none, as with a Manager's MaxEncoders.
+type EncoderPool struct {
+	ffmpeg  FFmpeg
+	slots   chan struct{}
+	waiting int32
+}
+
+// NewEncoderPool produces an EncoderPool of n slots that encodes with ffmpeg, use the zero FFmpeg for dca's.
+// Values of n less than 1 allow a single encoder.
+func NewEncoderPool(n int, ffmpeg FFmpeg) *EncoderPool {
+	if n < 1 {
+		n = 1
+	}
+	return &EncoderPool{ffmpeg: ffmpeg, slots: make(chan struct{}, n)}
+}
+
+// NewSource is like the NewSource function, but waits for a free slot first.
+// onWait, if not nil, is called if there is no free slot right away, e.g. to tell listeners the item is waiting for an encoder.
+// NewSource gives up waiting when ctx is done. The slot is freed when the source is closed.
+func (p *EncoderPool) NewSource(ctx context.Context, r io.Reader, opts *dca.EncodeOptions, onWait func()) (*SourceCloser, error) {
+	if err := p.acquire(ctx, onWait); err != nil {
+		return nil, err
+	}
+	return p.start(r, opts)
+}
+
+// TryNewSource is like NewSource, but fails with ErrEncoderLimit instead of waiting if there is no free slot.
+func (p *EncoderPool) TryNewSource(r io.Reader, opts *dca.EncodeOptions) (*SourceCloser, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return nil, ErrEncoderLimit
+	}
+	return p.start(r, opts)
+}
+
+// start starts an encoder in the slot the caller took, freeing the slot if it fails to start.
+func (p *EncoderPool) start(r io.Reader, opts *dca.EncodeOptions) (*SourceCloser, error) {
+	src, err := p.ffmpeg.NewSource(r, opts)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	src.release = func() {
+		<-p.slots
+	}
+	return src, nil
+}
+
+// InUse reports how many slots are taken by open sources.
+func (p *EncoderPool) InUse() int {
+	return len(p.slots)
+}
+
+// Waiting reports how many calls to NewSource are waiting for a slot.
+func (p *EncoderPool) Waiting() int {
+	return int(atomic.LoadInt32(&p.waiting))
+}
+
+func (p *EncoderPool) acquire(ctx context.Context, onWait func()) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	atomic.AddInt32(&p.waiting, 1)
+	defer atomic.AddInt32(&p.waiting, -1)
+	if onWait != nil {
+		onWait()
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "gave up waiting for an encoder")
+	}
+}
@@ -0,0 +1,94 @@
+package discordvoice
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jonas747/dca"
+)
+
+// limits of a single ffmpeg atempo filter
+const (
+	minAtempo = 0.5
+	maxAtempo = 2
+)
+
+// Retime changes the pitch and the tempo of media with ffmpeg's asetrate, aresample, and atempo filters.
+type Retime struct {
+	// Pitch is the ratio of the new pitch to the original, e.g. 2 is an octave higher.
+	Pitch float64
+	// Tempo is the ratio of the new speed to the original, e.g. 2 plays twice as fast.
+	Tempo float64
+}
+
+// PitchSpeed returns the Retime that changes the pitch and the tempo by the ratios,
+// values that are not positive keep the original.
+func PitchSpeed(pitch float64, tempo float64) Retime {
+	if pitch <= 0 {
+		pitch = 1
+	}
+	if tempo <= 0 {
+		tempo = 1
+	}
+	return Retime{Pitch: pitch, Tempo: tempo}
+}
+
+// Nightcore plays media faster and higher, like a record played too fast.
+func Nightcore() Retime {
+	return PitchSpeed(1.25, 1.25)
+}
+
+// Daycore plays media slower and lower, like a record played too slow.
+func Daycore() Retime {
+	return PitchSpeed(0.8, 0.8)
+}
+
+// Filter returns the ffmpeg audio filter of the Retime.
+// asetrate changes the pitch and the tempo together, and atempo corrects the tempo without changing the pitch.
+func (r Retime) Filter() string {
+	return r.filter(dca.StdEncodeOptions.FrameRate)
+}
+
+// filter returns the audio filter of the Retime for media sampled at rate.
+func (r Retime) filter(rate int) string {
+	filters := []string{
+		fmt.Sprintf("asetrate=%v", float64(rate)*r.Pitch),
+		fmt.Sprintf("aresample=%v", rate),
+	}
+	// atempo only accepts ratios from 0.5 to 2, chain it for ratios beyond
+	for tempo := r.Tempo / r.Pitch; tempo != 1; {
+		step := tempo
+		if step > maxAtempo {
+			step = maxAtempo
+		} else if step < minAtempo {
+			step = minAtempo
+		}
+		filters = append(filters, fmt.Sprintf("atempo=%v", step))
+		tempo /= step
+	}
+	return strings.Join(filters, ",")
+}
+
+// EncodeOptions returns a copy of opts, or of dca.StdEncodeOptions if opts is nil,
+// whose audio filter retimes the media after any audio filter of opts.
+// The encoder's input must be sampled at the frame rate of opts, which ffmpeg resamples it to by default.
+func (r Retime) EncodeOptions(opts *dca.EncodeOptions) *dca.EncodeOptions {
+	if opts == nil {
+		opts = dca.StdEncodeOptions
+	}
+	retimed := *opts
+	filter := r.filter(opts.FrameRate)
+	if retimed.AudioFilter != "" {
+		filter = retimed.AudioFilter + "," + filter
+	}
+	retimed.AudioFilter = filter
+	return &retimed
+}
+
+// Duration returns the player.Duration option of media that lasts d before it is retimed,
+// so the item's duration, and OnProgress reports against it, match how long the item plays.
+func (r Retime) Duration(d time.Duration) player.SongOption {
+	return player.Duration(time.Duration(float64(d) / r.Tempo))
+}
@@ -0,0 +1,79 @@
+package discordvoice
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jonas747/dca"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetimeFilter(t *testing.T) {
+	t.Parallel()
+	// asetrate changes the pitch and tempo together, which is all nightcore needs
+	assert.Equal(t, Retime{Pitch: 1.25, Tempo: 1.25}, Nightcore())
+	assert.Equal(t, "asetrate=60000,aresample=48000", Nightcore().Filter())
+	assert.Equal(t, Retime{Pitch: 0.8, Tempo: 0.8}, Daycore())
+	assert.Equal(t, "asetrate=38400,aresample=48000", Daycore().Filter())
+
+	// atempo corrects the tempo, chained for ratios beyond [0.5, 2]
+	assert.Equal(t, "asetrate=48000,aresample=48000,atempo=1.5", PitchSpeed(1, 1.5).Filter())
+	assert.Equal(t, "asetrate=96000,aresample=48000,atempo=0.5,atempo=0.5", PitchSpeed(2, 0.5).Filter())
+	assert.Equal(t, "asetrate=12000,aresample=48000,atempo=2,atempo=2,atempo=2", PitchSpeed(0.25, 2).Filter())
+	assert.Equal(t, "asetrate=48000,aresample=48000,atempo=2,atempo=1.5", PitchSpeed(1, 3).Filter())
+
+	// ratios that are not positive keep the original
+	assert.Equal(t, Retime{Pitch: 1, Tempo: 1.5}, PitchSpeed(0, 1.5))
+	assert.Equal(t, Retime{Pitch: 1.5, Tempo: 1}, PitchSpeed(1.5, -1))
+}
+
+func TestRetimeEncodeOptions(t *testing.T) {
+	t.Parallel()
+	opts := Nightcore().EncodeOptions(nil)
+	assert.Equal(t, "asetrate=60000,aresample=48000", opts.AudioFilter)
+	assert.Equal(t, dca.StdEncodeOptions.Bitrate, opts.Bitrate)
+	assert.Equal(t, "", dca.StdEncodeOptions.AudioFilter, "expected the standard options to be left alone")
+
+	// the filter follows the caller's filters at the caller's frame rate, and the caller's options are left alone
+	custom := *dca.StdEncodeOptions
+	custom.FrameRate = 44100
+	custom.AudioFilter = "volume=0.5"
+	opts = Nightcore().EncodeOptions(&custom)
+	assert.Equal(t, "volume=0.5,asetrate=55125,aresample=44100", opts.AudioFilter)
+	assert.Equal(t, "volume=0.5", custom.AudioFilter)
+}
+
+func TestRetimeDuration(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// hold the queue with an item that is still opening
+	opening := make(chan struct{})
+	hold := make(chan struct{})
+	defer close(hold)
+	_, err := p.Enqueue("", func() (player.Source, error) {
+		close(opening)
+		<-hold
+		return nil, io.EOF
+	}, func() (io.Writer, error) {
+		return ioutil.Discard, nil
+	})
+	require.NoError(t, err)
+	<-opening
+
+	// the duration is how long the item plays once it is retimed
+	_, err = p.Enqueue("nightcore", nil, nil, Nightcore().Duration(5*time.Minute))
+	require.NoError(t, err)
+	_, err = p.Enqueue("slowed", nil, nil, PitchSpeed(1, 0.5).Duration(5*time.Minute))
+	require.NoError(t, err)
+	queue := p.Queue()
+	require.Len(t, queue, 2)
+	assert.Equal(t, 4*time.Minute, queue[0].Duration)
+	assert.Equal(t, 10*time.Minute, queue[1].Duration)
+}
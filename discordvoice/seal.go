@@ -0,0 +1,234 @@
+package discordvoice
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// samples per channel in each 20ms frame at 48kHz, which the RTP timestamp counts
+const frameSamples = 960
+
+// PacketConn is implemented by VoiceConns whose send loop writes RTP packets to the voice server as they are,
+// e.g. a native implementation of the voice gateway, so Writers can encrypt the packets before they reach the loop.
+// discordgo's voice connections encrypt frames in their own send loop and do not implement PacketConn.
+type PacketConn interface {
+	VoiceConn
+	// PacketSend receives the encrypted RTP packets to send to the channel,
+	// Writers of a Device with PreEncrypt send to it instead of OpusSend, whose capacity it must have.
+	PacketSend() chan<- []byte
+	// SSRC is the synchronization source the voice gateway assigned to the connection.
+	SSRC() uint32
+	// SecretKey is the key of the session the voice gateway described, for the xsalsa20_poly1305 mode.
+	SecretKey() *[32]byte
+}
+
+// Sealer encrypts the packets of Writers on a pool of goroutines,
+// so the send loop of a PacketConn only paces packets and stays on time on a busy host.
+// Writers of every guild can share one Sealer, its pool bounds how much CPU encryption takes at once.
+// A Writer does not wait for its frames to be encrypted, their packets are handed to its connection in order as they are done.
+type Sealer struct {
+	jobs chan sealJob
+	// closed by Close, jobs is never closed so that sending to it after Close does not panic
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+type sealJob struct {
+	header [12]byte
+	opus   []byte
+	key    *[32]byte
+	done   chan []byte
+}
+
+// NewSealer starts a Sealer with a pool of n goroutines, values of n less than 1 start one per CPU.
+// Be sure to call Sealer.Close once no Writer uses it.
+func NewSealer(n int) *Sealer {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	s := &Sealer{jobs: make(chan sealJob, n), quit: make(chan struct{})}
+	s.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// PreEncrypt makes the Device's Writers encrypt packets on the Sealer's pool before they hand them to the voice connection,
+// if the connection is a PacketConn. Writers send opus frames to other connections as usual.
+// The connections of Session are discordgo's, which do not implement PacketConn,
+// so PreEncrypt only takes effect with a VoiceJoiner whose connections do.
+func PreEncrypt(s *Sealer) Option {
+	return func(d *Device) {
+		d.sealer = s
+	}
+}
+
+func (s *Sealer) work() {
+	defer s.wg.Done()
+	for {
+		select {
+		case job := <-s.jobs:
+			job.done <- seal(job.header, job.opus, job.key)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Seal encrypts an opus frame into an RTP packet on the pool, waiting for the packet.
+// Seal returns nil if the Sealer is closed before the packet is encrypted.
+func (s *Sealer) Seal(header [12]byte, opus []byte, key *[32]byte) []byte {
+	done := make(chan []byte, 1)
+	select {
+	case s.jobs <- sealJob{header: header, opus: opus, key: key, done: done}:
+	case <-s.quit:
+		return nil
+	}
+	select {
+	case packet := <-done:
+		return packet
+	case <-s.quit:
+		return nil
+	}
+}
+
+// Close stops the pool once the packets being encrypted are done, packets that wait to be encrypted are dropped.
+// Writes of Writers that encrypt with the Sealer fail like a send timeout after it is closed.
+func (s *Sealer) Close() {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+	s.wg.Wait()
+}
+
+// sealQueue hands the packets of a Writer to a PacketConn in order as the Sealer finishes encrypting them,
+// so a Write only waits for room in the queue instead of for its frame to be encrypted.
+// The goroutine that hands packets over only runs while there are packets queued.
+type sealQueue struct {
+	sealer *Sealer
+	conn   PacketConn
+	// packets of the frames handed to the Sealer, in the order they were written
+	pending chan chan []byte
+	stop    chan struct{}
+	once    sync.Once
+
+	mu sync.Mutex
+	// frames written that have not been handed to the connection yet, and whether a goroutine is handing them over
+	queued  int
+	running bool
+}
+
+func newSealQueue(sealer *Sealer, conn PacketConn) *sealQueue {
+	return &sealQueue{
+		sealer:  sealer,
+		conn:    conn,
+		pending: make(chan chan []byte, cap(conn.PacketSend())),
+		stop:    make(chan struct{}),
+	}
+}
+
+// push hands a frame to the Sealer behind the frames written before it,
+// reporting false if there was no room for it in the queue before timeout or the Sealer is closed.
+// The frame must not change until it is encrypted, i.e. until the queue has room for another frame after it.
+func (q *sealQueue) push(header [12]byte, opus []byte, timeout <-chan time.Time) bool {
+	select {
+	case <-q.sealer.quit:
+		return false
+	default:
+	}
+	done := make(chan []byte, 1)
+	select {
+	case q.pending <- done:
+	case <-timeout:
+		return false
+	}
+	ok := true
+	select {
+	case q.sealer.jobs <- sealJob{header: header, opus: opus, key: q.conn.SecretKey(), done: done}:
+	case <-q.sealer.quit:
+		// the frame already has its place in pending, forward skips it
+		done <- nil
+		ok = false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued++
+	if !q.running {
+		q.running = true
+		go q.forward()
+	}
+	return ok
+}
+
+// forward hands packets to the connection as they are encrypted until none are queued or the queue or the Sealer is closed.
+func (q *sealQueue) forward() {
+	for {
+		q.mu.Lock()
+		if q.queued == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+		// a frame is queued before it is counted, so there is one waiting
+		done := <-q.pending
+		var packet []byte
+		select {
+		case packet = <-done:
+		case <-q.stop:
+			return
+		case <-q.sealer.quit:
+			// the Sealer may have dropped the frame before encrypting it
+			return
+		}
+		if packet != nil {
+			select {
+			case q.conn.PacketSend() <- packet:
+			case <-q.stop:
+				return
+			}
+		}
+		q.mu.Lock()
+		q.queued--
+		q.mu.Unlock()
+	}
+}
+
+// len is how many frames were written that have not been handed to the connection yet.
+func (q *sealQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// close stops handing packets to the connection, e.g. once the Writer replaced it.
+func (q *sealQueue) close() {
+	q.once.Do(func() {
+		close(q.stop)
+	})
+}
+
+// rtpHeader is the header of the RTP packet of a frame, like discordgo's.
+func rtpHeader(seq uint16, timestamp uint32, ssrc uint32) (header [12]byte) {
+	header[0] = 0x80
+	header[1] = 0x78
+	binary.BigEndian.PutUint16(header[2:], seq)
+	binary.BigEndian.PutUint32(header[4:], timestamp)
+	binary.BigEndian.PutUint32(header[8:], ssrc)
+	return header
+}
+
+// seal encrypts an opus frame after its RTP header, which doubles as the nonce in the xsalsa20_poly1305 mode.
+func seal(header [12]byte, opus []byte, key *[32]byte) []byte {
+	var nonce [24]byte
+	copy(nonce[:], header[:])
+	packet := make([]byte, len(header), len(header)+len(opus)+secretbox.Overhead)
+	copy(packet, header[:])
+	return secretbox.Seal(packet, opus, &nonce, key)
+}
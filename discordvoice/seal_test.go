@@ -0,0 +1,281 @@
+package discordvoice
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// fakePacketConn is a FakeVoiceConn whose send loop takes encrypted packets.
+type fakePacketConn struct {
+	*FakeVoiceConn
+	packets chan []byte
+	ssrc    uint32
+	key     [32]byte
+}
+
+func (c *fakePacketConn) PacketSend() chan<- []byte {
+	return c.packets
+}
+
+func (c *fakePacketConn) SSRC() uint32 {
+	return c.ssrc
+}
+
+func (c *fakePacketConn) SecretKey() *[32]byte {
+	return &c.key
+}
+
+// packetJoiner joins fakePacketConns, each with its own SSRC and key.
+type packetJoiner struct {
+	*FakeJoiner
+	conns []*fakePacketConn
+}
+
+func (j *packetJoiner) ChannelVoiceJoin(guildID string, channelID string, mute bool, deaf bool) (VoiceConn, error) {
+	vconn, err := j.FakeJoiner.ChannelVoiceJoin(guildID, channelID, mute, deaf)
+	if err != nil {
+		return nil, err
+	}
+	n := len(j.conns) + 1
+	pc := &fakePacketConn{
+		FakeVoiceConn: vconn.(*FakeVoiceConn),
+		packets:       make(chan []byte, j.buffer),
+		ssrc:          uint32(n),
+	}
+	pc.key[0] = byte(n)
+	j.conns = append(j.conns, pc)
+	return pc, nil
+}
+
+// unseal decrypts a packet, reporting its sequence number and timestamp.
+func unseal(t *testing.T, packet []byte, conn *fakePacketConn) (seq uint16, timestamp uint32, opus []byte) {
+	require.True(t, len(packet) > 12)
+	assert.Equal(t, []byte{0x80, 0x78}, packet[:2])
+	assert.Equal(t, conn.ssrc, binary.BigEndian.Uint32(packet[8:12]))
+	var nonce [24]byte
+	copy(nonce[:], packet[:12])
+	opus, ok := secretbox.Open(nil, packet[12:], &nonce, &conn.key)
+	require.True(t, ok, "expected the packet to be encrypted with the key of the connection")
+	return binary.BigEndian.Uint16(packet[2:]), binary.BigEndian.Uint32(packet[4:]), opus
+}
+
+func TestPreEncrypt(t *testing.T) {
+	t.Parallel()
+	sealer := NewSealer(2)
+	defer sealer.Close()
+	joiner := &packetJoiner{FakeJoiner: NewFakeJoiner(2, "channel")}
+	d := NewDevice(joiner, "guild", time.Second, PreEncrypt(sealer), onceBackoff)
+	defer d.Close()
+
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	for _, frame := range []string{"a", "b"} {
+		n, err := w.Write([]byte(frame))
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+	conn := joiner.conns[0]
+	assert.Len(t, conn.Frames(), 0, "expected no opus frames to be sent to the connection's send loop")
+	queued, _ := w.(*Writer).Buffered()
+	assert.Equal(t, 2*frameDuration, queued)
+
+	seq, timestamp, opus := unseal(t, <-conn.packets, conn)
+	assert.Equal(t, uint16(0), seq)
+	assert.Equal(t, uint32(0), timestamp)
+	assert.Equal(t, []byte("a"), opus)
+	seq, timestamp, opus = unseal(t, <-conn.packets, conn)
+	assert.Equal(t, uint16(1), seq)
+	assert.Equal(t, uint32(frameSamples), timestamp)
+	assert.Equal(t, []byte("b"), opus)
+
+	// frames still queued when the connection drops are encrypted again for the new connection
+	_, err = w.Write([]byte("c"))
+	require.NoError(t, err)
+	conn.SetReady(false)
+	_, err = w.Write([]byte("d"))
+	require.NoError(t, err)
+	require.Len(t, joiner.conns, 2)
+	conn = joiner.conns[1]
+	for _, frame := range []string{"c", "d"} {
+		_, _, opus = unseal(t, <-conn.packets, conn)
+		assert.Equal(t, []byte(frame), opus)
+	}
+}
+
+func TestPreEncryptAhead(t *testing.T) {
+	t.Parallel()
+	sealer := NewSealer(1)
+	defer sealer.Close()
+	// occupy the only goroutine of the pool until the test receives from blocked
+	blocked := make(chan []byte)
+	sealer.jobs <- sealJob{opus: []byte("x"), key: new([32]byte), done: blocked}
+	joiner := &packetJoiner{FakeJoiner: NewFakeJoiner(2, "channel")}
+	d := NewDevice(joiner, "guild", time.Second, PreEncrypt(sealer), onceBackoff)
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+
+	// writes return before their frames are encrypted
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("a"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the write not to wait for the frame to be encrypted")
+	}
+	conn := joiner.conns[0]
+	assert.Len(t, conn.packets, 0)
+	queued, _ := w.(*Writer).Buffered()
+	assert.Equal(t, frameDuration, queued, "expected a frame waiting to be encrypted to count as queued")
+
+	<-blocked
+	_, _, opus := unseal(t, <-conn.packets, conn)
+	assert.Equal(t, []byte("a"), opus)
+}
+
+func TestPreEncryptDTX(t *testing.T) {
+	t.Parallel()
+	sealer := NewSealer(2)
+	defer sealer.Close()
+	joiner := &packetJoiner{FakeJoiner: NewFakeJoiner(4, "channel")}
+	d := NewDevice(joiner, "guild", time.Second, PreEncrypt(sealer), DTX(true), onceBackoff)
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+
+	// frames of silence are not sent, but the RTP timestamp counts them
+	for _, frame := range [][]byte{[]byte("abcd"), {0xF8, 0xFF, 0xFE}, {0xF8, 0xFF, 0xFE}, []byte("efgh")} {
+		_, err := w.Write(frame)
+		require.NoError(t, err)
+	}
+	conn := joiner.conns[0]
+	seq, timestamp, opus := unseal(t, <-conn.packets, conn)
+	assert.Equal(t, uint16(0), seq)
+	assert.Equal(t, uint32(0), timestamp)
+	assert.Equal(t, []byte("abcd"), opus)
+	seq, timestamp, opus = unseal(t, <-conn.packets, conn)
+	assert.Equal(t, uint16(1), seq, "expected sequence numbers to count the packets that were sent")
+	assert.Equal(t, uint32(3*frameSamples), timestamp, "expected the timestamp to count the frames that were not sent")
+	assert.Equal(t, []byte("efgh"), opus)
+	assert.Len(t, conn.packets, 0)
+}
+
+func TestPreEncryptOpusSend(t *testing.T) {
+	t.Parallel()
+	sealer := NewSealer(1)
+	defer sealer.Close()
+	// discordgo's connections encrypt frames themselves
+	joiner := NewFakeJoiner(2, "channel")
+	d := NewDevice(joiner, "guild", time.Second, PreEncrypt(sealer))
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), receive(t, joiner.Joined()[0]))
+
+	// packet connections of a Device that does not pre-encrypt get opus frames
+	pj := &packetJoiner{FakeJoiner: NewFakeJoiner(2, "channel")}
+	d = NewDevice(pj, "guild", time.Second)
+	defer d.Close()
+	w, err = d.Open("channel")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b"), receive(t, pj.conns[0].FakeVoiceConn))
+	assert.Len(t, pj.conns[0].packets, 0)
+}
+
+func TestPreEncryptClosedSealer(t *testing.T) {
+	t.Parallel()
+	sealer := NewSealer(1)
+	joiner := &packetJoiner{FakeJoiner: NewFakeJoiner(2, "channel")}
+	d := NewDevice(joiner, "guild", time.Second, PreEncrypt(sealer), onceBackoff)
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(t, err)
+
+	// closing the Sealer first is not a panic
+	sealer.Close()
+	assert.Nil(t, sealer.Seal(rtpHeader(0, 0, 1), []byte("a"), new([32]byte)))
+	_, err = w.Write([]byte("a"))
+	assert.Error(t, err, "expected writes to fail once the Sealer is closed")
+	for _, conn := range joiner.conns {
+		assert.Len(t, conn.packets, 0)
+	}
+	sealer.Close()
+}
+
+// a typical 20ms frame at 64kb/s
+var benchFrame = make([]byte, 160)
+
+func BenchmarkSeal(b *testing.B) {
+	var key [32]byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		seal(rtpHeader(uint16(i), uint32(i*frameSamples), 1), benchFrame, &key)
+	}
+}
+
+func BenchmarkSealer(b *testing.B) {
+	sealer := NewSealer(0)
+	defer sealer.Close()
+	var key [32]byte
+	b.ReportAllocs()
+	// the Writers of many guilds encrypting at once
+	b.RunParallel(func(pb *testing.PB) {
+		var seq uint16
+		for pb.Next() {
+			sealer.Seal(rtpHeader(seq, uint32(seq)*frameSamples, 1), benchFrame, &key)
+			seq++
+		}
+	})
+}
+
+func benchmarkWriter(b *testing.B, opts ...Option) {
+	joiner := &packetJoiner{FakeJoiner: NewFakeJoiner(10, "channel")}
+	d := NewDevice(joiner, "guild", time.Second, opts...)
+	defer d.Close()
+	w, err := d.Open("channel")
+	require.NoError(b, err)
+	conn := joiner.conns[0]
+	// the connection's send loop, which only takes packets off the queue
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-conn.packets:
+			case <-conn.Frames():
+			case <-done:
+				return
+			}
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(benchFrame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	benchmarkWriter(b)
+}
+
+func BenchmarkWriterPreEncrypt(b *testing.B) {
+	sealer := NewSealer(0)
+	defer sealer.Close()
+	benchmarkWriter(b, PreEncrypt(sealer))
+}
@@ -2,6 +2,7 @@ package discordvoice
 
 import (
 	"io"
+	"sync"
 	"time"
 
 	"github.com/jeffreymkabot/discordvoice"
@@ -11,18 +12,58 @@ import (
 // SourceCloser provides a source of opus frames suitable for a discord voice channel.
 type SourceCloser struct {
 	r   io.Reader
-	enc *dca.EncodeSession
+	enc encoder
+	// frees the encoder's slot of a Manager, if any
+	release func()
+	once    sync.Once
 }
 
 // NewSource produces a source of opus frames suitable for a discord voice channel.
 // The opus encoder requires ffmpeg available in the PATH.
 // If the reader implements io.Closer the reader will be closed when the source is closed.
 func NewSource(r io.Reader, opts *dca.EncodeOptions) (*SourceCloser, error) {
-	enc, err := dca.EncodeMem(r, opts)
-	if err != nil {
-		return nil, err
+	return FFmpeg{}.NewSource(r, opts)
+}
+
+// NewSeekOpener produces a player.SeekOpenerFunc for use with the player.Reopen option,
+// reopening the reader and restarting the opus encoder at the offset with opts, dca.StdEncodeOptions if nil.
+func NewSeekOpener(open func() (io.Reader, error), opts *dca.EncodeOptions) player.SeekOpenerFunc {
+	return FFmpeg{}.NewSeekOpener(open, opts)
+}
+
+// NewSeekOpener is like the NewSeekOpener function, but runs the configured ffmpeg.
+func (f FFmpeg) NewSeekOpener(open func() (io.Reader, error), opts *dca.EncodeOptions) player.SeekOpenerFunc {
+	if opts == nil {
+		opts = dca.StdEncodeOptions
+	}
+	return func(offset time.Duration) (player.Source, error) {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		// ffmpeg only seeks to whole seconds through dca
+		seekOpts := *opts
+		seekOpts.StartTime = int(offset / time.Second)
+		src, err := f.NewSource(r, &seekOpts)
+		if err != nil {
+			if rc, ok := r.(io.Closer); ok {
+				rc.Close()
+			}
+			return nil, err
+		}
+		// discard frames up to the exact offset
+		skip := (offset - time.Duration(seekOpts.StartTime)*time.Second) / src.FrameDuration()
+		for i := 0; i < int(skip); i++ {
+			frame, err := src.ReadFrame()
+			if err != nil {
+				break
+			}
+			if src.PooledFrames() {
+				player.FreeFrame(frame)
+			}
+		}
+		return src, nil
 	}
-	return &SourceCloser{r: r, enc: enc}, nil
 }
 
 // ReadFrame implements player.SourceCloser.
@@ -30,19 +71,36 @@ func (s *SourceCloser) ReadFrame() ([]byte, error) {
 	return s.enc.OpusFrame()
 }
 
+// PooledFrames implements player.PooledSource.
+// A configured FFmpeg's frames are read into buffers from the player's frame pool,
+// the zero FFmpeg's dca encode session allocates each frame itself.
+func (s *SourceCloser) PooledFrames() bool {
+	_, pooled := s.enc.(*ffmpegEncoder)
+	return pooled
+}
+
 // FrameDuration implements player.SourceCloser.
 func (s *SourceCloser) FrameDuration() time.Duration {
 	return s.enc.FrameDuration()
 }
 
+// PCM implements player.PCMSource, the source produces opus frames.
+func (s *SourceCloser) PCM() bool {
+	return false
+}
+
 // Close implements player.SourceCloser.
 func (s *SourceCloser) Close() error {
 	s.enc.Cleanup()
+	if s.release != nil {
+		s.once.Do(s.release)
+	}
 	if rc, ok := s.r.(io.Closer); ok {
 		return rc.Close()
 	}
 	return nil
 }
 
-// do no compile unless SourceCloser implements player.SourceCloser.
+// do no compile unless SourceCloser implements player.SourceCloser and player.PooledSource.
 var _ player.SourceCloser = &SourceCloser{}
+var _ player.PooledSource = &SourceCloser{}
@@ -0,0 +1,77 @@
+package discordvoice
+
+import "time"
+
+// WriterStats describes how a Writer's voice connection has been keeping up,
+// to tell problems on discord's side, e.g. send timeouts and reconnects, apart from problems encoding frames fast enough.
+type WriterStats struct {
+	// FramesSent is how many frames the Writer handed to its voice connection.
+	FramesSent int64
+	// FramesExpected is how many frames the Writer should have sent for as long as it has been speaking,
+	// more than FramesSent if frames were written late.
+	FramesExpected int64
+	SendTimeouts   int64
+	Reconnects     int64
+	// SendWait is the average time a frame waited for room in the voice connection's queue.
+	SendWait time.Duration
+	// GatewayLatency is the round trip time of the discord gateway's heartbeat,
+	// reported by the VoiceJoiner if it implements LatencyReporter, 0 otherwise.
+	// It is not the round trip of the voice connection the frames are sent on, which discordgo does not measure,
+	// so a high GatewayLatency points to the bot's connection to discord as a whole rather than to the voice server.
+	GatewayLatency time.Duration
+}
+
+// LatencyReporter is implemented by VoiceJoiners that measure the round trip time of their discord gateway connection.
+type LatencyReporter interface {
+	Latency() time.Duration
+}
+
+// writerStats are the counters behind WriterStats, guarded by the Writer's mu.
+type writerStats struct {
+	sent         int64
+	sendTimeouts int64
+	reconnects   int64
+	sendWait     time.Duration
+	// frames expected in the finished stretches of speaking
+	expected int64
+	// when the current stretch of speaking started and when its last frame was sent
+	since    time.Time
+	lastSent time.Time
+}
+
+// stretch is how many frames were expected in the current stretch of speaking.
+func (s *writerStats) stretch() int64 {
+	if s.lastSent.Before(s.since) {
+		return 0
+	}
+	return int64(s.lastSent.Sub(s.since)/frameDuration) + 1
+}
+
+// Stats reports how the Writer's voice connection has been keeping up since the Writer was opened.
+func (w *Writer) Stats() WriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := WriterStats{
+		FramesSent:     w.stats.sent,
+		FramesExpected: w.stats.expected,
+		SendTimeouts:   w.stats.sendTimeouts,
+		Reconnects:     w.stats.reconnects,
+	}
+	if w.speaking {
+		stats.FramesExpected += w.stats.stretch()
+	}
+	if w.stats.sent > 0 {
+		stats.SendWait = w.stats.sendWait / time.Duration(w.stats.sent)
+	}
+	if lr, ok := w.joiner.(LatencyReporter); ok {
+		stats.GatewayLatency = lr.Latency()
+	}
+	return stats
+}
+
+func (s sessionJoiner) Latency() time.Duration {
+	return s.discord.HeartbeatLatency()
+}
+
+// do not compile unless the discordgo session's VoiceJoiner implements LatencyReporter
+var _ LatencyReporter = sessionJoiner{}
@@ -0,0 +1,30 @@
+package discordvoice
+
+// opus frames this short carry nothing but silence, e.g. the frames a VBR encoder produces for digital silence
+const dtxFrameLen = 3
+
+// PacketLoss sets the packet loss percentage that Device.EncodeOptions tells the opus encoder to expect,
+// making the encoder's frames more robust on lossy connections at the cost of quality.
+// By default the packet loss of the options passed to EncodeOptions is kept.
+// The in-band forward error correction of opus cannot be turned on through dca's ffmpeg arguments.
+func PacketLoss(percent int) Option {
+	return func(d *Device) {
+		if percent >= 0 && percent <= 100 {
+			d.packetLoss = percent
+		}
+	}
+}
+
+// DTX turns on discontinuous transmission: Writers do not send frames that carry nothing but silence,
+// and turn off their speaking indicator during long silences, saving bandwidth during quiet passages.
+// The frames that are not sent are still reported as written to the player.
+func DTX(enabled bool) Option {
+	return func(d *Device) {
+		d.dtx = enabled
+	}
+}
+
+// silent reports whether the Writer does not send the frame because of DTX.
+func (w *Writer) silent(p []byte) bool {
+	return w.dtx && len(p) <= dtxFrameLen
+}
@@ -0,0 +1,137 @@
+package discordvoice
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// VoiceConn is the part of a discord voice connection that a Writer sends frames through.
+// It is satisfied by wrapping a *discordgo.VoiceConnection, and by FakeVoiceConn in tests.
+type VoiceConn interface {
+	// OpusSend receives the opus frames to send to the channel.
+	OpusSend() chan<- []byte
+	Speaking(b bool) error
+	Disconnect() error
+	// ChannelID is the channel the connection is in, which changes if the bot is moved to another channel.
+	ChannelID() string
+	Ready() bool
+}
+
+// discordgoVoiceConn adapts a *discordgo.VoiceConnection to VoiceConn.
+type discordgoVoiceConn struct {
+	vconn *discordgo.VoiceConnection
+}
+
+func (c discordgoVoiceConn) OpusSend() chan<- []byte {
+	return c.vconn.OpusSend
+}
+
+func (c discordgoVoiceConn) Speaking(b bool) error {
+	return c.vconn.Speaking(b)
+}
+
+func (c discordgoVoiceConn) Disconnect() error {
+	return c.vconn.Disconnect()
+}
+
+func (c discordgoVoiceConn) ChannelID() string {
+	c.vconn.RWMutex.RLock()
+	defer c.vconn.RWMutex.RUnlock()
+	return c.vconn.ChannelID
+}
+
+func (c discordgoVoiceConn) Ready() bool {
+	c.vconn.RWMutex.RLock()
+	defer c.vconn.RWMutex.RUnlock()
+	return c.vconn.Ready
+}
+
+// FakeVoiceConn is a VoiceConn that keeps the frames sent to it instead of sending them to discord,
+// so the timeout and reconnect logic of Writers can be tested without a discord gateway.
+// Frames are only taken out of the connection by receiving from Frames,
+// so a test can fill the buffer to make the next send time out.
+type FakeVoiceConn struct {
+	mu           sync.Mutex
+	channelID    string
+	ready        bool
+	speaking     bool
+	disconnected bool
+	send         chan []byte
+}
+
+// NewFakeVoiceConn creates a ready FakeVoiceConn in a channel that buffers up to buffer frames.
+func NewFakeVoiceConn(channelID string, buffer int) *FakeVoiceConn {
+	return &FakeVoiceConn{
+		channelID: channelID,
+		ready:     true,
+		send:      make(chan []byte, buffer),
+	}
+}
+
+func (c *FakeVoiceConn) OpusSend() chan<- []byte {
+	return c.send
+}
+
+// Frames receives the frames sent to the connection.
+func (c *FakeVoiceConn) Frames() <-chan []byte {
+	return c.send
+}
+
+func (c *FakeVoiceConn) Speaking(b bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.speaking = b
+	return nil
+}
+
+// IsSpeaking reports the last value passed to Speaking.
+func (c *FakeVoiceConn) IsSpeaking() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.speaking
+}
+
+func (c *FakeVoiceConn) Disconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = false
+	c.disconnected = true
+	return nil
+}
+
+// Disconnected reports whether Disconnect was called.
+func (c *FakeVoiceConn) Disconnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.disconnected
+}
+
+func (c *FakeVoiceConn) ChannelID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channelID
+}
+
+func (c *FakeVoiceConn) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready
+}
+
+// SetReady simulates the connection dropping, or coming back, e.g. during a discord outage.
+func (c *FakeVoiceConn) SetReady(ready bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = ready
+}
+
+// Move simulates the bot being dragged into another channel.
+func (c *FakeVoiceConn) Move(channelID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channelID = channelID
+}
+
+// do not compile unless FakeVoiceConn implements VoiceConn
+var _ VoiceConn = &FakeVoiceConn{}
@@ -0,0 +1,70 @@
+package discordvoice
+
+import "time"
+
+// Disconnecting its own voice connection, e.g. to reconnect after a send timeout,
+// is echoed back to the bot as a voice state without a channel, which must not be mistaken for someone else disconnecting it.
+const leaveGrace = 5 * time.Second
+
+// MoveEvent describes someone else moving the bot to another voice channel or disconnecting it from voice.
+type MoveEvent struct {
+	// From is the channel of the open Writer.
+	From string
+	// To is the channel the Writer plays in now, empty if the bot was disconnected.
+	// Writes fail with ErrDisconnected until the Writer is moved or a new Writer is opened.
+	To string
+}
+
+// OnMoved sets a function called when someone else moves the bot to another voice channel of the guild,
+// e.g. by dragging it, or disconnects it from voice, so the player can be paused, retargeted, or stopped.
+// A Writer that was moved keeps playing in the new channel.
+func (d *Device) OnMoved(f func(MoveEvent)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onMoved = f
+}
+
+// Moved tells the Device that the voice state of the bot itself changed to channelID, empty if it left voice.
+// Devices created with New learn this from the discord session.
+func (d *Device) Moved(channelID string) {
+	d.mu.Lock()
+	if d.writer == nil {
+		d.mu.Unlock()
+		return
+	}
+	ev, ok := d.writer.moved(channelID)
+	onMoved := d.onMoved
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+	if ev.To == "" {
+		d.log.Infof("guild %v: disconnected from channel %v", d.guildID, ev.From)
+	} else {
+		d.log.Infof("guild %v: moved from channel %v to %v", d.guildID, ev.From, ev.To)
+	}
+	if onMoved != nil {
+		onMoved(ev)
+	}
+}
+
+// moved updates the Writer for a change to the bot's voice state,
+// reporting false if the Writer caused the change itself.
+func (w *Writer) moved(channelID string) (MoveEvent, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// an idle Writer left its channel itself
+	if channelID == w.channelID || w.disconnected || w.idle {
+		return MoveEvent{}, false
+	}
+	ev := MoveEvent{From: w.channelID, To: channelID}
+	if channelID == "" {
+		if w.clock.Now().Sub(w.leftAt) < leaveGrace {
+			return MoveEvent{}, false
+		}
+		w.disconnected = true
+		return ev, true
+	}
+	w.channelID = channelID
+	return ev, true
+}
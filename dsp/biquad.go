@@ -0,0 +1,95 @@
+package dsp
+
+import (
+	"math"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// Biquad is a second order IIR filter applied to each channel,
+// with coefficients from the Audio EQ Cookbook by Robert Bristow-Johnson.
+type Biquad struct {
+	b0, b1, b2, a1, a2 float64
+	// previous inputs and outputs of each channel
+	x1, x2, y1, y2 [pcm.Channels]float64
+}
+
+// LowPass passes frequencies below freq Hz, with a resonance of q, e.g. 0.707 for no peak.
+func LowPass(freq float64, q float64) *Biquad {
+	w, alpha := omega(freq, q)
+	cos := math.Cos(w)
+	return normalize((1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// HighPass passes frequencies above freq Hz, with a resonance of q, e.g. 0.707 for no peak.
+func HighPass(freq float64, q float64) *Biquad {
+	w, alpha := omega(freq, q)
+	cos := math.Cos(w)
+	return normalize((1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// Peaking boosts or cuts frequencies around freq Hz by gain dB, in a band as narrow as q is high.
+func Peaking(freq float64, q float64, gain float64) *Biquad {
+	w, alpha := omega(freq, q)
+	a := math.Pow(10, gain/40)
+	cos := math.Cos(w)
+	return normalize(1+alpha*a, -2*cos, 1-alpha*a, 1+alpha/a, -2*cos, 1-alpha/a)
+}
+
+// LowShelf boosts or cuts frequencies below freq Hz by gain dB.
+func LowShelf(freq float64, gain float64) *Biquad {
+	a := math.Pow(10, gain/40)
+	w, alpha := omega(freq, math.Sqrt2/2)
+	cos := math.Cos(w)
+	sq := 2 * math.Sqrt(a) * alpha
+	return normalize(
+		a*((a+1)-(a-1)*cos+sq),
+		2*a*((a-1)-(a+1)*cos),
+		a*((a+1)-(a-1)*cos-sq),
+		(a+1)+(a-1)*cos+sq,
+		-2*((a-1)+(a+1)*cos),
+		(a+1)+(a-1)*cos-sq,
+	)
+}
+
+// HighShelf boosts or cuts frequencies above freq Hz by gain dB.
+func HighShelf(freq float64, gain float64) *Biquad {
+	a := math.Pow(10, gain/40)
+	w, alpha := omega(freq, math.Sqrt2/2)
+	cos := math.Cos(w)
+	sq := 2 * math.Sqrt(a) * alpha
+	return normalize(
+		a*((a+1)+(a-1)*cos+sq),
+		-2*a*((a-1)+(a+1)*cos),
+		a*((a+1)+(a-1)*cos-sq),
+		(a+1)-(a-1)*cos+sq,
+		2*((a-1)-(a+1)*cos),
+		(a+1)-(a-1)*cos-sq,
+	)
+}
+
+func omega(freq float64, q float64) (w float64, alpha float64) {
+	w = 2 * math.Pi * freq / pcm.SampleRate
+	return w, math.Sin(w) / (2 * q)
+}
+
+func normalize(b0, b1, b2, a0, a1, a2 float64) *Biquad {
+	return &Biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+// Process implements Filter.
+func (f *Biquad) Process(samples []float32) {
+	for i, s := range samples {
+		c := i % pcm.Channels
+		x := float64(s)
+		y := f.b0*x + f.b1*f.x1[c] + f.b2*f.x2[c] - f.a1*f.y1[c] - f.a2*f.y2[c]
+		f.x2[c], f.x1[c] = f.x1[c], x
+		f.y2[c], f.y1[c] = f.y1[c], y
+		samples[i] = float32(y)
+	}
+}
+
+// Reset implements Filter.
+func (f *Biquad) Reset() {
+	f.x1, f.x2, f.y1, f.y2 = [pcm.Channels]float64{}, [pcm.Channels]float64{}, [pcm.Channels]float64{}, [pcm.Channels]float64{}
+}
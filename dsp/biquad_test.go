@@ -0,0 +1,87 @@
+package dsp_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jeffreymkabot/discordvoice/dsp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// impulse is n stereo samples of silence but for a full scale sample in the left channel of the first.
+func impulse(n int) []float32 {
+	samples := make([]float32, 2*n)
+	samples[0] = 1
+	return samples
+}
+
+// left is the samples of the left channel of stereo samples.
+func left(samples []float32) []float64 {
+	out := make([]float64, 0, len(samples)/2)
+	for i := 0; i < len(samples); i += 2 {
+		out = append(out, float64(samples[i]))
+	}
+	return out
+}
+
+func assertSamples(t *testing.T, want []float64, got []float64) {
+	require.Len(t, got, len(want))
+	for i := range want {
+		assert.InDelta(t, want[i], got[i], 1e-6, "expected sample %v", i)
+	}
+}
+
+func TestLowPass(t *testing.T) {
+	t.Parallel()
+	// at a quarter of the sample rate, cos(w) = 0 and alpha = 1/sqrt(2), so
+	// b0 = b2 = (2-sqrt(2))/2, b1 = 2-sqrt(2), a1 = 0, and a2 = 3-2*sqrt(2)
+	b0 := (2 - math.Sqrt2) / 2
+	b1 := 2 - math.Sqrt2
+	a2 := 3 - 2*math.Sqrt2
+	samples := impulse(4)
+	dsp.LowPass(12000, math.Sqrt2/2).Process(samples)
+	assertSamples(t, []float64{b0, b1, b0 - a2*b0, -a2 * b1}, left(samples))
+	for i := 1; i < len(samples); i += 2 {
+		assert.Equal(t, float32(0), samples[i], "expected the right channel to be filtered on its own")
+	}
+}
+
+func TestHighPass(t *testing.T) {
+	t.Parallel()
+	// b0 = b2 = 1/(2+sqrt(2)) and b1 = -2*b0, with the same poles as the low pass filter
+	b0 := 1 / (2 + math.Sqrt2)
+	a2 := 3 - 2*math.Sqrt2
+	samples := impulse(4)
+	dsp.HighPass(12000, math.Sqrt2/2).Process(samples)
+	assertSamples(t, []float64{b0, -2 * b0, b0 - a2*b0, 2 * a2 * b0}, left(samples))
+}
+
+func TestBiquadDC(t *testing.T) {
+	t.Parallel()
+	// a constant signal passes a low pass filter and is blocked by a high pass filter
+	dc := func(f dsp.Filter) float64 {
+		samples := make([]float32, 2*4800)
+		for i := range samples {
+			samples[i] = 0.5
+		}
+		f.Process(samples)
+		return float64(samples[len(samples)-1])
+	}
+	assert.InDelta(t, 0.5, dc(dsp.LowPass(1000, math.Sqrt2/2)), 1e-4)
+	assert.InDelta(t, 0, dc(dsp.HighPass(1000, math.Sqrt2/2)), 1e-4)
+	assert.InDelta(t, 0.5, dc(dsp.Peaking(1000, 1, 12)), 1e-4, "expected a peaking filter to leave other frequencies alone")
+	assert.InDelta(t, 0.5*math.Pow(10, 6.0/20), dc(dsp.LowShelf(1000, 6)), 1e-4)
+	assert.InDelta(t, 0.5, dc(dsp.HighShelf(1000, 6)), 1e-4)
+}
+
+func TestBiquadReset(t *testing.T) {
+	t.Parallel()
+	f := dsp.LowPass(12000, math.Sqrt2/2)
+	first := impulse(4)
+	f.Process(first)
+	f.Reset()
+	second := impulse(4)
+	f.Process(second)
+	assert.Equal(t, first, second, "expected the filter to forget the first impulse")
+}
@@ -0,0 +1,115 @@
+// Package dsp provides composable transforms of standard PCM, e.g. gain, equalization, and limiting,
+// that wrap any source of standard PCM, so audio can be shaped without ffmpeg's audio filters,
+// e.g. for mp3 sources that are decoded in Go.
+package dsp
+
+import (
+	"io"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// Filter transforms frames of interleaved standard PCM in place.
+// Samples are scaled to [-1, 1) but may exceed that range between filters, they are clipped once the chain ends.
+// Filters keep state between frames, so a Filter must only process one stream.
+type Filter interface {
+	Process(samples []float32)
+	// Reset forgets the state of earlier frames, e.g. after a seek.
+	Reset()
+}
+
+// Chain is a Filter that applies its filters in order.
+type Chain []Filter
+
+// Process implements Filter.
+func (c Chain) Process(samples []float32) {
+	for _, f := range c {
+		f.Process(samples)
+	}
+}
+
+// Reset implements Filter.
+func (c Chain) Reset() {
+	for _, f := range c {
+		f.Reset()
+	}
+}
+
+// Wrap returns a source of the frames of src transformed by the filters in order.
+// The source is a player.SeekableSource if src is, forgetting the state of the filters when it seeks,
+// and closes src when it is closed if src is an io.Closer.
+func Wrap(src player.Source, filters ...Filter) player.Source {
+	return newSource(src, filters...).wrap()
+}
+
+type source struct {
+	src    player.Source
+	filter Filter
+	// called once src ends, if set
+	atEOF func()
+	eof   bool
+}
+
+func newSource(src player.Source, filters ...Filter) *source {
+	return &source{src: src, filter: Chain(filters)}
+}
+
+// wrap returns the source as a player.SeekableSource if the wrapped source is one.
+func (s *source) wrap() player.Source {
+	if _, ok := s.src.(player.SeekableSource); ok {
+		return seekableSource{s}
+	}
+	return s
+}
+
+func (s *source) ReadFrame() ([]byte, error) {
+	frame, err := s.src.ReadFrame()
+	if err == io.EOF && s.atEOF != nil && !s.eof {
+		s.eof = true
+		defer s.atEOF()
+	}
+	if len(frame) == 0 {
+		return frame, err
+	}
+	samples := pcm.ToFloat(make([]float32, 0, len(frame)/2), pcm.Decode(make([]int16, 0, len(frame)/2), frame))
+	s.filter.Process(samples)
+	// only frames of pooled sources are handed over, other sources may still own theirs
+	out := frame[:0]
+	if !s.PooledFrames() {
+		out = make([]byte, 0, len(frame))
+	}
+	return pcm.Encode(out, pcm.ToInt(make([]int16, 0, len(samples)), samples)), err
+}
+
+func (s *source) FrameDuration() time.Duration {
+	return s.src.FrameDuration()
+}
+
+func (s *source) PooledFrames() bool {
+	ps, ok := s.src.(player.PooledSource)
+	return ok && ps.PooledFrames()
+}
+
+func (s *source) Close() error {
+	if c, ok := s.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type seekableSource struct {
+	*source
+}
+
+func (s seekableSource) SeekTo(d time.Duration) (time.Duration, error) {
+	s.filter.Reset()
+	s.eof = false
+	return s.src.(player.SeekableSource).SeekTo(d)
+}
+
+// do not compile unless the sources implement the player's source interfaces
+var _ player.SourceCloser = &source{}
+var _ player.PooledSource = &source{}
+var _ player.SeekableSource = seekableSource{}
@@ -0,0 +1,111 @@
+package dsp_test
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/dsp"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seekableSource plays frames of samples from the frame at the position it seeks to.
+type seekableSource struct {
+	frames [][]int16
+	pos    int
+	closed bool
+}
+
+func (s *seekableSource) ReadFrame() ([]byte, error) {
+	if s.pos >= len(s.frames) {
+		return nil, io.EOF
+	}
+	s.pos++
+	return pcm.Encode(nil, s.frames[s.pos-1]), nil
+}
+
+func (s *seekableSource) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+func (s *seekableSource) SeekTo(d time.Duration) (time.Duration, error) {
+	s.pos = int(d / pcm.FrameDuration)
+	return time.Duration(s.pos) * pcm.FrameDuration, nil
+}
+
+func (s *seekableSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func readSamples(t *testing.T, src player.Source) []int16 {
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	return pcm.Decode(nil, frame)
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+	// filters apply in order, so the limiter sees the boosted samples
+	chain := dsp.Chain{dsp.Gain(2), dsp.NewLimiter(20*math.Log10(0.5), time.Second)}
+	samples := []float32{0.5, 0.125}
+	chain.Process(samples)
+	assert.InDelta(t, 0.5, float64(samples[0]), 1e-6)
+	assert.InDelta(t, 0.125, float64(samples[1]), 1e-6)
+	chain = dsp.Chain{dsp.NewLimiter(20*math.Log10(0.5), time.Second), dsp.Gain(2)}
+	samples = []float32{0.5, 0.125}
+	chain.Process(samples)
+	assert.InDelta(t, 1, float64(samples[0]), 1e-6)
+	assert.InDelta(t, 0.25, float64(samples[1]), 1e-6)
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+	frames := [][]int16{{10000, -20000, 100, 0}, {1, 2, 3, 4}}
+	src := &seekableSource{frames: frames}
+	wrapped := dsp.Wrap(src, dsp.Gain(2))
+	// samples beyond full scale are clipped once the chain ends
+	assert.Equal(t, []int16{20000, -32768, 200, 0}, readSamples(t, wrapped))
+	assert.Equal(t, []int16{2, 4, 6, 8}, readSamples(t, wrapped))
+	assert.Equal(t, []int16{1, 2, 3, 4}, frames[1], "expected the frames of a source that does not pool them to be left alone")
+	_, err := wrapped.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, pcm.FrameDuration, wrapped.FrameDuration())
+
+	require.NoError(t, wrapped.(io.Closer).Close())
+	assert.True(t, src.closed)
+}
+
+func TestWrapSeek(t *testing.T) {
+	t.Parallel()
+	impulse := []int16{16384, 0, 0, 0, 0, 0}
+	src := &seekableSource{frames: [][]int16{impulse, impulse}}
+	wrapped := dsp.Wrap(src, dsp.LowPass(12000, math.Sqrt2/2))
+	seeker, ok := wrapped.(player.SeekableSource)
+	require.True(t, ok, "expected a seekable source to stay seekable")
+	first := readSamples(t, seeker)
+
+	// the filter forgets the frames before the seek, so the second impulse is filtered the same as the first
+	pos, err := seeker.SeekTo(pcm.FrameDuration)
+	require.NoError(t, err)
+	assert.Equal(t, pcm.FrameDuration, pos)
+	assert.Equal(t, first, readSamples(t, seeker))
+
+	_, ok = dsp.Wrap(&endedSource{}, dsp.Gain(1)).(player.SeekableSource)
+	assert.False(t, ok, "expected a source that cannot seek not to be seekable")
+}
+
+// endedSource is a source that cannot seek.
+type endedSource struct{}
+
+func (*endedSource) ReadFrame() ([]byte, error) {
+	return nil, io.EOF
+}
+
+func (*endedSource) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
@@ -0,0 +1,65 @@
+package dsp
+
+import (
+	"math"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// Gain is a Filter that scales samples by a ratio.
+type Gain float64
+
+// GainDB returns the Gain that boosts or cuts by db decibels.
+func GainDB(db float64) Gain {
+	return Gain(math.Pow(10, db/20))
+}
+
+// Process implements Filter.
+func (g Gain) Process(samples []float32) {
+	for i := range samples {
+		samples[i] *= float32(g)
+	}
+}
+
+// Reset implements Filter.
+func (Gain) Reset() {}
+
+// Limiter keeps peaks under a threshold, reducing the gain right away when a peak would exceed it
+// and restoring the gain over the release time.
+type Limiter struct {
+	threshold float64
+	// gain restored per sample of each channel
+	release float64
+	gain    float64
+}
+
+// NewLimiter creates a Limiter that keeps peaks under threshold dB below full scale, e.g. -1.
+func NewLimiter(threshold float64, release time.Duration) *Limiter {
+	step := 1.0
+	if samples := pcm.Standard.Samples(release) / pcm.Channels; samples > 0 {
+		step = 1 / float64(samples)
+	}
+	return &Limiter{threshold: math.Pow(10, threshold/20), release: step, gain: 1}
+}
+
+// Process implements Filter.
+func (l *Limiter) Process(samples []float32) {
+	for i := 0; i+pcm.Channels <= len(samples); i += pcm.Channels {
+		frame := samples[i : i+pcm.Channels]
+		l.gain = math.Min(1, l.gain+l.release)
+		for _, s := range frame {
+			if peak := math.Abs(float64(s)) * l.gain; peak > l.threshold {
+				l.gain = l.threshold / math.Abs(float64(s))
+			}
+		}
+		for c := range frame {
+			frame[c] *= float32(l.gain)
+		}
+	}
+}
+
+// Reset implements Filter.
+func (l *Limiter) Reset() {
+	l.gain = 1
+}
@@ -0,0 +1,55 @@
+package dsp_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/dsp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGain(t *testing.T) {
+	t.Parallel()
+	samples := []float32{0.5, -1, 0.25, 0}
+	dsp.Gain(0.5).Process(samples)
+	assert.Equal(t, []float32{0.25, -0.5, 0.125, 0}, samples)
+	assert.InDelta(t, 0.5, float64(dsp.GainDB(-20*math.Log10(2))), 1e-9)
+	assert.InDelta(t, 10, float64(dsp.GainDB(20)), 1e-9)
+}
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+	// a threshold of half of full scale, released over 48 samples of each channel
+	l := dsp.NewLimiter(20*math.Log10(0.5), time.Millisecond)
+	samples := []float32{
+		// the loudest channel sets the gain of both
+		0.25, 1,
+		// then the gain recovers by 1/48 each sample
+		0.25, 0.25,
+		0.25, 0.25,
+	}
+	l.Process(samples)
+	want := []float64{
+		0.125, 0.5,
+		0.25 * (0.5 + 1.0/48), 0.25 * (0.5 + 1.0/48),
+		0.25 * (0.5 + 2.0/48), 0.25 * (0.5 + 2.0/48),
+	}
+	for i := range want {
+		assert.InDelta(t, want[i], float64(samples[i]), 1e-6, "expected sample %v", i)
+	}
+
+	// quiet samples pass through once the gain has recovered
+	quiet := make([]float32, 2*48)
+	for i := range quiet {
+		quiet[i] = 0.25
+	}
+	l.Process(quiet)
+	assert.Equal(t, float32(0.25), quiet[len(quiet)-1])
+
+	l.Process([]float32{1, 0})
+	l.Reset()
+	samples = []float32{0.25, 0.25}
+	l.Process(samples)
+	assert.Equal(t, []float32{0.25, 0.25}, samples, "expected the gain to be restored by Reset")
+}
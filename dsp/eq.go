@@ -0,0 +1,88 @@
+package dsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/pkg/errors"
+)
+
+// limits of an equalizer band's gain in dB
+const (
+	minBandGain = -30
+	maxBandGain = 30
+)
+
+// Band boosts or cuts frequencies around a center frequency.
+type Band struct {
+	// Freq is the center frequency in Hz.
+	Freq float64
+	// Gain is the boost, or the cut if negative, in dB.
+	Gain float64
+	// Q is how narrow the band is, 1 if 0, e.g. 0.5 is about two octaves wide and 2 is about two thirds of an octave.
+	Q float64
+}
+
+func (b Band) q() float64 {
+	if b.Q == 0 {
+		return 1
+	}
+	return b.Q
+}
+
+func (b Band) validate() error {
+	if b.Freq <= 0 || b.Freq >= pcm.SampleRate/2 {
+		return errors.Errorf("band frequency %v Hz is outside (0, %v)", b.Freq, pcm.SampleRate/2)
+	}
+	if b.Gain < minBandGain || b.Gain > maxBandGain {
+		return errors.Errorf("band gain %v dB is outside [%v, %v]", b.Gain, minBandGain, maxBandGain)
+	}
+	if b.Q < 0 {
+		return errors.Errorf("band Q %v is negative", b.Q)
+	}
+	return nil
+}
+
+// Equalizer returns a Chain of a Peaking filter for each band.
+func Equalizer(bands ...Band) (Chain, error) {
+	chain := make(Chain, 0, len(bands))
+	for _, b := range bands {
+		if err := b.validate(); err != nil {
+			return nil, err
+		}
+		chain = append(chain, Peaking(b.Freq, b.q(), b.Gain))
+	}
+	return chain, nil
+}
+
+// EQ equalizes the item's source with the bands, which must produce standard PCM, e.g. a source from the mp3 package.
+// Bands with a frequency outside the audible range of standard PCM, a gain beyond ±30dB, or a negative Q are ignored.
+// Use EQFilter to equalize media encoded by ffmpeg instead.
+func EQ(bands ...Band) player.SongOption {
+	var valid []Band
+	for _, b := range bands {
+		if b.validate() == nil {
+			valid = append(valid, b)
+		}
+	}
+	return player.Transform(func(src player.Source) player.Source {
+		// every play needs filters of its own
+		eq, _ := Equalizer(valid...)
+		return Wrap(src, eq)
+	})
+}
+
+// EQFilter returns the ffmpeg audio filter that equalizes with the bands,
+// e.g. for the AudioFilter of the encode options of the discordvoice package.
+func EQFilter(bands ...Band) (string, error) {
+	filters := make([]string, 0, len(bands))
+	for _, b := range bands {
+		if err := b.validate(); err != nil {
+			return "", err
+		}
+		filters = append(filters, fmt.Sprintf("equalizer=f=%v:t=q:w=%v:g=%v", b.Freq, b.q(), b.Gain))
+	}
+	return strings.Join(filters, ","), nil
+}
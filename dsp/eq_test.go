@@ -0,0 +1,127 @@
+package dsp_test
+
+import (
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/dsp"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a PCM device that keeps the samples of every frame written to it.
+type recorder struct {
+	mu     sync.Mutex
+	frames [][]int16
+}
+
+func (r *recorder) Write(frame []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, pcm.Decode(nil, frame))
+	return len(frame), nil
+}
+
+func (r *recorder) DeviceInfo() player.DeviceCaps {
+	return player.DeviceCaps{PCM: true, Realtime: true}
+}
+
+// chord is n frames of tones at 100Hz, 1kHz, and 8kHz, louder in the left channel than the right.
+func chord(n int) [][]int16 {
+	frames := make([][]int16, n)
+	for f := range frames {
+		frames[f] = make([]int16, pcm.FrameLen)
+		for i := 0; i < pcm.FrameSamples; i++ {
+			x := 2 * math.Pi * float64(f*pcm.FrameSamples+i) / pcm.SampleRate
+			s := 4000*math.Sin(100*x) + 4000*math.Sin(1000*x) + 4000*math.Sin(8000*x)
+			frames[f][2*i], frames[f][2*i+1] = int16(s), int16(s/2)
+		}
+	}
+	return frames
+}
+
+// play plays the frames as an item of a Player with the options, reporting what the device received.
+func play(t *testing.T, frames [][]int16, opts ...player.SongOption) [][]int16 {
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+	rec := &recorder{}
+	ended := make(chan error, 1)
+	opts = append(opts, player.OnEnd(func(_ time.Duration, err error) {
+		ended <- errors.Cause(err)
+	}))
+	openSrc := func() (player.Source, error) {
+		return &seekableSource{frames: frames}, nil
+	}
+	openDst := func() (io.Writer, error) {
+		return rec, nil
+	}
+	_, err := p.Enqueue("", openSrc, openDst, opts...)
+	require.NoError(t, err)
+	require.Equal(t, io.EOF, <-ended)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.frames
+}
+
+// filtered reads the frames through the filters.
+func filtered(t *testing.T, frames [][]int16, filters ...dsp.Filter) [][]int16 {
+	src := dsp.Wrap(&seekableSource{frames: frames}, filters...)
+	var out [][]int16
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			return out
+		}
+		require.NoError(t, err)
+		out = append(out, pcm.Decode(nil, frame))
+	}
+}
+
+func TestEQ(t *testing.T) {
+	t.Parallel()
+	frames := chord(10)
+	eq := dsp.EQ(
+		dsp.Band{Freq: 100, Gain: 6},
+		// ignored
+		dsp.Band{Freq: 30000, Gain: 3},
+		dsp.Band{Freq: 1000, Gain: 40},
+		dsp.Band{Freq: 1000, Gain: 3, Q: -1},
+		dsp.Band{Freq: 8000, Gain: -6, Q: 2},
+	)
+	want := filtered(t, frames, dsp.Peaking(100, 1, 6), dsp.Peaking(8000, 2, -6))
+	assert.NotEqual(t, frames, want)
+	assert.Equal(t, want, play(t, frames, eq), "expected a peaking filter for each valid band")
+	assert.Equal(t, want, play(t, frames, eq), "expected every play to start with filters of its own")
+	assert.Equal(t, frames, play(t, frames, dsp.EQ()), "expected no bands to leave the item alone")
+}
+
+func TestEqualizer(t *testing.T) {
+	t.Parallel()
+	chain, err := dsp.Equalizer(dsp.Band{Freq: 100, Gain: 6}, dsp.Band{Freq: 8000, Gain: -6, Q: 2})
+	require.NoError(t, err)
+	assert.Equal(t, dsp.Chain{dsp.Peaking(100, 1, 6), dsp.Peaking(8000, 2, -6)}, chain)
+
+	filter, err := dsp.EQFilter(dsp.Band{Freq: 100, Gain: 6}, dsp.Band{Freq: 8000, Gain: -6, Q: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "equalizer=f=100:t=q:w=1:g=6,equalizer=f=8000:t=q:w=2:g=-6", filter)
+
+	for _, b := range []dsp.Band{
+		{Freq: 0, Gain: 3},
+		{Freq: 24000, Gain: 3},
+		{Freq: 1000, Gain: -31},
+		{Freq: 1000, Gain: 31},
+		{Freq: 1000, Gain: 3, Q: -1},
+	} {
+		_, err := dsp.Equalizer(b)
+		assert.Error(t, err, "expected %+v to be invalid", b)
+		_, err = dsp.EQFilter(dsp.Band{Freq: 100, Gain: 6}, b)
+		assert.Error(t, err, "expected %+v to be invalid", b)
+	}
+}
@@ -0,0 +1,179 @@
+package dsp
+
+import (
+	"io"
+	"math"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/pkg/errors"
+)
+
+// ITU-R BS.1770 gating blocks, in samples of each channel
+const (
+	loudnessBlock = pcm.SampleRate * 4 / 10
+	loudnessStep  = pcm.SampleRate / 10
+)
+
+// ITU-R BS.1770 gates in LUFS and LU
+const (
+	absoluteGate = -70
+	relativeGate = -10
+)
+
+// Loudness is the loudness of a stream measured per EBU R128.
+type Loudness struct {
+	// Integrated is the gated loudness of the whole stream in LUFS, -Inf if the stream is silent.
+	Integrated float64
+	// TruePeak is the loudest the stream gets between samples in dBTP, estimated by oversampling 4 times.
+	TruePeak float64
+}
+
+// Gain reports the gain in dB that brings the stream to the target loudness in LUFS,
+// e.g. -23 for EBU R128 broadcast or -14 for music streaming, 0 if the stream is silent.
+// Store it with the media to play it at a consistent loudness later, e.g. with GainDB.
+func (l Loudness) Gain(target float64) float64 {
+	if math.IsInf(l.Integrated, -1) {
+		return 0
+	}
+	return target - l.Integrated
+}
+
+// Meter is a Filter that measures the loudness of the samples it processes without changing them.
+type Meter struct {
+	// K-weighting
+	shelf, highPass *Biquad
+	// sum of the mean squares of each channel's K-weighted samples in each 100ms step
+	steps []float64
+	sum   float64
+	n     int
+	peak  float64
+	// last samples of each channel, to interpolate the true peak
+	prev [pcm.Channels][3]float64
+}
+
+// NewMeter creates a Meter.
+func NewMeter() *Meter {
+	return &Meter{
+		// ITU-R BS.1770 coefficients for 48kHz
+		shelf:    normalize(1.53512485958697, -2.69169618940638, 1.19839281085285, 1, -1.69065929318241, 0.73248077421585),
+		highPass: normalize(1, -2, 1, 1, -1.99004745483398, 0.99007225036621),
+	}
+}
+
+// Process implements Filter.
+func (m *Meter) Process(samples []float32) {
+	weighted := append([]float32(nil), samples...)
+	m.shelf.Process(weighted)
+	m.highPass.Process(weighted)
+	for i, s := range weighted {
+		c := i % pcm.Channels
+		m.truePeak(c, float64(samples[i]))
+		m.sum += float64(s) * float64(s)
+		if c == pcm.Channels-1 {
+			m.n++
+			if m.n == loudnessStep {
+				m.steps = append(m.steps, m.sum/loudnessStep)
+				m.sum, m.n = 0, 0
+			}
+		}
+	}
+}
+
+// truePeak estimates the peak between the previous samples of the channel with Catmull-Rom interpolation.
+func (m *Meter) truePeak(c int, s float64) {
+	p := &m.prev[c]
+	m.peak = math.Max(m.peak, math.Abs(s))
+	for k := 1; k < 4; k++ {
+		t := float64(k) / 4
+		v := 0.5 * (2*p[1] + (-p[0]+p[2])*t + (2*p[0]-5*p[1]+4*p[2]-s)*t*t + (-p[0]+3*p[1]-3*p[2]+s)*t*t*t)
+		m.peak = math.Max(m.peak, math.Abs(v))
+	}
+	p[0], p[1], p[2] = p[1], p[2], s
+}
+
+// Reset implements Filter, starting the measurement over.
+func (m *Meter) Reset() {
+	m.shelf.Reset()
+	m.highPass.Reset()
+	m.steps = nil
+	m.sum, m.n, m.peak = 0, 0, 0
+	m.prev = [pcm.Channels][3]float64{}
+}
+
+// Loudness reports the loudness of the samples processed so far.
+func (m *Meter) Loudness() Loudness {
+	// overlapping blocks of four steps
+	var blocks []float64
+	perBlock := loudnessBlock / loudnessStep
+	for i := 0; i+perBlock <= len(m.steps); i++ {
+		sum := 0.0
+		for _, s := range m.steps[i : i+perBlock] {
+			sum += s
+		}
+		blocks = append(blocks, sum/float64(perBlock))
+	}
+
+	gated := gate(blocks, absoluteGate)
+	integrated := math.Inf(-1)
+	if len(gated) > 0 {
+		gated = gate(gated, lufs(mean(gated))+relativeGate)
+		integrated = lufs(mean(gated))
+	}
+	return Loudness{Integrated: integrated, TruePeak: 20 * math.Log10(m.peak)}
+}
+
+// gate returns the blocks louder than the threshold in LUFS.
+func gate(blocks []float64, threshold float64) []float64 {
+	var gated []float64
+	for _, b := range blocks {
+		if lufs(b) > threshold {
+			gated = append(gated, b)
+		}
+	}
+	return gated
+}
+
+func mean(blocks []float64) float64 {
+	sum := 0.0
+	for _, b := range blocks {
+		sum += b
+	}
+	return sum / float64(len(blocks))
+}
+
+// lufs converts the sum of the mean squares of each channel's K-weighted samples to LUFS, each channel weighs 1.
+func lufs(meanSquare float64) float64 {
+	return -0.691 + 10*math.Log10(meanSquare)
+}
+
+// Analyze reads src to its end as fast as it produces frames and reports its loudness,
+// e.g. to measure media ahead of playback. src must produce standard PCM.
+func Analyze(src player.Source) (Loudness, error) {
+	m := NewMeter()
+	s := Wrap(src, m)
+	for {
+		frame, err := s.ReadFrame()
+		if ps, ok := s.(player.PooledSource); ok && ps.PooledFrames() {
+			player.FreeFrame(frame)
+		}
+		if err == io.EOF {
+			return m.Loudness(), nil
+		}
+		if err != nil {
+			return m.Loudness(), errors.Wrap(err, "failed to analyze loudness")
+		}
+	}
+}
+
+// Measure returns a source of the frames of src that measures their loudness as they play,
+// calling f with the loudness once src ends, e.g. to store the gain of the media for future plays.
+// If the source seeks the loudness is only measured from the last seek, and f is not called if src fails.
+func Measure(src player.Source, f func(Loudness)) player.Source {
+	m := NewMeter()
+	s := newSource(src, m)
+	s.atEOF = func() {
+		f(m.Loudness())
+	}
+	return s.wrap()
+}
@@ -0,0 +1,136 @@
+package dsp_test
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/dsp"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tone is d of a 1kHz sine at level dBFS in both channels.
+func tone(d time.Duration, level float64) []float32 {
+	amplitude := math.Pow(10, level/20)
+	n := pcm.Standard.Samples(d) / pcm.Channels
+	samples := make([]float32, 0, 2*n)
+	for i := 0; i < n; i++ {
+		s := float32(amplitude * math.Sin(2*math.Pi*1000*float64(i)/pcm.SampleRate))
+		samples = append(samples, s, s)
+	}
+	return samples
+}
+
+func measure(samples ...[]float32) dsp.Loudness {
+	m := dsp.NewMeter()
+	for _, s := range samples {
+		m.Process(append([]float32(nil), s...))
+	}
+	return m.Loudness()
+}
+
+func TestMeter(t *testing.T) {
+	t.Parallel()
+	// EBU Tech 3341 case 1: a stereo 1kHz tone at -23dBFS measures -23 LUFS
+	l := measure(tone(5*time.Second, -23))
+	assert.InDelta(t, -23, l.Integrated, 0.1)
+	assert.InDelta(t, -23, l.TruePeak, 0.1)
+	assert.InDelta(t, -14+23, l.Gain(-14), 0.1)
+
+	// a tone 10 LU quieter measures 10 LU quieter
+	assert.InDelta(t, -33, measure(tone(5*time.Second, -33)).Integrated, 0.1)
+}
+
+func TestMeterGates(t *testing.T) {
+	t.Parallel()
+	// EBU Tech 3341 case 3, shortened: quiet passages more than 10 LU below the rest are gated out
+	l := measure(tone(5*time.Second, -36), tone(20*time.Second, -23), tone(5*time.Second, -36))
+	assert.InDelta(t, -23, l.Integrated, 0.1)
+
+	// as is silence
+	silence := make([]float32, 2*pcm.SampleRate*10)
+	assert.InDelta(t, -23, measure(silence, tone(20*time.Second, -23), silence).Integrated, 0.1)
+
+	l = measure(silence)
+	assert.True(t, math.IsInf(l.Integrated, -1), "expected silence to measure -Inf LUFS, not %v", l.Integrated)
+	assert.Equal(t, 0.0, l.Gain(-14), "expected no gain for silence")
+
+	// less than a gating block is too short to measure
+	assert.True(t, math.IsInf(measure(tone(300*time.Millisecond, -23)).Integrated, -1))
+}
+
+func TestMeterTruePeak(t *testing.T) {
+	t.Parallel()
+	// a tone at a quarter of the sample rate whose samples fall halfway between its peaks, 3dB below them
+	n := pcm.SampleRate
+	samples := make([]float32, 0, 2*n)
+	for i := 0; i < n; i++ {
+		s := float32(math.Sin(math.Pi/2*float64(i) + math.Pi/4))
+		samples = append(samples, s, s)
+	}
+	l := measure(samples)
+	assert.True(t, l.TruePeak > -2 && l.TruePeak <= 0, "expected a true peak above the -3dB samples, not %v", l.TruePeak)
+}
+
+func TestMeterReset(t *testing.T) {
+	t.Parallel()
+	m := dsp.NewMeter()
+	m.Process(tone(5*time.Second, -13))
+	m.Reset()
+	m.Process(tone(5*time.Second, -23))
+	l := m.Loudness()
+	assert.InDelta(t, -23, l.Integrated, 0.1, "expected the measurement to start over")
+	assert.InDelta(t, -23, l.TruePeak, 0.1)
+}
+
+// toneSource plays a tone in frames of standard PCM.
+func toneSource(d time.Duration, level float64) *seekableSource {
+	samples := pcm.ToInt(nil, tone(d, level))
+	src := &seekableSource{}
+	for len(samples) > 0 {
+		n := pcm.FrameLen
+		if n > len(samples) {
+			n = len(samples)
+		}
+		src.frames = append(src.frames, samples[:n])
+		samples = samples[n:]
+	}
+	return src
+}
+
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	src := toneSource(5*time.Second, -23)
+	l, err := dsp.Analyze(src)
+	require.NoError(t, err)
+	assert.InDelta(t, -23, l.Integrated, 0.1)
+	assert.Equal(t, len(src.frames), src.pos, "expected the whole source to be read")
+}
+
+func TestMeasure(t *testing.T) {
+	t.Parallel()
+	var measured []dsp.Loudness
+	played := toneSource(5*time.Second, -23)
+	src := dsp.Measure(played, func(l dsp.Loudness) {
+		measured = append(measured, l)
+	})
+	n := 0
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.True(t, n < len(played.frames))
+		assert.Equal(t, played.frames[n], pcm.Decode(nil, frame), "expected the frames to play unchanged")
+		n++
+	}
+	assert.Equal(t, len(played.frames), n)
+	_, err := src.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+	require.Len(t, measured, 1, "expected the loudness to be reported once when the source ends")
+	assert.InDelta(t, -23, measured[0].Integrated, 0.1)
+}
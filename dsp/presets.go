@@ -0,0 +1,82 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+)
+
+// bass boost shelf frequency in Hz and the most it boosts in dB
+const (
+	bassFreq     = 110
+	maxBassBoost = 20
+)
+
+// limiter of the presets, 1dB under full scale
+const (
+	presetCeiling = -1
+	presetRelease = 50 * time.Millisecond
+)
+
+// BassBoost boosts the bass of the item's source by level dB, clamped to [0, 20],
+// followed by a limiter so the boost does not clip. The source must produce standard PCM.
+// Use BassBoostFilter to boost the bass of media encoded by ffmpeg instead.
+func BassBoost(level float64) player.SongOption {
+	level = clampBass(level)
+	return player.Transform(func(src player.Source) player.Source {
+		return Wrap(src, LowShelf(bassFreq, level), NewLimiter(presetCeiling, presetRelease))
+	})
+}
+
+// BassBoostFilter returns the ffmpeg audio filter that boosts the bass by level dB, clamped to [0, 20],
+// followed by a limiter so the boost does not clip.
+func BassBoostFilter(level float64) string {
+	return fmt.Sprintf("bass=g=%v:f=%v:w=0.6,alimiter=limit=%.3f", clampBass(level), bassFreq, math.Pow(10, presetCeiling/20.0))
+}
+
+func clampBass(level float64) float64 {
+	return math.Max(0, math.Min(maxBassBoost, level))
+}
+
+// frequency in Hz under which Karaoke keeps the center of the mix, where the bass and the kick usually are
+const karaokeBass = 200
+
+// karaoke removes the center of a stereo mix, keeping its bass.
+type karaoke struct {
+	bass *Biquad
+}
+
+// Karaoke removes the vocals of the item's source, or whatever is mixed in the center of a stereo track,
+// by playing the difference of the channels, keeping the bass of the center so the track does not sound thin.
+// Tracks with vocals off center or reverb on the vocals keep some of them. The source must produce standard PCM.
+// Use KaraokeFilter to remove the vocals of media encoded by ffmpeg instead.
+func Karaoke() player.SongOption {
+	return player.Transform(func(src player.Source) player.Source {
+		return Wrap(src, &karaoke{bass: LowPass(karaokeBass, math.Sqrt2/2)})
+	})
+}
+
+// KaraokeFilter returns the ffmpeg audio filter that removes the center of a stereo mix, keeping its bass.
+func KaraokeFilter() string {
+	return fmt.Sprintf("asplit[a][b];[a]stereotools=mlev=0.015625[side];[b]lowpass=f=%v[bass];[side][bass]amix=inputs=2,volume=2", karaokeBass)
+}
+
+func (k *karaoke) Process(samples []float32) {
+	// the bass filter runs on the center, duplicated to both of its channels
+	mid := make([]float32, len(samples))
+	for i := 0; i+1 < len(samples); i += 2 {
+		m := (samples[i] + samples[i+1]) / 2
+		mid[i], mid[i+1] = m, m
+	}
+	k.bass.Process(mid)
+	for i := 0; i+1 < len(samples); i += 2 {
+		side := (samples[i] - samples[i+1]) / 2
+		samples[i], samples[i+1] = mid[i]+side, mid[i+1]-side
+	}
+}
+
+func (k *karaoke) Reset() {
+	k.bass.Reset()
+}
@@ -0,0 +1,102 @@
+package dsp_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/dsp"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bassBoost is the filters BassBoost documents, a low shelf at 110Hz and a limiter 1dB under full scale.
+func bassBoost(level float64) []dsp.Filter {
+	return []dsp.Filter{dsp.LowShelf(110, level), dsp.NewLimiter(-1, 50*time.Millisecond)}
+}
+
+func TestBassBoost(t *testing.T) {
+	t.Parallel()
+	frames := chord(10)
+	want := filtered(t, frames, bassBoost(12)...)
+	assert.NotEqual(t, frames, want)
+	assert.Equal(t, want, play(t, frames, dsp.BassBoost(12)))
+
+	// the level is clamped to [0, 20]
+	assert.Equal(t, filtered(t, frames, bassBoost(20)...), play(t, frames, dsp.BassBoost(50)))
+	assert.Equal(t, filtered(t, frames, bassBoost(0)...), play(t, frames, dsp.BassBoost(-5)))
+}
+
+func TestBassBoostClips(t *testing.T) {
+	t.Parallel()
+	// a loud 60Hz tone boosted as much as it can be stays under the limiter's ceiling
+	frames := make([][]int16, 25)
+	for f := range frames {
+		frames[f] = make([]int16, pcm.FrameLen)
+		for i := range frames[f] {
+			x := 2 * math.Pi * 60 * float64(f*pcm.FrameSamples+i/2) / pcm.SampleRate
+			frames[f][i] = int16(30000 * math.Sin(x))
+		}
+	}
+	ceiling := math.Pow(10, -1.0/20) * 32768
+	peak := 0
+	for _, frame := range play(t, frames, dsp.BassBoost(20)) {
+		if p := pcm.Peak(frame); p > peak {
+			peak = p
+		}
+	}
+	assert.True(t, float64(peak) <= ceiling+1, "expected the boost to be limited to %v, not %v", ceiling, peak)
+	assert.True(t, float64(peak) > ceiling-100, "expected the boost to reach the ceiling, not %v", peak)
+}
+
+func TestBassBoostFilter(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "bass=g=12:f=110:w=0.6,alimiter=limit=0.891", dsp.BassBoostFilter(12))
+	assert.Equal(t, "bass=g=20:f=110:w=0.6,alimiter=limit=0.891", dsp.BassBoostFilter(50))
+	assert.Equal(t, "bass=g=0:f=110:w=0.6,alimiter=limit=0.891", dsp.BassBoostFilter(-5))
+}
+
+// stereo is n frames of a tone at freq Hz in the left channel and the tone times balance in the right.
+func stereo(n int, freq float64, balance float64) [][]int16 {
+	frames := make([][]int16, n)
+	for f := range frames {
+		frames[f] = make([]int16, pcm.FrameLen)
+		for i := 0; i < pcm.FrameSamples; i++ {
+			s := 10000 * math.Sin(2*math.Pi*freq*float64(f*pcm.FrameSamples+i)/pcm.SampleRate)
+			frames[f][2*i], frames[f][2*i+1] = int16(s), int16(s*balance)
+		}
+	}
+	return frames
+}
+
+func TestKaraoke(t *testing.T) {
+	t.Parallel()
+	// the center of the mix is removed
+	for _, frame := range play(t, stereo(10, 5000, 1), dsp.Karaoke())[1:] {
+		assert.True(t, pcm.Peak(frame) < 50, "expected a tone in the center to be removed, not to peak at %v", pcm.Peak(frame))
+	}
+
+	// the sides of the mix are kept
+	sides := stereo(10, 5000, -1)
+	assert.Equal(t, sides, play(t, sides, dsp.Karaoke()))
+
+	// and so is the bass in the center
+	bass := stereo(25, 50, 1)
+	out := play(t, bass, dsp.Karaoke())
+	require.Len(t, out, len(bass))
+	// a frame is a whole period of 50Hz, and the filter shifts its phase but keeps its level
+	for f := 5; f < len(out); f++ {
+		assert.InDelta(t, 10000, pcm.Peak(out[f]), 300, "expected the bass in the center to be kept in frame %v", f)
+		for i := 0; i < len(out[f]); i += 2 {
+			require.Equal(t, out[f][i], out[f][i+1], "expected the bass to stay in the center")
+		}
+	}
+}
+
+func TestKaraokeFilter(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t,
+		"asplit[a][b];[a]stereotools=mlev=0.015625[side];[b]lowpass=f=200[bass];[side][bass]amix=inputs=2,volume=2",
+		dsp.KaraokeFilter())
+}
@@ -0,0 +1,68 @@
+package player
+
+import (
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// OverlayDuck attenuates the playing item to ratio of its gain while clips played by Player.Overlay are mixed over it,
+// e.g. so a soundboard sound or a spoken announcement can be heard over the music.
+// The gain ramps down over ramp when the first overlay starts and back up over ramp when the last one ends.
+// Clips of the Transition option do not duck the item.
+// Values of ratio less than 0 or greater than 1 are ignored, the item is not ducked by default.
+func OverlayDuck(ratio float64, ramp time.Duration) Option {
+	return func(cfg *config) {
+		if ratio >= 0 && ratio <= 1 {
+			cfg.OverlayDuck = ratio
+			cfg.OverlayRamp = ramp
+		}
+	}
+}
+
+// ducker attenuates the frames of the playing item, only touched by the playback goroutine.
+type ducker struct {
+	// how far the item is ducked, from 0 not at all to 1 all the way to the duck ratio
+	level float64
+	// decoded samples reused by every frame
+	samples []int16
+}
+
+func (d *ducker) ducked() bool {
+	return d.level > 0
+}
+
+// apply moves the duck level one frame toward down or up and scales the samples in frame,
+// interpolating the gain across the frame so the ramp has no steps.
+func (d *ducker) apply(frame []byte, down bool, ratio float64, ramp time.Duration, frameDur time.Duration) {
+	from := d.level
+	step := 1.0
+	if ramp > 0 {
+		step = float64(frameDur) / float64(ramp)
+	}
+	if down {
+		d.level += step
+		if d.level > 1 {
+			d.level = 1
+		}
+	} else {
+		d.level -= step
+		if d.level < 0 {
+			d.level = 0
+		}
+	}
+	if from == 0 && d.level == 0 {
+		return
+	}
+
+	d.samples = pcm.Decode(d.samples[:0], frame)
+	samples := d.samples
+	for i, s := range samples {
+		level := d.level
+		if ramp > 0 {
+			level = from + (d.level-from)*float64(i+1)/float64(len(samples))
+		}
+		samples[i] = pcm.Clip(float64(s) * (1 - level*(1-ratio)))
+	}
+	pcm.Encode(frame[:0], samples)
+}
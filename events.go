@@ -0,0 +1,153 @@
+package player
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+)
+
+// how many events the player keeps by default
+const defaultEventLog = 100
+
+// EventKind is what happened in an Event.
+type EventKind int
+
+const (
+	// EventEnqueued is an item entering the queue.
+	EventEnqueued EventKind = iota
+	// EventStarted is an item starting to play.
+	EventStarted
+	// EventPaused is the current item pausing.
+	EventPaused
+	// EventResumed is the current item resuming.
+	EventResumed
+	// EventEnded is an item ending, whether it played or not, with the reason in the event's Err.
+	EventEnded
+	// EventIdle is the player going idle after its IdleFunc timeout or StopAfter.
+	EventIdle
+	// EventOutage is the player applying its Outage policy, with the last device error in the event's Err.
+	EventOutage
+	// EventClosed is the player closing.
+	EventClosed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventEnqueued:
+		return "enqueued"
+	case EventStarted:
+		return "started"
+	case EventPaused:
+		return "paused"
+	case EventResumed:
+		return "resumed"
+	case EventEnded:
+		return "ended"
+	case EventIdle:
+		return "idle"
+	case EventOutage:
+		return "outage"
+	case EventClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Event is something that happened in the player's lifecycle.
+type Event struct {
+	Time time.Time
+	Kind EventKind
+	// ID and Title of the item the event is about, zero for events of the player itself like EventIdle.
+	ID    uint64
+	Title string
+	// Elapsed is how long the item had played for EventPaused, EventResumed, and EventEnded.
+	Elapsed time.Duration
+	Err     error
+}
+
+// EventLog sets how many of the most recent events the player keeps for RecentEvents, 100 by default.
+// Values less than 1 keep no events.
+func EventLog(n int) Option {
+	return func(cfg *config) {
+		cfg.EventLog = n
+	}
+}
+
+// RecentEvents returns the events the player has kept, oldest first,
+// e.g. to find out after the fact why the music stopped.
+func (p *Player) RecentEvents() []Event {
+	return p.events.recent()
+}
+
+// eventLog is a ring of the most recent events.
+type eventLog struct {
+	clock  clock.Clock
+	mu     sync.Mutex
+	events []Event
+	// number of events recorded, the next is recorded at n % len(events)
+	n int
+}
+
+func newEventLog(size int, clk clock.Clock) *eventLog {
+	if size < 0 {
+		size = 0
+	}
+	return &eventLog{clock: clk, events: make([]Event, size)}
+}
+
+func (l *eventLog) record(ev Event) {
+	if len(l.events) == 0 {
+		return
+	}
+	ev.Time = l.clock.Now()
+	l.mu.Lock()
+	l.events[l.n%len(l.events)] = ev
+	l.n++
+	l.mu.Unlock()
+}
+
+func (l *eventLog) recent() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.n
+	if n > len(l.events) {
+		n = len(l.events)
+	}
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = l.events[(l.n-n+i)%len(l.events)]
+	}
+	return events
+}
+
+// event records an event of the player itself.
+func (p *Player) event(kind EventKind, err error) {
+	p.events.record(Event{Kind: kind, Err: err})
+}
+
+// itemEvent records an event of an item.
+func (p *Player) itemEvent(kind EventKind, song *songItem, elapsed time.Duration, err error) {
+	p.events.record(Event{Kind: kind, ID: song.seq, Title: song.title, Elapsed: elapsed, Err: err})
+}
+
+// recordEvents wraps an item's callbacks so its lifecycle is recorded before each is called.
+func (p *Player) recordEvents(cb *callbacks, song *songItem) {
+	onStart, onPause, onResume, onEnd := cb.onStart, cb.onPause, cb.onResume, cb.onEnd
+	cb.onStart = func() {
+		p.itemEvent(EventStarted, song, 0, nil)
+		onStart()
+	}
+	cb.onPause = func(elapsed time.Duration) {
+		p.itemEvent(EventPaused, song, elapsed, nil)
+		onPause(elapsed)
+	}
+	cb.onResume = func(elapsed time.Duration) {
+		p.itemEvent(EventResumed, song, elapsed, nil)
+		onResume(elapsed)
+	}
+	cb.onEnd = func(elapsed time.Duration, err error) {
+		p.itemEvent(EventEnded, song, elapsed, err)
+		onEnd(elapsed, err)
+	}
+}
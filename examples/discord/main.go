@@ -40,16 +40,15 @@ func main() {
 		if err != nil {
 			return nil, err
 		}
-		return discordvoice.NewSource(f, dca.StdEncodeOptions)
+		return discordvoice.NewSource(f, device.EncodeOptions(*channelID, dca.StdEncodeOptions))
 	}
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, os.Kill)
-	end := make(chan struct{})
 
 	p := player.New()
 	defer p.Close()
-	p.Enqueue("test", openSource, openDevice,
+	track, err := p.Enqueue("test", openSource, openDevice,
 		player.OnStart(func() {
 			log.Print("playback started")
 		}),
@@ -58,11 +57,13 @@ func main() {
 		}, 1*time.Second),
 		player.OnEnd(func(e time.Duration, err error) {
 			log.Printf("playback stopped after %v because %v", e, err)
-			close(end)
 		}))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	select {
-	case <-end:
+	case <-track.Done():
 	case <-sig:
 	case <-time.After(10 * time.Second):
 	}
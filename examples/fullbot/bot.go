@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/discordvoice"
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+)
+
+// item is a queued file, saved to the state file so the queue survives a restart.
+type item struct {
+	Path      string `json:"path"`
+	ChannelID string `json:"channel_id"`
+}
+
+// guild is the player and device of a guild and the items it has queued.
+type guild struct {
+	*discordvoice.Guild
+
+	// items that have not ended yet in the order they were queued
+	mu    sync.Mutex
+	items []*item
+}
+
+type bot struct {
+	manager  *discordvoice.Manager
+	mediaDir string
+	// open the file and the voice channel an item plays in, replaced in tests to play without ffmpeg and discord
+	openSrc func(g *guild, it item) (player.Source, error)
+	openDst func(g *guild, it item) (io.Writer, error)
+
+	// queued items of each guild the manager has a player for
+	mu     sync.Mutex
+	guilds map[string]*guild
+}
+
+func newBot(manager *discordvoice.Manager, mediaDir string) *bot {
+	b := &bot{
+		manager:  manager,
+		mediaDir: mediaDir,
+		guilds:   make(map[string]*guild),
+	}
+	b.openSrc = b.openFile
+	b.openDst = func(g *guild, it item) (io.Writer, error) {
+		return g.Device.Open(it.ChannelID)
+	}
+	return b
+}
+
+// guild gets the player of a guild from the manager, which creates it if needed,
+// or nil once the manager is closed.
+// The manager replaces the player of a guild that the bot left and joined again.
+func (b *bot) guild(guildID string) *guild {
+	mg := b.manager.Guild(guildID)
+	if mg == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.guilds[guildID]
+	if !ok || g.Guild != mg {
+		g = &guild{Guild: mg}
+		g.Device.OnReconnect(func(ev discordvoice.ReconnectEvent) {
+			if ev.Err != nil {
+				log.Printf("guild %v lost its voice channel %v after reconnecting: %v", guildID, ev.ChannelID, ev.Err)
+			}
+		})
+		b.guilds[guildID] = g
+	}
+	return g
+}
+
+func (b *bot) close() {
+	b.manager.Close()
+}
+
+// openFile opens an item's file from the media directory, encoded for the voice channel it plays in.
+func (b *bot) openFile(g *guild, it item) (player.Source, error) {
+	f, err := os.Open(filepath.Join(b.mediaDir, filepath.Base(it.Path)))
+	if err != nil {
+		return nil, err
+	}
+	return discordvoice.NewSource(f, g.Device.EncodeOptions(it.ChannelID, dca.StdEncodeOptions))
+}
+
+// enqueue queues a file to play in a voice channel, calling report with any error that ends it.
+func (b *bot) enqueue(g *guild, it item, report func(string)) error {
+	openSrc := func() (player.Source, error) {
+		return b.openSrc(g, it)
+	}
+	openDst := func() (io.Writer, error) {
+		return b.openDst(g, it)
+	}
+
+	queued := &it
+	g.mu.Lock()
+	g.items = append(g.items, queued)
+	g.mu.Unlock()
+	_, err := g.Player.Enqueue(it.Path, openSrc, openDst,
+		player.OnEnd(func(elapsed time.Duration, err error) {
+			g.remove(queued)
+			switch errors.Cause(err) {
+			case nil, io.EOF, player.ErrSkipped, player.ErrRemoved, player.ErrCleared, player.ErrStopped, player.ErrClosed:
+			default:
+				report(fmt.Sprintf("%v stopped after %v: %v", it.Path, elapsed, err))
+			}
+		}),
+	)
+	if err != nil {
+		g.remove(queued)
+	}
+	return err
+}
+
+// remove forgets an item once it has ended.
+func (g *guild) remove(it *item) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range g.items {
+		if g.items[i] == it {
+			g.items = append(g.items[:i], g.items[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/discordvoice"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// silence is an endless source of frames.
+type silence struct{}
+
+func (silence) ReadFrame() ([]byte, error) {
+	return []byte{0xF8, 0xFF, 0xFE}, nil
+}
+
+func (silence) FrameDuration() time.Duration {
+	return 20 * time.Millisecond
+}
+
+// frames is a source of n frames of silence.
+type frames struct {
+	n int
+}
+
+func (f *frames) ReadFrame() ([]byte, error) {
+	if f.n == 0 {
+		return nil, io.EOF
+	}
+	f.n--
+	return []byte{0xF8, 0xFF, 0xFE}, nil
+}
+
+func (f *frames) FrameDuration() time.Duration {
+	return 20 * time.Millisecond
+}
+
+// stuckWriter holds the first frame written to it until release is closed,
+// so the first item of a guild keeps playing and the items after it stay queued.
+type stuckWriter struct {
+	release chan struct{}
+}
+
+func (w stuckWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+// newTestBot creates a bot whose items play without ffmpeg or discord, closing it when the test ends.
+// The first item of each guild plays until release is called, which players must be before they can close.
+func newTestBot(t *testing.T) (b *bot, release func()) {
+	b = newBot(discordvoice.NewManager(&discordgo.Session{}, time.Second), t.TempDir())
+	stuck := stuckWriter{make(chan struct{})}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			close(stuck.release)
+		})
+	}
+	b.openSrc = func(*guild, item) (player.Source, error) {
+		return silence{}, nil
+	}
+	b.openDst = func(*guild, item) (io.Writer, error) {
+		return stuck, nil
+	}
+	t.Cleanup(func() {
+		release()
+		b.close()
+	})
+	return b, release
+}
+
+// enqueue queues items in a guild, failing the test if any cannot be queued.
+func enqueue(t *testing.T, b *bot, guildID string, items ...item) *guild {
+	g := b.guild(guildID)
+	require.NotNil(t, g)
+	for _, it := range items {
+		require.NoError(t, b.enqueue(g, it, func(msg string) { t.Error(msg) }))
+	}
+	return g
+}
+
+// titles are the titles of the item playing in a guild, if any, and the items queued after it.
+func titles(g *guild) []string {
+	var titles []string
+	if current, ok := g.Player.Current(); ok {
+		titles = append(titles, current.Title)
+	}
+	for _, entry := range g.Player.Queue() {
+		titles = append(titles, entry.Title)
+	}
+	return titles
+}
+
+func TestEnqueueReport(t *testing.T) {
+	t.Parallel()
+	b, _ := newTestBot(t)
+	b.openSrc = func(_ *guild, it item) (player.Source, error) {
+		if it.Path == "missing.mp3" {
+			return nil, errors.New("no such file")
+		}
+		return &frames{3}, nil
+	}
+	b.openDst = func(*guild, item) (io.Writer, error) {
+		return ioutil.Discard, nil
+	}
+
+	reports := make(chan string, 2)
+	report := func(msg string) { reports <- msg }
+	g := b.guild("guild")
+	require.NotNil(t, g)
+	require.NoError(t, b.enqueue(g, item{Path: "a.mp3", ChannelID: "channel"}, report))
+	require.NoError(t, b.enqueue(g, item{Path: "missing.mp3", ChannelID: "channel"}, report))
+
+	// items play in order, so the first has ended by the time the second reports
+	select {
+	case msg := <-reports:
+		assert.Contains(t, msg, "missing.mp3", "expected only the item that failed to be reported, not one that played to its end")
+		assert.Contains(t, msg, "no such file")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the item that failed to be reported")
+	}
+	assert.Empty(t, reports)
+}
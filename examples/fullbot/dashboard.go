@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/discordvoice"
+)
+
+// handler serves the dashboard and the event streams of the guilds' queues.
+func (b *bot) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.serveDashboard)
+	mux.HandleFunc("/events", b.serveEvents)
+	return mux
+}
+
+func (b *bot) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	queues := make(map[string][]player.QueueEntry)
+	for _, id := range b.manager.Guilds() {
+		if g := b.manager.Guild(id); g != nil {
+			queues[id] = g.Player.Queue()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(queues); err != nil {
+		log.Printf("failed to serve dashboard: %v", err)
+	}
+}
+
+// serveEvents streams the queue of a guild as server-sent events, one whenever the queue changes,
+// until the client goes away or the guild's player closes.
+func (b *bot) serveEvents(w http.ResponseWriter, r *http.Request) {
+	// only stream guilds the manager already has a player for instead of creating one
+	var mg *discordvoice.Guild
+	guildID := r.URL.Query().Get("guild")
+	for _, id := range b.manager.Guilds() {
+		if id == guildID {
+			mg = b.manager.Guild(id)
+		}
+	}
+	if mg == nil {
+		http.Error(w, "unknown guild", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	queues, unsubscribe := mg.Player.SubscribeQueue()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	send := func(queue []player.QueueEntry) error {
+		data, err := json.Marshal(queue)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: queue\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := send(mg.Player.Queue()); err != nil {
+		log.Printf("failed to stream events: %v", err)
+		return
+	}
+	gone := r.Context().Done()
+	for {
+		select {
+		case queue, ok := <-queues:
+			if !ok {
+				return
+			}
+			if err := send(queue); err != nil {
+				log.Printf("failed to stream events: %v", err)
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
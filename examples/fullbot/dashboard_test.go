@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboard(t *testing.T) {
+	t.Parallel()
+	b, _ := newTestBot(t)
+	g := enqueue(t, b, "guild", item{Path: "a.mp3", ChannelID: "channel"}, item{Path: "b.mp3", ChannelID: "channel"})
+	enqueue(t, b, "other", item{Path: "c.mp3", ChannelID: "voice"})
+	require.Eventually(t, func() bool {
+		_, playing := g.Player.Current()
+		return playing
+	}, 5*time.Second, time.Millisecond, "expected the first item to play")
+
+	rec := httptest.NewRecorder()
+	b.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var queues map[string][]entry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &queues))
+	require.Len(t, queues, 2)
+	require.Len(t, queues["guild"], 1, "expected the queue after the item that is playing")
+	assert.Equal(t, "b.mp3", queues["guild"][0].Title)
+}
+
+// entry is the part of a player.QueueEntry the tests check, whose Resolution is marshaled as text.
+type entry struct {
+	Title string
+}
+
+// event reads the next server-sent event.
+func event(t *testing.T, r *bufio.Reader) (name string, data string) {
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+	b, release := newTestBot(t)
+	enqueue(t, b, "guild", item{Path: "a.mp3", ChannelID: "channel"})
+	srv := httptest.NewServer(b.handler())
+	defer srv.Close()
+
+	// guilds without a player are not created by streaming them
+	resp, err := http.Get(srv.URL + "/events?guild=missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NotContains(t, b.manager.Guilds(), "missing")
+
+	resp, err = http.Get(srv.URL + "/events?guild=guild")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	r := bufio.NewReader(resp.Body)
+	name, _ := event(t, r)
+	assert.Equal(t, "queue", name, "expected the queue as soon as the stream starts")
+
+	// each change to the queue is streamed until the queue has the item
+	enqueue(t, b, "guild", item{Path: "b.mp3", ChannelID: "channel"})
+	var queue []entry
+	for len(queue) == 0 || queue[len(queue)-1].Title != "b.mp3" {
+		name, data := event(t, r)
+		assert.Equal(t, "queue", name)
+		require.NoError(t, json.Unmarshal([]byte(data), &queue))
+	}
+
+	// the stream ends when the guild's player closes
+	release()
+	b.manager.Remove("guild")
+	_, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,158 @@
+// Command fullbot is a multi-guild music bot with a small web dashboard.
+//
+// Each guild gets its own player that plays files from the media directory in the voice channel of whoever queued them.
+// Chat commands:
+//
+//	!play <file>   queue a file from the media directory
+//	!skip          skip the current item
+//	!pause         pause the current item
+//	!resume        resume the current item
+//	!stop          clear the queue
+//	!queue         list the queue in an embed
+//
+// The dashboard at http://<addr>/ serves the queue of every guild as JSON,
+// http://<addr>/events?guild=<id> streams the queue of a guild as server-sent events whenever it changes,
+// and the queues are saved to the state file on shutdown and restored on startup.
+// The bot only uses the player and discordvoice packages, so it also serves as an end-to-end check of how they work together.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/discordvoice"
+)
+
+func main() {
+	tok := flag.String("t", "", "discord token")
+	addr := flag.String("addr", "localhost:8080", "dashboard address")
+	mediaDir := flag.String("media", "media", "directory of playable files")
+	stateFile := flag.String("state", "fullbot.json", "file to persist queues in")
+	flag.Parse()
+
+	session, err := discordgo.New("Bot " + *tok)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b := newBot(discordvoice.NewManager(session, 1*time.Second, discordvoice.PlayerOptions(player.QueueLength(100))), *mediaDir)
+	session.AddHandler(b.onMessage)
+
+	err = session.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	if err := b.restore(*stateFile); err != nil {
+		log.Printf("failed to restore queues: %v", err)
+	}
+
+	go func() {
+		log.Print(http.ListenAndServe(*addr, b.handler()))
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	if err := b.save(*stateFile); err != nil {
+		log.Printf("failed to save queues: %v", err)
+	}
+	b.close()
+}
+
+func (b *bot) onMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author.ID == s.State.User.ID || m.GuildID == "" || !strings.HasPrefix(m.Content, "!") {
+		return
+	}
+	reply := func(msg string) {
+		s.ChannelMessageSend(m.ChannelID, msg)
+	}
+	args := strings.Fields(m.Content)
+	g := b.guild(m.GuildID)
+	if g == nil {
+		return
+	}
+
+	switch args[0] {
+	case "!play":
+		if len(args) < 2 {
+			reply("usage: !play <file>")
+			return
+		}
+		channelID := voiceChannel(s, m.GuildID, m.Author.ID)
+		if channelID == "" {
+			reply("join a voice channel first")
+			return
+		}
+		if err := b.enqueue(g, item{Path: args[1], ChannelID: channelID}, reply); err != nil {
+			reply(fmt.Sprintf("failed to queue %v: %v", args[1], err))
+		}
+	case "!skip":
+		if err := g.Player.Skip(); err != nil {
+			reply(err.Error())
+		}
+	case "!pause":
+		if paused, err := g.Player.Pause(); err != nil {
+			reply(err.Error())
+		} else if !paused {
+			reply("already paused")
+		}
+	case "!resume":
+		if resumed, err := g.Player.Resume(); err != nil {
+			reply(err.Error())
+		} else if !resumed {
+			reply("not paused")
+		}
+	case "!stop":
+		g.Player.Clear()
+	case "!queue":
+		current, playing := g.Player.Current()
+		queue := g.Player.Queue()
+		if !playing && len(queue) == 0 {
+			reply("the queue is empty")
+			return
+		}
+		s.ChannelMessageSendEmbed(m.ChannelID, queueEmbed(current, playing, queue))
+	}
+}
+
+// queueEmbed shows the item that is playing, if any, and the items queued after it with how long until they play.
+func queueEmbed(current player.QueueEntry, playing bool, queue []player.QueueEntry) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{Title: "Queue"}
+	if playing {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Now playing", Value: current.Title})
+	}
+	var lines []string
+	for i, entry := range queue {
+		lines = append(lines, fmt.Sprintf("%d. %v (in %v)", i+1, entry.Title, entry.Until.Round(time.Second)))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "nothing else is queued")
+	}
+	embed.Description = strings.Join(lines, "\n")
+	return embed
+}
+
+// voiceChannel finds the voice channel a user is in.
+func voiceChannel(s *discordgo.Session, guildID string, userID string) string {
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		return ""
+	}
+	for _, vs := range guild.VoiceStates {
+		if vs.UserID == userID {
+			return vs.ChannelID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// save writes the items of every guild that have not ended, including the ones playing.
+func (b *bot) save(path string) error {
+	b.mu.Lock()
+	state := make(map[string][]item, len(b.guilds))
+	for id, g := range b.guilds {
+		g.mu.Lock()
+		for _, it := range g.items {
+			state[id] = append(state[id], *it)
+		}
+		g.mu.Unlock()
+	}
+	b.mu.Unlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// restore queues the items saved by a previous run.
+func (b *bot) restore(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var state map[string][]item
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for guildID, items := range state {
+		g := b.guild(guildID)
+		if g == nil {
+			return nil
+		}
+		for _, it := range items {
+			if err := b.enqueue(g, it, func(msg string) { log.Print(msg) }); err != nil {
+				log.Printf("failed to restore %v: %v", it.Path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "fullbot.json")
+	b, _ := newTestBot(t)
+	enqueue(t, b, "guild", item{Path: "a.mp3", ChannelID: "channel"}, item{Path: "b.mp3", ChannelID: "channel"})
+	enqueue(t, b, "other", item{Path: "c.mp3", ChannelID: "voice"})
+
+	// the items that have not ended are saved, including the ones playing
+	require.NoError(t, b.save(path))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var state map[string][]item
+	require.NoError(t, json.Unmarshal(data, &state))
+	assert.Equal(t, map[string][]item{
+		"guild": {{Path: "a.mp3", ChannelID: "channel"}, {Path: "b.mp3", ChannelID: "channel"}},
+		"other": {{Path: "c.mp3", ChannelID: "voice"}},
+	}, state)
+
+	// the next run queues them again in the same order
+	restored, _ := newTestBot(t)
+	require.NoError(t, restored.restore(path))
+	g := restored.guild("guild")
+	assert.Equal(t, []string{"a.mp3", "b.mp3"}, titles(g))
+	assert.Len(t, g.items, 2)
+	assert.Equal(t, []string{"c.mp3"}, titles(restored.guild("other")))
+}
+
+func TestStateMissing(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	b, _ := newTestBot(t)
+	assert.NoError(t, b.restore(filepath.Join(dir, "missing.json")), "expected the first run to start with empty queues")
+	assert.Empty(t, b.manager.Guilds())
+
+	path := filepath.Join(dir, "corrupt.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0644))
+	assert.Error(t, b.restore(path))
+}
@@ -7,9 +7,9 @@ import (
 	"os/signal"
 	"time"
 
-	"github.com/hajimehoshi/oto"
 	"github.com/jeffreymkabot/discordvoice"
 	"github.com/jeffreymkabot/discordvoice/mp3"
+	"github.com/jeffreymkabot/discordvoice/native"
 )
 
 func main() {
@@ -23,16 +23,15 @@ func main() {
 
 	bufferSize := 1 << 15
 	openDevice := func() (io.Writer, error) {
-		return oto.NewPlayer(44100, 2, 2, bufferSize)
+		return native.Open(44100, 2, bufferSize)
 	}
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, os.Kill)
-	end := make(chan struct{})
 
 	p := player.New()
 	defer p.Close()
-	p.Enqueue("test", openSource, openDevice,
+	track, err := p.Enqueue("test", openSource, openDevice,
 		player.OnStart(func() {
 			log.Print("playback started")
 		}),
@@ -41,12 +40,14 @@ func main() {
 		}, 1*time.Second),
 		player.OnEnd(func(e time.Duration, err error) {
 			log.Printf("playback stopped after %v because %v", e, err)
-			close(end)
 		}),
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	select {
-	case <-end:
+	case <-track.Done():
 	case <-sig:
 	case <-time.After(10 * time.Second):
 	}
@@ -0,0 +1,122 @@
+package player
+
+import (
+	"expvar"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Expvar publishes the player's counters and gauges with the expvar package as a map named prefix,
+// e.g. "player" or "player.<guild ID>" for bots with a player per guild, so they are served by expvar's /debug/vars handler.
+// The map holds the state, elapsed_seconds of the current item, queue_length, and counts of
+// tracks played, tracks that ended with an error, open_failures, frames written, and underruns.
+// A map already published under the prefix by a player that was closed is reused,
+// but the player does not publish its variables if another player that is still open has published under the prefix.
+// Measurements are passed on to the Metrics set with the Measure option.
+func Expvar(prefix string) Option {
+	return func(cfg *config) {
+		cfg.ExpvarPrefix = prefix
+	}
+}
+
+// expvarMetrics counts measurements for expvar and passes them on.
+type expvarMetrics struct {
+	next         Metrics
+	frames       expvar.Int
+	underruns    expvar.Int
+	tracks       expvar.Int
+	errors       expvar.Int
+	openFailures expvar.Int
+}
+
+func (m *expvarMetrics) FrameWritten(n int) {
+	m.frames.Add(1)
+	m.next.FrameWritten(n)
+}
+
+func (m *expvarMetrics) Underrun() {
+	m.underruns.Add(1)
+	m.next.Underrun()
+}
+
+// SendTimeout is reported by devices, not the player.
+func (m *expvarMetrics) SendTimeout() {
+	m.next.SendTimeout()
+}
+
+func (m *expvarMetrics) QueueDepth(n int) {
+	m.next.QueueDepth(n)
+}
+
+func (m *expvarMetrics) TrackPlayed(err error) {
+	m.tracks.Add(1)
+	if cause := errors.Cause(err); cause != nil && cause != io.EOF && cause != io.ErrUnexpectedEOF && cause != ErrSkipped {
+		m.errors.Add(1)
+	}
+	m.next.TrackPlayed(err)
+}
+
+func (m *expvarMetrics) OpenFailed(what string) {
+	m.openFailures.Add(1)
+	m.next.OpenFailed(what)
+}
+
+var (
+	// guards checking for and publishing the map under a prefix, which expvar does not do in one step
+	expvarMu sync.Mutex
+	// open players by the prefix they published under
+	expvarOwners = make(map[string]*Player)
+)
+
+// publish publishes the player's variables under the prefix, measuring them by wrapping the configured Metrics.
+func (p *Player) publish(prefix string) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	var vars *expvar.Map
+	switch v := expvar.Get(prefix).(type) {
+	case nil:
+		vars = expvar.NewMap(prefix)
+	case *expvar.Map:
+		if expvarOwners[prefix] != nil {
+			p.cfg.Logger.Errorf("cannot publish expvar %q, it is already published by another player", prefix)
+			return
+		}
+		vars = v
+	default:
+		p.cfg.Logger.Errorf("cannot publish expvar %q, it is already published and is not a map", prefix)
+		return
+	}
+	expvarOwners[prefix] = p
+
+	m := &expvarMetrics{next: p.cfg.Metrics}
+	p.cfg.Metrics = m
+	vars.Set("state", expvar.Func(func() interface{} {
+		return p.State().String()
+	}))
+	vars.Set("elapsed_seconds", expvar.Func(func() interface{} {
+		elapsed, _ := p.Elapsed()
+		return elapsed.Seconds()
+	}))
+	vars.Set("queue_length", expvar.Func(func() interface{} {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return len(p.queue)
+	}))
+	vars.Set("tracks", &m.tracks)
+	vars.Set("errors", &m.errors)
+	vars.Set("open_failures", &m.openFailures)
+	vars.Set("frames", &m.frames)
+	vars.Set("underruns", &m.underruns)
+}
+
+// unpublish lets another player reuse the map the player published under the prefix.
+// The map keeps the player's last values until then.
+func (p *Player) unpublish(prefix string) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvarOwners[prefix] == p {
+		delete(expvarOwners, prefix)
+	}
+}
@@ -0,0 +1,65 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// dcaMetadata is the subset of the DCA1 metadata that describes the opus stream.
+type dcaMetadata struct {
+	DCA struct {
+		Version int `json:"version"`
+		Tool    struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		} `json:"tool"`
+	} `json:"dca"`
+	Opus struct {
+		SampleRate int `json:"sample_rate"`
+		Channels   int `json:"channels"`
+	} `json:"opus"`
+}
+
+// dca records the DCA1 magic and metadata followed by each frame prefixed with its 16-bit little-endian length.
+type dca struct{}
+
+func newDCA(w *bufio.Writer, cfg config) (dca, error) {
+	var md dcaMetadata
+	md.DCA.Version = 1
+	md.DCA.Tool.Name = "discordvoice"
+	md.DCA.Tool.URL = "https://github.com/jeffreymkabot/discordvoice"
+	md.Opus.SampleRate = cfg.SampleRate
+	md.Opus.Channels = cfg.Channels
+	data, err := json.Marshal(md)
+	if err != nil {
+		return dca{}, err
+	}
+
+	if _, err := w.WriteString("DCA1"); err != nil {
+		return dca{}, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int32(len(data))); err != nil {
+		return dca{}, err
+	}
+	_, err = w.Write(data)
+	return dca{}, err
+}
+
+func (dca) writeFrame(w *bufio.Writer, frame []byte) error {
+	if len(frame) > 1<<15-1 {
+		return errors.Errorf("frame of %v bytes is too long", len(frame))
+	}
+	if err := binary.Write(w, binary.LittleEndian, int16(len(frame))); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func (dca) finish(*bufio.Writer, *os.File) error {
+	return nil
+}
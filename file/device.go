@@ -0,0 +1,155 @@
+// Package file provides a device that records frames to a file.
+package file
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/pkg/errors"
+)
+
+// Format is the container a Writer records frames in.
+type Format int
+
+const (
+	// Raw records frames back to back without any framing, which suits PCM frames.
+	Raw Format = iota
+	// DCA records opus frames in the DCA1 format used by github.com/jonas747/dca.
+	DCA
+	// Ogg records opus frames in an Ogg Opus stream, the .opus files most audio tools understand.
+	Ogg
+	// FLAC records interleaved 16-bit little-endian PCM frames losslessly in a FLAC stream.
+	FLAC
+)
+
+type config struct {
+	Format     Format
+	SampleRate int
+	Channels   int
+}
+
+// Option functions configure a Writer.
+// Pass Options to the Create function.
+type Option func(*config)
+
+// Container sets the format of the file, Raw by default.
+func Container(f Format) Option {
+	return func(cfg *config) {
+		cfg.Format = f
+	}
+}
+
+// SampleRate sets the sample rate of the recorded audio, 48000 by default.
+// Opus frames are always timed at 48kHz, the sample rate is recorded as the rate of the original audio.
+func SampleRate(rate int) Option {
+	return func(cfg *config) {
+		if rate > 0 {
+			cfg.SampleRate = rate
+		}
+	}
+}
+
+// Channels sets the number of channels of the recorded audio, 2 by default.
+func Channels(n int) Option {
+	return func(cfg *config) {
+		if n > 0 {
+			cfg.Channels = n
+		}
+	}
+}
+
+// container frames the recorded frames for a format.
+type container interface {
+	// writeFrame records a frame.
+	writeFrame(w *bufio.Writer, frame []byte) error
+	// finish records anything needed to end the file, e.g. an end of stream marker or headers that depend on the whole recording.
+	finish(w *bufio.Writer, f *os.File) error
+}
+
+// Writer records the frames written to it to a file.
+type Writer struct {
+	f    *os.File
+	buf  *bufio.Writer
+	cfg  config
+	cont container
+}
+
+// Create produces a Writer that records frames to a new file at path, truncating any existing file.
+// By default frames are recorded back to back without any framing, pass the Container option to record to another format.
+// The file is not usable by other tools until the Writer is closed.
+func Create(path string, opts ...Option) (*Writer, error) {
+	cfg := config{Format: Raw, SampleRate: 48000, Channels: 2}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, buf: bufio.NewWriter(f), cfg: cfg}
+	switch cfg.Format {
+	case Raw:
+		w.cont = raw{}
+	case DCA:
+		w.cont, err = newDCA(w.buf, cfg)
+	case Ogg:
+		w.cont, err = newOgg(w.buf, cfg)
+	case FLAC:
+		w.cont, err = newFLAC(w.buf, cfg)
+	default:
+		err = errors.Errorf("unknown format %v", cfg.Format)
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to write header")
+	}
+	return w, nil
+}
+
+// Write implements io.Writer.
+// Each call to Write must pass exactly one frame.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.cont.writeFrame(w.buf, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// DeviceInfo implements player.DeviceInfo.
+// A file accepts frames as fast as they are written so the player paces writes unless the item is not Realtime.
+func (w *Writer) DeviceInfo() player.DeviceCaps {
+	return player.DeviceCaps{
+		Opus:     w.cfg.Format != FLAC,
+		PCM:      w.cfg.Format == Raw || w.cfg.Format == FLAC,
+		Realtime: false,
+	}
+}
+
+// Close finishes the file format, flushes any buffered frames and closes the file.
+func (w *Writer) Close() error {
+	err := w.cont.finish(w.buf, w.f)
+	if ferr := w.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// raw records frames back to back.
+type raw struct{}
+
+func (raw) writeFrame(w *bufio.Writer, frame []byte) error {
+	_, err := w.Write(frame)
+	return err
+}
+
+func (raw) finish(*bufio.Writer, *os.File) error {
+	return nil
+}
+
+// do not compile unless Writer implements player.DeviceInfo
+var _ player.DeviceInfo = &Writer{}
@@ -0,0 +1,139 @@
+package file_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeffreymkabot/discordvoice/dca"
+	"github.com/jeffreymkabot/discordvoice/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// record writes each frame to a new file of the format and returns the file's contents.
+func record(t *testing.T, frames [][]byte, opts ...file.Option) []byte {
+	dir, err := ioutil.TempDir("", "file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording")
+	w, err := file.Create(path, opts...)
+	require.NoError(t, err)
+	for _, frame := range frames {
+		n, err := w.Write(frame)
+		require.NoError(t, err)
+		assert.Equal(t, len(frame), n)
+	}
+	require.NoError(t, w.Close())
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
+
+// checksum is a bitwise CRC of the width with the polynomial, an initial value of 0, and no reflection,
+// the checksum of ogg pages and flac frames.
+func checksum(data []byte, width uint, poly uint64) uint64 {
+	top := uint64(1) << (width - 1)
+	mask := uint64(1)<<width - 1
+	var crc uint64
+	for _, b := range data {
+		for i := uint(8); i > 0; i-- {
+			bit := b>>(i-1)&1 == 1
+			msb := crc&top != 0
+			crc = crc << 1 & mask
+			if bit != msb {
+				crc ^= poly
+			}
+		}
+	}
+	return crc
+}
+
+func TestChecksum(t *testing.T) {
+	t.Parallel()
+	// the check values of CRC-8/SMBUS, CRC-16/UMTS, and CRC-32/CKSUM without its final inversion
+	check := []byte("123456789")
+	assert.Equal(t, uint64(0xF4), checksum(check, 8, 0x07))
+	assert.Equal(t, uint64(0xFEE8), checksum(check, 16, 0x8005))
+	assert.Equal(t, uint64(0x89A1897F), checksum(check, 32, 0x04C11DB7))
+}
+
+func TestRaw(t *testing.T) {
+	t.Parallel()
+	data := record(t, [][]byte{[]byte("ab"), []byte("c")})
+	assert.Equal(t, "abc", string(data))
+}
+
+func TestDCA(t *testing.T) {
+	t.Parallel()
+	frames := [][]byte{[]byte("a"), make([]byte, 1<<15-1), []byte("c")}
+	data := record(t, frames, file.Container(file.DCA), file.SampleRate(44100), file.Channels(1))
+
+	// the dca package reads the file back
+	src, err := dca.NewSource(bytes.NewReader(data))
+	require.NoError(t, err)
+	md := src.Metadata()
+	require.NotNil(t, md)
+	assert.Equal(t, 1, md.DCA.Version)
+	assert.Equal(t, "discordvoice", md.DCA.Tool.Name)
+	assert.Equal(t, 44100, md.Opus.SampleRate)
+	assert.Equal(t, 1, md.Opus.Channels)
+	for _, frame := range frames {
+		read, err := src.ReadFrame()
+		require.NoError(t, err)
+		assert.Equal(t, frame, read)
+	}
+	_, err = src.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a frame too long for a dca file fails to write
+	w, err := file.Create(filepath.Join(dir, "long"), file.Container(file.DCA))
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 1<<15))
+	assert.Error(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = file.Create(filepath.Join(dir, "unknown"), file.Container(file.Format(99)))
+	assert.Error(t, err)
+	_, err = file.Create(filepath.Join(dir, "missing", "file"))
+	assert.Error(t, err)
+	_, err = file.Create(filepath.Join(dir, "flac"), file.Container(file.FLAC), file.Channels(9))
+	assert.Error(t, err)
+	_, err = file.Create(filepath.Join(dir, "flac"), file.Container(file.FLAC), file.SampleRate(1<<20))
+	assert.Error(t, err)
+}
+
+func TestDeviceInfo(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cases := []struct {
+		format    file.Format
+		opus, pcm bool
+	}{
+		{format: file.Raw, opus: true, pcm: true},
+		{format: file.DCA, opus: true, pcm: false},
+		{format: file.Ogg, opus: true, pcm: false},
+		{format: file.FLAC, opus: false, pcm: true},
+	}
+	for _, c := range cases {
+		w, err := file.Create(filepath.Join(dir, "recording"), file.Container(c.format))
+		require.NoError(t, err)
+		caps := w.DeviceInfo()
+		assert.Equal(t, c.opus, caps.Opus, "expected format %v to take opus frames: %v", c.format, c.opus)
+		assert.Equal(t, c.pcm, caps.PCM, "expected format %v to take pcm frames: %v", c.format, c.pcm)
+		assert.False(t, caps.Realtime)
+		require.NoError(t, w.Close())
+	}
+}
@@ -0,0 +1,167 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// offset of the STREAMINFO block after the "fLaC" marker and the metadata block header
+const flacStreamInfoOffset = 8
+
+// flac records PCM frames as verbatim FLAC frames (https://xiph.org/flac/format.html).
+// Each PCM frame becomes a FLAC frame of its own, numbered by its first sample,
+// and the STREAMINFO block is rewritten on Close once the length of the stream is known.
+type flac struct {
+	sampleRate int
+	channels   int
+	// samples per channel recorded so far
+	samples uint64
+	// smallest and largest block sizes in samples and frame sizes in bytes
+	minBlock, maxBlock int
+	minFrame, maxFrame int
+}
+
+func newFLAC(w *bufio.Writer, cfg config) (*flac, error) {
+	if cfg.Channels > 8 {
+		return nil, errors.Errorf("flac supports at most 8 channels, not %v", cfg.Channels)
+	}
+	if cfg.SampleRate >= 1<<20 {
+		return nil, errors.Errorf("flac does not support a sample rate of %v", cfg.SampleRate)
+	}
+	fl := &flac{sampleRate: cfg.SampleRate, channels: cfg.Channels}
+	if _, err := w.WriteString("fLaC"); err != nil {
+		return nil, err
+	}
+	// the last metadata block is a STREAMINFO block of 34 bytes
+	if _, err := w.Write([]byte{0x80, 0, 0, 34}); err != nil {
+		return nil, err
+	}
+	_, err := w.Write(fl.streamInfo())
+	return fl, err
+}
+
+func (fl *flac) writeFrame(w *bufio.Writer, frame []byte) error {
+	block := len(frame) / (2 * fl.channels)
+	if block == 0 || len(frame)%(2*fl.channels) != 0 {
+		return errors.Errorf("frame of %v bytes does not hold whole 16-bit samples of %v channels", len(frame), fl.channels)
+	}
+	if block > 1<<16 {
+		return errors.Errorf("frame of %v samples is too long", block)
+	}
+
+	// sync code and variable block size, block size from the end of the header and sample rate from STREAMINFO,
+	// independent channels with 16 bits per sample
+	out := []byte{0xff, 0xf9, 0x70, byte(fl.channels-1)<<4 | 0x08}
+	out = appendUTF8(out, fl.samples)
+	out = append(out, byte((block-1)>>8), byte(block-1))
+	out = append(out, crc8(out))
+	for ch := 0; ch < fl.channels; ch++ {
+		// verbatim subframe without wasted bits
+		out = append(out, 0x02)
+		for i := ch * 2; i < len(frame); i += 2 * fl.channels {
+			// samples are big-endian in flac
+			out = append(out, frame[i+1], frame[i])
+		}
+	}
+	crc := crc16(out)
+	out = append(out, byte(crc>>8), byte(crc))
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+
+	fl.samples += uint64(block)
+	if fl.minBlock == 0 || block < fl.minBlock {
+		fl.minBlock = block
+	}
+	if block > fl.maxBlock {
+		fl.maxBlock = block
+	}
+	if fl.minFrame == 0 || len(out) < fl.minFrame {
+		fl.minFrame = len(out)
+	}
+	if len(out) > fl.maxFrame {
+		fl.maxFrame = len(out)
+	}
+	return nil
+}
+
+// finish rewrites STREAMINFO with the block sizes, frame sizes, and number of samples that were recorded.
+func (fl *flac) finish(w *bufio.Writer, f *os.File) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(fl.streamInfo(), flacStreamInfoOffset)
+	return err
+}
+
+func (fl *flac) streamInfo() []byte {
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info[0:], uint16(fl.minBlock))
+	binary.BigEndian.PutUint16(info[2:], uint16(fl.maxBlock))
+	putUint24(info[4:], fl.minFrame)
+	putUint24(info[7:], fl.maxFrame)
+	// 20 bits of sample rate, 3 bits of channels - 1, 5 bits of bits per sample - 1, 36 bits of total samples
+	v := uint64(fl.sampleRate)<<44 | uint64(fl.channels-1)<<41 | uint64(16-1)<<36 | fl.samples&(1<<36-1)
+	binary.BigEndian.PutUint64(info[10:], v)
+	// the MD5 signature of the audio is left unset
+	return info
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+// appendUTF8 appends a sample number in the extended UTF-8 coding flac uses for up to 36 bits.
+func appendUTF8(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+	// number of continuation bytes, each holding 6 bits
+	n := 1
+	for v>>(uint(n)*6) >= 1<<uint(6-n) {
+		n++
+	}
+	lead := byte(0xff << uint(7-n))
+	b = append(b, lead|byte(v>>(uint(n)*6)))
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(uint(i)*6))&0x3f)
+	}
+	return b
+}
+
+// crc8 is the checksum of a frame header, polynomial x^8 + x^2 + x^1 + x^0.
+func crc8(b []byte) byte {
+	var crc byte
+	for _, c := range b {
+		crc ^= c
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16 is the checksum of a frame, polynomial x^16 + x^15 + x^2 + x^0.
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
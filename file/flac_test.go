@@ -0,0 +1,184 @@
+package file_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeffreymkabot/discordvoice/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type streamInfo struct {
+	minBlock, maxBlock int
+	minFrame, maxFrame int
+	sampleRate         int
+	channels           int
+	bitsPerSample      int
+	samples            uint64
+}
+
+type flacFrame struct {
+	// number of the first sample of the frame
+	number uint64
+	size   int
+	// interleaved 16-bit little-endian samples
+	pcm []byte
+}
+
+// readUTF8 reads a number in the extended UTF-8 coding of flac frame headers.
+func readUTF8(t *testing.T, b []byte) (v uint64, n int) {
+	if b[0] < 0x80 {
+		return uint64(b[0]), 1
+	}
+	n = 0
+	for b[0]<<uint(n)&0x80 != 0 {
+		n++
+	}
+	require.True(t, n >= 2 && n <= 7 && len(b) >= n, "expected a valid leading byte, not %#x", b[0])
+	v = uint64(b[0] & (0xFF >> uint(n+1)))
+	for _, c := range b[1:n] {
+		require.Equal(t, byte(0x80), c&0xC0, "expected a continuation byte")
+		v = v<<6 | uint64(c&0x3F)
+	}
+	return v, n
+}
+
+// readFLAC reads the STREAMINFO and verbatim 16-bit frames of a flac stream, checking their checksums.
+func readFLAC(t *testing.T, data []byte) (streamInfo, []flacFrame) {
+	require.True(t, len(data) >= 42)
+	require.Equal(t, "fLaC", string(data[:4]))
+	require.Equal(t, []byte{0x80, 0, 0, 34}, data[4:8], "expected STREAMINFO as the last metadata block")
+	raw := data[8:42]
+	v := binary.BigEndian.Uint64(raw[10:])
+	info := streamInfo{
+		minBlock:      int(binary.BigEndian.Uint16(raw[0:])),
+		maxBlock:      int(binary.BigEndian.Uint16(raw[2:])),
+		minFrame:      int(raw[4])<<16 | int(raw[5])<<8 | int(raw[6]),
+		maxFrame:      int(raw[7])<<16 | int(raw[8])<<8 | int(raw[9]),
+		sampleRate:    int(v >> 44),
+		channels:      int(v>>41&0x7) + 1,
+		bitsPerSample: int(v>>36&0x1F) + 1,
+		samples:       v & (1<<36 - 1),
+	}
+
+	var frames []flacFrame
+	for f := data[42:]; len(f) > 0; {
+		require.True(t, len(f) >= 4)
+		require.Equal(t, []byte{0xFF, 0xF9}, f[:2], "expected the sync code of a frame with a variable block size")
+		require.Equal(t, byte(0x70), f[2], "expected the block size at the end of the header and the sample rate of STREAMINFO")
+		require.Equal(t, byte(info.channels-1)<<4|0x08, f[3], "expected independent channels of 16-bit samples")
+		number, n := readUTF8(t, f[4:])
+		pos := 4 + n
+		block := int(binary.BigEndian.Uint16(f[pos:])) + 1
+		pos += 2
+		assert.Equal(t, uint64(f[pos]), checksum(f[:pos], 8, 0x07), "expected the checksum of the header of frame %v", len(frames))
+		pos++
+
+		pcm := make([]byte, 2*block*info.channels)
+		for ch := 0; ch < info.channels; ch++ {
+			require.Equal(t, byte(0x02), f[pos], "expected a verbatim subframe")
+			pos++
+			for s := 0; s < block; s++ {
+				i := 2 * (s*info.channels + ch)
+				pcm[i], pcm[i+1] = f[pos+1], f[pos]
+				pos += 2
+			}
+		}
+		assert.Equal(t, uint64(binary.BigEndian.Uint16(f[pos:])), checksum(f[:pos], 16, 0x8005), "expected the checksum of frame %v", len(frames))
+		pos += 2
+		frames = append(frames, flacFrame{number: number, size: pos, pcm: pcm})
+		f = f[pos:]
+	}
+	return info, frames
+}
+
+// pcmFrame is a frame of n samples of each channel, counting down from start so some are negative.
+func pcmFrame(n int, channels int, start int16) []byte {
+	frame := make([]byte, 2*n*channels)
+	for i := 0; i < n*channels; i++ {
+		binary.LittleEndian.PutUint16(frame[2*i:], uint16(start-int16(i)))
+	}
+	return frame
+}
+
+func TestFLAC(t *testing.T) {
+	t.Parallel()
+	// enough frames for sample numbers of one, two, and three bytes, and a shorter last frame
+	frames := [][]byte{
+		pcmFrame(960, 2, 1000),
+		pcmFrame(960, 2, -1000),
+		pcmFrame(960, 2, 32767),
+		pcmFrame(960, 2, 0),
+		pcmFrame(100, 2, 5),
+	}
+	data := record(t, frames, file.Container(file.FLAC), file.SampleRate(44100))
+	info, decoded := readFLAC(t, data)
+	require.Len(t, decoded, len(frames))
+	var number uint64
+	minFrame, maxFrame := 0, 0
+	for i, f := range decoded {
+		assert.Equal(t, frames[i], f.pcm, "expected the samples of frame %v", i)
+		assert.Equal(t, number, f.number)
+		number += uint64(len(frames[i]) / 4)
+		if minFrame == 0 || f.size < minFrame {
+			minFrame = f.size
+		}
+		if f.size > maxFrame {
+			maxFrame = f.size
+		}
+	}
+
+	// STREAMINFO is rewritten once the whole stream is known
+	assert.Equal(t, streamInfo{
+		minBlock:      100,
+		maxBlock:      960,
+		minFrame:      minFrame,
+		maxFrame:      maxFrame,
+		sampleRate:    44100,
+		channels:      2,
+		bitsPerSample: 16,
+		samples:       4*960 + 100,
+	}, info)
+	assert.Equal(t, make([]byte, 16), data[26:42], "expected no MD5 signature")
+}
+
+func TestFLACMono(t *testing.T) {
+	t.Parallel()
+	frames := [][]byte{pcmFrame(1, 1, -1), pcmFrame(1<<16, 1, 7)}
+	info, decoded := readFLAC(t, record(t, frames, file.Container(file.FLAC), file.Channels(1)))
+	require.Len(t, decoded, 2)
+	assert.Equal(t, frames[0], decoded[0].pcm)
+	assert.Equal(t, frames[1], decoded[1].pcm)
+	assert.Equal(t, 1, info.channels)
+	assert.Equal(t, 48000, info.sampleRate)
+	assert.Equal(t, 1, info.minBlock)
+	assert.Equal(t, 0, info.maxBlock, "expected the largest block size of 65536 to wrap")
+	assert.Equal(t, uint64(1<<16+1), info.samples)
+}
+
+func TestFLACInvalidFrame(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	w, err := file.Create(filepath.Join(dir, "recording"), file.Container(file.FLAC))
+	require.NoError(t, err)
+	for _, frame := range [][]byte{nil, make([]byte, 6), pcmFrame(1<<16+1, 2, 0)} {
+		_, err = w.Write(frame)
+		assert.Error(t, err, "expected a frame of %v bytes to fail", len(frame))
+	}
+	require.NoError(t, w.Close())
+	info, decoded := readFLAC(t, mustRead(t, filepath.Join(dir, "recording")))
+	assert.Empty(t, decoded)
+	assert.Equal(t, uint64(0), info.samples)
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
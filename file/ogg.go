@@ -0,0 +1,173 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math/rand"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	oggBOS = 0x02
+	oggEOS = 0x04
+	// most segments in a page
+	oggMaxSegments = 255
+)
+
+// ogg records opus frames as the packets of an Ogg Opus stream (RFC 7845).
+// Packets are collected into pages of up to 255 segments.
+type ogg struct {
+	serial uint32
+	seq    uint32
+	// granule position at the end of the last packet, in 48kHz samples
+	granule int64
+	// packets of the page that is being collected
+	segments []byte
+	data     []byte
+}
+
+func newOgg(w *bufio.Writer, cfg config) (*ogg, error) {
+	o := &ogg{serial: rand.Uint32()}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = byte(cfg.Channels)
+	// pre-skip is unknown, the encoder's lookahead plays as a few milliseconds of leading audio
+	binary.LittleEndian.PutUint16(head[10:], 0)
+	binary.LittleEndian.PutUint32(head[12:], uint32(cfg.SampleRate))
+	// output gain 0 and channel mapping family 0, mono or stereo
+	if err := o.writePage(w, oggBOS, head); err != nil {
+		return nil, err
+	}
+
+	vendor := "discordvoice"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	// no user comments
+	if err := o.writePage(w, 0, tags); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *ogg) writeFrame(w *bufio.Writer, frame []byte) error {
+	samples, err := opusSamples(frame)
+	if err != nil {
+		return err
+	}
+	lacing := len(frame)/255 + 1
+	if len(o.segments)+lacing > oggMaxSegments {
+		if err := o.flush(w, 0); err != nil {
+			return err
+		}
+	}
+	o.segments = appendLacing(o.segments, len(frame))
+	o.data = append(o.data, frame...)
+	o.granule += int64(samples)
+	return nil
+}
+
+func (o *ogg) finish(w *bufio.Writer, _ *os.File) error {
+	return o.flush(w, oggEOS)
+}
+
+// flush writes the collected packets as a page.
+func (o *ogg) flush(w *bufio.Writer, flags byte) error {
+	err := o.writeSegments(w, flags, o.segments, o.data)
+	o.segments = o.segments[:0]
+	o.data = o.data[:0]
+	return err
+}
+
+// writePage writes a page with a single packet.
+func (o *ogg) writePage(w *bufio.Writer, flags byte, packet []byte) error {
+	return o.writeSegments(w, flags, appendLacing(nil, len(packet)), packet)
+}
+
+func (o *ogg) writeSegments(w *bufio.Writer, flags byte, segments []byte, data []byte) error {
+	page := make([]byte, 27, 27+len(segments)+len(data))
+	copy(page, "OggS")
+	page[5] = flags
+	// header pages are written before any packet so their granule position is 0
+	binary.LittleEndian.PutUint64(page[6:], uint64(o.granule))
+	binary.LittleEndian.PutUint32(page[14:], o.serial)
+	binary.LittleEndian.PutUint32(page[18:], o.seq)
+	page[26] = byte(len(segments))
+	page = append(page, segments...)
+	page = append(page, data...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	o.seq++
+	_, err := w.Write(page)
+	return err
+}
+
+// appendLacing appends the lacing values of a packet of n bytes to a segment table.
+func appendLacing(segments []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		segments = append(segments, 255)
+	}
+	return append(segments, byte(n))
+}
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return
+}()
+
+// oggCRC computes the checksum of a page whose checksum field is zero.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// opusSamples reads the number of 48kHz samples in an opus packet from its TOC byte (RFC 6716 section 3.1).
+func opusSamples(packet []byte) (int, error) {
+	if len(packet) < 1 {
+		return 0, errors.New("empty opus packet")
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+	var frameSize int
+	switch {
+	case config < 12:
+		// SILK 10, 20, 40, 60ms
+		frameSize = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		// hybrid 10, 20ms
+		frameSize = []int{480, 960}[config%2]
+	default:
+		// CELT 2.5, 5, 10, 20ms
+		frameSize = []int{120, 240, 480, 960}[config%4]
+	}
+	var frames int
+	switch toc & 0x03 {
+	case 0:
+		frames = 1
+	case 1, 2:
+		frames = 2
+	default:
+		if len(packet) < 2 {
+			return 0, errors.New("opus packet is missing its frame count")
+		}
+		frames = int(packet[1] & 0x3f)
+	}
+	return frames * frameSize, nil
+}
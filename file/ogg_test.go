@@ -0,0 +1,136 @@
+package file_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeffreymkabot/discordvoice/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type page struct {
+	flags   byte
+	granule int64
+	serial  uint32
+	seq     uint32
+	packets [][]byte
+}
+
+// readPages reads the pages of an ogg stream, checking their checksums.
+// Packets do not continue from one page to the next.
+func readPages(t *testing.T, data []byte) []page {
+	var pages []page
+	for len(data) > 0 {
+		require.True(t, len(data) >= 27, "expected a whole page header")
+		require.Equal(t, "OggS", string(data[:4]))
+		require.Equal(t, byte(0), data[4], "expected version 0")
+		n := int(data[26])
+		require.True(t, len(data) >= 27+n)
+		segments := data[27 : 27+n]
+		size := 27 + n
+		for _, s := range segments {
+			size += int(s)
+		}
+		require.True(t, len(data) >= size)
+
+		// the checksum covers the page with its checksum field zeroed
+		zeroed := append([]byte(nil), data[:size]...)
+		crc := binary.LittleEndian.Uint32(zeroed[22:])
+		copy(zeroed[22:26], []byte{0, 0, 0, 0})
+		assert.Equal(t, uint64(crc), checksum(zeroed, 32, 0x04C11DB7), "expected the checksum of page %v", len(pages))
+
+		p := page{
+			flags:   data[5],
+			granule: int64(binary.LittleEndian.Uint64(data[6:])),
+			serial:  binary.LittleEndian.Uint32(data[14:]),
+			seq:     binary.LittleEndian.Uint32(data[18:]),
+		}
+		body := data[27+n : size]
+		var packet []byte
+		for _, s := range segments {
+			packet = append(packet, body[:s]...)
+			body = body[s:]
+			if s < 255 {
+				p.packets = append(p.packets, packet)
+				packet = nil
+			}
+		}
+		require.Nil(t, packet, "expected the last packet of the page to end")
+		pages = append(pages, p)
+		data = data[size:]
+	}
+	return pages
+}
+
+func TestOgg(t *testing.T) {
+	t.Parallel()
+	var frames [][]byte
+	// a 20ms CELT packet whose segments fill a page
+	frames = append(frames, append([]byte{0xF8}, bytes.Repeat([]byte{1}, 255*255-2)...))
+	// a 10ms SILK packet, two 20ms CELT frames, and three 60ms SILK frames
+	frames = append(frames, []byte{0x00, 2}, []byte{0xF9, 3}, []byte{0x1B, 0x03, 4})
+	// enough 20ms packets for another page
+	for i := 0; i < 300; i++ {
+		frames = append(frames, []byte{0xF8, byte(i)})
+	}
+	data := record(t, frames, file.Container(file.Ogg), file.SampleRate(44100), file.Channels(1))
+
+	pages := readPages(t, data)
+	require.Len(t, pages, 5)
+	for i, p := range pages {
+		assert.Equal(t, pages[0].serial, p.serial)
+		assert.Equal(t, uint32(i), p.seq)
+	}
+
+	// the identification and comment headers on pages of their own
+	assert.Equal(t, byte(0x02), pages[0].flags, "expected the first page to begin the stream")
+	assert.Equal(t, int64(0), pages[0].granule)
+	require.Len(t, pages[0].packets, 1)
+	head := pages[0].packets[0]
+	require.Len(t, head, 19)
+	assert.Equal(t, "OpusHead", string(head[:8]))
+	assert.Equal(t, byte(1), head[8], "expected version 1")
+	assert.Equal(t, byte(1), head[9], "expected 1 channel")
+	assert.Equal(t, uint32(44100), binary.LittleEndian.Uint32(head[12:]))
+	assert.Equal(t, byte(0), head[18], "expected channel mapping family 0")
+	require.Len(t, pages[1].packets, 1)
+	tags := pages[1].packets[0]
+	assert.Equal(t, "OpusTags", string(tags[:8]))
+	assert.Equal(t, uint32(len("discordvoice")), binary.LittleEndian.Uint32(tags[8:]))
+	assert.Equal(t, "discordvoice", string(tags[12:24]))
+	assert.Equal(t, uint32(0), binary.LittleEndian.Uint32(tags[24:]), "expected no user comments")
+
+	// granule positions count the 48kHz samples at the end of each page
+	var packets [][]byte
+	for _, p := range pages[2:] {
+		packets = append(packets, p.packets...)
+	}
+	assert.Equal(t, frames, packets)
+	assert.Len(t, pages[2].packets, 1)
+	assert.Equal(t, int64(960), pages[2].granule)
+	assert.Len(t, pages[3].packets, 255)
+	assert.Equal(t, int64(960+480+2*960+3*2880+252*960), pages[3].granule)
+	assert.Equal(t, byte(0), pages[3].flags)
+	assert.Equal(t, byte(0x04), pages[4].flags, "expected the last page to end the stream")
+	assert.Equal(t, int64(960+480+2*960+3*2880+300*960), pages[4].granule)
+}
+
+func TestOggInvalidPacket(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	w, err := file.Create(filepath.Join(dir, "recording"), file.Container(file.Ogg))
+	require.NoError(t, err)
+	_, err = w.Write(nil)
+	assert.Error(t, err)
+	// a packet of arbitrarily many frames is missing its count
+	_, err = w.Write([]byte{0xFB})
+	assert.Error(t, err)
+	require.NoError(t, w.Close())
+}
@@ -0,0 +1,381 @@
+// Package httpsource streams media over HTTP and survives the connection dropping,
+// resuming with a Range request from where the stream broke off instead of failing the item.
+package httpsource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/pkg/errors"
+)
+
+// longest wait between attempts to resume
+const maxBackoff = 30 * time.Second
+
+type config struct {
+	Client    *http.Client
+	Header    http.Header
+	Timeout   time.Duration
+	Retries   int
+	Backoff   time.Duration
+	Redirects int
+	Clock     clock.Clock
+}
+
+// Option functions configure a Reader.
+// Pass Options to the Open function.
+type Option func(*config)
+
+// Client sets the client that makes the requests, http.DefaultClient by default.
+// The client's Timeout should be 0, since it would limit how long the whole stream takes to read.
+func Client(c *http.Client) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.Client = c
+		}
+	}
+}
+
+// Header adds a header to every request, e.g. a User-Agent or a Cookie that the server requires.
+func Header(key, value string) Option {
+	return func(cfg *config) {
+		cfg.Header.Add(key, value)
+	}
+}
+
+// Timeout sets how long to wait for the response to a request, or for the next bytes of the stream,
+// before the connection is considered dropped, 10 seconds by default.
+func Timeout(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.Timeout = d
+		}
+	}
+}
+
+// Retry sets how many times in a row to try to resume the stream, waiting backoff and then twice as long each time.
+// The count starts over whenever the stream makes progress.
+// Streams are retried 5 times starting after half a second by default.
+func Retry(n int, backoff time.Duration) Option {
+	return func(cfg *config) {
+		if n >= 0 {
+			cfg.Retries = n
+		}
+		if backoff > 0 {
+			cfg.Backoff = backoff
+		}
+	}
+}
+
+// Redirects sets how many redirects a request may follow, 10 by default.
+// Streams resume at the URL the redirects led to, or at the original URL if that one no longer works,
+// e.g. because a signed URL expired.
+func Redirects(n int) Option {
+	return func(cfg *config) {
+		if n >= 0 {
+			cfg.Redirects = n
+		}
+	}
+}
+
+// Clock sets the clock that times out requests and reads and backs off between attempts to resume, clock.Real by default.
+// Tests can pass a clock.Fake to drop connections and resume without waiting.
+func Clock(c clock.Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.Clock = c
+		}
+	}
+}
+
+// statusError is a response that was not the stream.
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("failed to get %v: %v", e.url, e.status)
+}
+
+// permanent reports whether trying again will not help, other than with a different URL.
+func (e statusError) permanent() bool {
+	return e.code >= 400 && e.code < 500 && e.code != http.StatusRequestTimeout && e.code != http.StatusTooManyRequests
+}
+
+var errClosed = errors.New("http source is closed")
+
+// Reader is the body of a response that is requested again from where it broke off whenever the connection drops.
+// Reader is safe to Close while another goroutine is blocked in Read.
+type Reader struct {
+	cfg    config
+	ctx    context.Context
+	cancel context.CancelFunc
+	// url is the URL the Reader was opened with, location is where its redirects led
+	url      string
+	location string
+	// validator is the ETag or the Last-Modified of the first response,
+	// so a resumed stream is known to be the same stream
+	validator string
+	size      int64
+	ctype     string
+
+	mu       sync.Mutex
+	offset   int64
+	attempts int
+	body     io.ReadCloser
+	stop     context.CancelFunc
+	timer    clock.Timer
+	err      error
+}
+
+// Open requests the url and returns its body once the server responds.
+// Reading ends with ctx, or when the Reader is closed.
+func Open(ctx context.Context, url string, opts ...Option) (*Reader, error) {
+	cfg := config{
+		Client:    http.DefaultClient,
+		Header:    make(http.Header),
+		Timeout:   10 * time.Second,
+		Retries:   5,
+		Backoff:   500 * time.Millisecond,
+		Redirects: 10,
+		Clock:     clock.Real,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	client := *cfg.Client
+	redirects := cfg.Redirects
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > redirects {
+			return errors.Errorf("stopped after %v redirects", redirects)
+		}
+		return nil
+	}
+	cfg.Client = &client
+
+	r := &Reader{cfg: cfg, url: url, location: url, size: -1}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	if err := r.resume(); err != nil {
+		r.cancel()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Size reports the size of the whole stream in bytes, or false if the server did not say.
+func (r *Reader) Size() (int64, bool) {
+	return r.size, r.size >= 0
+}
+
+// ContentType reports the Content-Type of the stream.
+func (r *Reader) ContentType() string {
+	return r.ctype
+}
+
+// Read implements io.Reader.
+// Read only fails once the stream cannot be resumed, the context is done, or the Reader is closed.
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.body == nil {
+			if err := r.resume(); err != nil {
+				r.err = err
+				return 0, err
+			}
+		}
+		r.timer.Reset(r.cfg.Timeout)
+		n, err := r.body.Read(p)
+		r.timer.Stop()
+		r.offset += int64(n)
+		if n > 0 {
+			r.attempts = 0
+		}
+		if err == nil {
+			return n, nil
+		}
+		r.closeBody()
+		if err == io.EOF && (r.size < 0 || r.offset >= r.size) {
+			r.err = io.EOF
+			return n, io.EOF
+		}
+		// the connection dropped, resume on the next pass or the next Read
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close ends the stream, unblocking any Read.
+func (r *Reader) Close() error {
+	r.cancel()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeBody()
+	if r.err == nil || r.err == io.EOF {
+		r.err = errClosed
+	}
+	return nil
+}
+
+func (r *Reader) closeBody() {
+	if r.body == nil {
+		return
+	}
+	r.timer.Stop()
+	r.stop()
+	r.body.Close()
+	r.body = nil
+}
+
+// resume requests the stream from the offset, backing off between failed attempts.
+func (r *Reader) resume() error {
+	backoff := r.cfg.Backoff
+	for {
+		err := r.request()
+		if err == nil {
+			return nil
+		}
+		if r.ctx.Err() != nil {
+			return errors.Wrapf(r.ctx.Err(), "failed to get %v", r.url)
+		}
+		if se, ok := err.(statusError); ok && se.permanent() {
+			if r.location == r.url {
+				return err
+			}
+			// the redirected location expired, start over from the original url
+			r.location = r.url
+			continue
+		}
+		r.attempts++
+		if r.attempts > r.cfg.Retries {
+			return err
+		}
+		timer := r.cfg.Clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-r.ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(r.ctx.Err(), "failed to get %v", r.url)
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// request requests the stream from the offset once.
+func (r *Reader) request() error {
+	ctx, stop := context.WithCancel(r.ctx)
+	req, err := http.NewRequest(http.MethodGet, r.location, nil)
+	if err != nil {
+		stop()
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range r.cfg.Header {
+		req.Header[k] = v
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+		if r.validator != "" {
+			req.Header.Set("If-Range", r.validator)
+		}
+	}
+
+	// the same timer bounds waiting for the response and then each Read of the body
+	timer := r.cfg.Clock.AfterFunc(r.cfg.Timeout, stop)
+	resp, err := r.cfg.Client.Do(req)
+	timer.Stop()
+	if err != nil {
+		stop()
+		return errors.Wrapf(err, "failed to get %v", r.url)
+	}
+	fail := func(err error) error {
+		resp.Body.Close()
+		stop()
+		return err
+	}
+
+	skip := int64(0)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && r.offset > 0:
+		start, size, ok := contentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != r.offset {
+			return fail(errors.Errorf("failed to resume %v at byte %v", r.url, r.offset))
+		}
+		if size >= 0 {
+			r.size = size
+		}
+	case resp.StatusCode == http.StatusOK:
+		if r.offset > 0 && r.size >= 0 && resp.ContentLength >= 0 && resp.ContentLength != r.size {
+			return fail(errors.Errorf("%v changed while it was streaming", r.url))
+		}
+		// the server ignored the range, or the stream changed since the If-Range validator
+		skip = r.offset
+		if r.offset == 0 {
+			r.size = resp.ContentLength
+			r.ctype = resp.Header.Get("Content-Type")
+			if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				r.validator = etag
+			} else {
+				r.validator = resp.Header.Get("Last-Modified")
+			}
+		}
+	default:
+		return fail(statusError{url: r.url, status: resp.Status, code: resp.StatusCode})
+	}
+	if skip > 0 {
+		timer := r.cfg.Clock.AfterFunc(r.cfg.Timeout, stop)
+		_, err := io.CopyN(ioutil.Discard, resp.Body, skip)
+		timer.Stop()
+		if err != nil {
+			return fail(errors.Wrapf(err, "failed to resume %v at byte %v", r.url, r.offset))
+		}
+	}
+
+	r.location = resp.Request.URL.String()
+	r.body, r.stop = resp.Body, stop
+	r.timer = r.cfg.Clock.AfterFunc(r.cfg.Timeout, stop)
+	r.timer.Stop()
+	return nil
+}
+
+// contentRange parses the start and the size of a Content-Range, the size is -1 if it is unknown.
+func contentRange(s string) (start int64, size int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, false
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	slash := strings.IndexByte(s, '/')
+	dash := strings.IndexByte(s, '-')
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(s[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	size = -1
+	if total := s[slash+1:]; total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, size, true
+}
+
+var _ io.ReadCloser = &Reader{}
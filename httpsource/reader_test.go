@@ -0,0 +1,250 @@
+package httpsource_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/jeffreymkabot/discordvoice/httpsource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const content = "0123456789"
+
+// server serves content, handling each request with the next of its handlers.
+type server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers []http.HandlerFunc
+	// the Range and If-Range headers of each request
+	ranges   []string
+	ifRanges []string
+	requests chan struct{}
+}
+
+func newServer(handlers ...http.HandlerFunc) *server {
+	s := &server{handlers: handlers, requests: make(chan struct{}, len(handlers)+1)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, req.Header.Get("Range"))
+		s.ifRanges = append(s.ifRanges, req.Header.Get("If-Range"))
+		n := len(s.ranges)
+		s.mu.Unlock()
+		select {
+		case s.requests <- struct{}{}:
+		default:
+		}
+		if n > len(s.handlers) {
+			http.Error(w, "unexpected request", http.StatusInternalServerError)
+			return
+		}
+		s.handlers[n-1](w, req)
+	}))
+	return s
+}
+
+func (s *server) headers() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...), append([]string(nil), s.ifRanges...)
+}
+
+// serve serves the content from the start of the request's range, if any.
+func serve(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("ETag", `"v1"`)
+	start := 0
+	if r := req.Header.Get("Range"); r != "" {
+		start, _ = strconv.Atoi(r[len("bytes=") : len(r)-1])
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-9/10")
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	w.Write([]byte(content[start:]))
+}
+
+// drop serves the first n bytes of the content before the connection drops.
+func drop(n int) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write([]byte(content[:n]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func status(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(code)
+	}
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+	s := newServer(drop(4), serve)
+	defer s.Close()
+
+	r, err := httpsource.Open(context.Background(), s.URL)
+	require.NoError(t, err)
+	defer r.Close()
+	size, ok := r.Size()
+	assert.True(t, ok)
+	assert.Equal(t, int64(len(content)), size)
+
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(b))
+	ranges, ifRanges := s.headers()
+	assert.Equal(t, []string{"", "bytes=4-"}, ranges, "expected the stream to resume where it broke off")
+	assert.Equal(t, []string{"", `"v1"`}, ifRanges, "expected the stream to resume only if it did not change")
+}
+
+func TestResumeIgnoredRange(t *testing.T) {
+	t.Parallel()
+	// the server does not support ranges
+	s := newServer(drop(4), func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(content))
+	})
+	defer s.Close()
+
+	r, err := httpsource.Open(context.Background(), s.URL)
+	require.NoError(t, err)
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(b), "expected the bytes already read to be skipped")
+}
+
+func TestResumeChanged(t *testing.T) {
+	t.Parallel()
+	s := newServer(drop(4), func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("a different stream"))
+	})
+	defer s.Close()
+
+	r, err := httpsource.Open(context.Background(), s.URL, httpsource.Retry(0, 0))
+	require.NoError(t, err)
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	assert.Error(t, err, "expected a stream that changed not to resume")
+	assert.Equal(t, content[:4], string(b))
+}
+
+func TestResumeBackoff(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := newServer(drop(4), status(http.StatusServiceUnavailable), serve)
+	defer s.Close()
+
+	r, err := httpsource.Open(context.Background(), s.URL,
+		httpsource.Clock(clk), httpsource.Timeout(time.Hour), httpsource.Retry(1, time.Second))
+	require.NoError(t, err)
+	defer r.Close()
+
+	type read struct {
+		b   []byte
+		err error
+	}
+	done := make(chan read, 1)
+	go func() {
+		b, err := ioutil.ReadAll(r)
+		done <- read{b, err}
+	}()
+	// the stream dropped and the first attempt to resume failed
+	<-s.requests
+	<-s.requests
+	start := clk.Now()
+	for resumed := false; !resumed; {
+		select {
+		case <-s.requests:
+			resumed = true
+		case <-time.After(time.Millisecond):
+			clk.Advance(100 * time.Millisecond)
+		}
+	}
+	assert.True(t, clk.Now().Sub(start) >= time.Second, "expected the stream to back off before it resumes again")
+
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, content, string(res.b))
+}
+
+func TestReadTimeout(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	stalled := make(chan struct{})
+	s := newServer(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write([]byte(content[:4]))
+		w.(http.Flusher).Flush()
+		<-stalled
+	}, serve)
+	defer s.Close()
+	defer close(stalled)
+
+	r, err := httpsource.Open(context.Background(), s.URL, httpsource.Clock(clk), httpsource.Timeout(time.Second))
+	require.NoError(t, err)
+	defer r.Close()
+	b := make([]byte, len(content))
+	n, err := r.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	type read struct {
+		b   []byte
+		err error
+	}
+	done := make(chan read, 1)
+	go func() {
+		b, err := ioutil.ReadAll(r)
+		done <- read{b, err}
+	}()
+	// the read of the stalled connection times out
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, content[4:], string(res.b))
+	ranges, _ := s.headers()
+	assert.Equal(t, []string{"", "bytes=4-"}, ranges)
+}
+
+func TestOpenFails(t *testing.T) {
+	t.Parallel()
+	s := newServer(status(http.StatusNotFound))
+	defer s.Close()
+	_, err := httpsource.Open(context.Background(), s.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+	ranges, _ := s.headers()
+	assert.Len(t, ranges, 1, "expected a permanent error not to be retried")
+
+	// the stream drops and cannot resume
+	s = newServer(drop(4), status(http.StatusNotFound))
+	defer s.Close()
+	r, err := httpsource.Open(context.Background(), s.URL)
+	require.NoError(t, err)
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	assert.Error(t, err)
+	assert.Equal(t, content[:4], string(b))
+
+	// the stream is not resumed once its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	s = newServer(drop(4))
+	defer s.Close()
+	r, err = httpsource.Open(ctx, s.URL)
+	require.NoError(t, err)
+	defer r.Close()
+	cancel()
+	_, err = ioutil.ReadAll(r)
+	assert.Error(t, err)
+	ranges, _ = s.headers()
+	assert.Len(t, ranges, 1)
+}
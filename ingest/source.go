@@ -0,0 +1,278 @@
+// Package ingest relays live feeds, e.g. RTMP or RTSP streams, into a discord voice channel.
+// An ffmpeg process pulls the feed and encodes its audio to opus, and is restarted whenever the feed drops.
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/webm"
+	"github.com/pkg/errors"
+)
+
+type config struct {
+	FFmpeg    string
+	Bitrate   int
+	Transport string
+	Timeout   time.Duration
+	Retries   int
+	Backoff   time.Duration
+	InputArgs []string
+}
+
+// Option functions configure a Source.
+// Pass Options to the Open function.
+type Option func(*config)
+
+// FFmpegPath sets the ffmpeg executable, "ffmpeg" in the PATH by default.
+func FFmpegPath(path string) Option {
+	return func(cfg *config) {
+		if path != "" {
+			cfg.FFmpeg = path
+		}
+	}
+}
+
+// Bitrate sets the bitrate of the opus encoder in kb/s, 64 by default.
+func Bitrate(kbps int) Option {
+	return func(cfg *config) {
+		if kbps > 0 {
+			cfg.Bitrate = kbps
+		}
+	}
+}
+
+// RTSPTransport sets the lower transport of RTSP feeds, "tcp" by default since UDP drops packets behind NATs.
+func RTSPTransport(t string) Option {
+	return func(cfg *config) {
+		if t != "" {
+			cfg.Transport = t
+		}
+	}
+}
+
+// Timeout sets how long the feed may go without audio before it is considered dropped, 10 seconds by default.
+func Timeout(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.Timeout = d
+		}
+	}
+}
+
+// Retry sets how many times in a row to reconnect to the feed, waiting backoff and then twice as long each time.
+// The count starts over whenever the feed plays.
+// Feeds are reconnected 5 times starting after 1 second by default.
+func Retry(n int, backoff time.Duration) Option {
+	return func(cfg *config) {
+		if n >= 0 {
+			cfg.Retries = n
+		}
+		if backoff > 0 {
+			cfg.Backoff = backoff
+		}
+	}
+}
+
+// InputArgs sets ffmpeg arguments that go before the feed's url, e.g. "-analyzeduration" and a shorter duration.
+func InputArgs(args ...string) Option {
+	return func(cfg *config) {
+		cfg.InputArgs = args
+	}
+}
+
+// longest wait between attempts to reconnect
+const maxBackoff = 30 * time.Second
+
+var errClosed = errors.New("ingest source is closed")
+
+// Source provides opus frames of a live feed suitable for a discord voice channel.
+// Source is safe to Close while another goroutine is blocked in ReadFrame.
+type Source struct {
+	url    string
+	cfg    config
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	src      *webm.SourceCloser
+	kill     context.CancelFunc
+	timer    *time.Timer
+	attempts int
+	played   bool
+	err      error
+}
+
+// Open starts pulling the feed at the url and returns once its audio begins, requiring ffmpeg, see FFmpegPath.
+// The feed plays until it ends and cannot be reconnected, ctx is done, or the Source is closed.
+func Open(ctx context.Context, url string, opts ...Option) (*Source, error) {
+	cfg := config{
+		FFmpeg:    "ffmpeg",
+		Bitrate:   64,
+		Transport: "tcp",
+		Timeout:   10 * time.Second,
+		Retries:   5,
+		Backoff:   1 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	s := &Source{url: url, cfg: cfg}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	if err := s.connect(); err != nil {
+		s.cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadFrame implements player.SourceCloser.
+func (s *Source) ReadFrame() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.err != nil {
+			return nil, s.err
+		}
+		if s.src == nil {
+			if err := s.connect(); err != nil {
+				// a feed that played and could not be reconnected has ended
+				if s.played && s.ctx.Err() == nil {
+					err = io.EOF
+				}
+				s.err = err
+				return nil, err
+			}
+		}
+		s.timer.Reset(s.cfg.Timeout)
+		frame, err := s.src.ReadFrame()
+		s.timer.Stop()
+		if err == nil {
+			s.attempts = 0
+			s.played = true
+			return frame, nil
+		}
+		// the feed dropped, reconnect on the next pass
+		s.stop()
+	}
+}
+
+// FrameDuration implements player.SourceCloser.
+func (s *Source) FrameDuration() time.Duration {
+	return 20 * time.Millisecond
+}
+
+// PCM implements player.PCMSource, the source produces opus frames.
+func (s *Source) PCM() bool {
+	return false
+}
+
+// Close implements player.SourceCloser.
+func (s *Source) Close() error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+	if s.err == nil || s.err == io.EOF {
+		s.err = errClosed
+	}
+	return nil
+}
+
+// connect starts ffmpeg, backing off between failed attempts.
+func (s *Source) connect() error {
+	backoff := s.cfg.Backoff
+	for {
+		err := s.start()
+		if err == nil {
+			return nil
+		}
+		if s.ctx.Err() != nil {
+			return errors.Wrapf(s.ctx.Err(), "failed to ingest %v", s.url)
+		}
+		s.attempts++
+		if s.attempts > s.cfg.Retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-s.ctx.Done():
+			return errors.Wrapf(s.ctx.Err(), "failed to ingest %v", s.url)
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// start starts ffmpeg once and waits for the header of its output.
+func (s *Source) start() error {
+	ctx, kill := context.WithCancel(s.ctx)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.cfg.FFmpeg, s.args()...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		kill()
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		kill()
+		return errors.Wrapf(err, "failed to ingest %v", s.url)
+	}
+	// the same timer bounds waiting for the feed to begin and then each frame
+	timer := time.AfterFunc(s.cfg.Timeout, kill)
+	src, err := webm.NewSource(stdout)
+	timer.Stop()
+	if err != nil {
+		kill()
+		cmd.Wait()
+		return errors.Wrapf(err, "failed to ingest %v: %s", s.url, bytes.TrimSpace(stderr.Bytes()))
+	}
+	s.cmd, s.src, s.kill, s.timer = cmd, src, kill, timer
+	return nil
+}
+
+// stop ends ffmpeg, if it is running.
+func (s *Source) stop() {
+	if s.src == nil {
+		return
+	}
+	s.timer.Stop()
+	s.kill()
+	s.src.Close()
+	s.cmd.Wait()
+	s.cmd, s.src = nil, nil
+}
+
+func (s *Source) args() []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-fflags", "nobuffer"}
+	if strings.HasPrefix(s.url, "rtsp://") || strings.HasPrefix(s.url, "rtsps://") {
+		args = append(args, "-rtsp_transport", s.cfg.Transport)
+	}
+	args = append(args, s.cfg.InputArgs...)
+	return append(args,
+		"-i", s.url,
+		"-map", "0:a:0",
+		"-vn",
+		"-c:a", "libopus",
+		"-b:a", strconv.Itoa(s.cfg.Bitrate)+"k",
+		"-ar", "48000",
+		"-ac", "2",
+		"-frame_duration", "20",
+		"-application", "audio",
+		"-f", "webm",
+		"-live", "1",
+		"pipe:1",
+	)
+}
+
+var _ player.SourceCloser = &Source{}
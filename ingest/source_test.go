@@ -0,0 +1,234 @@
+package ingest_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/ingest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnv makes the test binary act as ffmpeg, see TestMain.
+const fakeEnv = "INGEST_FAKE_FFMPEG"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEnv) == "1" {
+		os.Exit(runFakeFFmpeg(os.Args[1:]))
+	}
+	// the tests run the test binary as ffmpeg
+	os.Setenv(fakeEnv, "1")
+	os.Exit(m.Run())
+}
+
+// ebml encodes an element with an 8 byte size.
+func ebml(id []byte, data []byte) []byte {
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	size[0] = 0x01
+	return append(append(append([]byte{}, id...), size...), data...)
+}
+
+// unknownSize starts an element that lasts until the end of the stream, like ffmpeg's live webm output.
+func unknownSize(id []byte) []byte {
+	return append(append([]byte{}, id...), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+}
+
+// runFakeFFmpeg pulls a feed whose url is scheme://behavior/dir, counting its attempts and saving its arguments in dir.
+// Feeds that "play-N" play three opus packets 0xFC, attempt, i on each of their first N attempts and then refuse connections.
+// Feeds that "hang" never begin, and feeds that "stall" stop after their first packet.
+func runFakeFFmpeg(args []string) int {
+	var url string
+	for i, arg := range args {
+		if arg == "-i" && i+1 < len(args) {
+			url = args[i+1]
+		}
+	}
+	rest := url[strings.Index(url, "://")+3:]
+	behavior, dir := rest[:strings.Index(rest, "/")], rest[strings.Index(rest, "/"):]
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "attempts"))
+	attempt, _ := strconv.Atoi(string(b))
+	attempt++
+	ioutil.WriteFile(filepath.Join(dir, "attempts"), []byte(strconv.Itoa(attempt)), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "args"), []byte(strings.Join(args, " ")), 0644)
+
+	w := bufio.NewWriter(os.Stdout)
+	header := func() {
+		w.Write(unknownSize([]byte{0x18, 0x53, 0x80, 0x67}))
+		entry := append(append(ebml([]byte{0xD7}, []byte{1}), ebml([]byte{0x83}, []byte{2})...), ebml([]byte{0x86}, []byte("A_OPUS"))...)
+		w.Write(ebml([]byte{0x16, 0x54, 0xAE, 0x6B}, ebml([]byte{0xAE}, entry)))
+		w.Write(unknownSize([]byte{0x1F, 0x43, 0xB6, 0x75}))
+		w.Write(ebml([]byte{0xE7}, []byte{0}))
+		w.Flush()
+	}
+	block := func(i int) {
+		w.Write(ebml([]byte{0xA3}, []byte{0x81, 0, 0, 0x80, 0xFC, byte(attempt), byte(i)}))
+		w.Flush()
+	}
+	switch {
+	case strings.HasPrefix(behavior, "play-"):
+		n, _ := strconv.Atoi(strings.TrimPrefix(behavior, "play-"))
+		if attempt > n {
+			fmt.Fprintln(os.Stderr, url+": Connection refused")
+			return 1
+		}
+		header()
+		for i := 0; i < 3; i++ {
+			block(i)
+		}
+	case behavior == "hang":
+		header()
+		time.Sleep(time.Minute)
+	case behavior == "stall":
+		header()
+		block(0)
+		time.Sleep(time.Minute)
+	}
+	return 0
+}
+
+// feed is the url of a feed of the fake ffmpeg, and the directory it keeps its attempts and arguments in.
+func feed(t *testing.T, scheme string, behavior string) (url string, dir string) {
+	dir, err := ioutil.TempDir("", "ingest")
+	require.NoError(t, err)
+	return scheme + "://" + behavior + dir, dir
+}
+
+func attempts(t *testing.T, dir string) int {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "attempts"))
+	require.NoError(t, err)
+	n, err := strconv.Atoi(string(b))
+	require.NoError(t, err)
+	return n
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	url, dir := feed(t, "rtmp", "play-2")
+	defer os.RemoveAll(dir)
+	src, err := ingest.Open(context.Background(), url, ingest.FFmpegPath(os.Args[0]), ingest.Retry(1, time.Millisecond))
+	require.NoError(t, err)
+	defer src.Close()
+	assert.False(t, src.PCM())
+	assert.Equal(t, 20*time.Millisecond, src.FrameDuration())
+
+	// the feed is reconnected when it drops, and ends once it cannot be reconnected
+	var frames [][]byte
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		frames = append(frames, frame)
+	}
+	assert.Equal(t, [][]byte{
+		{0xFC, 1, 0}, {0xFC, 1, 1}, {0xFC, 1, 2},
+		{0xFC, 2, 0}, {0xFC, 2, 1}, {0xFC, 2, 2},
+	}, frames)
+	assert.Equal(t, 4, attempts(t, dir), "expected the feed to be retried once after it failed to reconnect")
+	_, err = src.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+
+	require.NoError(t, src.Close())
+	_, err = src.ReadFrame()
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err, "expected a closed source not to end like the feed did")
+}
+
+func TestSourceArgs(t *testing.T) {
+	t.Parallel()
+	url, dir := feed(t, "rtmp", "play-1")
+	defer os.RemoveAll(dir)
+	src, err := ingest.Open(context.Background(), url, ingest.FFmpegPath(os.Args[0]))
+	require.NoError(t, err)
+	src.Close()
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-hide_banner -loglevel error -nostdin -fflags nobuffer -i "+url+
+		" -map 0:a:0 -vn -c:a libopus -b:a 64k -ar 48000 -ac 2 -frame_duration 20 -application audio -f webm -live 1 pipe:1", string(args))
+
+	// RTSP feeds pick a transport, and input arguments go before the feed
+	url, dir = feed(t, "rtsp", "play-1")
+	defer os.RemoveAll(dir)
+	src, err = ingest.Open(context.Background(), url, ingest.FFmpegPath(os.Args[0]),
+		ingest.RTSPTransport("udp"), ingest.Bitrate(96), ingest.InputArgs("-analyzeduration", "0"))
+	require.NoError(t, err)
+	src.Close()
+	args, err = ioutil.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-hide_banner -loglevel error -nostdin -fflags nobuffer -rtsp_transport udp -analyzeduration 0 -i "+url+
+		" -map 0:a:0 -vn -c:a libopus -b:a 96k -ar 48000 -ac 2 -frame_duration 20 -application audio -f webm -live 1 pipe:1", string(args))
+}
+
+func TestOpenFails(t *testing.T) {
+	t.Parallel()
+	url, dir := feed(t, "rtmp", "play-0")
+	defer os.RemoveAll(dir)
+	_, err := ingest.Open(context.Background(), url, ingest.FFmpegPath(os.Args[0]), ingest.Retry(2, time.Millisecond))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Connection refused", "expected ffmpeg's error")
+	assert.Equal(t, 3, attempts(t, dir))
+
+	_, err = ingest.Open(context.Background(), url, ingest.FFmpegPath("not a program"), ingest.Retry(0, time.Millisecond))
+	assert.Error(t, err)
+}
+
+func TestOpenTimeout(t *testing.T) {
+	t.Parallel()
+	url, dir := feed(t, "rtmp", "hang")
+	defer os.RemoveAll(dir)
+	start := time.Now()
+	_, err := ingest.Open(context.Background(), url, ingest.FFmpegPath(os.Args[0]),
+		ingest.Timeout(50*time.Millisecond), ingest.Retry(1, time.Millisecond))
+	assert.Error(t, err)
+	assert.Equal(t, 2, attempts(t, dir))
+	assert.True(t, time.Since(start) < 30*time.Second, "expected a feed that never begins to time out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = ingest.Open(ctx, url, ingest.FFmpegPath(os.Args[0]))
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+}
+
+func TestCloseWhileReading(t *testing.T) {
+	t.Parallel()
+	url, dir := feed(t, "rtmp", "stall")
+	defer os.RemoveAll(dir)
+	src, err := ingest.Open(context.Background(), url, ingest.FFmpegPath(os.Args[0]))
+	require.NoError(t, err)
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xFC, 1, 0}, frame)
+
+	// the source is closed while the stalled feed is being read
+	read := make(chan error, 1)
+	go func() {
+		_, err := src.ReadFrame()
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, src.Close())
+	select {
+	case err := <-read:
+		assert.Error(t, err)
+		assert.NotEqual(t, io.EOF, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected ReadFrame to return once the source closed")
+	}
+	assert.Equal(t, 1, attempts(t, dir), "expected a closed source not to reconnect")
+}
@@ -0,0 +1,145 @@
+package player
+
+import (
+	"math"
+	"math/cmplx"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// number of mono samples transformed to find the spectrum, about 21ms of standard PCM
+const fftSize = 1024
+
+// lowest and highest frequencies in Hz of the bands of Levels
+const (
+	minBandFreq = 40
+	maxBandFreq = 16000
+)
+
+// Levels summarizes the audio written to the device since the previous Levels, e.g. for a VU meter or a visualizer.
+// Every level is relative to full scale, from 0 for silence to 1 for the loudest sample 16-bit PCM can hold.
+type Levels struct {
+	// Peak is the loudest sample.
+	Peak float64
+	// RMS is the root mean square of the samples, closer to how loud they sound than Peak.
+	RMS float64
+	// Bands is the spectrum of the most recent frames split into logarithmically spaced frequency bands, from bass to treble.
+	Bands []float64
+}
+
+// OnLevels sets a function called every interval during the item's playback
+// with the levels of the audio written to the device in that interval, split into bands for the spectrum.
+// Levels can only be measured if the item's source produces interleaved 16-bit little-endian PCM, e.g. sources from the mp3 package,
+// overlays and the ambient source are included.
+func OnLevels(f func(elapsed time.Duration, levels Levels), interval time.Duration, bands int) SongOption {
+	return func(s *songItem) {
+		if f != nil && interval > 0 && bands > 0 {
+			s.onLevels = f
+			s.levelsInterval = interval
+			s.levelBands = bands
+		}
+	}
+}
+
+// analyzer measures the levels of the frames written to the device.
+type analyzer struct {
+	bands int
+	// most recent mono samples, oldest first
+	window []float64
+	peak   float64
+	sumSq  float64
+	n      int
+}
+
+func newAnalyzer(bands int) *analyzer {
+	return &analyzer{bands: bands, window: make([]float64, fftSize)}
+}
+
+// add measures a frame of standard PCM.
+func (a *analyzer) add(frame []byte) {
+	samples := pcm.Decode(make([]int16, 0, len(frame)/2), frame)
+	var mono []float64
+	for i := 0; i+pcm.Channels <= len(samples); i += pcm.Channels {
+		sum := 0.0
+		for _, s := range samples[i : i+pcm.Channels] {
+			v := float64(s) / 32768
+			a.peak = math.Max(a.peak, math.Abs(v))
+			a.sumSq += v * v
+			a.n++
+			sum += v
+		}
+		mono = append(mono, sum/pcm.Channels)
+	}
+	if len(mono) >= len(a.window) {
+		copy(a.window, mono[len(mono)-len(a.window):])
+		return
+	}
+	copy(a.window, a.window[len(mono):])
+	copy(a.window[len(a.window)-len(mono):], mono)
+}
+
+// levels reports the levels since the previous call.
+func (a *analyzer) levels() Levels {
+	l := Levels{Peak: a.peak, Bands: a.spectrum()}
+	if a.n > 0 {
+		l.RMS = math.Sqrt(a.sumSq / float64(a.n))
+	}
+	a.peak, a.sumSq, a.n = 0, 0, 0
+	return l
+}
+
+// spectrum reports the loudest frequency of each band in the window.
+func (a *analyzer) spectrum() []float64 {
+	x := make([]complex128, fftSize)
+	for i, s := range a.window {
+		// hann window
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/(fftSize-1))
+		x[i] = complex(s*w, 0)
+	}
+	fft(x)
+
+	bands := make([]float64, a.bands)
+	ratio := math.Pow(maxBandFreq/minBandFreq, 1/float64(a.bands))
+	binHz := float64(pcm.SampleRate) / fftSize
+	for b := range bands {
+		lo := int(minBandFreq * math.Pow(ratio, float64(b)) / binHz)
+		hi := int(minBandFreq * math.Pow(ratio, float64(b+1)) / binHz)
+		if hi <= lo {
+			hi = lo + 1
+		}
+		for k := lo; k < hi && k < fftSize/2; k++ {
+			// a full scale sine reaches 1, the hann window halves the amplitude
+			bands[b] = math.Max(bands[b], cmplx.Abs(x[k])*4/fftSize)
+		}
+		bands[b] = math.Min(bands[b], 1)
+	}
+	return bands
+}
+
+// fft transforms x in place with the iterative radix-2 Cooley-Tukey algorithm, len(x) must be a power of 2.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], w*x[start+k+size/2]
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
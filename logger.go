@@ -0,0 +1,18 @@
+package player
+
+// Logger receives diagnostic messages from a Player or a device, formatted like fmt.Printf.
+// A Logger must be safe to use in multiple goroutines.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NopLogger discards every message, it is the default Logger.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
@@ -0,0 +1,30 @@
+package player
+
+// Metrics receives measurements from a Player or a device, e.g. to export them as prometheus collectors.
+// A Metrics must be safe to use in multiple goroutines and should return quickly since it is called during playback.
+type Metrics interface {
+	// FrameWritten counts a frame of n bytes written to a device.
+	FrameWritten(n int)
+	// Underrun counts a frame written to a BufferedDevice after the device ran out of queued audio.
+	Underrun()
+	// SendTimeout counts a frame a device gave up sending, e.g. because the voice connection stalled.
+	SendTimeout()
+	// QueueDepth reports the number of queued items whenever the queue changes.
+	QueueDepth(n int)
+	// TrackPlayed counts an item that ended, with the error passed to its OnEnd callback.
+	TrackPlayed(err error)
+	// OpenFailed counts an item whose "source" or "device" failed to open.
+	OpenFailed(what string)
+}
+
+// NopMetrics discards every measurement, it is the default Metrics.
+var NopMetrics Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) FrameWritten(int)  {}
+func (nopMetrics) Underrun()         {}
+func (nopMetrics) SendTimeout()      {}
+func (nopMetrics) QueueDepth(int)    {}
+func (nopMetrics) TrackPlayed(error) {}
+func (nopMetrics) OpenFailed(string) {}
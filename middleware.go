@@ -0,0 +1,39 @@
+package player
+
+import "time"
+
+// PlayRequest describes an item that is about to play.
+type PlayRequest struct {
+	Entry QueueEntry
+	// OpenSrc and OpenDst open the item's source and device,
+	// OpenDst is the player's DefaultDevice if the item was enqueued without one.
+	OpenSrc SourceOpenerFunc
+	OpenDst DeviceOpenerFunc
+
+	song *songItem
+}
+
+// PlayFunc opens the source and device of an item and plays it,
+// returning how long the item played and why it ended like the item's OnEnd callback receives.
+type PlayFunc func(req PlayRequest) (elapsed time.Duration, err error)
+
+// Middleware wraps how the player plays each item, e.g. to log, meter, rate limit, or retry items.
+// A Middleware may change the openers of the request before it calls next,
+// or end the item without calling next by returning an error.
+type Middleware func(next PlayFunc) PlayFunc
+
+// Use installs middleware around how the player plays each item.
+// The first middleware is the outermost, it is called first and returns last.
+func Use(mw ...Middleware) Option {
+	return func(cfg *config) {
+		cfg.Middleware = append(cfg.Middleware, mw...)
+	}
+}
+
+// chain wraps play in the middleware installed with Use.
+func chain(play PlayFunc, mw []Middleware) PlayFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		play = mw[i](play)
+	}
+	return play
+}
@@ -0,0 +1,45 @@
+package player
+
+import (
+	"io"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// mixInto ducks a frame of the playing item for overlays and mixes the ambient source and any overlays into it.
+// mixInto returns a new pooled frame so frames still owned by the source are never modified,
+// and reports false if there was nothing to mix.
+func (p *Player) mixInto(frame []byte, frameDur time.Duration) ([]byte, bool) {
+	duck := p.cfg.OverlayDuck < 1 && p.overlays.ducking()
+	if !p.ambient.active() && !p.overlays.active() && !duck && !p.duck.ducked() {
+		return frame, false
+	}
+	mixed := AllocFrame(len(frame))
+	copy(mixed, frame)
+	if duck || p.duck.ducked() {
+		p.duck.apply(mixed, duck, p.cfg.OverlayDuck, p.cfg.OverlayRamp, frameDur)
+	}
+	p.ambient.mixDucked(mixed)
+	p.overlays.mix(mixed)
+	return mixed, true
+}
+
+// mixPCM adds gain times the interleaved 16-bit little-endian samples in src to those in dst, clipping to the 16-bit range.
+// The samples are decoded into scratch, which is returned so the caller can reuse it for the next frame.
+func mixPCM(dst, src []byte, gain float64, scratch []int16) []int16 {
+	scratch = pcm.Decode(scratch[:0], dst)
+	n := len(scratch)
+	scratch = pcm.Decode(scratch, src)
+	pcm.Mix(scratch[:n], scratch[n:], gain)
+	// re-encode in place, dst is exactly long enough
+	pcm.Encode(dst[:0], scratch[:n])
+	return scratch
+}
+
+// pcmDevice reports whether a device takes PCM frames that the ambient source and overlays can be mixed into.
+// Devices that do not implement DeviceInfo are assumed to take opus, like discord voice connections.
+func pcmDevice(dst io.Writer) bool {
+	caps, ok := Caps(dst)
+	return ok && caps.PCM
+}
@@ -0,0 +1,216 @@
+// Package mixer provides a source that sums any number of PCM sources,
+// e.g. to crossfade between items, layer soundboard clips, or play one mix to several devices.
+// Every source must produce standard PCM, see the pcm package.
+package mixer
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// Option functions configure a Mixer.
+// Pass Options to the New function.
+type Option func(*Mixer)
+
+// KeepOpen makes the Mixer produce silence while it has no inputs instead of ending.
+// The Mixer ends once its last input ends by default.
+func KeepOpen(keep bool) Option {
+	return func(m *Mixer) {
+		m.keepOpen = keep
+	}
+}
+
+// Mixer is a player.Source that sums the frames of its inputs.
+// If the sum would clip, the mix is attenuated just enough that it does not, and recovers over the following frames.
+type Mixer struct {
+	mu       sync.Mutex
+	inputs   []*Input
+	keepOpen bool
+	closed   bool
+	// attenuation applied to the mix to keep it from clipping, recovering toward 1
+	safety float64
+}
+
+// New creates an empty Mixer.
+func New(opts ...Option) *Mixer {
+	m := &Mixer{safety: 1}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Input is a source added to a Mixer.
+type Input struct {
+	m    *Mixer
+	src  player.Source
+	done chan struct{}
+
+	// guarded by the Mixer's mu
+	gain  float64
+	step  float64
+	to    float64
+	buf   []byte
+	ended bool
+}
+
+// Add mixes the source at gain starting with the next frame of the Mixer.
+// The source is closed when it ends or is removed, if it is an io.Closer.
+func (m *Mixer) Add(src player.Source, gain float64) *Input {
+	in := &Input{m: m, src: src, done: make(chan struct{}), gain: gain, to: gain}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		in.close()
+		return in
+	}
+	m.inputs = append(m.inputs, in)
+	return in
+}
+
+// Len reports the number of inputs that have not ended or been removed.
+func (m *Mixer) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.inputs)
+}
+
+// SetGain changes the gain of the input starting with the next frame.
+func (in *Input) SetGain(gain float64) {
+	in.Fade(gain, 0)
+}
+
+// Fade ramps the gain of the input to gain over d, e.g. to crossfade from one input to another.
+// An input faded to 0 keeps playing silently until it is removed.
+func (in *Input) Fade(gain float64, d time.Duration) {
+	in.m.mu.Lock()
+	defer in.m.mu.Unlock()
+	in.to = gain
+	in.step = math.Inf(1)
+	if d > 0 {
+		in.step = math.Abs(gain-in.gain) * float64(pcm.FrameDuration) / float64(d)
+	}
+}
+
+// Remove stops mixing the input and closes its source.
+func (in *Input) Remove() {
+	in.m.mu.Lock()
+	defer in.m.mu.Unlock()
+	in.m.remove(in)
+}
+
+// Done is closed once the input ends or is removed.
+func (in *Input) Done() <-chan struct{} {
+	return in.done
+}
+
+// ReadFrame implements player.SourceCloser.
+func (m *Mixer) ReadFrame() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || (len(m.inputs) == 0 && !m.keepOpen) {
+		return nil, io.EOF
+	}
+
+	sum := make([]float64, pcm.FrameLen)
+	// inputs can be removed while mixing
+	for _, in := range append([]*Input(nil), m.inputs...) {
+		in.fill()
+		in.ramp()
+		n := len(in.buf) / 2
+		if n > pcm.FrameLen {
+			n = pcm.FrameLen
+		}
+		for i, s := range pcm.Decode(make([]int16, 0, n), in.buf[:n*2]) {
+			sum[i] += float64(s) * in.gain
+		}
+		in.buf = in.buf[n*2:]
+		// read ahead so an input is removed with its last frame instead of adding a frame of silence
+		in.fill()
+		if in.ended && len(in.buf) == 0 {
+			m.remove(in)
+		}
+	}
+
+	peak := 0.0
+	for _, s := range sum {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	// recover over about a second
+	m.safety = math.Min(1, m.safety+float64(pcm.FrameDuration)/float64(time.Second))
+	if peak*m.safety > math.MaxInt16 {
+		m.safety = math.MaxInt16 / peak
+	}
+	samples := make([]int16, pcm.FrameLen)
+	for i, s := range sum {
+		samples[i] = pcm.Clip(s * m.safety)
+	}
+	return pcm.Encode(make([]byte, 0, pcm.FrameBytes), samples), nil
+}
+
+// FrameDuration implements player.SourceCloser.
+func (m *Mixer) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+// Close implements player.SourceCloser, removing every input.
+// Sources added after the Mixer is closed are closed right away.
+func (m *Mixer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, in := range m.inputs {
+		in.close()
+	}
+	m.inputs = nil
+	return nil
+}
+
+// remove stops mixing the input while holding mu.
+func (m *Mixer) remove(in *Input) {
+	for i, other := range m.inputs {
+		if other == in {
+			m.inputs = append(m.inputs[:i], m.inputs[i+1:]...)
+			in.close()
+			return
+		}
+	}
+}
+
+// fill reads from the input until a whole frame is buffered or the input ends.
+func (in *Input) fill() {
+	for !in.ended && len(in.buf) < pcm.FrameBytes {
+		frame, err := in.src.ReadFrame()
+		in.buf = append(in.buf, frame...)
+		if ps, ok := in.src.(player.PooledSource); ok && ps.PooledFrames() {
+			player.FreeFrame(frame)
+		}
+		if err != nil {
+			in.ended = true
+		}
+	}
+}
+
+// ramp moves the gain one frame toward the gain the input is fading to.
+func (in *Input) ramp() {
+	if in.gain < in.to {
+		in.gain = math.Min(in.to, in.gain+in.step)
+	} else if in.gain > in.to {
+		in.gain = math.Max(in.to, in.gain-in.step)
+	}
+}
+
+func (in *Input) close() {
+	if c, ok := in.src.(io.Closer); ok {
+		c.Close()
+	}
+	close(in.done)
+}
+
+// do not compile unless Mixer implements player.SourceCloser
+var _ player.SourceCloser = &Mixer{}
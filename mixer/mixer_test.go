@@ -0,0 +1,168 @@
+package mixer_test
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/mixer"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// constSource plays frames of size samples that are all the same value.
+type constSource struct {
+	frames int
+	size   int
+	value  int16
+	closed bool
+}
+
+func newConstSource(frames int, value int16) *constSource {
+	return &constSource{frames: frames, size: pcm.FrameLen, value: value}
+}
+
+func (s *constSource) ReadFrame() ([]byte, error) {
+	if s.frames == 0 {
+		return nil, io.EOF
+	}
+	s.frames--
+	samples := make([]int16, s.size)
+	for i := range samples {
+		samples[i] = s.value
+	}
+	return pcm.Encode(nil, samples), nil
+}
+
+func (s *constSource) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+func (s *constSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+// readSamples reads a frame of the mix.
+func readSamples(t *testing.T, m *mixer.Mixer) []int16 {
+	frame, err := m.ReadFrame()
+	require.NoError(t, err)
+	require.Len(t, frame, pcm.FrameBytes)
+	return pcm.Decode(nil, frame)
+}
+
+// constant is a frame of samples that are all the same value.
+func constant(value int16) []int16 {
+	samples := make([]int16, pcm.FrameLen)
+	for i := range samples {
+		samples[i] = value
+	}
+	return samples
+}
+
+func TestMixerGain(t *testing.T) {
+	t.Parallel()
+	m := mixer.New()
+	defer m.Close()
+	m.Add(newConstSource(3, 1000), 0.5)
+	quiet := m.Add(newConstSource(3, 2000), 0.25)
+	assert.Equal(t, constant(1000), readSamples(t, m))
+
+	quiet.SetGain(1)
+	assert.Equal(t, constant(2500), readSamples(t, m), "expected the new gain to apply to the next frame")
+	quiet.SetGain(0)
+	assert.Equal(t, constant(500), readSamples(t, m))
+}
+
+func TestMixerFade(t *testing.T) {
+	t.Parallel()
+	m := mixer.New()
+	defer m.Close()
+	in := m.Add(newConstSource(5, 1000), 1)
+	in.Fade(0, 4*pcm.FrameDuration)
+	for _, want := range []int16{750, 500, 250, 0, 0} {
+		assert.Equal(t, constant(want), readSamples(t, m))
+	}
+}
+
+func TestMixerClips(t *testing.T) {
+	t.Parallel()
+	m := mixer.New()
+	defer m.Close()
+	m.Add(newConstSource(2, 30000), 1)
+	m.Add(newConstSource(2, 30000), 1)
+	m.Add(newConstSource(4, 1000), 1)
+
+	// the mix is attenuated just enough not to clip instead of wrapping around
+	for i := 0; i < 2; i++ {
+		for _, s := range readSamples(t, m) {
+			require.InDelta(t, math.MaxInt16, s, 1, "expected frame %v to be attenuated to full scale", i)
+		}
+	}
+	// and recovers gradually once the loud inputs end
+	safety := math.MaxInt16/61000.0 + 0.02
+	for _, s := range readSamples(t, m) {
+		require.InDelta(t, 1000*safety, s, 1)
+	}
+	safety += 0.02
+	for _, s := range readSamples(t, m) {
+		require.InDelta(t, 1000*safety, s, 1)
+	}
+}
+
+func TestMixerInputsEnd(t *testing.T) {
+	t.Parallel()
+	m := mixer.New()
+	defer m.Close()
+	short := newConstSource(1, 100)
+	long := newConstSource(3, 200)
+	// half frames, ending halfway through the mixer's second frame
+	halves := newConstSource(3, 400)
+	halves.size = pcm.FrameLen / 2
+	shortIn := m.Add(short, 1)
+	longIn := m.Add(long, 1)
+	m.Add(halves, 1)
+	assert.Equal(t, 3, m.Len())
+
+	// an input is removed with its last frame
+	assert.Equal(t, constant(700), readSamples(t, m))
+	assert.Equal(t, 2, m.Len())
+	assert.True(t, short.closed)
+	select {
+	case <-shortIn.Done():
+	default:
+		assert.Fail(t, "expected the input to be done once its source ended")
+	}
+
+	// a source that ends partway through a frame is padded with silence
+	samples := readSamples(t, m)
+	assert.Equal(t, constant(600)[:pcm.FrameLen/2], samples[:pcm.FrameLen/2])
+	assert.Equal(t, constant(200)[pcm.FrameLen/2:], samples[pcm.FrameLen/2:])
+	assert.Equal(t, 1, m.Len())
+	assert.True(t, halves.closed)
+
+	assert.Equal(t, constant(200), readSamples(t, m))
+	assert.Equal(t, 0, m.Len())
+	assert.True(t, long.closed)
+	<-longIn.Done()
+	_, err := m.ReadFrame()
+	assert.Equal(t, io.EOF, err, "expected the mixer to end with its last input")
+}
+
+func TestMixerKeepOpen(t *testing.T) {
+	t.Parallel()
+	m := mixer.New(mixer.KeepOpen(true))
+	assert.Equal(t, constant(0), readSamples(t, m), "expected silence without any inputs")
+	m.Add(newConstSource(1, 100), 1)
+	assert.Equal(t, constant(100), readSamples(t, m))
+	assert.Equal(t, constant(0), readSamples(t, m))
+
+	require.NoError(t, m.Close())
+	_, err := m.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+	late := newConstSource(1, 100)
+	<-m.Add(late, 1).Done()
+	assert.True(t, late.closed, "expected a source added after the mixer closed to be closed")
+}
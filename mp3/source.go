@@ -7,6 +7,7 @@ import (
 
 	mp3 "github.com/hajimehoshi/go-mp3"
 	"github.com/jeffreymkabot/discordvoice"
+	"github.com/pkg/errors"
 )
 
 // go-mp3 constants
@@ -16,16 +17,26 @@ const (
 	bytesPerFrame = 4608
 )
 
+// ErrNotSeekable is returned by SeekTo if the source was not created from an io.Seeker.
+var ErrNotSeekable = errors.New("mp3 source is not seekable")
+
 // SourceCloser provides a source of decoded PCM frames from an mp3.
 type SourceCloser struct {
-	decoder *mp3.Decoder
+	decoder  *mp3.Decoder
+	seekable bool
+	// set by seeking past the end
+	eof bool
 }
 
 // NewSource produces a source of decoded PCM frames from an mp3.
+// The source can seek if r implements io.Seeker.
 // If the reader implements io.Closer the reader will be closed when the source is closed.
 func NewSource(r io.Reader) (*SourceCloser, error) {
+	_, seekable := r.(io.Seeker)
 	rc, ok := r.(io.ReadCloser)
-	if !ok {
+	if !ok && seekable {
+		rc = nopCloser{r.(io.ReadSeeker)}
+	} else if !ok {
 		rc = ioutil.NopCloser(r)
 	}
 
@@ -34,17 +45,41 @@ func NewSource(r io.Reader) (*SourceCloser, error) {
 		return nil, err
 	}
 
-	return &SourceCloser{decoder: dec}, nil
+	return &SourceCloser{decoder: dec, seekable: seekable}, nil
+}
+
+// nopCloser keeps the io.Seeker of a reader that go-mp3 requires to seek, unlike ioutil.NopCloser.
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// SampleRate reports the sample rate of the decoded PCM, e.g. to resample it for discord with the resample package.
+// The decoded PCM always has 2 channels.
+func (src *SourceCloser) SampleRate() int {
+	return src.decoder.SampleRate()
 }
 
 // ReadFrame implements player.SourceCloser.
+// Frames are allocated from the player's frame pool.
 func (src *SourceCloser) ReadFrame() (frame []byte, err error) {
-	frame = make([]byte, bytesPerFrame)
+	if src.eof {
+		return nil, io.EOF
+	}
+	frame = player.AllocFrame(bytesPerFrame)
 	nr, err := src.decoder.Read(frame)
 	frame = frame[0:nr]
 	return
 }
 
+// PooledFrames implements player.PooledSource.
+func (src *SourceCloser) PooledFrames() bool {
+	return true
+}
+
 // FrameDuration implements player.SourceCloser.
 func (src *SourceCloser) FrameDuration() time.Duration {
 	bytesPerSecond := bytesPerSample * src.decoder.SampleRate()
@@ -52,11 +87,36 @@ func (src *SourceCloser) FrameDuration() time.Duration {
 	return time.Duration(secondsPerFrame * float64(time.Second))
 }
 
+// SeekTo implements player.SeekableSource, moving to the sample that plays at d.
+// SeekTo returns ErrNotSeekable if the source was not created from an io.Seeker.
+func (src *SourceCloser) SeekTo(d time.Duration) (time.Duration, error) {
+	if !src.seekable {
+		return 0, ErrNotSeekable
+	}
+	if d < 0 {
+		d = 0
+	}
+	rate := int64(src.decoder.SampleRate())
+	pos := int64(d) * rate / int64(time.Second) * bytesPerSample
+	// go-mp3 cannot seek to the end itself
+	if length := src.decoder.Length(); length >= 0 && pos >= length {
+		src.eof = true
+		return time.Duration(length/bytesPerSample) * time.Second / time.Duration(rate), nil
+	}
+	if _, err := src.decoder.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	src.eof = false
+	return time.Duration(pos/bytesPerSample) * time.Second / time.Duration(rate), nil
+}
+
 // Close implements player.SourceCloser.
 func (src *SourceCloser) Close() error {
 	// go-mp3 calls close on the underlying reader
 	return src.decoder.Close()
 }
 
-// do not compile unless SourceCloser implements player.SourceCloser
+// do not compile unless SourceCloser implements player.SourceCloser, player.PooledSource, and player.SeekableSource
 var _ player.SourceCloser = &SourceCloser{}
+var _ player.PooledSource = &SourceCloser{}
+var _ player.SeekableSource = &SourceCloser{}
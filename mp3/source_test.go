@@ -0,0 +1,110 @@
+package mp3_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/mp3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixture = "../media/test_file.mp3"
+
+func open(t *testing.T) *mp3.SourceCloser {
+	f, err := os.Open(fixture)
+	require.NoError(t, err)
+	src, err := mp3.NewSource(f)
+	require.NoError(t, err)
+	return src
+}
+
+// decode is the whole decoded PCM of the fixture.
+func decode(t *testing.T) []byte {
+	src := open(t)
+	defer src.Close()
+	var pcm []byte
+	for {
+		frame, err := src.ReadFrame()
+		pcm = append(pcm, frame...)
+		if err == io.EOF {
+			return pcm
+		}
+		require.NoError(t, err)
+	}
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	src := open(t)
+	defer src.Close()
+	rate := src.SampleRate()
+	assert.Equal(t, time.Duration(1152)*time.Second/time.Duration(rate), src.FrameDuration(),
+		"expected frames of 1152 samples of each channel")
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.Len(t, frame, 4608)
+}
+
+func TestSeekTo(t *testing.T) {
+	t.Parallel()
+	pcm := decode(t)
+	src := open(t)
+	defer src.Close()
+	rate := time.Duration(src.SampleRate())
+
+	for _, d := range []time.Duration{time.Second, 250 * time.Millisecond, 0, 3 * time.Second} {
+		pos, err := src.SeekTo(d)
+		require.NoError(t, err)
+		sample := int(d * rate / time.Second)
+		assert.Equal(t, time.Duration(sample)*time.Second/rate, pos, "expected to seek to the sample that plays at %v", d)
+		require.True(t, 4*sample < len(pcm), "expected the fixture to be longer than %v", d)
+
+		// the next frame starts at the sample, like it does when the whole mp3 is decoded
+		frame, err := src.ReadFrame()
+		require.NoError(t, err)
+		end := 4*sample + len(frame)
+		if end > len(pcm) {
+			end = len(pcm)
+		}
+		assert.True(t, bytes.Equal(pcm[4*sample:end], frame), "expected the frame after seeking to %v to be the decoded samples from there", d)
+	}
+
+	// a negative position seeks to the beginning
+	pos, err := src.SeekTo(-time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), pos)
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(pcm[:len(frame)], frame))
+
+	// seeking past the end ends the source at its length, until it seeks back
+	pos, err = src.SeekTo(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(len(pcm)/4)*time.Second/rate, pos)
+	_, err = src.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+	_, err = src.SeekTo(0)
+	require.NoError(t, err)
+	frame, err = src.ReadFrame()
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(pcm[:len(frame)], frame))
+}
+
+func TestSeekToNotSeekable(t *testing.T) {
+	t.Parallel()
+	f, err := os.Open(fixture)
+	require.NoError(t, err)
+	defer f.Close()
+	// hide the file's io.Seeker
+	src, err := mp3.NewSource(struct{ io.Reader }{f})
+	require.NoError(t, err)
+	_, err = src.SeekTo(time.Second)
+	assert.Equal(t, mp3.ErrNotSeekable, err)
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.Len(t, frame, 4608, "expected a failed seek not to disturb the source")
+}
@@ -0,0 +1,171 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var errShortBox = errors.New("truncated mp4 box")
+
+// box is the header of an ISO base media file format box.
+type box struct {
+	typ string
+	// size of the whole box and of its header, size is -1 if the box extends to the end of the file
+	size   int64
+	header int64
+}
+
+func readBox(r io.Reader) (box, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return box{}, err
+	}
+	b := box{typ: string(hdr[4:]), size: int64(binary.BigEndian.Uint32(hdr[:])), header: 8}
+	switch b.size {
+	case 0:
+		b.size = -1
+	case 1:
+		var large [8]byte
+		if _, err := io.ReadFull(r, large[:]); err != nil {
+			return box{}, noEOF(err)
+		}
+		b.size = int64(binary.BigEndian.Uint64(large[:]))
+		b.header = 16
+	}
+	if b.size >= 0 && b.size < b.header {
+		return box{}, errors.Errorf("mp4 box %q of %v bytes is invalid", b.typ, b.size)
+	}
+	return b, nil
+}
+
+// children parses the boxes within the data of a box.
+func children(data []byte, f func(typ string, data []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errShortBox
+		}
+		size := int64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := int64(8)
+		switch size {
+		case 0:
+			size = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errShortBox
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:]))
+			header = 16
+		}
+		if size < header || size > int64(len(data)) {
+			return errShortBox
+		}
+		if err := f(typ, data[header:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// find returns the data of the first box along the path of nested box types.
+func find(data []byte, path ...string) ([]byte, bool) {
+	var found []byte
+	ok := false
+	children(data, func(typ string, child []byte) error {
+		if ok || typ != path[0] {
+			return nil
+		}
+		if len(path) == 1 {
+			found, ok = child, true
+			return nil
+		}
+		found, ok = find(child, path[1:]...)
+		return nil
+	})
+	return found, ok
+}
+
+// fields reads the big-endian integers of a box's data in order, reporting false if the data is too short.
+type fields struct {
+	data []byte
+	ok   bool
+}
+
+func newFields(data []byte) *fields {
+	return &fields{data: data, ok: true}
+}
+
+func (f *fields) skip(n int) {
+	if len(f.data) < n {
+		f.ok = false
+		f.data = nil
+		return
+	}
+	f.data = f.data[n:]
+}
+
+func (f *fields) u8() uint8 {
+	if len(f.data) < 1 {
+		f.ok = false
+		return 0
+	}
+	v := f.data[0]
+	f.data = f.data[1:]
+	return v
+}
+
+func (f *fields) u16() uint16 {
+	if len(f.data) < 2 {
+		f.ok = false
+		return 0
+	}
+	v := binary.BigEndian.Uint16(f.data)
+	f.data = f.data[2:]
+	return v
+}
+
+func (f *fields) u32() uint32 {
+	if len(f.data) < 4 {
+		f.ok = false
+		return 0
+	}
+	v := binary.BigEndian.Uint32(f.data)
+	f.data = f.data[4:]
+	return v
+}
+
+func (f *fields) u64() uint64 {
+	if len(f.data) < 8 {
+		f.ok = false
+		return 0
+	}
+	v := binary.BigEndian.Uint64(f.data)
+	f.data = f.data[8:]
+	return v
+}
+
+// versioned reads the version and flags of a full box.
+func (f *fields) versioned() (version uint8, flags uint32) {
+	v := f.u32()
+	return uint8(v >> 24), v & 0xFFFFFF
+}
+
+// readData reads n bytes of a box, growing the buffer as the bytes arrive
+// so a corrupt box cannot make it allocate more than the file holds.
+func readData(r io.Reader, n int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, n)
+	return buf.Bytes(), noEOF(err)
+}
+
+// noEOF reports an unexpected EOF if the stream ends in the middle of a box.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
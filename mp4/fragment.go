@@ -0,0 +1,103 @@
+package mp4
+
+// tfhd flags
+const (
+	tfhdBaseDataOffset    = 0x000001
+	tfhdSampleDescription = 0x000002
+	tfhdDefaultDuration   = 0x000008
+	tfhdDefaultSize       = 0x000010
+	tfhdDefaultFlags      = 0x000020
+	tfhdDefaultBaseIsMoof = 0x020000
+	trunDataOffset        = 0x000001
+	trunFirstSampleFlags  = 0x000004
+	trunSampleDuration    = 0x000100
+	trunSampleSize        = 0x000200
+	trunSampleFlags       = 0x000400
+	trunCompositionOffset = 0x000800
+)
+
+// parseMoof lists the samples of the track in a movie fragment that starts at offset moofStart of the file.
+// Fragments whose track data does not follow in the next mdat are not supported.
+func parseMoof(moof []byte, moofStart int64, trackID uint32, defaults trackDefaults, decodeTime uint64) ([]sample, uint64, error) {
+	var samples []sample
+	err := children(moof, func(typ string, traf []byte) error {
+		if typ != "traf" {
+			return nil
+		}
+		tfhd, ok := find(traf, "tfhd")
+		if !ok {
+			return errShortBox
+		}
+		f := newFields(tfhd)
+		_, flags := f.versioned()
+		if f.u32() != trackID {
+			return nil
+		}
+		base := moofStart
+		if flags&tfhdBaseDataOffset != 0 {
+			base = int64(f.u64())
+		}
+		if flags&tfhdSampleDescription != 0 {
+			f.u32()
+		}
+		dur, size := defaults.duration, defaults.size
+		if flags&tfhdDefaultDuration != 0 {
+			dur = f.u32()
+		}
+		if flags&tfhdDefaultSize != 0 {
+			size = f.u32()
+		}
+		if !f.ok {
+			return errShortBox
+		}
+
+		if tfdt, ok := find(traf, "tfdt"); ok {
+			f := newFields(tfdt)
+			if version, _ := f.versioned(); version == 1 {
+				decodeTime = f.u64()
+			} else {
+				decodeTime = uint64(f.u32())
+			}
+		}
+
+		// each trun continues where the previous left off unless it has a data offset
+		offset := base
+		return children(traf, func(typ string, trun []byte) error {
+			if typ != "trun" {
+				return nil
+			}
+			f := newFields(trun)
+			_, flags := f.versioned()
+			count := f.u32()
+			if flags&trunDataOffset != 0 {
+				offset = base + int64(int32(f.u32()))
+			}
+			if flags&trunFirstSampleFlags != 0 {
+				f.u32()
+			}
+			for i := uint32(0); i < count && f.ok; i++ {
+				s := sample{offset: offset, size: size, dur: dur, time: decodeTime}
+				if flags&trunSampleDuration != 0 {
+					s.dur = f.u32()
+				}
+				if flags&trunSampleSize != 0 {
+					s.size = f.u32()
+				}
+				if flags&trunSampleFlags != 0 {
+					f.u32()
+				}
+				if flags&trunCompositionOffset != 0 {
+					f.u32()
+				}
+				offset += int64(s.size)
+				decodeTime += uint64(s.dur)
+				samples = append(samples, s)
+			}
+			if !f.ok {
+				return errShortBox
+			}
+			return nil
+		})
+	})
+	return samples, decodeTime, err
+}
@@ -0,0 +1,254 @@
+// Package mp4 provides a source of the audio samples of an MP4 or M4A file, e.g. the audio served by many CDNs,
+// demuxed without decoding them. Opus samples can be sent to discord as they are, AAC samples must be decoded.
+// Fragmented files are read as a stream, other files must be seekable since their samples can be anywhere.
+package mp4
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/pkg/errors"
+)
+
+// samples of each channel in an AAC frame
+const aacFrameSamples = 1024
+
+// ErrNotSeekable is returned by NewSource if a file that is not fragmented cannot seek,
+// and by SeekTo if the file is fragmented.
+var ErrNotSeekable = errors.New("mp4 source is not seekable")
+
+// SourceCloser provides the samples of the first AAC or opus track of an MP4 file.
+type SourceCloser struct {
+	r     io.Reader
+	track *Track
+	// offset in the file that is read next
+	pos int64
+
+	// samples of the whole track if it is not fragmented, or of the current fragment, and the next to read
+	samples []sample
+	next    int
+
+	fragmented bool
+	defaults   trackDefaults
+	decodeTime uint64
+	// data of the mdat of the current fragment, and where it starts in the file
+	mdat      []byte
+	mdatStart int64
+}
+
+// NewSource produces a source of the samples of the first AAC or opus track of an MP4 file, reading its header right away.
+// Files that are not fragmented must be read from an io.ReadSeeker, and can then seek.
+// If the reader implements io.Closer the reader will be closed when the source is closed.
+func NewSource(r io.Reader) (*SourceCloser, error) {
+	src := &SourceCloser{r: r}
+	for src.track == nil {
+		b, err := readBox(r)
+		if err == io.EOF {
+			return nil, ErrNoAudio
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read mp4 header")
+		}
+		src.pos += b.header
+		if b.typ != "moov" {
+			if err := src.skip(b); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		moov, err := src.read(b)
+		if err != nil {
+			return nil, err
+		}
+		track, samples, defaults, err := parseMoov(moov)
+		if err != nil {
+			return nil, err
+		}
+		src.track, src.samples, src.defaults = track, samples, defaults
+	}
+	src.fragmented = len(src.samples) == 0
+	if !src.fragmented {
+		if _, ok := r.(io.Seeker); !ok {
+			return nil, ErrNotSeekable
+		}
+	}
+	return src, nil
+}
+
+// Track describes the track the source reads.
+func (src *SourceCloser) Track() Track {
+	return *src.track
+}
+
+// Duration reports how long the track plays, or false if the file does not say, e.g. if it is fragmented.
+func (src *SourceCloser) Duration() (time.Duration, bool) {
+	return src.track.Duration, src.track.Duration > 0
+}
+
+// ReadFrame implements player.SourceCloser.
+func (src *SourceCloser) ReadFrame() ([]byte, error) {
+	frame, _, err := src.ReadTimestampedFrame()
+	return frame, err
+}
+
+// ReadTimestampedFrame implements player.TimestampedSource, timestamping each sample with its decode time.
+func (src *SourceCloser) ReadTimestampedFrame() ([]byte, time.Duration, error) {
+	if src.fragmented {
+		return src.readFragmented()
+	}
+	if src.next >= len(src.samples) {
+		return nil, 0, io.EOF
+	}
+	s := src.samples[src.next]
+	if _, err := src.r.(io.Seeker).Seek(s.offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	frame, err := readData(src.r, int64(s.size))
+	if err != nil {
+		return nil, 0, err
+	}
+	src.next++
+	return frame, src.timestamp(s.time), nil
+}
+
+func (src *SourceCloser) readFragmented() ([]byte, time.Duration, error) {
+	for src.next >= len(src.samples) {
+		if err := src.nextFragment(); err != nil {
+			return nil, 0, err
+		}
+	}
+	s := src.samples[src.next]
+	src.next++
+	start := s.offset - src.mdatStart
+	if start < 0 || start+int64(s.size) > int64(len(src.mdat)) {
+		return nil, 0, errors.New("mp4 sample is outside its fragment's mdat")
+	}
+	return src.mdat[start : start+int64(s.size)], src.timestamp(s.time), nil
+}
+
+// nextFragment reads up to the mdat of the next fragment of the track.
+func (src *SourceCloser) nextFragment() error {
+	var moofStart int64 = -1
+	for {
+		b, err := readBox(src.r)
+		if err != nil {
+			return err
+		}
+		start := src.pos
+		src.pos += b.header
+		switch b.typ {
+		case "moof":
+			moof, err := src.read(b)
+			if err != nil {
+				return err
+			}
+			moofStart = start
+			src.samples, src.decodeTime, err = parseMoof(moof, moofStart, src.track.ID, src.defaults, src.decodeTime)
+			src.next = 0
+			if err != nil {
+				return err
+			}
+		case "mdat":
+			if moofStart < 0 {
+				if err := src.skip(b); err != nil {
+					return err
+				}
+				continue
+			}
+			src.mdatStart = src.pos
+			src.mdat, err = src.read(b)
+			return err
+		default:
+			if err := src.skip(b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (src *SourceCloser) timestamp(t uint64) time.Duration {
+	return time.Duration(t) * time.Second / time.Duration(src.track.timescale)
+}
+
+// FrameDuration implements player.SourceCloser, the duration of the first sample.
+func (src *SourceCloser) FrameDuration() time.Duration {
+	dur := src.defaults.duration
+	if len(src.samples) > 0 {
+		dur = src.samples[0].dur
+	}
+	if dur > 0 {
+		return time.Duration(dur) * time.Second / time.Duration(src.track.timescale)
+	}
+	if src.track.Codec == AAC && src.track.SampleRate > 0 {
+		return aacFrameSamples * time.Second / time.Duration(src.track.SampleRate)
+	}
+	return 20 * time.Millisecond
+}
+
+// PCM implements player.PCMSource, the source produces AAC or opus samples.
+func (src *SourceCloser) PCM() bool {
+	return false
+}
+
+// SeekTo implements player.SeekableSource, moving to the sample that plays at d.
+// SeekTo returns ErrNotSeekable if the file is fragmented.
+func (src *SourceCloser) SeekTo(d time.Duration) (time.Duration, error) {
+	if src.fragmented {
+		return 0, ErrNotSeekable
+	}
+	t := uint64(d) * uint64(src.track.timescale) / uint64(time.Second)
+	i := 0
+	for i < len(src.samples)-1 && src.samples[i+1].time <= t {
+		i++
+	}
+	src.next = i
+	if len(src.samples) == 0 {
+		return 0, nil
+	}
+	return src.timestamp(src.samples[i].time), nil
+}
+
+// Close implements player.SourceCloser.
+func (src *SourceCloser) Close() error {
+	if c, ok := src.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// read reads the data of the box whose header was just read.
+func (src *SourceCloser) read(b box) ([]byte, error) {
+	if b.size < 0 {
+		data, err := ioutil.ReadAll(src.r)
+		src.pos += int64(len(data))
+		return data, err
+	}
+	data, err := readData(src.r, b.size-b.header)
+	src.pos += int64(len(data))
+	return data, err
+}
+
+// skip skips the data of the box whose header was just read, seeking over it if possible.
+func (src *SourceCloser) skip(b box) error {
+	if b.size < 0 {
+		return io.EOF
+	}
+	n := b.size - b.header
+	if s, ok := src.r.(io.Seeker); ok {
+		if _, err := s.Seek(n, io.SeekCurrent); err != nil {
+			return err
+		}
+		src.pos += n
+		return nil
+	}
+	copied, err := io.CopyN(ioutil.Discard, src.r, n)
+	src.pos += copied
+	return noEOF(err)
+}
+
+// do not compile unless SourceCloser implements player.SourceCloser, player.TimestampedSource, and player.SeekableSource
+var _ player.SourceCloser = &SourceCloser{}
+var _ player.TimestampedSource = &SourceCloser{}
+var _ player.SeekableSource = &SourceCloser{}
@@ -0,0 +1,312 @@
+package mp4_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/mp4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// box encodes a box of the parts.
+func box(typ string, parts ...[]byte) []byte {
+	data := cat(parts...)
+	return cat(u32(uint32(8+len(data))), []byte(typ), data)
+}
+
+// fullBox encodes a full box of the parts.
+func fullBox(typ string, version uint8, flags uint32, parts ...[]byte) []byte {
+	return box(typ, append([][]byte{u32(uint32(version)<<24 | flags)}, parts...)...)
+}
+
+// movie describes the fixture of an opus track at 48kHz.
+type movie struct {
+	handler string
+	// timescale of the track
+	timescale uint32
+	// stsz count and sizes, fixed if only one
+	count uint32
+	sizes []uint32
+	// stsc runs of first chunk and samples per chunk
+	runs [][2]uint32
+	// stco offsets relative to the start of mdat's data
+	chunks []uint32
+	// per sample duration
+	delta uint32
+	// trex defaults for fragments
+	trex bool
+	// replaces the stsd box if not nil
+	stsd []byte
+}
+
+// opusMovie is a movie of three samples, of 3, 2, and 1 bytes, in two chunks.
+var opusMovie = movie{
+	handler:   "soun",
+	timescale: 48000,
+	count:     3,
+	sizes:     []uint32{0, 3, 2, 1},
+	runs:      [][2]uint32{{1, 2}},
+	chunks:    []uint32{0, 3 + 2},
+	delta:     960,
+}
+
+// mdatData holds the samples of opusMovie.
+var mdatData = []byte("aaabbc")
+
+func (m movie) moov(mdatStart uint32) []byte {
+	var stsz [][]byte
+	for _, size := range m.sizes {
+		stsz = append(stsz, u32(size))
+	}
+	stszBox := fullBox("stsz", 0, 0, cat(u32(m.sizes[0]), u32(m.count)), cat(stsz[1:]...))
+	var stsc [][]byte
+	for _, r := range m.runs {
+		stsc = append(stsc, u32(r[0]), u32(r[1]), u32(1))
+	}
+	var stco [][]byte
+	for _, c := range m.chunks {
+		stco = append(stco, u32(mdatStart+c))
+	}
+	stsd := m.stsd
+	if stsd == nil {
+		entry := box("Opus",
+			make([]byte, 6), u16(1), make([]byte, 8),
+			u16(2), u16(16), make([]byte, 4), u32(48000<<16),
+			box("dOps", []byte{0, 2, 0x01, 0x38}),
+		)
+		stsd = fullBox("stsd", 0, 0, u32(1), entry)
+	}
+	stbl := box("stbl",
+		stsd,
+		fullBox("stts", 0, 0, u32(1), u32(m.count), u32(m.delta)),
+		fullBox("stsc", 0, 0, u32(uint32(len(m.runs))), cat(stsc...)),
+		stszBox,
+		fullBox("stco", 0, 0, u32(uint32(len(m.chunks))), cat(stco...)),
+	)
+	mdia := box("mdia",
+		fullBox("mdhd", 0, 0, make([]byte, 8), u32(m.timescale), u32(m.count*m.delta), make([]byte, 4)),
+		fullBox("hdlr", 0, 0, make([]byte, 4), []byte(m.handler), make([]byte, 12), []byte{0}),
+		box("minf", stbl),
+	)
+	trak := box("trak",
+		fullBox("tkhd", 0, 3, make([]byte, 8), u32(1), make([]byte, 4), u32(m.count*m.delta)),
+		mdia,
+	)
+	var mvex []byte
+	if m.trex {
+		mvex = box("mvex", fullBox("trex", 0, 0, u32(1), u32(1), u32(m.delta), u32(2), u32(0)))
+	}
+	return box("moov", trak, mvex)
+}
+
+// file encodes the movie followed by the mdat of its samples.
+func (m movie) file(mdat []byte) []byte {
+	ftyp := box("ftyp", []byte("isom"), u32(0x200), []byte("isomiso2mp41"))
+	// the size of the moov does not depend on where the mdat starts
+	start := uint32(len(ftyp) + len(m.moov(0)) + 8)
+	return cat(ftyp, m.moov(start), box("mdat", mdat))
+}
+
+// fragment encodes a movie fragment of enough samples of 2 bytes to hold the mdat, using the trex defaults, followed by its mdat.
+func fragment(seq uint32, decodeTime uint32, mdat []byte) []byte {
+	traf := func(dataOffset uint32) []byte {
+		return box("traf",
+			fullBox("tfhd", 0, 0x020000, u32(1)),
+			fullBox("tfdt", 0, 0, u32(decodeTime)),
+			fullBox("trun", 0, 0x000001, u32(uint32(len(mdat)+1)/2), u32(dataOffset)),
+		)
+	}
+	moof := func(dataOffset uint32) []byte {
+		return box("moof", fullBox("mfhd", 0, 0, u32(seq)), traf(dataOffset))
+	}
+	// the data offset is relative to the start of the moof, the mdat's data follows its header
+	offset := uint32(len(moof(0)) + 8)
+	return cat(moof(offset), box("mdat", mdat))
+}
+
+// fragmentedFile encodes a movie without samples followed by two fragments.
+func fragmentedFile() []byte {
+	m := opusMovie
+	m.count, m.sizes, m.runs, m.chunks, m.trex = 0, []uint32{0}, nil, nil, true
+	ftyp := box("ftyp", []byte("iso6"), u32(0), []byte("iso6"))
+	return cat(ftyp, m.moov(0), fragment(1, 0, []byte("aabb")), fragment(2, 1920, []byte("cc")))
+}
+
+type frame struct {
+	data string
+	pts  time.Duration
+}
+
+// readAll reads the timestamped frames of a source until it ends or fails.
+func readAll(src *mp4.SourceCloser) ([]frame, error) {
+	var frames []frame
+	for {
+		data, pts, err := src.ReadTimestampedFrame()
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, frame{string(data), pts})
+	}
+}
+
+// reader hides the Seek method of a reader.
+type reader struct {
+	io.Reader
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	src, err := mp4.NewSource(bytes.NewReader(opusMovie.file(mdatData)))
+	require.NoError(t, err)
+	defer src.Close()
+
+	track := src.Track()
+	assert.Equal(t, mp4.Opus, track.Codec)
+	assert.Equal(t, uint32(1), track.ID)
+	assert.Equal(t, 2, track.Channels)
+	assert.Equal(t, 48000, track.SampleRate)
+	assert.Equal(t, []byte{0, 2, 0x01, 0x38}, track.Config)
+	duration, ok := src.Duration()
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Millisecond, duration)
+	assert.Equal(t, 20*time.Millisecond, src.FrameDuration())
+
+	frames, err := readAll(src)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []frame{{"aaa", 0}, {"bb", 20 * time.Millisecond}, {"c", 40 * time.Millisecond}}, frames)
+
+	at, err := src.SeekTo(30 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Millisecond, at, "expected to seek to the sample playing at the offset")
+	frames, err = readAll(src)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []frame{{"bb", 20 * time.Millisecond}, {"c", 40 * time.Millisecond}}, frames)
+
+	_, err = mp4.NewSource(reader{bytes.NewReader(opusMovie.file(mdatData))})
+	assert.Equal(t, mp4.ErrNotSeekable, err, "expected files that are not fragmented to need seeking")
+}
+
+func TestSourceFragmented(t *testing.T) {
+	t.Parallel()
+	// fragmented files stream, they do not need to seek
+	src, err := mp4.NewSource(reader{bytes.NewReader(fragmentedFile())})
+	require.NoError(t, err)
+	_, ok := src.Duration()
+	assert.False(t, ok)
+	assert.Equal(t, 20*time.Millisecond, src.FrameDuration(), "expected the frame duration of the trex defaults")
+
+	frames, err := readAll(src)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []frame{{"aa", 0}, {"bb", 20 * time.Millisecond}, {"cc", 40 * time.Millisecond}}, frames)
+	_, err = src.SeekTo(0)
+	assert.Equal(t, mp4.ErrNotSeekable, err)
+}
+
+func TestSourceMalformed(t *testing.T) {
+	t.Parallel()
+	withMovie := func(f func(m *movie)) []byte {
+		m := opusMovie
+		m.chunks = append([]uint32(nil), m.chunks...)
+		f(&m)
+		return m.file(mdatData)
+	}
+	ftyp := box("ftyp", []byte("isom"))
+	cases := []struct {
+		name string
+		file []byte
+		// whether the header is fine and the samples are not
+		readErr bool
+		err     error
+	}{
+		{name: "empty", file: nil, err: mp4.ErrNoAudio},
+		{name: "no moov", file: ftyp, err: mp4.ErrNoAudio},
+		{name: "truncated box header", file: []byte{0, 0, 0, 8, 'f'}},
+		{name: "box smaller than its header", file: cat(u32(4), []byte("ftyp"))},
+		{name: "truncated large box size", file: cat(u32(1), []byte("ftyp"), u32(0))},
+		{name: "large box smaller than its header", file: cat(u32(1), []byte("ftyp"), u64(8))},
+		{name: "huge box", file: cat(ftyp, u32(1), []byte("moov"), u64(1<<62))},
+		{name: "truncated moov", file: opusMovie.file(mdatData)[:len(ftyp)+50]},
+		{name: "child box overruns moov", file: cat(ftyp, box("moov", u32(100), []byte("trak")))},
+		{name: "short child box", file: cat(ftyp, box("moov", []byte{0, 0, 0}))},
+		{name: "no audio track", file: withMovie(func(m *movie) { m.handler = "vide" }), err: mp4.ErrNoAudio},
+		{name: "no timescale", file: withMovie(func(m *movie) { m.timescale = 0 })},
+		{name: "truncated sample entry", file: withMovie(func(m *movie) {
+			m.stsd = fullBox("stsd", 0, 0, u32(1), box("Opus", make([]byte, 10)))
+		})},
+		{name: "stsz counts more sizes than it has", file: withMovie(func(m *movie) { m.count = 100 })},
+		{name: "too few chunks", file: withMovie(func(m *movie) { m.chunks = m.chunks[:1] })},
+		{name: "no chunks", file: withMovie(func(m *movie) { m.chunks = nil })},
+		{name: "sample past the end of the file", file: withMovie(func(m *movie) { m.chunks[1] = 1000 }), readErr: true},
+		{name: "huge sample", file: withMovie(func(m *movie) { m.sizes = []uint32{0, 3, 2, 0xFFFFFFF0} }), readErr: true},
+		{name: "truncated mdat", file: opusMovie.file(mdatData)[:len(opusMovie.file(mdatData))-1], readErr: true},
+		{name: "sample outside its fragment", file: cat(fragmentedFile(), fragment(3, 2880, []byte("d"))), readErr: true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			src, err := mp4.NewSource(bytes.NewReader(c.file))
+			if !c.readErr {
+				require.Error(t, err)
+				if c.err != nil {
+					assert.Equal(t, c.err, errors.Cause(err))
+				}
+				return
+			}
+			require.NoError(t, err)
+			_, err = readAll(src)
+			assert.NotEqual(t, io.EOF, err, "expected reading the samples to fail")
+		})
+	}
+}
+
+// Files cut off anywhere, e.g. by a download that failed, fail to read instead of panicking.
+func TestSourceTruncated(t *testing.T) {
+	t.Parallel()
+	file := opusMovie.file(mdatData)
+	for n := 0; n < len(file); n++ {
+		src, err := mp4.NewSource(bytes.NewReader(file[:n]))
+		if err != nil {
+			continue
+		}
+		_, err = readAll(src)
+		assert.NotEqual(t, io.EOF, err, "expected the movie cut off after %v bytes to fail", n)
+	}
+
+	// a fragmented file cut off in between fragments ends early
+	file = fragmentedFile()
+	for n := 0; n < len(file); n++ {
+		src, err := mp4.NewSource(reader{bytes.NewReader(file[:n])})
+		if err != nil {
+			continue
+		}
+		readAll(src)
+	}
+}
@@ -0,0 +1,334 @@
+package mp4
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Codec is the codec of an audio track.
+type Codec int
+
+const (
+	// AAC tracks hold AAC access units, which must be decoded before they can be played.
+	AAC Codec = iota
+	// Opus tracks hold opus packets, which can be sent to discord as they are.
+	Opus
+)
+
+func (c Codec) String() string {
+	switch c {
+	case AAC:
+		return "aac"
+	case Opus:
+		return "opus"
+	}
+	return "unknown"
+}
+
+// ErrNoAudio is returned by NewSource if the file has no AAC or opus track.
+var ErrNoAudio = errors.New("no aac or opus track")
+
+// Track describes the audio track of a file.
+type Track struct {
+	ID         uint32
+	Codec      Codec
+	SampleRate int
+	Channels   int
+	// Config is the AudioSpecificConfig of an AAC track, which a decoder needs,
+	// or the body of the dOps box of an opus track.
+	Config []byte
+	// Duration is how long the track plays, 0 if unknown, e.g. for fragmented files.
+	Duration time.Duration
+
+	timescale uint32
+}
+
+// sample is where a sample is in the file and when it plays, in the track's timescale.
+type sample struct {
+	offset int64
+	size   uint32
+	time   uint64
+	dur    uint32
+}
+
+// fragment defaults of the track, from mvex/trex
+type trackDefaults struct {
+	duration uint32
+	size     uint32
+}
+
+// parseMoov finds the first audio track of the movie and its samples, if the file is not fragmented.
+func parseMoov(moov []byte) (*Track, []sample, trackDefaults, error) {
+	var track *Track
+	var samples []sample
+	err := children(moov, func(typ string, trak []byte) error {
+		if typ != "trak" || track != nil {
+			return nil
+		}
+		t, s, err := parseTrak(trak)
+		if err != nil || t == nil {
+			return err
+		}
+		track, samples = t, s
+		return nil
+	})
+	if err != nil {
+		return nil, nil, trackDefaults{}, err
+	}
+	if track == nil {
+		return nil, nil, trackDefaults{}, ErrNoAudio
+	}
+
+	var defaults trackDefaults
+	if mvex, ok := find(moov, "mvex"); ok {
+		children(mvex, func(typ string, trex []byte) error {
+			f := newFields(trex)
+			f.versioned()
+			if typ != "trex" || f.u32() != track.ID {
+				return nil
+			}
+			f.u32() // default sample description index
+			defaults.duration = f.u32()
+			defaults.size = f.u32()
+			return nil
+		})
+	}
+	return track, samples, defaults, nil
+}
+
+// parseTrak describes the track if it is an AAC or opus audio track.
+func parseTrak(trak []byte) (*Track, []sample, error) {
+	hdlr, ok := find(trak, "mdia", "hdlr")
+	if !ok || len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+		return nil, nil, nil
+	}
+	stbl, ok := find(trak, "mdia", "minf", "stbl")
+	if !ok {
+		return nil, nil, nil
+	}
+	stsd, ok := find(stbl, "stsd")
+	if !ok {
+		return nil, nil, nil
+	}
+	track, err := parseStsd(stsd)
+	if err != nil || track == nil {
+		return nil, nil, err
+	}
+
+	if tkhd, ok := find(trak, "tkhd"); ok {
+		f := newFields(tkhd)
+		if version, _ := f.versioned(); version == 1 {
+			f.skip(16)
+		} else {
+			f.skip(8)
+		}
+		track.ID = f.u32()
+	}
+	if mdhd, ok := find(trak, "mdia", "mdhd"); ok {
+		f := newFields(mdhd)
+		var duration uint64
+		if version, _ := f.versioned(); version == 1 {
+			f.skip(16)
+			track.timescale = f.u32()
+			duration = f.u64()
+		} else {
+			f.skip(8)
+			track.timescale = f.u32()
+			duration = uint64(f.u32())
+		}
+		if f.ok && track.timescale > 0 {
+			track.Duration = time.Duration(duration) * time.Second / time.Duration(track.timescale)
+		}
+	}
+	if track.timescale == 0 {
+		return nil, nil, errors.New("audio track has no timescale")
+	}
+
+	samples, err := parseStbl(stbl)
+	return track, samples, err
+}
+
+// parseStsd describes the first sample entry if it is AAC or opus.
+func parseStsd(stsd []byte) (*Track, error) {
+	f := newFields(stsd)
+	f.versioned()
+	if f.u32() == 0 || !f.ok {
+		return nil, nil
+	}
+	var track *Track
+	err := children(f.data, func(typ string, entry []byte) error {
+		if track != nil {
+			return nil
+		}
+		var codec Codec
+		switch typ {
+		case "mp4a":
+			codec = AAC
+		case "Opus":
+			codec = Opus
+		default:
+			return nil
+		}
+		// AudioSampleEntry
+		e := newFields(entry)
+		e.skip(6 + 2 + 8)
+		channels := e.u16()
+		e.skip(2 + 4)
+		rate := e.u32() >> 16
+		if !e.ok {
+			return errShortBox
+		}
+		track = &Track{Codec: codec, Channels: int(channels), SampleRate: int(rate)}
+		switch codec {
+		case AAC:
+			if esds, ok := find(e.data, "esds"); ok && len(esds) > 4 {
+				track.Config = parseEsds(esds[4:])
+			}
+		case Opus:
+			if dops, ok := find(e.data, "dOps"); ok {
+				track.Config = dops
+			}
+		}
+		return nil
+	})
+	return track, err
+}
+
+// parseEsds finds the AudioSpecificConfig in the descriptors of an esds box.
+func parseEsds(data []byte) []byte {
+	for len(data) > 0 {
+		tag := data[0]
+		data = data[1:]
+		size := 0
+		for i := 0; i < 4 && len(data) > 0; i++ {
+			b := data[0]
+			data = data[1:]
+			size = size<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		switch tag {
+		case 0x03:
+			// ES_Descriptor, whose fields precede the nested descriptors
+			f := newFields(data)
+			f.u16()
+			flags := f.u8()
+			if flags&0x80 != 0 {
+				f.u16()
+			}
+			if flags&0x40 != 0 {
+				f.skip(int(f.u8()))
+			}
+			if flags&0x20 != 0 {
+				f.u16()
+			}
+			data = f.data
+		case 0x04:
+			// DecoderConfigDescriptor, whose fields precede the DecoderSpecificInfo
+			if len(data) < 13 {
+				return nil
+			}
+			data = data[13:]
+		case 0x05:
+			if size > len(data) {
+				return nil
+			}
+			return append([]byte(nil), data[:size]...)
+		default:
+			if size > len(data) {
+				return nil
+			}
+			data = data[size:]
+		}
+	}
+	return nil
+}
+
+// parseStbl lists the samples of a track that is not fragmented.
+func parseStbl(stbl []byte) ([]sample, error) {
+	var sizes []uint32
+	if stsz, ok := find(stbl, "stsz"); ok {
+		f := newFields(stsz)
+		f.versioned()
+		fixed, count := f.u32(), f.u32()
+		for i := uint32(0); i < count && f.ok; i++ {
+			size := fixed
+			if fixed == 0 {
+				size = f.u32()
+			}
+			sizes = append(sizes, size)
+		}
+		if !f.ok {
+			return nil, errShortBox
+		}
+	}
+	if len(sizes) == 0 {
+		// fragmented
+		return nil, nil
+	}
+
+	var chunks []int64
+	if stco, ok := find(stbl, "stco"); ok {
+		f := newFields(stco)
+		f.versioned()
+		for n := f.u32(); n > 0 && f.ok; n-- {
+			chunks = append(chunks, int64(f.u32()))
+		}
+	} else if co64, ok := find(stbl, "co64"); ok {
+		f := newFields(co64)
+		f.versioned()
+		for n := f.u32(); n > 0 && f.ok; n-- {
+			chunks = append(chunks, int64(f.u64()))
+		}
+	}
+
+	type run struct{ first, perChunk uint32 }
+	var runs []run
+	if stsc, ok := find(stbl, "stsc"); ok {
+		f := newFields(stsc)
+		f.versioned()
+		for n := f.u32(); n > 0 && f.ok; n-- {
+			runs = append(runs, run{first: f.u32(), perChunk: f.u32()})
+			f.u32()
+		}
+	}
+
+	var durations []uint32
+	if stts, ok := find(stbl, "stts"); ok {
+		f := newFields(stts)
+		f.versioned()
+		for n := f.u32(); n > 0 && f.ok; n-- {
+			count, delta := f.u32(), f.u32()
+			for i := uint32(0); i < count && len(durations) < len(sizes); i++ {
+				durations = append(durations, delta)
+			}
+		}
+	}
+
+	samples := make([]sample, 0, len(sizes))
+	var t uint64
+	for c, offset := range chunks {
+		// the run of the chunk is the last that starts at or before it, chunks are numbered from 1
+		perChunk := uint32(0)
+		for _, r := range runs {
+			if r.first <= uint32(c+1) {
+				perChunk = r.perChunk
+			}
+		}
+		for i := uint32(0); i < perChunk && len(samples) < len(sizes); i++ {
+			s := sample{offset: offset, size: sizes[len(samples)], time: t}
+			if len(samples) < len(durations) {
+				s.dur = durations[len(samples)]
+			}
+			t += uint64(s.dur)
+			offset += int64(s.size)
+			samples = append(samples, s)
+		}
+	}
+	if len(samples) < len(sizes) {
+		return nil, errors.New("mp4 sample table is inconsistent")
+	}
+	return samples, nil
+}
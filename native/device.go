@@ -0,0 +1,35 @@
+// Package native provides a device that plays PCM frames on the system's audio output.
+package native
+
+import (
+	"github.com/hajimehoshi/oto"
+	"github.com/jeffreymkabot/discordvoice"
+)
+
+// Writer plays interleaved 16-bit little-endian PCM frames on the system's audio output.
+type Writer struct {
+	*oto.Player
+}
+
+// Open produces a Writer for PCM with the sample rate and number of channels,
+// e.g. Open(44100, 2, 1<<15) for frames from the mp3 package.
+// bufferSize is the size in bytes of the audio output's buffer.
+func Open(sampleRate int, channels int, bufferSize int) (*Writer, error) {
+	p, err := oto.NewPlayer(sampleRate, channels, 2, bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{Player: p}, nil
+}
+
+// DeviceInfo implements player.DeviceInfo.
+// The audio output blocks until it is ready for the next frame.
+func (w *Writer) DeviceInfo() player.DeviceCaps {
+	return player.DeviceCaps{
+		PCM:      true,
+		Realtime: true,
+	}
+}
+
+// do not compile unless Writer implements player.DeviceInfo
+var _ player.DeviceInfo = &Writer{}
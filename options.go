@@ -1,17 +1,90 @@
 package player
 
-import "time"
+import (
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+)
 
 type config struct {
 	QueueLength int
 	Idle        func()
 	IdleTimeout int
+	SubQueues   []string
+	MergePolicy MergePolicy
+	AmbientDuck float64
+
+	DefaultDevice DeviceOpenerFunc
+	OpenTimeout   time.Duration
+	DeviceTimeout time.Duration
+	Resolvers     int
+	Duplicates    DuplicatePolicy
+
+	OutagePolicy    OutagePolicy
+	OutageThreshold int
+	OutageRetry     time.Duration
+	OnOutage        func(err error)
+
+	TransitionSrc  SourceOpenerFunc
+	TransitionGain float64
+	TransitionLead time.Duration
+
+	OverlayDuck float64
+	OverlayRamp time.Duration
+
+	CallbackTimeout   time.Duration
+	OnCallbackTimeout func(callback string)
+
+	Middleware []Middleware
+
+	Logger       Logger
+	Metrics      Metrics
+	ExpvarPrefix string
+	EventLog     int
+	Clock        clock.Clock
 }
 
 // Option functions configure behaviors of the Player.
 // Pass Options to the New function.
 type Option func(*config)
 
+// Log sets where the player reports what it is doing, e.g. items starting and ending and devices failing to open.
+// Messages are discarded by default.
+func Log(l Logger) Option {
+	return func(cfg *config) {
+		if l != nil {
+			cfg.Logger = l
+		}
+	}
+}
+
+// Measure sets where the player reports measurements of playback, e.g. frames written and items played.
+// Measurements are discarded by default.
+func Measure(m Metrics) Option {
+	return func(cfg *config) {
+		if m != nil {
+			cfg.Metrics = m
+		}
+	}
+}
+
+// Clock sets the clock that times the player's idle timeout, pacing, progress ticker, and other deadlines, clock.Real by default.
+// Tests can pass a clock.Fake to run timeouts deterministically instead of waiting on the wall clock.
+func Clock(c clock.Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.Clock = c
+		}
+	}
+}
+
+// DefaultDevice provides the writer for items enqueued without a DeviceOpenerFunc.
+func DefaultDevice(openDst DeviceOpenerFunc) Option {
+	return func(cfg *config) {
+		cfg.DefaultDevice = openDst
+	}
+}
+
 // QueueLength is the maximum number of items that will be allowed in the Player queue.
 // Values less than 1 allow an unbounded queue.
 func QueueLength(n int) Option {
@@ -30,6 +103,167 @@ func IdleFunc(idle func(), d int) Option {
 	}
 }
 
+// DuplicatePolicy decides what Enqueue does with an item whose DedupeKey matches an item that is queued or playing.
+type DuplicatePolicy int
+
+const (
+	// DuplicatesAllow queues duplicate items like any other item.
+	DuplicatesAllow DuplicatePolicy = iota
+	// DuplicatesReject fails to enqueue duplicate items with ErrDuplicate.
+	DuplicatesReject
+	// DuplicatesCoalesce accepts duplicate items without queueing them, their OnEnd callback receives ErrDuplicate.
+	// Enqueue returns the Track of the item they duplicate.
+	DuplicatesCoalesce
+)
+
+func (d DuplicatePolicy) String() string {
+	switch d {
+	case DuplicatesAllow:
+		return "allow"
+	case DuplicatesReject:
+		return "reject"
+	case DuplicatesCoalesce:
+		return "coalesce"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the policy by its name, e.g. in a Snapshot.
+func (d DuplicatePolicy) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Duplicates sets how Enqueue handles items enqueued with the DedupeKey option, DuplicatesAllow by default.
+func Duplicates(policy DuplicatePolicy) Option {
+	return func(cfg *config) {
+		cfg.Duplicates = policy
+	}
+}
+
+// OutagePolicy decides what the player does when items repeatedly fail to open their device, e.g. during a discord outage.
+type OutagePolicy int
+
+const (
+	// OutageIgnore lets every item fail on its own.
+	OutageIgnore OutagePolicy = iota
+	// OutagePark puts the item that failed back at the front of the queue and parks the player, preserving the queue.
+	OutagePark
+	// OutageDrop ends every queued item with ErrOutage.
+	OutageDrop
+)
+
+func (o OutagePolicy) String() string {
+	switch o {
+	case OutageIgnore:
+		return "ignore"
+	case OutagePark:
+		return "park"
+	case OutageDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the policy by its name, e.g. in a Snapshot.
+func (o OutagePolicy) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// Outage sets what the player does once threshold items in a row failed to open their device, OutageIgnore by default.
+// A player parked by OutagePark unparks itself after retry, or waits for Player.Unpark if retry is 0.
+// notify, if not nil, is called with the error of the item that failed last.
+func Outage(policy OutagePolicy, threshold int, retry time.Duration, notify func(err error)) Option {
+	return func(cfg *config) {
+		cfg.OutagePolicy = policy
+		cfg.OutageThreshold = threshold
+		cfg.OutageRetry = retry
+		if notify != nil {
+			cfg.OnOutage = notify
+		}
+	}
+}
+
+// CallbackTimeout bounds how long the player waits for each callback of an item, e.g. OnEnd, to return.
+// A callback still running after d is abandoned to finish in the background while playback continues,
+// so a callback that hangs can never block the queue or Player.Close.
+// notify, if not nil, is called with the name of the abandoned callback, e.g. "OnEnd".
+// By default the player waits for every callback to return.
+func CallbackTimeout(d time.Duration, notify func(callback string)) Option {
+	return func(cfg *config) {
+		cfg.CallbackTimeout = d
+		if notify != nil {
+			cfg.OnCallbackTimeout = notify
+		}
+	}
+}
+
+// Resolvers is the number of background workers that run the ResolverFuncs of queued items, 1 by default.
+// Values less than 1 leave items to be resolved right before they play.
+func Resolvers(n int) Option {
+	return func(cfg *config) {
+		cfg.Resolvers = n
+	}
+}
+
+// AmbientDuck is the ratio of the ambient gain applied to the source set by Player.SetAmbient while items are playing.
+// Values less than 0 or greater than 1 are ignored.
+func AmbientDuck(ratio float64) Option {
+	return func(cfg *config) {
+		if ratio >= 0 && ratio <= 1 {
+			cfg.AmbientDuck = ratio
+		}
+	}
+}
+
+// Transition sets a clip, e.g. a stinger or swoosh, that is mixed over the change from one item to the next.
+// If the item's Duration is known the clip starts lead before the item ends,
+// otherwise the clip starts with the next item.
+// Like the ambient source, the clip must produce PCM in the same format as the queued items,
+// so the clip is skipped if it is not PCM or the item it would be mixed into is not, see PCMSource and DeviceInfo.
+func Transition(openSrc SourceOpenerFunc, gain float64, lead time.Duration) Option {
+	return func(cfg *config) {
+		cfg.TransitionSrc = openSrc
+		cfg.TransitionGain = gain
+		cfg.TransitionLead = lead
+	}
+}
+
+// MergePolicy decides how items from different sub-queues are ordered in the Player queue.
+type MergePolicy int
+
+const (
+	// MergeStrict plays every item of a sub-queue before any item of a lower priority sub-queue.
+	MergeStrict MergePolicy = iota
+	// MergeInterleave takes turns playing one item from each sub-queue in order of priority.
+	MergeInterleave
+)
+
+func (m MergePolicy) String() string {
+	switch m {
+	case MergeStrict:
+		return "strict"
+	case MergeInterleave:
+		return "interleave"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the policy by its name, e.g. in a Snapshot.
+func (m MergePolicy) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// SubQueues divides the Player queue into named sub-queues listed from highest to lowest priority,
+// e.g. SubQueues(MergeStrict, "priority", "music") so that announcements always play before music.
+// Items enqueued without the SubQueue option are placed in an unnamed sub-queue with the lowest priority.
+// Playlist reports the items of all sub-queues merged in the order they will play.
+func SubQueues(policy MergePolicy, names ...string) Option {
+	return func(cfg *config) {
+		cfg.SubQueues = names
+		cfg.MergePolicy = policy
+	}
+}
+
 // SongOption functions configure the playback of individual items.
 // Pass SongOptions to the Player.Enqueue function.
 type SongOption func(*songItem)
@@ -41,6 +275,89 @@ func Duration(d time.Duration) SongOption {
 	}
 }
 
+// Resolve sets a function that looks up the item's metadata before it plays.
+// Queued items are resolved in the background in the order they will play,
+// and an item that reaches the front of the queue first is resolved right before it plays.
+func Resolve(f ResolverFunc) SongOption {
+	return func(s *songItem) {
+		if f != nil {
+			s.resolver = f
+			s.resolution.state = ResolvePending
+			s.resolution.done = make(chan struct{})
+		}
+	}
+}
+
+// SubQueue places the item in one of the sub-queues passed to the SubQueues option.
+// Enqueue fails with ErrUnknownQueue if there is no sub-queue with that name.
+func SubQueue(name string) SongOption {
+	return func(s *songItem) {
+		s.subQueue = name
+	}
+}
+
+// Priority places the item before every item of its sub-queue with a lower priority, 0 by default.
+// Items of the same priority play in the order they were enqueued,
+// so e.g. announcements enqueued with a priority above the music play next without clearing the music.
+// With MergeInterleave the item takes the earliest turn of its sub-queue that no item of the same or higher priority holds.
+func Priority(n int) SongOption {
+	return func(s *songItem) {
+		s.priority = n
+	}
+}
+
+// Realtime decides whether the item is written to its device at playback speed.
+// By default the player paces devices whose PacedDevice RequiresPacing, or failing that whose DeviceInfo is not Realtime,
+// and other devices are expected to block until they are ready for the next frame.
+// Realtime(true) paces writes to any device.
+// Realtime(false) writes to the device as fast as the source and the device allow,
+// e.g. to render the item to a file device.
+func Realtime(rt bool) SongOption {
+	return func(s *songItem) {
+		if rt {
+			s.realtime = realtimeOn
+		} else {
+			s.realtime = realtimeOff
+		}
+	}
+}
+
+// ExpiresAt sets a time after which the item is no longer worth playing, e.g. live event audio that would be stale.
+// An item that would start playing after t is passed over and its OnEnd callback receives ErrExpired.
+func ExpiresAt(t time.Time) SongOption {
+	return func(s *songItem) {
+		s.expiresAt = t
+	}
+}
+
+// DedupeKey identifies the item so that the player's Duplicates policy can recognize when it is enqueued more than once,
+// e.g. by the URL of its media.
+func DedupeKey(key string) SongOption {
+	return func(s *songItem) {
+		s.dedupeKey = key
+	}
+}
+
+// Reopen sets a function used by Player.JumpTo to reopen the item at an offset,
+// e.g. by restarting an ffmpeg encoder with a -ss offset, if its source does not implement SeekableSource.
+func Reopen(f SeekOpenerFunc) SongOption {
+	return func(s *songItem) {
+		s.reopen = f
+	}
+}
+
+// Transform wraps the item's source when it is opened, e.g. with filters from the dsp package.
+// Transforms wrap the source in the order they are passed to Enqueue, so the last one reads from all the others.
+// A transform that does not implement io.Closer or SeekableSource leaves closing or seeking to the source it wraps,
+// e.g. Player.JumpTo seeks the wrapped source directly, without the transform forgetting any state it keeps between frames.
+func Transform(f func(Source) Source) SongOption {
+	return func(s *songItem) {
+		if f != nil {
+			s.transforms = append(s.transforms, f)
+		}
+	}
+}
+
 // OnStart sets a function that is called when the item's playback begins.
 func OnStart(f func()) SongOption {
 	return func(s *songItem) {
@@ -62,6 +379,27 @@ func OnEnd(f func(elapsed time.Duration, err error)) SongOption {
 	}
 }
 
+// OnStartID is like OnStart, but the callback also receives the item's ID,
+// since the item may start playing before Enqueue returns its Track.
+func OnStartID(f func(id uint64)) SongOption {
+	return func(s *songItem) {
+		if f != nil {
+			s.onStart = func() { f(s.track.ID) }
+		}
+	}
+}
+
+// OnEndID is like OnEnd, but the callback also receives the item's ID,
+// e.g. to forget the item by ID even if it ended before Enqueue returned or was removed while it was queued.
+// The ID is 0 for an item that ends with ErrDuplicate without being queued.
+func OnEndID(f func(id uint64, elapsed time.Duration, err error)) SongOption {
+	return func(s *songItem) {
+		if f != nil {
+			s.onEnd = func(elapsed time.Duration, err error) { f(s.track.ID, elapsed, err) }
+		}
+	}
+}
+
 // OnProgress sets a function called periodically during the item's playback.
 // The callback receives how long the item has played and a slice of frame-to-frame latencies.
 func OnProgress(f func(elapsed time.Duration, frameTime []time.Duration), interval time.Duration) SongOption {
@@ -73,6 +411,44 @@ func OnProgress(f func(elapsed time.Duration, frameTime []time.Duration), interv
 	}
 }
 
+// OnDurationCorrected sets a function called when the item plays to its end
+// and the time it played differs from the duration passed to the Duration option by more than threshold.
+// The callback receives the declared and the actual duration,
+// e.g. to correct cached metadata so that later plays of the same media estimate their remaining time better.
+func OnDurationCorrected(f func(declared time.Duration, actual time.Duration), threshold time.Duration) SongOption {
+	return func(s *songItem) {
+		if f != nil {
+			s.onDurationCorrected = f
+			s.correctionThreshold = threshold
+		}
+	}
+}
+
+// Quantize decides how an OnProgress interval is fit to the frame duration of the item's source.
+type Quantize int
+
+const (
+	// QuantizeDown reports progress every whole number of frames that fits in the interval, at least every frame.
+	QuantizeDown Quantize = iota
+	// QuantizeUp reports progress every whole number of frames that covers the interval.
+	QuantizeUp
+	// QuantizeExact reports progress on a ticker every interval no matter the frame duration,
+	// the elapsed time is as of the latest frame that was written.
+	QuantizeExact
+)
+
+// ProgressQuantize sets how the OnProgress interval is fit to the item's frame duration, QuantizeDown by default.
+// The frame duration is not known until the item's source opens,
+// so report, if not nil, is called right before OnStart with the interval that is in effect.
+func ProgressQuantize(q Quantize, report func(effective time.Duration)) SongOption {
+	return func(s *songItem) {
+		s.progressQuantize = q
+		if report != nil {
+			s.onProgressInterval = report
+		}
+	}
+}
+
 // OnPause sets a function called when the item's playback pauses.
 // The callback receives how long the item has played
 func OnPause(f func(elapsed time.Duration)) SongOption {
@@ -0,0 +1,102 @@
+package player
+
+import "time"
+
+// Park holds items in the queue instead of playing them, e.g. while the voice service of a guild is down.
+// The current item keeps playing and items can still be enqueued, nothing else plays until Unpark is called.
+func (p *Player) Park() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	p.park(0)
+	return nil
+}
+
+// Unpark resumes playing items from the queue after Park or after the player parked itself because of its Outage policy.
+func (p *Player) Unpark() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	if !p.parked {
+		return nil
+	}
+	p.parked = false
+	p.stopUnparkTimer()
+	if len(p.queue) > 0 {
+		ok, err := p.offer(p.queue[0])
+		if ok {
+			p.queue = p.queue[1:]
+			p.queueChanged()
+		}
+		return err
+	}
+	return nil
+}
+
+// Parked reports whether the player is holding items in the queue.
+func (p *Player) Parked() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.parked
+}
+
+// park holds the queue, letting it go after retry unless retry is 0.
+// The caller must hold mu.
+func (p *Player) park(retry time.Duration) {
+	p.parked = true
+	p.stopUnparkTimer()
+	if retry > 0 {
+		p.unparkTimer = p.cfg.Clock.AfterFunc(retry, func() {
+			p.Unpark()
+		})
+	}
+}
+
+func (p *Player) stopUnparkTimer() {
+	if p.unparkTimer != nil {
+		p.unparkTimer.Stop()
+		p.unparkTimer = nil
+	}
+}
+
+// outage applies the Outage policy once enough items in a row failed to open their device.
+// outage reports true if the item that failed last was put back in the queue instead of ending.
+func (p *Player) outage(song *songItem, err error) bool {
+	if p.cfg.OutagePolicy == OutageIgnore || p.deviceFailures == 0 || p.deviceFailures < p.cfg.OutageThreshold {
+		return false
+	}
+	p.cfg.Logger.Errorf("%v items in a row failed to open their device: %v", p.deviceFailures, err)
+	p.deviceFailures = 0
+	p.event(EventOutage, err)
+	requeued := p.applyOutage(song)
+	p.cfg.OnOutage(err)
+	return requeued
+}
+
+func (p *Player) applyOutage(song *songItem) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.quit:
+		return false
+	default:
+	}
+	switch p.cfg.OutagePolicy {
+	case OutagePark:
+		p.queue = append([]*songItem{song}, p.queue...)
+		p.queueChanged()
+		p.park(p.cfg.OutageRetry)
+		return true
+	case OutageDrop:
+		p.clear(ErrOutage)
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package player
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Overlay plays a short clip over the playing item without interrupting it, e.g. a soundboard sound.
+// The clip is opened right away and mixed at gain into the next frame of the item,
+// so it is heard as soon as the device plays that frame. Several overlays can play at once.
+// Overlays pause with the item, carry over into the next item if the item ends first and the next item is PCM,
+// and are dropped when the player goes idle or closes.
+// Like SetAmbient, mixing requires that the clip and the item produce interleaved 16-bit little-endian PCM
+// with the same sample rate and channels.
+// Overlay returns ErrNotPlaying if nothing is playing, enqueue the clip instead,
+// and ErrNotPCM if the playing item's source or device is not PCM, see PCMSource and DeviceInfo.
+func (p *Player) Overlay(openSrc SourceOpenerFunc, gain float64) error {
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	if openSrc == nil {
+		return ErrNilOpener
+	}
+	p.ctrlMu.Lock()
+	playing, mixable := p.playing, p.mixable
+	p.ctrlMu.Unlock()
+	if !playing {
+		return ErrNotPlaying
+	}
+	if !mixable {
+		return ErrNotPCM
+	}
+	src, err := openSrc()
+	if err != nil {
+		return errors.Wrap(err, "failed to open overlay")
+	}
+	p.overlays.add(src, gain, true)
+	return nil
+}
+
+// startTransition mixes the transition clip into the playing items.
+// A clip that is not PCM is skipped, see PCMSource.
+func (p *Player) startTransition() {
+	p.transitioned = true
+	if p.cfg.TransitionSrc == nil {
+		return
+	}
+	src, err := p.cfg.TransitionSrc()
+	if err != nil {
+		return
+	}
+	if !producesPCM(src) {
+		p.cfg.Logger.Debugf("skipping transition clip that is not PCM")
+		if c, ok := src.(io.Closer); ok {
+			c.Close()
+		}
+		return
+	}
+	p.overlays.add(src, p.cfg.TransitionGain, false)
+}
+
+// transitionDue reports whether the transition clip should start overlapping the end of the playing item.
+func (p *Player) transitionDue(elapsed time.Duration, cb callbacks) bool {
+	if p.transitioned || p.cfg.TransitionSrc == nil || cb.duration <= 0 || cb.duration-elapsed > p.cfg.TransitionLead {
+		return false
+	}
+	// only transition if there is something to transition to
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.queue) > 0
+}
+
+// overlays are one-shot clips mixed into the frames of the playing item.
+type overlays struct {
+	mu    sync.Mutex
+	clips []*clip
+	// decoded samples reused by every mix
+	samples []int16
+}
+
+type clip struct {
+	src  Source
+	gain float64
+	// samples read from src that have not been mixed yet
+	buf   []byte
+	ended bool
+	// whether the clip ducks the playing item
+	duck bool
+}
+
+func (o *overlays) add(src Source, gain float64, duck bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clips = append(o.clips, &clip{src: src, gain: gain, duck: duck})
+}
+
+// ducking reports whether any clip ducks the playing item.
+func (o *overlays) ducking() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, c := range o.clips {
+		if c.duck {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *overlays) active() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.clips) > 0
+}
+
+// mix adds the next samples of every clip into the frame and removes the clips that have ended.
+func (o *overlays) mix(frame []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	clips := o.clips[:0]
+	for _, c := range o.clips {
+		c.fill(len(frame))
+		n := len(frame)
+		if n > len(c.buf) {
+			n = len(c.buf)
+		}
+		o.samples = mixPCM(frame[:n], c.buf[:n], c.gain, o.samples)
+		c.buf = c.buf[n:]
+		// read ahead so a clip is removed with its last frame instead of ducking one frame too many
+		c.fill(1)
+		if c.ended && len(c.buf) == 0 {
+			c.close()
+			continue
+		}
+		clips = append(clips, c)
+	}
+	for i := len(clips); i < len(o.clips); i++ {
+		o.clips[i] = nil
+	}
+	o.clips = clips
+}
+
+func (o *overlays) clear() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, c := range o.clips {
+		c.close()
+	}
+	o.clips = nil
+}
+
+// fill reads from the clip until at least n bytes are buffered or the clip ends.
+func (c *clip) fill(n int) {
+	for !c.ended && len(c.buf) < n {
+		frame, err := c.src.ReadFrame()
+		c.buf = append(c.buf, frame...)
+		if isPooled(c.src) {
+			FreeFrame(frame)
+		}
+		if err != nil {
+			c.ended = true
+		}
+	}
+}
+
+func (c *clip) close() {
+	if rc, ok := c.src.(io.Closer); ok {
+		rc.Close()
+	}
+}
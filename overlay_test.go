@@ -0,0 +1,243 @@
+package player_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransition(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.Transition(func() (player.Source, error) {
+		return &pcmSource{sample: 100, n: 3}, nil
+	}, 1, 2*time.Millisecond))
+	defer p.Close()
+
+	dst := &sampleRecorder{}
+	openDst := func() (io.Writer, error) {
+		return dst, nil
+	}
+	queued := make(chan struct{})
+	end := make(chan struct{})
+
+	_, err := p.Enqueue("first",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 2000, n: 5}, nil
+		},
+		openDst,
+		player.Duration(5*time.Millisecond),
+		player.OnStart(func() { <-queued }),
+	)
+	require.NoError(t, err)
+	_, err = p.Enqueue("second",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 3000, n: 5}, nil
+		},
+		openDst,
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	close(queued)
+	<-end
+
+	expected := []int16{2000, 2000, 2000, 2100, 2100, 3100, 3000, 3000, 3000, 3000}
+	assert.Equal(t, expected, dst.Samples(), "expected transition to overlap the end of the first item and the start of the second")
+}
+
+func TestTransitionNotPCM(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.Transition(func() (player.Source, error) {
+		return &pcmSource{sample: 100, n: 3}, nil
+	}, 1, 2*time.Millisecond))
+	defer p.Close()
+
+	opusDst, pcmDst := &opusRecorder{}, &sampleRecorder{}
+	queued := make(chan struct{})
+	end := make(chan struct{})
+	_, err := p.Enqueue("first",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 2000, n: 5}, nil
+		},
+		func() (io.Writer, error) {
+			return opusDst, nil
+		},
+		player.Duration(5*time.Millisecond),
+		player.OnStart(func() { <-queued }),
+	)
+	require.NoError(t, err)
+	_, err = p.Enqueue("second",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 3000, n: 5}, nil
+		},
+		func() (io.Writer, error) {
+			return pcmDst, nil
+		},
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	close(queued)
+	<-end
+
+	assert.Equal(t, []int16{2000, 2000, 2000, 2000, 2000}, opusDst.Samples(), "expected no transition mixed into frames for an opus device")
+	assert.Equal(t, []int16{3100, 3100, 3100, 3000, 3000}, pcmDst.Samples(), "expected the transition to play over the start of the next PCM item instead")
+}
+
+func TestCloseDuringTransition(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.Transition(func() (player.Source, error) {
+		return &pcmSource{sample: 100, n: 3}, nil
+	}, 1, time.Hour))
+
+	started := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 2000, n: 1000}, nil
+		},
+		func() (io.Writer, error) {
+			return &sampleRecorder{}, nil
+		},
+		player.Duration(time.Second),
+		player.Realtime(true),
+		player.OnStart(func() { close(started) }),
+	)
+	require.NoError(t, err)
+	_, err = p.Enqueue("", nil, nil)
+	require.NoError(t, err)
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(1 * time.Second):
+		assert.FailNow(t, "close did not return while transition was due")
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	openClip := func() (player.Source, error) {
+		return &pcmSource{sample: 100, n: 2}, nil
+	}
+	assert.Equal(t, player.ErrNotPlaying, p.Overlay(openClip, 1), "expected overlay to need a playing item")
+
+	dst := &sampleRecorder{}
+	started := make(chan struct{})
+	overlaid := make(chan struct{})
+	end := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 2000, n: 5}, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.OnStart(func() {
+			close(started)
+			<-overlaid
+		}),
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	<-started
+	require.NoError(t, p.Overlay(openClip, 1))
+	close(overlaid)
+	<-end
+
+	expected := []int16{2100, 2100, 2000, 2000, 2000}
+	assert.Equal(t, expected, dst.Samples(), "expected overlay to be mixed into the playing item")
+}
+
+func TestOverlayDuck(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.OverlayDuck(0.5, 0))
+	defer p.Close()
+
+	dst := &sampleRecorder{}
+	started := make(chan struct{})
+	overlaid := make(chan struct{})
+	end := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return &pcmSource{sample: 2000, n: 5}, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.OnStart(func() {
+			close(started)
+			<-overlaid
+		}),
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	<-started
+	require.NoError(t, p.Overlay(func() (player.Source, error) {
+		return &pcmSource{sample: 100, n: 2}, nil
+	}, 1))
+	close(overlaid)
+	<-end
+
+	expected := []int16{1100, 1100, 2000, 2000, 2000}
+	assert.Equal(t, expected, dst.Samples(), "expected item to be ducked while the overlay plays")
+}
+
+// opusSource produces n frames of opus, which cannot be mixed.
+type opusSource struct {
+	pcmSource
+}
+
+func (s *opusSource) PCM() bool {
+	return false
+}
+
+func TestOverlayNotPCM(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	overlay := func(src player.Source, dst io.Writer) error {
+		started := make(chan struct{})
+		overlaid := make(chan error)
+		end := make(chan struct{})
+		_, err := p.Enqueue("",
+			func() (player.Source, error) {
+				return src, nil
+			},
+			func() (io.Writer, error) {
+				return dst, nil
+			},
+			player.OnStart(func() {
+				close(started)
+				<-overlaid
+			}),
+			player.OnEnd(func(time.Duration, error) { close(end) }),
+		)
+		require.NoError(t, err)
+		<-started
+		err = p.Overlay(func() (player.Source, error) {
+			return &pcmSource{sample: 100, n: 2}, nil
+		}, 1)
+		close(overlaid)
+		<-end
+		return err
+	}
+
+	dst := &opusRecorder{}
+	assert.Equal(t, player.ErrNotPCM, overlay(&pcmSource{sample: 2000, n: 3}, dst), "expected overlay to need a PCM device")
+	assert.Equal(t, []int16{2000, 2000, 2000}, dst.Samples())
+
+	pcmDst := &sampleRecorder{}
+	assert.Equal(t, player.ErrNotPCM, overlay(&opusSource{pcmSource{sample: 2000, n: 3}}, pcmDst), "expected overlay to need a PCM source")
+	assert.Equal(t, []int16{2000, 2000, 2000}, pcmDst.Samples())
+}
@@ -0,0 +1,179 @@
+// Package pcm defines the PCM representation that the player's mixing and analysis work on,
+// interleaved 16-bit samples of 48kHz stereo in 20ms frames, with converters for audio in other shapes.
+// Samples are int16 at the edges, where frames are read from sources and written to devices,
+// and float32 in between wherever processing needs headroom.
+package pcm
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const (
+	// SampleRate is the number of samples per second of each channel.
+	SampleRate = 48000
+	// Channels is the number of interleaved channels.
+	Channels = 2
+	// FrameDuration is the duration of a frame, the duration of an opus frame sent to discord.
+	FrameDuration = 20 * time.Millisecond
+	// FrameSamples is the number of samples of each channel in a frame.
+	FrameSamples = SampleRate / int(time.Second/FrameDuration)
+	// FrameLen is the number of interleaved samples in a frame.
+	FrameLen = FrameSamples * Channels
+	// FrameBytes is the size of a frame encoded as 16-bit little-endian samples.
+	FrameBytes = FrameLen * 2
+)
+
+// Format describes PCM that is not in the standard representation.
+type Format struct {
+	SampleRate int
+	Channels   int
+}
+
+// Standard is the format of the standard representation.
+var Standard = Format{SampleRate: SampleRate, Channels: Channels}
+
+// Samples reports how many interleaved samples of the format last d.
+func (f Format) Samples(d time.Duration) int {
+	return int(int64(d) * int64(f.SampleRate) / int64(time.Second) * int64(f.Channels))
+}
+
+// Duration reports how long n interleaved samples of the format last.
+func (f Format) Duration(n int) time.Duration {
+	return time.Duration(int64(n) * int64(time.Second) / int64(f.SampleRate*f.Channels))
+}
+
+// Decode appends the 16-bit little-endian samples in b to dst, ignoring a trailing odd byte.
+func Decode(dst []int16, b []byte) []int16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		dst = append(dst, int16(binary.LittleEndian.Uint16(b[i:])))
+	}
+	return dst
+}
+
+// Encode appends the samples to dst as 16-bit little-endian samples.
+func Encode(dst []byte, samples []int16) []byte {
+	for _, s := range samples {
+		dst = append(dst, byte(s), byte(uint16(s)>>8))
+	}
+	return dst
+}
+
+// ToFloat appends the samples to dst scaled to [-1, 1).
+func ToFloat(dst []float32, samples []int16) []float32 {
+	for _, s := range samples {
+		dst = append(dst, float32(s)/32768)
+	}
+	return dst
+}
+
+// ToInt appends the samples to dst scaled to 16 bits, clipping samples outside [-1, 1).
+func ToInt(dst []int16, samples []float32) []int16 {
+	for _, s := range samples {
+		dst = append(dst, Clip(float64(s)*32768))
+	}
+	return dst
+}
+
+// Clip converts a sample to 16 bits, truncating toward zero and clipping it to the 16-bit range.
+func Clip(s float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, s)))
+}
+
+// Remix appends the interleaved samples with from channels to dst with to channels.
+// Mono is copied to every channel, every channel is averaged down to mono,
+// and otherwise channels are dropped or filled with silence.
+func Remix(dst []int16, samples []int16, from int, to int) []int16 {
+	for i := 0; i+from <= len(samples); i += from {
+		frame := samples[i : i+from]
+		switch {
+		case from == to:
+			dst = append(dst, frame...)
+		case from == 1:
+			for c := 0; c < to; c++ {
+				dst = append(dst, frame[0])
+			}
+		case to == 1:
+			var sum int
+			for _, s := range frame {
+				sum += int(s)
+			}
+			dst = append(dst, int16(sum/from))
+		default:
+			for c := 0; c < to; c++ {
+				if c < from {
+					dst = append(dst, frame[c])
+				} else {
+					dst = append(dst, 0)
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// Mix adds gain times src to dst, clipping to the 16-bit range.
+func Mix(dst []int16, src []int16, gain float64) {
+	n := len(dst)
+	if len(src) < n {
+		n = len(src)
+	}
+	for i := 0; i < n; i++ {
+		dst[i] = Clip(float64(dst[i]) + float64(src[i])*gain)
+	}
+}
+
+// Peak reports the largest magnitude of the samples.
+func Peak(samples []int16) int {
+	peak := 0
+	for _, s := range samples {
+		v := int(s)
+		if v < 0 {
+			v = -v
+		}
+		if v > peak {
+			peak = v
+		}
+	}
+	return peak
+}
+
+// Framer cuts samples that arrive in chunks of any size into frames of a fixed number of interleaved samples,
+// e.g. to turn the output of a decoder into standard frames.
+type Framer struct {
+	frameLen int
+	buf      []int16
+}
+
+// NewFramer creates a Framer for frames of frameLen interleaved samples, e.g. FrameLen.
+func NewFramer(frameLen int) *Framer {
+	return &Framer{frameLen: frameLen}
+}
+
+// Write adds samples after those already written.
+func (f *Framer) Write(samples []int16) {
+	f.buf = append(f.buf, samples...)
+}
+
+// Next returns the next whole frame, or false if there are not enough samples written yet.
+func (f *Framer) Next() ([]int16, bool) {
+	if len(f.buf) < f.frameLen {
+		return nil, false
+	}
+	frame := make([]int16, f.frameLen)
+	copy(frame, f.buf)
+	f.buf = f.buf[:copy(f.buf, f.buf[f.frameLen:])]
+	return frame, true
+}
+
+// Flush returns the remaining samples padded with silence to a whole frame, or false if there are none.
+func (f *Framer) Flush() ([]int16, bool) {
+	if len(f.buf) == 0 {
+		return nil, false
+	}
+	frame := make([]int16, f.frameLen)
+	copy(frame, f.buf)
+	f.buf = f.buf[:0]
+	return frame, true
+}
@@ -0,0 +1,91 @@
+package pcm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstants(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 960, pcm.FrameSamples)
+	assert.Equal(t, 1920, pcm.FrameLen)
+	assert.Equal(t, 3840, pcm.FrameBytes)
+	assert.Equal(t, pcm.FrameLen, pcm.Standard.Samples(pcm.FrameDuration))
+	assert.Equal(t, pcm.FrameDuration, pcm.Standard.Duration(pcm.FrameLen))
+	mono := pcm.Format{SampleRate: 44100, Channels: 1}
+	assert.Equal(t, 441, mono.Samples(10*time.Millisecond))
+	assert.Equal(t, time.Second, mono.Duration(44100))
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	samples := []int16{0, 1, -1, 32767, -32768, 0x1234}
+	b := pcm.Encode(nil, samples)
+	assert.Equal(t, []byte{0, 0, 1, 0, 0xFF, 0xFF, 0xFF, 0x7F, 0, 0x80, 0x34, 0x12}, b)
+	assert.Equal(t, samples, pcm.Decode(nil, b))
+	// a trailing odd byte is not a sample
+	assert.Equal(t, samples, pcm.Decode(nil, append(b, 0x56)))
+	assert.Empty(t, pcm.Decode(nil, []byte{1}))
+}
+
+func TestFloat(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []float32{0, 0.5, -1}, pcm.ToFloat(nil, []int16{0, 16384, -32768}))
+	assert.Equal(t, []int16{0, 16384, -32768, 32767, -32768}, pcm.ToInt(nil, []float32{0, 0.5, -1, 2, -2}), "expected samples outside [-1, 1) to clip")
+	assert.Equal(t, int16(1), pcm.Clip(1.9))
+	assert.Equal(t, int16(-1), pcm.Clip(-1.9))
+}
+
+func TestRemix(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []int16{1, 1, 2, 2}, pcm.Remix(nil, []int16{1, 2}, 1, 2))
+	assert.Equal(t, []int16{2, -3}, pcm.Remix(nil, []int16{1, 3, -2, -4}, 2, 1))
+	assert.Equal(t, []int16{1, 2, 4, 5}, pcm.Remix(nil, []int16{1, 2, 3, 4, 5, 6}, 3, 2))
+	assert.Equal(t, []int16{1, 2, 0, 3, 4, 0}, pcm.Remix(nil, []int16{1, 2, 3, 4}, 2, 3))
+	// a partial frame at the end is dropped
+	assert.Equal(t, []int16{1, 2}, pcm.Remix(nil, []int16{1, 2, 3}, 2, 2))
+}
+
+func TestMix(t *testing.T) {
+	t.Parallel()
+	dst := []int16{100, 32000, -32000}
+	pcm.Mix(dst, []int16{50, 2000, -2000, 7}, 0.5)
+	assert.Equal(t, []int16{125, 32767, -32768}, dst)
+	assert.Equal(t, 32768, pcm.Peak([]int16{5, -32768, 100}))
+	assert.Equal(t, 0, pcm.Peak(nil))
+}
+
+func TestFramer(t *testing.T) {
+	t.Parallel()
+	f := pcm.NewFramer(4)
+	_, ok := f.Next()
+	assert.False(t, ok)
+
+	// chunks of any size are cut into whole frames
+	f.Write([]int16{1, 2, 3})
+	_, ok = f.Next()
+	assert.False(t, ok, "expected no frame before enough samples are written")
+	f.Write([]int16{4, 5, 6, 7, 8, 9, 10})
+	frame, ok := f.Next()
+	assert.True(t, ok)
+	assert.Equal(t, []int16{1, 2, 3, 4}, frame)
+	frame, ok = f.Next()
+	assert.True(t, ok)
+	assert.Equal(t, []int16{5, 6, 7, 8}, frame)
+	f.Write([]int16{11})
+	frame[0] = 0
+	_, ok = f.Next()
+	assert.False(t, ok)
+
+	// the partial final frame is padded with silence
+	frame, ok = f.Flush()
+	assert.True(t, ok)
+	assert.Equal(t, []int16{9, 10, 11, 0}, frame)
+	_, ok = f.Flush()
+	assert.False(t, ok)
+	_, ok = f.Next()
+	assert.False(t, ok)
+}
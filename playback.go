@@ -2,8 +2,12 @@ package player
 
 import (
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/jeffreymkabot/discordvoice/pcm"
 	"github.com/jonas747/dca"
 	"github.com/pkg/errors"
 )
@@ -12,96 +16,398 @@ func (p *Player) playback() {
 	p.wg.Add(1)
 	// isIdle := pollTimeout == 0
 	pollTimeout := time.Duration(p.cfg.IdleTimeout) * time.Millisecond
+	// whether an item played since the player was last idle
+	played := false
 
 	for {
+		// the ambient source plays by itself until the next song or until the player goes idle
+		if played {
+			p.ambient.idle(p.writer)
+		}
 		song, err := p.poll(pollTimeout)
-		if err == errPollTimeout {
+		p.ambient.busy()
+		if err == errStopped {
+			p.stop()
+		}
+		if err == errPollTimeout || err == errStopped {
 			pollTimeout = 0
+			played = false
+			p.overlays.clear()
+			p.event(EventIdle, nil)
 			p.cfg.Idle()
 			continue
 		} else if err != nil {
-			if wc, ok := p.writer.(io.Closer); ok {
-				wc.Close()
-			}
+			p.overlays.clear()
+			p.closeDevices()
 			p.wg.Done()
 			return
 		}
 		pollTimeout = time.Duration(p.cfg.IdleTimeout) * time.Millisecond
 
+		if !song.expiresAt.IsZero() && p.cfg.Clock.Now().After(song.expiresAt) {
+			p.setCurrent(nil)
+			song.onEnd(0, ErrExpired)
+			continue
+		}
+
+		// a transition that did not overlap the end of the previous item plays over the start of this one
+		if played && !p.transitioned {
+			p.startTransition()
+		}
+		p.transitioned = false
+
 		p.wg.Add(1)
+		p.setState(StateOpening)
+		p.cfg.Logger.Debugf("item %v is starting", song.seq)
 		elapsed, err := p.openAndPlay(song)
+		p.cfg.Logger.Debugf("item %v ended after %v: %v", song.seq, elapsed, err)
+		p.setCurrent(nil)
+		p.setState(StateIdle)
+		if p.outage(song, err) {
+			p.wg.Done()
+			continue
+		}
+		p.cfg.Metrics.TrackPlayed(err)
+		p.debug.itemEnded(song, err, p.cfg.Clock.Now())
+		song.correctDuration(elapsed, err)
 		song.onEnd(elapsed, err)
 		p.wg.Done()
+		played = true
 	}
 }
 
+func (p *Player) setCurrent(song *songItem) {
+	p.mu.Lock()
+	p.current = song
+	p.ctrlMu.Lock()
+	p.skipStart = nil
+	p.ctrlMu.Unlock()
+	p.mu.Unlock()
+}
+
 func (p *Player) openAndPlay(song *songItem) (elapsed time.Duration, err error) {
-	writer, err := song.openDst()
+	if err = p.awaitResolved(song); err != nil {
+		return
+	}
+
+	openDst := song.openDst
+	if openDst == nil {
+		openDst = p.cfg.DefaultDevice
+	}
+	p.mu.RLock()
+	entry := song.entry()
+	p.mu.RUnlock()
+	return p.playItem(PlayRequest{Entry: entry, OpenSrc: song.openSrc, OpenDst: openDst, song: song})
+}
+
+// playRequest opens the source and device of an item and plays it, at the end of the middleware chain.
+func (p *Player) playRequest(req PlayRequest) (elapsed time.Duration, err error) {
+	song := req.song
+	if req.OpenSrc == nil || req.OpenDst == nil {
+		err = ErrNilOpener
+		return
+	}
+
+	var writer io.Writer
+	err = p.within(p.cfg.DeviceTimeout, func() (err error) {
+		writer, err = req.OpenDst()
+		return
+	}, func() {
+		p.abandon(writer)
+	})
 	if err != nil {
+		p.deviceFailures++
 		err = errors.Wrap(err, "failed to open device")
+		p.cfg.Logger.Errorf("item %v: %v", song.seq, err)
+		p.cfg.Metrics.OpenFailed("device")
 		return
 	}
+	p.deviceFailures = 0
+	p.closeAbandoned(writer)
 
 	// keep track of the open writer so it can get closed when the player closes if is a closer
 	p.writer = writer
+	p.ctrlMu.Lock()
+	p.deviceOpened = true
+	p.devicePCM = pcmDevice(writer)
+	p.ctrlMu.Unlock()
 
-	src, err := song.openSrc()
+	openTimeout := song.openTimeout
+	if openTimeout == 0 {
+		openTimeout = p.cfg.OpenTimeout
+	}
+	var src Source
+	err = p.within(openTimeout, func() (err error) {
+		src, err = req.OpenSrc()
+		return
+	}, func() {
+		closeLate(src)
+	})
 	if err != nil {
 		err = errors.Wrap(err, "failed to open song")
+		p.cfg.Logger.Errorf("item %v: %v", song.seq, err)
+		p.cfg.Metrics.OpenFailed("source")
 		return
 	}
-	if rc, ok := src.(io.Closer); ok {
-		defer rc.Close()
+	if _, ok := src.(SeekableSource); !ok && song.reopen != nil {
+		src = &reopener{Source: src, reopen: song.reopen}
+	}
+	layers := []Source{src}
+	for _, transform := range song.transforms {
+		src = transform(src)
+		layers = append(layers, src)
+	}
+	// transforms that do not close or seek leave it to the sources they wrap
+	var closer io.Closer
+	var seeker SeekableSource
+	for i := len(layers) - 1; i >= 0; i-- {
+		if c, ok := layers[i].(io.Closer); ok && closer == nil {
+			closer = c
+		}
+		if ss, ok := layers[i].(SeekableSource); ok && seeker == nil {
+			seeker = ss
+		}
+	}
+	// the source may also be closed by the watchdog in play, to abort a read that stalled
+	var closeOnce sync.Once
+	closeSrc := func() {
+		if closer != nil {
+			closeOnce.Do(func() { closer.Close() })
+		}
 	}
+	defer closeSrc()
 
-	elapsed, err = play(p, src, writer, song.callbacks)
+	elapsed, err = play(p, src, seeker, writer, song.callbacks, closeSrc)
 	return
 }
 
-func play(player *Player, src Source, dst io.Writer, cb callbacks) (elapsed time.Duration, err error) {
+// play plays src to dst, seeking with seeker if it is not nil.
+func play(player *Player, src Source, seeker SeekableSource, dst io.Writer, cb callbacks, closeSrc func()) (elapsed time.Duration, err error) {
 	var frame []byte
+	var pts time.Duration
 	nWrites, frameDur := 0, src.FrameDuration()
+	pooled := isPooled(src)
+	// the ambient source and overlays are only mixed into PCM
+	mixable := pcmDevice(dst) && producesPCM(src)
 
-	var writeInterval int
+	// report progress every writeInterval frames, or on progressTick if writeInterval is 0
+	writeInterval, progressInterval := cb.progress(frameDur)
+	var progressTick <-chan time.Time
 	var writeLatencies []time.Duration
 	var prevWriteTime time.Time
-	if cb.progressInterval > 0 {
-		writeInterval = int(cb.progressInterval / frameDur)
+	writesSinceProgress := 0
+	if progressInterval > 0 {
 		writeLatencies = make([]time.Duration, 0, writeInterval)
+		if writeInterval == 0 {
+			ticker := player.cfg.Clock.NewTicker(progressInterval)
+			defer ticker.Stop()
+			progressTick = ticker.C()
+		}
+	}
+	// report levels every levelsInterval frames
+	var levels *analyzer
+	levelsInterval, writesSinceLevels := 0, 0
+	if cb.levelsInterval > 0 {
+		levels = newAnalyzer(cb.levelBands)
+		levelsInterval = 1
+		if frameDur > 0 && cb.levelsInterval > frameDur {
+			levelsInterval = int(cb.levelsInterval / frameDur)
+		}
+	}
+	reportProgress := func() {
+		tmp := make([]time.Duration, len(writeLatencies))
+		copy(tmp, writeLatencies)
+		writeLatencies = writeLatencies[len(writeLatencies):]
+		cb.onProgress(elapsed, tmp)
 	}
 
-	// drain any buffered control signals (e.g. client called Skip() before any song was queued)
+	// discard any pending control requests (e.g. client called Skip() before any song was queued)
 	drain(player.ctrl)
+	player.ctrlMu.Lock()
+	player.mixable = mixable
+	player.sourcePCM = producesPCM(src)
+	player.ctrlMu.Unlock()
+	if !mixable {
+		// a transition or overlays carried over from the previous item cannot be mixed into this one
+		player.overlays.clear()
+	}
+	player.setPlaying(true)
+	defer player.setPlaying(false)
+
+	// gate reads and writes in order to respect pause/skip signals
+	pc := newPacer(cb.realtime.paced(dst), frameDur, player.cfg.Clock)
+	defer pc.stop()
+	defer silence(dst)
+	buffered, _ := dst.(BufferedDevice)
+	// playing if ready == pc.C(), paused if ready == nil
+	ready := pc.C()
+	// elapsed is offset by where playback last jumped to
+	var offset time.Duration
+	// started with the first frame, if the item has a PlaybackTimeout
+	var wd *watchdog
 
-	// gate reads and writes in order to respect and pause/skip signals
-	ticker := time.NewTicker(1)
-	defer ticker.Stop()
-	// playing if ready == ticker, paused if ready == nil
-	ready := ticker.C
+	// frame read ahead by fastForward that is written next
+	var held []byte
+	var heldPTS time.Duration
+	defer func() {
+		if held != nil && pooled {
+			FreeFrame(held)
+		}
+	}()
+	next := func() ([]byte, time.Duration, error) {
+		if held != nil {
+			frame, pts := held, heldPTS
+			held = nil
+			return frame, pts, nil
+		}
+		wd.read(true)
+		defer wd.read(false)
+		return readFrame(src, elapsed)
+	}
 
+	// fastForward discards frames until sound follows a silent section,
+	// holding on to the first frame of sound so that it is written next.
+	// The scan stops after maxFastForward of the source or at the next control request, which playback handles next.
+	fastForward := func() error {
+		var silence time.Duration
+		// sources that do not know their frame duration are scanned in frames of standard PCM
+		maxFrames := int(maxFastForward / pcm.FrameDuration)
+		if frameDur > 0 {
+			maxFrames = int(maxFastForward / frameDur)
+		}
+		for scanned := 0; scanned < maxFrames; scanned++ {
+			select {
+			case <-player.quit:
+				return ErrClosed
+			case <-wd.done():
+				return nil
+			case <-player.ctrl:
+				player.wake()
+				return nil
+			default:
+			}
+			frame, framePTS, readErr := next()
+			if readErr != nil {
+				if pooled {
+					FreeFrame(frame)
+				}
+				return errors.Wrap(readErr, "failed to read frame")
+			}
+			silent := silentPCM(frame)
+			if !silent && silence >= minSilence {
+				held, heldPTS = frame, framePTS
+				return nil
+			}
+			if silent {
+				silence += frameDur
+			} else {
+				silence = 0
+			}
+			if pooled {
+				FreeFrame(frame)
+			}
+			nWrites++
+			elapsed = offset + time.Duration(nWrites)*frameDur
+		}
+		player.cfg.Logger.Debugf("no sound after fast-forwarding %v frames", maxFrames)
+		return nil
+	}
+
+	// handle pending control requests, reports whether playback should end
+	handle := func() bool {
+		c := player.takeControl()
+		if c.skip != nil {
+			err = c.skip
+			return true
+		}
+		if c.seek {
+			if seeker != nil {
+				pos, seekErr := seeker.SeekTo(c.seekTo)
+				if seekErr != nil {
+					err = errors.Wrap(seekErr, "failed to seek")
+					return true
+				}
+				offset, nWrites = pos, 0
+				elapsed = offset
+				wd.seek(pos, ready == nil)
+				atomic.StoreInt64(&player.elapsed, int64(elapsed))
+				if held != nil && pooled {
+					FreeFrame(held)
+				}
+				held = nil
+			}
+		}
+		if c.skipSilence {
+			if ffErr := fastForward(); ffErr != nil {
+				err = ffErr
+				return true
+			}
+			atomic.StoreInt64(&player.elapsed, int64(elapsed))
+		}
+		if c.pause {
+			if ready != nil {
+				pc.pause()
+				wd.pause()
+				silence(dst)
+				player.setState(StatePaused)
+				cb.onPause(elapsed)
+				ready = nil
+			} else {
+				pc.resume()
+				wd.resume()
+				player.setState(StatePlaying)
+				cb.onResume(elapsed)
+				ready = pc.C()
+			}
+		}
+		return false
+	}
+
+	if progressInterval > 0 {
+		cb.onProgressInterval(progressInterval)
+	}
 	cb.onStart()
+	wd = newWatchdog(player.cfg.Clock, cb.duration, cb.playbackMargin, closeSrc)
+	defer func() {
+		wd.stop()
+		if wd.fired() {
+			err = ErrPlaybackTimeout
+		}
+	}()
 	for {
 		select {
 		case <-player.quit:
 			err = ErrClosed
 			return
-		case c := <-player.ctrl:
-			switch c {
-			case skip:
-				err = ErrSkipped
+		case <-wd.done():
+			return
+		case <-player.ctrl:
+			if handle() {
 				return
-			case pause:
-				if ready != nil {
-					cb.onPause(elapsed)
-					ready = nil
-				} else {
-					cb.onResume(elapsed)
-					ready = ticker.C
-				}
+			}
+		case <-progressTick:
+			// no progress is made while paused
+			if ready != nil {
+				reportProgress()
 			}
 		case <-ready:
-			frame, err = src.ReadFrame()
+			// control signals take priority over the next frame, e.g. Pause() called in the OnStart callback
+			select {
+			case <-player.ctrl:
+				pc.retry()
+				if handle() {
+					return
+				}
+				continue
+			default:
+			}
+
+			var framePTS time.Duration
+			frame, framePTS, err = next()
 			if err != nil {
+				if pooled {
+					FreeFrame(frame)
+				}
 				err = errors.Wrap(err, "failed to read frame")
 				// include some extra debug info if failed well before we should have
 				if cb.duration > 0 && cb.duration-elapsed > 1*time.Second {
@@ -111,34 +417,278 @@ func play(player *Player, src Source, dst io.Writer, cb callbacks) (elapsed time
 				}
 				return
 			}
-			_, err = dst.Write(frame)
+			// devices are promised monotonically increasing timestamps even if the source's are not
+			if nWrites > 0 && framePTS <= pts {
+				framePTS = pts + frameDur
+			}
+			pts = framePTS
+			mixed, isMixed := frame, false
+			if mixable {
+				mixed, isMixed = player.mixInto(frame, frameDur)
+			}
+			if isMixed && pooled {
+				FreeFrame(frame)
+			}
+			if buffered != nil && nWrites > 0 {
+				if queued, _ := buffered.Buffered(); queued == 0 {
+					player.cfg.Metrics.Underrun()
+				}
+			}
+			if levels != nil {
+				levels.add(mixed)
+			}
+			var n int
+			n, err = writeFrame(dst, mixed, pts)
+			// writers must not retain the frame so it can be reused as soon as the write completes
+			if pooled || isMixed {
+				FreeFrame(mixed)
+			}
 			if err != nil {
 				err = errors.Wrap(err, "failed to write frame")
 				return
 			}
+			player.cfg.Metrics.FrameWritten(n)
 
 			nWrites++
-			elapsed = time.Duration(nWrites) * frameDur
+			elapsed = offset + time.Duration(nWrites)*frameDur
+			atomic.StoreInt64(&player.elapsed, int64(elapsed))
+			if mixable && player.transitionDue(elapsed, cb) {
+				player.startTransition()
+			}
 
-			// only invoke onProgress callback if given a valid progressInterval
-			if writeInterval > 0 {
-				now := time.Now()
-				if !prevWriteTime.IsZero() {
-					writeLatencies = append(writeLatencies, now.Sub(prevWriteTime))
+			if buffered != nil && cb.realtime != realtimeOff {
+				pc.nextBuffered(buffered.Buffered())
+			} else {
+				pc.next()
+			}
+
+			now := player.cfg.Clock.Now()
+			if !prevWriteTime.IsZero() {
+				latency := now.Sub(prevWriteTime)
+				player.debug.frameWritten(latency)
+				if progressInterval > 0 {
+					writeLatencies = append(writeLatencies, latency)
+				}
+			}
+			prevWriteTime = now
+
+			if levels != nil {
+				writesSinceLevels++
+				if writesSinceLevels == levelsInterval {
+					writesSinceLevels = 0
+					cb.onLevels(elapsed, levels.levels())
 				}
-				prevWriteTime = now
-				if nWrites%writeInterval == 0 {
-					tmp := make([]time.Duration, len(writeLatencies))
-					copy(tmp, writeLatencies)
-					writeLatencies = writeLatencies[len(writeLatencies):]
-					cb.onProgress(elapsed, tmp)
+			}
+
+			// only invoke onProgress callback if given a valid progressInterval
+			if progressInterval > 0 {
+				writesSinceProgress++
+				if writeInterval > 0 && writesSinceProgress == writeInterval {
+					writesSinceProgress = 0
+					reportProgress()
 				}
 			}
 		}
 	}
 }
 
-func drain(ctrl <-chan control) {
+// progress fits the progress interval to the frame duration,
+// returning how many frames apart progress is reported and the effective interval.
+// Progress is reported on a ticker instead of frames if the number of frames is 0.
+func (cb callbacks) progress(frameDur time.Duration) (frames int, interval time.Duration) {
+	if cb.progressInterval <= 0 {
+		return 0, 0
+	}
+	if cb.progressQuantize == QuantizeExact || frameDur <= 0 {
+		return 0, cb.progressInterval
+	}
+	if cb.progressQuantize == QuantizeUp {
+		frames = int((cb.progressInterval + frameDur - 1) / frameDur)
+	} else {
+		frames = int(cb.progressInterval / frameDur)
+	}
+	if frames < 1 {
+		frames = 1
+	}
+	return frames, time.Duration(frames) * frameDur
+}
+
+// correctDuration reports the actual duration of an item that played to its end if it is off from the declared duration.
+func (cb callbacks) correctDuration(elapsed time.Duration, err error) {
+	if cb.duration <= 0 {
+		return
+	}
+	if cause := errors.Cause(err); cause != io.EOF && cause != io.ErrUnexpectedEOF {
+		return
+	}
+	diff := elapsed - cb.duration
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > cb.correctionThreshold {
+		cb.onDurationCorrected(cb.duration, elapsed)
+	}
+}
+
+// whether to pace writes to the device
+type realtime int
+
+const (
+	realtimeAuto realtime = iota
+	realtimeOn
+	realtimeOff
+)
+
+// paced decides whether the player needs to pace writes to the device.
+func (rt realtime) paced(dst io.Writer) bool {
+	switch rt {
+	case realtimeOn:
+		return true
+	case realtimeOff:
+		return false
+	}
+	if pd, ok := dst.(PacedDevice); ok {
+		return pd.RequiresPacing()
+	}
+	if caps, ok := Caps(dst); ok {
+		return !caps.Realtime
+	}
+	return false
+}
+
+// pacer gates the play loop until the next frame is due.
+// Paced frames are due on frame duration boundaries measured from a monotonic deadline,
+// so a late write is made up for by the following writes instead of drifting.
+// Unpaced frames are due immediately.
+type pacer struct {
+	paced    bool
+	buffered bool
+	frameDur time.Duration
+	clock    clock.Clock
+	timer    clock.Timer
+	deadline time.Time
+	pausedAt time.Time
+}
+
+func newPacer(paced bool, frameDur time.Duration, clk clock.Clock) *pacer {
+	return &pacer{
+		paced:    paced,
+		frameDur: frameDur,
+		clock:    clk,
+		timer:    clk.NewTimer(0),
+		deadline: clk.Now(),
+	}
+}
+
+// C fires when the next frame is due.
+func (pc *pacer) C() <-chan time.Time {
+	return pc.timer.C()
+}
+
+// next schedules the frame following one that was written.
+func (pc *pacer) next() {
+	if pc.paced {
+		pc.deadline = pc.deadline.Add(pc.frameDur)
+	}
+	pc.reset()
+}
+
+// nextBuffered schedules the frame following one that was written to a buffered device,
+// so that the next frame is due once the device has no more than its target queued.
+func (pc *pacer) nextBuffered(queued time.Duration, target time.Duration) {
+	pc.buffered = true
+	pc.deadline = pc.clock.Now().Add(queued - target)
+	pc.timer.Reset(pc.deadline.Sub(pc.clock.Now()))
+}
+
+// retry schedules the same frame again after C fired but no frame was written.
+func (pc *pacer) retry() {
+	pc.reset()
+}
+
+func (pc *pacer) pause() {
+	pc.pausedAt = pc.clock.Now()
+}
+
+// resume pushes back the deadline by how long playback was paused.
+// A buffered device has drained while paused so the next frame is due immediately.
+func (pc *pacer) resume() {
+	if pc.paced && !pc.buffered {
+		pc.deadline = pc.deadline.Add(pc.clock.Now().Sub(pc.pausedAt))
+	}
+	// discard the signal if C fired while paused
+	if !pc.timer.Stop() {
+		select {
+		case <-pc.timer.C():
+		default:
+		}
+	}
+	pc.reset()
+}
+
+func (pc *pacer) reset() {
+	if pc.paced {
+		pc.timer.Reset(pc.deadline.Sub(pc.clock.Now()))
+	} else {
+		pc.timer.Reset(0)
+	}
+}
+
+func (pc *pacer) stop() {
+	pc.timer.Stop()
+}
+
+// silence tells a SilencingDevice that the player stopped writing to it.
+func silence(dst io.Writer) {
+	if sd, ok := dst.(SilencingDevice); ok {
+		sd.Silence()
+	}
+}
+
+// reopener seeks by replacing its source with one opened at the new position.
+type reopener struct {
+	Source
+	reopen SeekOpenerFunc
+}
+
+func (r *reopener) SeekTo(d time.Duration) (time.Duration, error) {
+	src, err := r.reopen(d)
+	if err != nil {
+		return 0, err
+	}
+	r.close()
+	r.Source = src
+	return d, nil
+}
+
+func (r *reopener) Close() error {
+	return r.close()
+}
+
+func (r *reopener) close() error {
+	if c, ok := r.Source.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// readFrame reads the next frame and its presentation timestamp
+func readFrame(src Source, elapsed time.Duration) ([]byte, time.Duration, error) {
+	if ts, ok := src.(TimestampedSource); ok {
+		return ts.ReadTimestampedFrame()
+	}
+	frame, err := src.ReadFrame()
+	return frame, elapsed, err
+}
+
+func writeFrame(dst io.Writer, frame []byte, pts time.Duration) (int, error) {
+	if ts, ok := dst.(TimestampedWriter); ok {
+		return ts.WriteTimestamped(frame, pts)
+	}
+	return dst.Write(frame)
+}
+
+func drain(ctrl <-chan struct{}) {
 	for {
 		select {
 		case <-ctrl:
@@ -150,9 +700,9 @@ func drain(ctrl <-chan control) {
 
 var defaultEncodeOptions = dca.EncodeOptions{
 	Volume:           256,
-	Channels:         2,
-	FrameRate:        48000,
-	FrameDuration:    20,
+	Channels:         pcm.Channels,
+	FrameRate:        pcm.SampleRate,
+	FrameDuration:    int(pcm.FrameDuration / time.Millisecond),
 	Bitrate:          128,
 	RawOutput:        false,
 	Application:      dca.AudioApplicationAudio,
@@ -1,14 +1,18 @@
 package player_test
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hajimehoshi/oto"
 	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/clock"
 	"github.com/jeffreymkabot/discordvoice/mp3"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -59,3 +63,832 @@ func TestPlayback(t *testing.T) {
 		require.FailNow(t, "timeout after 25 seconds")
 	}
 }
+
+type timestampRecorder struct {
+	pts []time.Duration
+}
+
+func (w *timestampRecorder) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *timestampRecorder) WriteTimestamped(p []byte, pts time.Duration) (int, error) {
+	w.pts = append(w.pts, pts)
+	return len(p), nil
+}
+
+type timestampedSource struct {
+	stringSource
+	pts []time.Duration
+}
+
+func (s *timestampedSource) ReadTimestampedFrame() ([]byte, time.Duration, error) {
+	frame, err := s.ReadFrame()
+	if err != nil {
+		return frame, 0, err
+	}
+	pts := s.pts[0]
+	s.pts = s.pts[1:]
+	return frame, pts, nil
+}
+
+func TestTimestamps(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	play := func(openSrc player.SourceOpenerFunc) []time.Duration {
+		dst := &timestampRecorder{}
+		end := make(chan struct{})
+		_, err := p.Enqueue("", openSrc, func() (io.Writer, error) { return dst, nil },
+			player.OnEnd(func(time.Duration, error) { close(end) }),
+		)
+		require.NoError(t, err)
+		<-end
+		return dst.pts
+	}
+
+	pts := play(func() (player.Source, error) {
+		return &stringSource{strings.NewReader("abc")}, nil
+	})
+	assert.Equal(t, []time.Duration{0, 1 * time.Second, 2 * time.Second}, pts, "expected frames to be timestamped by elapsed time")
+
+	pts = play(func() (player.Source, error) {
+		return &timestampedSource{
+			stringSource: stringSource{strings.NewReader("abcd")},
+			pts:          []time.Duration{5 * time.Second, 7 * time.Second, 7 * time.Second, 6 * time.Second},
+		}, nil
+	})
+	assert.Equal(t, []time.Duration{5 * time.Second, 7 * time.Second, 8 * time.Second, 9 * time.Second}, pts, "expected source timestamps to be kept monotonic")
+}
+
+type pacedRecorder struct {
+	writes []time.Time
+	// clock that writes are timed by, the time package if nil
+	clock clock.Clock
+}
+
+func (w *pacedRecorder) Write(p []byte) (int, error) {
+	if w.clock != nil {
+		w.writes = append(w.writes, w.clock.Now())
+	} else {
+		w.writes = append(w.writes, time.Now())
+	}
+	return len(p), nil
+}
+
+func (w *pacedRecorder) RequiresPacing() bool {
+	return true
+}
+
+type shortFrameSource struct {
+	stringSource
+}
+
+func (s *shortFrameSource) FrameDuration() time.Duration {
+	return 20 * time.Millisecond
+}
+
+func TestPacedDevice(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := player.New(player.Clock(clk))
+	defer p.Close()
+
+	dst := &pacedRecorder{clock: clk}
+	end := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return &shortFrameSource{stringSource{strings.NewReader("abcdef")}}, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	// the first frame is written right away, each read after waits for the frame before it to play
+	for i := 0; i < 6; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(20 * time.Millisecond)
+	}
+	<-end
+
+	require.Len(t, dst.writes, 6)
+	for i, w := range dst.writes {
+		assert.Equal(t, time.Unix(0, 0).Add(time.Duration(i)*20*time.Millisecond), w, "expected writes to be paced by frame duration")
+	}
+
+	dst = &pacedRecorder{clock: clk}
+	end = make(chan struct{})
+	_, err = p.Enqueue("",
+		func() (player.Source, error) {
+			return &shortFrameSource{stringSource{strings.NewReader("abcdef")}}, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.Realtime(false),
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	<-end
+
+	require.Len(t, dst.writes, 6)
+	assert.Equal(t, dst.writes[0], dst.writes[5], "expected writes not to be paced when not realtime")
+}
+
+type bufferedRecorder struct {
+	pacedRecorder
+	queued, target time.Duration
+}
+
+func (w *bufferedRecorder) Buffered() (time.Duration, time.Duration) {
+	return w.queued, w.target
+}
+
+func TestBufferedDevice(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	play := func(dst io.Writer) {
+		end := make(chan struct{})
+		_, err := p.Enqueue("",
+			func() (player.Source, error) {
+				return &shortFrameSource{stringSource{strings.NewReader("abcdef")}}, nil
+			},
+			func() (io.Writer, error) {
+				return dst, nil
+			},
+			player.OnEnd(func(time.Duration, error) { close(end) }),
+		)
+		require.NoError(t, err)
+		<-end
+	}
+
+	// device is always over its target
+	dst := &bufferedRecorder{queued: 40 * time.Millisecond, target: 20 * time.Millisecond}
+	play(dst)
+	require.Len(t, dst.writes, 6)
+	assert.True(t, dst.writes[5].Sub(dst.writes[0]) >= 100*time.Millisecond, "expected writes to wait for the device buffer to drain")
+
+	// paced device is always under its target
+	dst = &bufferedRecorder{queued: 0, target: 20 * time.Millisecond}
+	play(dst)
+	require.Len(t, dst.writes, 6)
+	assert.True(t, dst.writes[5].Sub(dst.writes[0]) < 100*time.Millisecond, "expected writes to fill the device buffer")
+}
+
+type seekableSource struct {
+	stringSource
+}
+
+func (s *seekableSource) SeekTo(d time.Duration) (time.Duration, error) {
+	_, err := s.Seek(int64(d/time.Second), io.SeekStart)
+	return d.Truncate(time.Second), err
+}
+
+type byteRecorder struct {
+	b []byte
+}
+
+func (w *byteRecorder) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+func TestJumpTo(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	jump := func(openSrc player.SourceOpenerFunc, opts ...player.SongOption) (string, time.Duration) {
+		dst := &byteRecorder{}
+		var elapsed time.Duration
+		end := make(chan struct{})
+		opts = append(opts,
+			player.OnStart(func() {
+				p.JumpTo(6500 * time.Millisecond)
+			}),
+			player.OnEnd(func(e time.Duration, err error) {
+				elapsed = e
+				close(end)
+			}),
+		)
+		_, err := p.Enqueue("", openSrc, func() (io.Writer, error) { return dst, nil }, opts...)
+		require.NoError(t, err)
+		<-end
+		return string(dst.b), elapsed
+	}
+
+	played, elapsed := jump(func() (player.Source, error) {
+		return &seekableSource{stringSource{strings.NewReader("hello world")}}, nil
+	})
+	assert.Equal(t, "world", played, "expected seekable source to jump to the frame")
+	assert.Equal(t, 11*time.Second, elapsed)
+
+	played, elapsed = jump(nopSongOpener, player.Reopen(func(offset time.Duration) (player.Source, error) {
+		r := strings.NewReader("hello world")
+		r.Seek(int64(offset/time.Second), io.SeekStart)
+		return &stringSource{r}, nil
+	}))
+	assert.Equal(t, "world", played, "expected source to reopen at the offset")
+	assert.Equal(t, 11500*time.Millisecond, elapsed)
+
+	played, _ = jump(nopSongOpener)
+	assert.Equal(t, "hello world", played, "expected jump to have no effect on a source that cannot seek")
+
+	played, _ = jump(func() (player.Source, error) {
+		return &seekableSource{stringSource{strings.NewReader("hello world")}}, nil
+	}, player.Transform(func(src player.Source) player.Source {
+		return mapSource{src, bytes.ToUpper}
+	}))
+	assert.Equal(t, "WORLD", played, "expected jump to seek the source under a transform that cannot seek")
+}
+
+type capsRecorder struct {
+	writes []time.Time
+	caps   player.DeviceCaps
+}
+
+func (w *capsRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, time.Now())
+	return len(p), nil
+}
+
+func (w *capsRecorder) DeviceInfo() player.DeviceCaps {
+	return w.caps
+}
+
+func TestDeviceCapsPacing(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	play := func(dst *capsRecorder) time.Duration {
+		end := make(chan struct{})
+		_, err := p.Enqueue("",
+			func() (player.Source, error) {
+				return &shortFrameSource{stringSource{strings.NewReader("abcdef")}}, nil
+			},
+			func() (io.Writer, error) {
+				return dst, nil
+			},
+			player.OnEnd(func(time.Duration, error) { close(end) }),
+		)
+		require.NoError(t, err)
+		<-end
+		require.Len(t, dst.writes, 6)
+		return dst.writes[5].Sub(dst.writes[0])
+	}
+
+	assert.True(t, play(&capsRecorder{caps: player.DeviceCaps{Realtime: false}}) >= 100*time.Millisecond, "expected writes to a device that is not realtime to be paced")
+	assert.True(t, play(&capsRecorder{caps: player.DeviceCaps{Realtime: true}}) < 100*time.Millisecond, "expected writes to a realtime device not to be paced")
+}
+
+// silenceRecorder records the frames written to it, with "_" wherever the player asked for silence
+type silenceRecorder struct {
+	byteRecorder
+}
+
+func (w *silenceRecorder) Silence() error {
+	w.b = append(w.b, '_')
+	return nil
+}
+
+func TestSilencingDevice(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	dst := &silenceRecorder{}
+	track, err := p.Enqueue("", nopSongOpener, func() (io.Writer, error) { return dst, nil },
+		player.OnProgress(func(elapsed time.Duration, _ []time.Duration) {
+			if elapsed == 5*time.Second {
+				p.Pause()
+			}
+		}, time.Second),
+		player.OnPause(func(time.Duration) {
+			p.Resume()
+		}),
+	)
+	require.NoError(t, err)
+	<-track.Done()
+	assert.Equal(t, "hello_ world_", string(dst.b), "expected silence when the item paused and ended")
+}
+
+// sectionSource plays each of its pcmSources in turn
+type sectionSource []*pcmSource
+
+func (s *sectionSource) ReadFrame() ([]byte, error) {
+	for len(*s) > 0 {
+		frame, err := (*s)[0].ReadFrame()
+		if err != io.EOF {
+			return frame, err
+		}
+		*s = (*s)[1:]
+	}
+	return nil, io.EOF
+}
+
+func (s *sectionSource) FrameDuration() time.Duration {
+	return 1 * time.Millisecond
+}
+
+func TestSkipToNextSound(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	dst := &sampleRecorder{}
+	var elapsed time.Duration
+	end := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return &sectionSource{
+				{sample: 1000, n: 100},
+				// too short to count as a silent section
+				{sample: 0, n: 500},
+				{sample: 2000, n: 100},
+				{sample: 0, n: 1500},
+				{sample: 3000, n: 100},
+			}, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.OnStart(func() {
+			assert.NoError(t, p.SkipToNextSound())
+		}),
+		player.OnEnd(func(e time.Duration, _ error) {
+			elapsed = e
+			close(end)
+		}),
+	)
+	require.NoError(t, err)
+	<-end
+
+	samples := dst.Samples()
+	require.Len(t, samples, 100, "expected playback to resume after the silent section")
+	assert.Equal(t, int16(3000), samples[0])
+	assert.Equal(t, 2300*time.Millisecond, elapsed, "expected skipped frames to count towards elapsed time")
+	assert.Equal(t, player.ErrNotPlaying, p.SkipToNextSound())
+}
+
+// silentSource plays silent frames forever, optionally waiting for resume after reached is closed at frame n
+type silentSource struct {
+	frameDur time.Duration
+	n        int
+	reached  chan struct{}
+	resume   chan struct{}
+}
+
+func (s *silentSource) ReadFrame() ([]byte, error) {
+	if s.reached != nil && s.n == 0 {
+		close(s.reached)
+		<-s.resume
+		s.reached = nil
+	}
+	s.n--
+	return make([]byte, 4), nil
+}
+
+func (s *silentSource) FrameDuration() time.Duration {
+	return s.frameDur
+}
+
+func TestSkipToNextSoundNeverSounds(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	dst := &sampleRecorder{writes: make(chan int16)}
+	var elapsed time.Duration
+	var endErr error
+	end := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return &silentSource{frameDur: 20 * time.Millisecond}, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.OnStart(func() {
+			assert.NoError(t, p.SkipToNextSound())
+		}),
+		player.OnEnd(func(e time.Duration, err error) {
+			elapsed, endErr = e, err
+			close(end)
+		}),
+	)
+	require.NoError(t, err)
+
+	// the scan gives up on a source that never returns to sound and playback resumes
+	select {
+	case <-dst.writes:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "expected playback to resume after scanning the source")
+	}
+	require.NoError(t, p.Skip())
+	for done := false; !done; {
+		select {
+		case <-dst.writes:
+		case <-end:
+			done = true
+		}
+	}
+	assert.Equal(t, player.ErrSkipped, endErr)
+	assert.True(t, elapsed >= 10*time.Minute && elapsed < 10*time.Minute+time.Second, "expected ten minutes of the source to be scanned, not %v", elapsed)
+}
+
+func TestSkipDuringSkipToNextSound(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	src := &silentSource{frameDur: time.Millisecond, n: 10, reached: make(chan struct{}), resume: make(chan struct{})}
+	dst := &sampleRecorder{}
+	var elapsed time.Duration
+	var endErr error
+	end := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return src, nil
+		},
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.OnStart(func() {
+			assert.NoError(t, p.SkipToNextSound())
+		}),
+		player.OnEnd(func(e time.Duration, err error) {
+			elapsed, endErr = e, err
+			close(end)
+		}),
+	)
+	require.NoError(t, err)
+
+	// a skip while the source is being scanned ends the item where the scan stopped
+	<-src.reached
+	require.NoError(t, p.Skip())
+	close(src.resume)
+	select {
+	case <-end:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected skip to stop the scan")
+	}
+	assert.Equal(t, player.ErrSkipped, endErr)
+	assert.Equal(t, 11*time.Millisecond, elapsed)
+	assert.Empty(t, dst.Samples())
+}
+
+func TestSkipToNextSoundNotPCM(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	end := make(chan struct{})
+	_, err := p.Enqueue("",
+		func() (player.Source, error) {
+			return &opusSource{pcmSource{sample: 1000, n: 3}}, nil
+		},
+		func() (io.Writer, error) {
+			return &opusRecorder{}, nil
+		},
+		player.OnStart(func() {
+			assert.Equal(t, player.ErrNotPCM, p.SkipToNextSound(), "expected silence detection to need a PCM source")
+		}),
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	<-end
+}
+
+// mapSource applies a function to each frame of a source
+type mapSource struct {
+	player.Source
+	f func([]byte) []byte
+}
+
+func (s mapSource) ReadFrame() ([]byte, error) {
+	frame, err := s.Source.ReadFrame()
+	return s.f(frame), err
+}
+
+func TestTransform(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	dst := &byteRecorder{}
+	end := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener,
+		func() (io.Writer, error) {
+			return dst, nil
+		},
+		player.Transform(func(src player.Source) player.Source {
+			return mapSource{src, bytes.ToUpper}
+		}),
+		player.Transform(func(src player.Source) player.Source {
+			return mapSource{src, func(b []byte) []byte {
+				return bytes.Replace(b, []byte("L"), []byte("1"), -1)
+			}}
+		}),
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	<-end
+	assert.Equal(t, "HE11O WOR1D", string(dst.b), "expected transforms to wrap the source in order")
+}
+
+type closeRecorder struct {
+	stringSource
+	closed chan struct{}
+}
+
+func (s *closeRecorder) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestTransformClose(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	defer p.Close()
+
+	src := &closeRecorder{stringSource{strings.NewReader("hello")}, make(chan struct{})}
+	end := make(chan struct{})
+	_, err := p.Enqueue("", func() (player.Source, error) { return src, nil }, nopDeviceOpener,
+		player.Transform(func(src player.Source) player.Source {
+			return mapSource{src, bytes.ToUpper}
+		}),
+		player.OnEnd(func(time.Duration, error) { close(end) }),
+	)
+	require.NoError(t, err)
+	<-end
+	select {
+	case <-src.closed:
+	default:
+		assert.Fail(t, "expected the source under a transform that cannot close to be closed")
+	}
+}
+
+func TestOpenTimeout(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := player.New(player.Clock(clk))
+	defer p.Close()
+
+	release := make(chan struct{})
+	late := &closeRecorder{stringSource{strings.NewReader("late")}, make(chan struct{})}
+	hung := func() (player.Source, error) {
+		<-release
+		return late, nil
+	}
+	ends := make(chan error, 2)
+	onEnd := player.OnEnd(func(_ time.Duration, err error) { ends <- err })
+
+	_, err := p.Enqueue("hung", hung, nopDeviceOpener, player.OpenTimeout(50*time.Millisecond), onEnd)
+	require.NoError(t, err)
+	dst := &byteRecorder{}
+	_, err = p.Enqueue("next", nopSongOpener, func() (io.Writer, error) { return dst, nil }, onEnd)
+	require.NoError(t, err)
+
+	clk.BlockUntil(1)
+	clk.Advance(49 * time.Millisecond)
+	select {
+	case <-ends:
+		require.FailNow(t, "expected the hung source to have until its timeout to open")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	select {
+	case err := <-ends:
+		assert.Equal(t, player.ErrOpenTimeout, errors.Cause(err), "expected the hung source to time out")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the hung source to time out")
+	}
+	<-ends
+	assert.Equal(t, "hello world", string(dst.b), "expected the next item to play after the timeout")
+
+	close(release)
+	select {
+	case <-late.closed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "expected the source that opened late to be closed")
+	}
+}
+
+type closeWriter struct {
+	byteRecorder
+	closed chan struct{}
+}
+
+func (w *closeWriter) Close() error {
+	close(w.closed)
+	return nil
+}
+
+func TestDeviceTimeout(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := player.New(player.Clock(clk), player.DeviceTimeout(50*time.Millisecond))
+	defer p.Close()
+
+	release := make(chan struct{})
+	late := &closeWriter{closed: make(chan struct{})}
+	hung := func() (io.Writer, error) {
+		<-release
+		return late, nil
+	}
+	end := make(chan error, 1)
+	_, err := p.Enqueue("hung", nopSongOpener, hung, player.OnEnd(func(_ time.Duration, err error) { end <- err }))
+	require.NoError(t, err)
+
+	clk.BlockUntil(1)
+	clk.Advance(49 * time.Millisecond)
+	select {
+	case <-end:
+		require.FailNow(t, "expected the hung device to have until its timeout to open")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	select {
+	case err := <-end:
+		assert.Equal(t, player.ErrOpenTimeout, errors.Cause(err), "expected the hung device to time out")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the hung device to time out")
+	}
+
+	close(release)
+	// the device that opened late is closed once the player knows it is not in use, at the latest when the player closes
+	p.Close()
+	select {
+	case <-late.closed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "expected the device that opened late to be closed")
+	}
+	assert.Empty(t, late.b, "expected nothing to play to the device that opened late")
+}
+
+func TestDeviceTimeoutShared(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := player.New(player.Clock(clk), player.DeviceTimeout(50*time.Millisecond))
+	defer p.Close()
+
+	// the device hands the same writer to every item, e.g. once it has joined the voice channel
+	shared := &closeWriter{closed: make(chan struct{})}
+	release := make(chan struct{})
+	hung := func() (io.Writer, error) {
+		<-release
+		return shared, nil
+	}
+	ends := make(chan error, 2)
+	onEnd := player.OnEnd(func(_ time.Duration, err error) { ends <- err })
+	_, err := p.Enqueue("hung", nopSongOpener, hung, onEnd)
+	require.NoError(t, err)
+	clk.BlockUntil(1)
+	clk.Advance(50 * time.Millisecond)
+	select {
+	case err := <-ends:
+		assert.Equal(t, player.ErrOpenTimeout, errors.Cause(err), "expected the hung device to time out")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the hung device to time out")
+	}
+
+	close(release)
+	_, err = p.Enqueue("next", nopSongOpener, func() (io.Writer, error) { return shared, nil }, onEnd)
+	require.NoError(t, err)
+	<-ends
+	assert.Equal(t, "hello world", string(shared.b), "expected the next item to play to the shared device")
+	select {
+	case <-shared.closed:
+		assert.Fail(t, "expected the shared device not to be closed while the player uses it")
+	default:
+	}
+
+	// closing the shared device twice would panic
+	p.Close()
+	select {
+	case <-shared.closed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "expected the player to close the shared device")
+	}
+}
+
+// stalledSource plays one frame and then blocks until it is closed.
+type stalledSource struct {
+	frames  int
+	stalled chan struct{}
+	closed  chan struct{}
+}
+
+func (s *stalledSource) ReadFrame() ([]byte, error) {
+	if s.frames == 0 {
+		s.frames++
+		return []byte{1}, nil
+	}
+	close(s.stalled)
+	<-s.closed
+	return nil, errors.New("read from closed source")
+}
+
+func (s *stalledSource) FrameDuration() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func (s *stalledSource) Close() error {
+	close(s.closed)
+	return nil
+}
+
+// endlessSource plays frames forever, recording whether it was closed during a read.
+type endlessSource struct {
+	reading      int32
+	closedInRead int32
+	closed       chan struct{}
+}
+
+func (s *endlessSource) ReadFrame() ([]byte, error) {
+	atomic.StoreInt32(&s.reading, 1)
+	defer atomic.StoreInt32(&s.reading, 0)
+	return []byte{1}, nil
+}
+
+func (s *endlessSource) FrameDuration() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func (s *endlessSource) Close() error {
+	atomic.StoreInt32(&s.closedInRead, atomic.LoadInt32(&s.reading))
+	close(s.closed)
+	return nil
+}
+
+// signalWriter signals each write.
+type signalWriter chan struct{}
+
+func (w signalWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func TestPlaybackTimeout(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := player.New(player.Clock(clk))
+	defer p.Close()
+
+	// a read that is stuck is aborted by closing the source
+	src := &stalledSource{stalled: make(chan struct{}), closed: make(chan struct{})}
+	end := make(chan error, 1)
+	_, err := p.Enqueue("stalled", func() (player.Source, error) { return src, nil }, nopDeviceOpener,
+		player.Duration(20*time.Millisecond),
+		player.PlaybackTimeout(50*time.Millisecond),
+		player.OnEnd(func(_ time.Duration, err error) { end <- err }),
+	)
+	require.NoError(t, err)
+	<-src.stalled
+
+	clk.Advance(69 * time.Millisecond)
+	select {
+	case <-end:
+		require.FailNow(t, "expected the stalled item to play until its duration and margin passed")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	select {
+	case err := <-end:
+		assert.Equal(t, player.ErrPlaybackTimeout, err, "expected the stalled item to time out")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the stalled item to time out")
+	}
+
+	// the player ends an item that times out between reads and closes the source itself
+	endless := &endlessSource{closed: make(chan struct{})}
+	wrote := make(signalWriter, 1)
+	_, err = p.Enqueue("endless", func() (player.Source, error) { return endless, nil },
+		func() (io.Writer, error) { return wrote, nil },
+		player.Realtime(true),
+		player.Duration(20*time.Millisecond),
+		player.PlaybackTimeout(50*time.Millisecond),
+		player.OnEnd(func(_ time.Duration, err error) { end <- err }),
+	)
+	require.NoError(t, err)
+	<-wrote
+
+	clk.Advance(70 * time.Millisecond)
+	select {
+	case err := <-end:
+		assert.Equal(t, player.ErrPlaybackTimeout, err, "expected the endless item to time out")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "expected the endless item to time out")
+	}
+	<-endless.closed
+	assert.Zero(t, atomic.LoadInt32(&endless.closedInRead), "expected the source not to be closed during a read")
+}
@@ -3,14 +3,19 @@ package player
 
 import (
 	"io"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jeffreymkabot/discordvoice/clock"
 	"github.com/pkg/errors"
 )
 
 // Version follows semantic versioning.
-const Version = "0.5.1"
+// 0.6.0 breaks the API of 0.5: Enqueue returns the queued Track as well as an error,
+// Skip returns an error when nothing is playing, and Pause returns whether the item was playing as well as an error.
+const Version = "0.6.0"
 
 // Player errors
 var (
@@ -18,26 +23,94 @@ var (
 	ErrClosed  = errors.New("player is closed")
 	ErrCleared = errors.New("cleared")
 	ErrSkipped = errors.New("skipped")
+	ErrRemoved = errors.New("removed")
+	ErrStopped = errors.New("stopped")
+	// ErrNotPlaying is returned by requests for the current item when nothing is playing.
+	ErrNotPlaying = errors.New("nothing is playing")
+	ErrExpired    = errors.New("expired")
+	ErrDuplicate  = errors.New("duplicate item")
+	ErrOutage     = errors.New("device outage")
+	// ErrNotFound is returned by requests for an item by its ID when the item is not in the queue.
+	ErrNotFound = errors.New("no such item")
+
+	ErrUnknownQueue = errors.New("unknown sub-queue")
+	ErrNilOpener    = errors.New("no source or device to open")
+	// ErrNotPCM is returned by requests that mix or analyze PCM when the device or source does not take or produce PCM.
+	ErrNotPCM = errors.New("device or source is not PCM")
 )
 
 var (
 	errPollTimeout = errors.New("poll timeout")
+	errStopped     = errors.New("stop requested")
 )
 
 // Player provides controllable playback to the provided audio device via a queue.
 // Player is safe to use in multiple goroutines.
 type Player struct {
+	// elapsed time of the current item, accessed atomically and first in the struct to stay 64-bit aligned
+	elapsed int64
+
 	cfg  *config
 	quit chan struct{}
 	wg   sync.WaitGroup
 
 	// device resource possibly opened by playback goroutine
 	writer io.Writer
+	// devices that opened after their item gave up on them, closed by the playback goroutine unless they are in use
+	lateMu sync.Mutex
+	late   []io.Writer
+	// whether the playback goroutine closed its devices when the player closed
+	devicesClosed bool
+
+	ambient  *ambient
+	overlays overlays
+	duck     ducker
+	// whether the transition clip already started for the next item
+	transitioned bool
 
 	mu      sync.RWMutex
 	queue   []*songItem
 	waiters []waiter
-	ctrl    chan control
+	// sequence number of the most recently enqueued item
+	seq uint64
+	// item that is playing or about to play, if any
+	current *songItem
+	// channels returned by SubscribeQueue
+	subscribers []chan []QueueEntry
+	// whether items are held in the queue, and when they are let go if the player parked itself
+	parked      bool
+	unparkTimer clock.Timer
+	// consecutive items that failed to open their device, only touched by the playback goroutine
+	deviceFailures int
+
+	ctrlMu  sync.Mutex
+	pending control
+	// whether an item is playing and whether it is paused once pending requests apply
+	playing bool
+	paused  bool
+	state   State
+	// the error the current item ends with if it was skipped or removed before it started playing
+	skipStart error
+	// deadline set by StopAfter, and whether it has passed
+	stopTimer clock.Timer
+	stopping  bool
+	// whether an item has opened a device, and whether the most recent device takes PCM
+	deviceOpened bool
+	devicePCM    bool
+	// whether the playing item's source and device are PCM, and whether its source is
+	mixable   bool
+	sourcePCM bool
+	// wakes playback when there are pending requests
+	ctrl chan struct{}
+	// wakes resolver workers when there are items to resolve
+	resolveWake chan struct{}
+
+	// plays each item through the middleware chain
+	playItem PlayFunc
+	// recent errors and frame latencies shown by DebugHandler
+	debug debugLog
+	// recent lifecycle events returned by RecentEvents
+	events *eventLog
 }
 
 // DeviceOpenerFunc provides the writer for playback.
@@ -48,6 +121,10 @@ type DeviceOpenerFunc func() (io.Writer, error)
 // If the source also implements io.Closer it will be closed after playback.
 type SourceOpenerFunc func() (Source, error)
 
+// SeekOpenerFunc opens an audio stream starting at an offset from the beginning.
+// If the source also implements io.Closer it will be closed after playback.
+type SeekOpenerFunc func(offset time.Duration) (Source, error)
+
 type Source interface {
 	ReadFrame() ([]byte, error)
 	FrameDuration() time.Duration
@@ -58,21 +135,146 @@ type SourceCloser interface {
 	io.Closer
 }
 
+// PCMSource is implemented by sources that know whether they produce interleaved 16-bit little-endian PCM,
+// e.g. sources of opus or AAC frames report false so the player does not mix overlays or the ambient source into their frames.
+// Sources that do not implement PCMSource are assumed to produce PCM.
+type PCMSource interface {
+	Source
+	PCM() bool
+}
+
+// producesPCM reports whether src produces PCM that the ambient source and overlays can be mixed into.
+func producesPCM(src Source) bool {
+	if ps, ok := src.(PCMSource); ok {
+		return ps.PCM()
+	}
+	return true
+}
+
+// SeekableSource is implemented by sources that can move to an arbitrary position, e.g. file-backed sources.
+// SeekTo returns the position that was reached, which may be rounded to a frame boundary.
+type SeekableSource interface {
+	Source
+	SeekTo(d time.Duration) (time.Duration, error)
+}
+
+// TimestampedSource is implemented by sources that know the presentation timestamp of each frame,
+// e.g. sources demuxed from a container shared with a video stream.
+// Frames from other sources are timestamped by how long the item has played when they are read.
+type TimestampedSource interface {
+	Source
+	ReadTimestampedFrame() (frame []byte, pts time.Duration, err error)
+}
+
+// TimestampedWriter is implemented by devices that want the presentation timestamp of each frame,
+// e.g. to keep the audio in sync with a video stream.
+// The player calls WriteTimestamped instead of Write with monotonically increasing timestamps.
+type TimestampedWriter interface {
+	io.Writer
+	WriteTimestamped(frame []byte, pts time.Duration) (n int, err error)
+}
+
+// PacedDevice is implemented by devices that accept frames faster than real time, e.g. files or network streams.
+// If RequiresPacing reports true the player schedules writes to the device on frame duration boundaries
+// instead of relying on the device to block until it is ready for the next frame.
+type PacedDevice interface {
+	io.Writer
+	RequiresPacing() bool
+}
+
+// SilencingDevice is implemented by devices that need to know when the player stops writing to them,
+// e.g. the discord Writer sends frames of silence so listeners do not hear the last frame interpolated.
+// The player calls Silence when the current item pauses or ends.
+type SilencingDevice interface {
+	io.Writer
+	Silence() error
+}
+
+// DeviceCaps describes the frames a device accepts and how it consumes them.
+type DeviceCaps struct {
+	// accepts opus frames
+	Opus bool
+	// accepts interleaved 16-bit little-endian PCM frames
+	PCM bool
+	// preferred duration of each frame, 0 if the device has no preference
+	FrameDuration time.Duration
+	// consumes frames in real time by itself, e.g. by blocking until it is ready for the next frame
+	Realtime bool
+}
+
+// DeviceInfo is implemented by devices that describe their capabilities.
+// Writes to a device that is not Realtime are paced by the player like writes to a PacedDevice.
+type DeviceInfo interface {
+	io.Writer
+	DeviceInfo() DeviceCaps
+}
+
+// Caps reports the capabilities of a device if it implements DeviceInfo,
+// e.g. so a SourceOpenerFunc can choose between opus and PCM.
+func Caps(dst io.Writer) (DeviceCaps, bool) {
+	if info, ok := dst.(DeviceInfo); ok {
+		return info.DeviceInfo(), true
+	}
+	return DeviceCaps{}, false
+}
+
+// BufferedDevice is implemented by devices that queue frames internally before playing them.
+// Buffered reports how much audio is queued in the device and how much the device wants queued.
+// The player delays the next frame while more than the target is queued
+// and writes the next frame immediately while less than the target is queued,
+// so playback keeps pace with the device instead of drifting with its internal buffer.
+type BufferedDevice interface {
+	io.Writer
+	Buffered() (queued time.Duration, target time.Duration)
+}
+
 type songItem struct {
-	openSrc SourceOpenerFunc
-	openDst DeviceOpenerFunc
-	title   string
+	seq      uint64
+	openSrc  SourceOpenerFunc
+	openDst  DeviceOpenerFunc
+	title    string
+	subQueue string
+	reopen   SeekOpenerFunc
+	// how long the source may take to open, the player's OpenTimeout if 0
+	openTimeout time.Duration
+	// wrap the source when it is opened
+	transforms []func(Source) Source
+	// item is not played if it would start after expiresAt, unless expiresAt is zero
+	expiresAt time.Time
+	// identifies duplicates of the item, if not empty
+	dedupeKey string
+	// higher priority items play before lower priority items of the same sub-queue
+	priority int
+	// position of subQueue in the player's sub-queues, lower ranks are higher priority
+	rank int
+	// promoted items stay at the front of the queue, ahead of items inserted by sub-queue and priority
+	promoted bool
+	// ends when onEnd is called
+	track *Track
+	resolution
 	callbacks
 }
 
 type callbacks struct {
 	duration         time.Duration
+	realtime         realtime
 	onStart          func()
 	onPause          func(elapsed time.Duration)
 	onResume         func(elapsed time.Duration)
 	progressInterval time.Duration
+	progressQuantize Quantize
 	onProgress       func(elapsed time.Duration, frameTimes []time.Duration)
 	onEnd            func(elapsed time.Duration, err error)
+	// receives the progress interval once it is fit to the frame duration
+	onProgressInterval func(effective time.Duration)
+	// how far off the declared duration can be before onDurationCorrected is called
+	correctionThreshold time.Duration
+	onDurationCorrected func(declared time.Duration, actual time.Duration)
+	levelsInterval      time.Duration
+	levelBands          int
+	onLevels            func(elapsed time.Duration, levels Levels)
+	// how much longer than its duration the item may play, if it is timed at all
+	playbackMargin time.Duration
 }
 
 type waiter struct {
@@ -83,38 +285,105 @@ type waiter struct {
 // New creates a Player.
 // Be sure to call Player.Close to clean up any resources.
 func New(opts ...Option) *Player {
-	cfg := config{Idle: func() {}}
+	player := newPlayer(opts...)
+	player.cfg.Idle()
+	go player.playback()
+	for i := 0; i < player.cfg.Resolvers; i++ {
+		go player.resolve()
+	}
+
+	return player
+}
+
+// newPlayer creates a Player without starting playback.
+func newPlayer(opts ...Option) *Player {
+	cfg := config{
+		Idle:              func() {},
+		AmbientDuck:       defaultAmbientDuck,
+		OverlayDuck:       1,
+		Resolvers:         1,
+		OnOutage:          func(error) {},
+		OnCallbackTimeout: func(string) {},
+		Logger:            NopLogger,
+		Metrics:           NopMetrics,
+		EventLog:          defaultEventLog,
+		Clock:             clock.Real,
+	}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
 
-	player := &Player{
-		cfg:  &cfg,
-		quit: make(chan struct{}),
+	p := &Player{
+		cfg:     &cfg,
+		quit:    make(chan struct{}),
+		ambient: newAmbient(cfg.AmbientDuck, cfg.Clock),
 		// buffered so Skip()/Pause() do not wait for if playback is busy reading/writing
-		ctrl: make(chan control, 1),
+		ctrl:        make(chan struct{}, 1),
+		resolveWake: make(chan struct{}, 1),
+		events:      newEventLog(cfg.EventLog, cfg.Clock),
 	}
-
-	player.cfg.Idle()
-	go player.playback()
-
-	return player
+	p.playItem = chain(p.playRequest, cfg.Middleware)
+	if cfg.ExpvarPrefix != "" {
+		p.publish(cfg.ExpvarPrefix)
+	}
+	return p
 }
 
 // Enqueue puts an item at the end of the queue.
-func (p *Player) Enqueue(title string, openSrc SourceOpenerFunc, openDst DeviceOpenerFunc, opts ...SongOption) error {
+// If openDst is nil the item plays to the player's DefaultDevice.
+// An item without a source, or without a device when the player has no DefaultDevice,
+// can be queued but ends with ErrNilOpener when it would start playing.
+// Every item is assigned an ID in the order that concurrent calls to Enqueue are serialized,
+// and items within the same sub-queue are played in ID order.
+// Enqueue returns a Track with the ID, which identifies the item in the queue and to requests like SkipTo.
+// The item may start playing, and even end, before Enqueue returns, so callbacks that need its ID should be set with OnStartID and OnEndID.
+func (p *Player) Enqueue(title string, openSrc SourceOpenerFunc, openDst DeviceOpenerFunc, opts ...SongOption) (*Track, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	select {
 	case <-p.quit:
-		return ErrClosed
+		return nil, ErrClosed
 	default:
 	}
 
 	if p.cfg.QueueLength > 0 && len(p.queue) >= p.cfg.QueueLength {
-		return ErrFull
+		return nil, ErrFull
+	}
+
+	song := p.newSongItem(title, openSrc, openDst, opts...)
+	rank, ok := p.rank(song.subQueue)
+	if !ok {
+		return nil, ErrUnknownQueue
+	}
+	if dup := p.duplicateOf(song); dup != nil {
+		if p.cfg.Duplicates == DuplicatesCoalesce {
+			song.onEnd(0, ErrDuplicate)
+			return dup.track, nil
+		}
+		return nil, ErrDuplicate
+	}
+	song.rank = rank
+	p.seq++
+	song.seq = p.seq
+	song.track.ID = song.seq
+	p.itemEvent(EventEnqueued, song, 0, nil)
+
+	// bypass queue and submit song straight to the first poller still waiting for a song
+	if ok, err := p.offer(song); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return song.track, nil
+	}
+
+	p.insert(song)
+	if song.resolver != nil {
+		p.wakeResolvers()
 	}
+	return song.track, nil
+}
 
+func (p *Player) newSongItem(title string, openSrc SourceOpenerFunc, openDst DeviceOpenerFunc, opts ...SongOption) *songItem {
 	song := &songItem{
 		openSrc: openSrc,
 		openDst: openDst,
@@ -125,31 +394,184 @@ func (p *Player) Enqueue(title string, openSrc SourceOpenerFunc, openDst DeviceO
 			onProgress: func(time.Duration, []time.Duration) {},
 			onPause:    func(time.Duration) {},
 			onResume:   func(time.Duration) {},
+
+			onProgressInterval:  func(time.Duration) {},
+			onDurationCorrected: func(time.Duration, time.Duration) {},
+			onLevels:            func(time.Duration, Levels) {},
 		},
 	}
-
 	for _, opt := range opts {
 		opt(song)
 	}
+	p.bound(&song.callbacks)
+	p.recordEvents(&song.callbacks, song)
+	song.track = newTrack()
+	onEnd := song.onEnd
+	song.onEnd = func(elapsed time.Duration, err error) {
+		onEnd(elapsed, err)
+		song.track.end(err)
+	}
+	return song
+}
 
-	// bypass queue and submit song straight to the first poller still waiting for a song
-	for len(p.waiters) > 0 {
+// Replace swaps the title, source, and options of the queued item with the ID, keeping its ID, device, and position in the queue,
+// e.g. to play from a fallback mirror or from a URL that resolved after the item was enqueued.
+// The options are applied from scratch so the item's callbacks are replaced by those in opts,
+// and the replaced callbacks are never called.
+// The Track returned by Enqueue follows the replacement.
+func (p *Player) Replace(id uint64, title string, openSrc SourceOpenerFunc, opts ...SongOption) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	idx := p.index(id)
+	if idx < 0 {
+		return ErrNotFound
+	}
+	song := p.newSongItem(title, openSrc, p.queue[idx].openDst, opts...)
+	rank, ok := p.rank(song.subQueue)
+	if !ok {
+		return ErrUnknownQueue
+	}
+	song.rank = rank
+	song.seq = id
+	song.track = p.queue[idx].track
+	song.promoted = p.queue[idx].promoted
+	p.queue[idx] = song
+	p.queueChanged()
+	if song.resolver != nil {
+		p.wakeResolvers()
+	}
+	return nil
+}
+
+// SetQueueLength changes the maximum number of items allowed in the queue, like the QueueLength option.
+// Shrinking the limit below the number of queued items keeps them,
+// but Enqueue fails with ErrFull until the queue is shorter than the new limit.
+func (p *Player) SetQueueLength(n int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	p.cfg.QueueLength = n
+	return nil
+}
+
+// offer passes an item to the first poller still waiting for one, reporting false if there is none.
+// The caller must hold mu.
+func (p *Player) offer(song *songItem) (bool, error) {
+	for !p.parked && len(p.waiters) > 0 {
 		waiter := p.waiters[0]
 		p.waiters = p.waiters[1:]
 		select {
 		case <-p.quit:
-			return ErrClosed
+			return false, ErrClosed
 		case waiter.input <- song:
-			return nil
+			p.current = song
+			return true, nil
 		case <-waiter.dead:
 			// waiter stopped waiting, try the next one
 		}
 	}
+	return false, nil
+}
 
-	p.queue = append(p.queue, song)
+// duplicateOf finds a queued or playing item with the same dedupe key, if any.
+func (p *Player) duplicateOf(song *songItem) *songItem {
+	if p.cfg.Duplicates == DuplicatesAllow || song.dedupeKey == "" {
+		return nil
+	}
+	if p.current != nil && p.current.dedupeKey == song.dedupeKey {
+		return p.current
+	}
+	for _, s := range p.queue {
+		if s.dedupeKey == song.dedupeKey {
+			return s
+		}
+	}
 	return nil
 }
 
+// rank finds the priority of a sub-queue.
+// Items without a sub-queue are ranked below all named sub-queues.
+func (p *Player) rank(subQueue string) (int, bool) {
+	if subQueue == "" {
+		return len(p.cfg.SubQueues), true
+	}
+	for i, name := range p.cfg.SubQueues {
+		if name == subQueue {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// insert places an item into the queue according to the merge policy of the sub-queues and the item's priority.
+// Promoted items at the front of the queue keep their place and are not merged with the rest.
+func (p *Player) insert(song *songItem) {
+	front := 0
+	for front < len(p.queue) && p.queue[front].promoted {
+		front++
+	}
+	rest := p.queue[front:]
+	idx := len(rest)
+	switch p.cfg.MergePolicy {
+	case MergeStrict:
+		// after every item of the same or higher priority
+		for i, s := range rest {
+			if s.rank > song.rank {
+				idx = i
+				break
+			}
+		}
+	case MergeInterleave:
+		// items take turns by sub-queue, the n-th item of each sub-queue plays in the n-th round
+		rounds := make(map[int]int)
+		round := 0
+		for _, s := range rest {
+			if s.rank == song.rank {
+				round++
+			}
+		}
+		for i, s := range rest {
+			r := rounds[s.rank]
+			rounds[s.rank]++
+			if r > round || (r == round && s.rank > song.rank) {
+				idx = i
+				break
+			}
+		}
+	}
+	idx += front
+
+	p.queue = append(p.queue, nil)
+	copy(p.queue[idx+1:], p.queue[idx:])
+	p.queue[idx] = song
+
+	// within the sub-queue higher priority items take the earlier turns, in the order they were enqueued
+	var slots []int
+	var items []*songItem
+	for i, s := range p.queue {
+		if i >= front && s.rank == song.rank {
+			slots = append(slots, i)
+			items = append(items, s)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].priority > items[j].priority
+	})
+	for i, slot := range slots {
+		p.queue[slot] = items[i]
+	}
+	p.queueChanged()
+}
+
 // poll blocks until an item is queued, player is closed, or timeout has passed if timeout > 0
 func (p *Player) poll(timeout time.Duration) (*songItem, error) {
 	select {
@@ -160,13 +582,21 @@ func (p *Player) poll(timeout time.Duration) (*songItem, error) {
 
 	var deadline <-chan time.Time
 	if timeout > 0 {
-		deadline = time.NewTimer(timeout).C
+		timer := p.cfg.Clock.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C()
+	}
+
+	if p.stopRequested() {
+		return nil, errStopped
 	}
 
 	p.mu.Lock()
-	if len(p.queue) > 0 {
+	if len(p.queue) > 0 && !p.parked {
 		song := p.queue[0]
 		p.queue = p.queue[1:]
+		p.current = song
+		p.queueChanged()
 		p.mu.Unlock()
 		return song, nil
 	}
@@ -181,16 +611,24 @@ func (p *Player) poll(timeout time.Duration) (*songItem, error) {
 	p.waiters = append(p.waiters, me)
 	p.mu.Unlock()
 
-	select {
-	case <-p.quit:
-		close(me.dead)
-		return nil, ErrClosed
-	case <-deadline:
-		// make sure enqueue does not consider me eligible anymore
-		close(me.dead)
-		return nil, errPollTimeout
-	case song := <-me.input:
-		return song, nil
+	for {
+		select {
+		case <-p.quit:
+			close(me.dead)
+			return nil, ErrClosed
+		case <-deadline:
+			// make sure enqueue does not consider me eligible anymore
+			close(me.dead)
+			return nil, errPollTimeout
+		case <-p.ctrl:
+			// nothing is playing so the only request that matters is StopAfter
+			if p.stopRequested() {
+				close(me.dead)
+				return nil, errStopped
+			}
+		case song := <-me.input:
+			return song, nil
+		}
 	}
 }
 
@@ -205,6 +643,126 @@ func (p *Player) Playlist() []string {
 	return titles
 }
 
+// QueueEntry describes an item in the queue.
+type QueueEntry struct {
+	// ID is returned by Enqueue and increases monotonically with each item that is enqueued.
+	ID       uint64
+	Title    string
+	SubQueue string
+	Priority int
+	// Resolution is the progress of the item's ResolverFunc and URL is the URL it resolved.
+	Resolution Resolution
+	URL        string
+	// Duration is the duration passed to the Duration option or resolved, 0 if unknown.
+	Duration time.Duration
+	// Until is how long until the item starts playing,
+	// the remaining duration of the current item plus the durations of the items ahead of it.
+	// Items of unknown duration count as 0 so Until is a lower bound.
+	Until time.Duration
+}
+
+// Queue returns the items in the queue in the order they will play.
+func (p *Player) Queue() []QueueEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.entries()
+}
+
+// PlaylistPage returns up to limit items of the queue starting at position offset, and the number of items in the queue,
+// so long queues can be shown a page at a time without describing every item.
+// The page is empty if offset is past the end of the queue.
+func (p *Player) PlaylistPage(offset int, limit int) (page []QueueEntry, total int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	total = len(p.queue)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total || limit <= 0 {
+		return nil, total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	until := p.remaining()
+	for _, song := range p.queue[:offset] {
+		until += song.duration
+	}
+	page = make([]QueueEntry, 0, end-offset)
+	for _, song := range p.queue[offset:end] {
+		entry := song.entry()
+		entry.Until = until
+		until += song.duration
+		page = append(page, entry)
+	}
+	return page, total
+}
+
+// Find returns the positions in the queue of the items that match, in the order they will play,
+// e.g. to find the items whose titles contain a search term.
+// Positions are only valid until the queue next changes.
+func (p *Player) Find(match func(QueueEntry) bool) []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var found []int
+	for i, entry := range p.entries() {
+		if match(entry) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// entries describes the queued items, the caller must hold mu.
+func (p *Player) entries() []QueueEntry {
+	until := p.remaining()
+	entries := make([]QueueEntry, len(p.queue))
+	for i, song := range p.queue {
+		entries[i] = song.entry()
+		entries[i].Until = until
+		until += song.duration
+	}
+	return entries
+}
+
+// QueueDuration reports how long until the queue runs out,
+// the remaining duration of the current item plus the durations of every queued item.
+// Items of unknown duration count as 0.
+func (p *Player) QueueDuration() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	d := p.remaining()
+	for _, song := range p.queue {
+		d += song.duration
+	}
+	return d
+}
+
+// remaining reports the remaining duration of the current item, 0 if unknown.
+func (p *Player) remaining() time.Duration {
+	if p.current == nil {
+		return 0
+	}
+	elapsed, _ := p.Elapsed()
+	if elapsed >= p.current.duration {
+		return 0
+	}
+	return p.current.duration - elapsed
+}
+
+func (s *songItem) entry() QueueEntry {
+	return QueueEntry{
+		ID:         s.seq,
+		Title:      s.title,
+		SubQueue:   s.subQueue,
+		Priority:   s.priority,
+		Resolution: s.resolution.state,
+		URL:        s.resolution.url,
+		Duration:   s.duration,
+	}
+}
+
 // Clear removes all queued items.
 // Clear does not skip the currently playing item.
 func (p *Player) Clear() {
@@ -218,24 +776,172 @@ func (p *Player) clear(reason error) {
 		s.onEnd(0, reason)
 	}
 	p.queue = nil
+	p.queueChanged()
 }
 
 // Skip the currently playing or paused item.
-func (p *Player) Skip() {
-	// ctrl channel is buffered to 1
+func (p *Player) Skip() error {
+	return p.controlPlaying(func(c *control) {
+		c.skip = ErrSkipped
+	})
+}
+
+// SkipTo skips the current item and every item ahead of the item with the ID in the queue, so that item plays next.
+// The skipped items end with ErrSkipped.
+func (p *Player) SkipTo(id uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	select {
-	case p.ctrl <- skip:
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	idx := p.index(id)
+	if idx < 0 {
+		return ErrNotFound
+	}
+	skipped := p.queue[:idx]
+	p.queue = p.queue[idx:]
+	for _, s := range skipped {
+		s.onEnd(0, ErrSkipped)
+	}
+	p.queueChanged()
+	if p.current != nil {
+		p.ctrlMu.Lock()
+		p.skipCurrent(ErrSkipped)
+		p.ctrlMu.Unlock()
+		p.wake()
+	}
+	return nil
+}
+
+// skipCurrent ends the current item with err, as soon as it starts if it is about to play.
+// The caller holds ctrlMu.
+func (p *Player) skipCurrent(err error) {
+	if p.playing {
+		p.pending.skip = err
+	} else {
+		p.skipStart = err
+	}
+}
+
+// RemoveID removes the item with the ID from the queue, or skips it if it is playing, and its OnEnd callback receives ErrRemoved.
+func (p *Player) RemoveID(id uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.quit:
+		return ErrClosed
 	default:
 	}
+	if p.current != nil && p.current.seq == id {
+		p.ctrlMu.Lock()
+		p.skipCurrent(ErrRemoved)
+		p.ctrlMu.Unlock()
+		p.wake()
+		return nil
+	}
+	idx := p.index(id)
+	if idx < 0 {
+		return ErrNotFound
+	}
+	song := p.queue[idx]
+	p.queue = append(p.queue[:idx], p.queue[idx+1:]...)
+	song.onEnd(0, ErrRemoved)
+	p.queueChanged()
+	return nil
 }
 
-// Pause the currently playing item or resume the currently paused item.
-func (p *Player) Pause() {
-	// ctrl channel is buffered to 1
+// Promote moves the item with the ID to the front of the queue so it plays next,
+// regardless of its sub-queue and priority.
+// The item stays ahead of any item enqueued later, items promoted earlier play after it.
+func (p *Player) Promote(id uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	select {
-	case p.ctrl <- pause:
+	case <-p.quit:
+		return ErrClosed
 	default:
 	}
+	idx := p.index(id)
+	if idx < 0 {
+		return ErrNotFound
+	}
+	song := p.queue[idx]
+	song.promoted = true
+	copy(p.queue[1:idx+1], p.queue[:idx])
+	p.queue[0] = song
+	p.queueChanged()
+	return nil
+}
+
+// index finds the position of the item with the ID in the queue, -1 if it is not queued.
+// The caller must hold mu.
+func (p *Player) index(id uint64) int {
+	for i, s := range p.queue {
+		if s.seq == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Current describes the item that is playing or about to play, or reports false if there is none.
+// Until is always 0.
+func (p *Player) Current() (QueueEntry, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.current == nil {
+		return QueueEntry{}, false
+	}
+	return p.current.entry(), true
+}
+
+// Pause the currently playing item.
+// Pause reports whether the item was playing, so pausing an item that is already paused does nothing.
+func (p *Player) Pause() (bool, error) {
+	return p.setPaused(true)
+}
+
+// Resume the currently paused item.
+// Resume reports whether the item was paused, so resuming an item that is already playing does nothing.
+func (p *Player) Resume() (bool, error) {
+	return p.setPaused(false)
+}
+
+// TogglePause pauses the currently playing item or resumes the currently paused item.
+func (p *Player) TogglePause() error {
+	return p.controlPlaying(func(c *control) {
+		p.paused = !p.paused
+		c.pause = !c.pause
+	})
+}
+
+func (p *Player) setPaused(paused bool) (changed bool, err error) {
+	err = p.controlPlaying(func(c *control) {
+		if p.paused == paused {
+			return
+		}
+		p.paused = paused
+		c.pause = !c.pause
+		changed = true
+	})
+	return
+}
+
+// JumpTo moves playback of the current item to d from its start.
+// Sources that implement SeekableSource are seeked,
+// otherwise the item is reopened at d if it was enqueued with the Reopen option.
+// JumpTo has no effect on items that are neither seekable nor reopenable.
+// The elapsed time reported to callbacks continues from the position that was reached.
+func (p *Player) JumpTo(d time.Duration) error {
+	if d < 0 {
+		d = 0
+	}
+	return p.controlPlaying(func(c *control) {
+		c.seek = true
+		c.seekTo = d
+	})
 }
 
 // Close releases the resources for the player and all queued items.
@@ -243,26 +949,172 @@ func (p *Player) Pause() {
 // You should call Close before opening another Player targetting the same resources.
 func (p *Player) Close() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	select {
 	case <-p.quit:
+		p.mu.Unlock()
 		return ErrClosed
 	default:
 	}
 
+	p.setState(StateClosing)
+	p.event(EventClosed, nil)
 	close(p.quit)
+	p.CancelStop()
+	p.stopUnparkTimer()
 	// clear calls onEnd callbacks of queued songs
 	p.clear(ErrClosed)
+	p.unsubscribeAll()
+	p.mu.Unlock()
+
 	// wait for onEnd callback of currently playing song
+	// without holding the lock, playback may need it to finish
 	p.wg.Wait()
+	if p.cfg.ExpvarPrefix != "" {
+		p.unpublish(p.cfg.ExpvarPrefix)
+	}
 	return nil
 }
 
-// send signals to the currently playing item
-type control byte
+// StopAfter stops playback after d has passed.
+// The current item finishes playing, or ends with ErrStopped at the deadline if cut is true,
+// then every queued item ends with ErrStopped and the player goes idle.
+// Items enqueued after the player has stopped play normally.
+// Calling StopAfter again replaces the previous deadline.
+func (p *Player) StopAfter(d time.Duration, cut bool) error {
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	p.ctrlMu.Lock()
+	defer p.ctrlMu.Unlock()
+	if p.stopTimer != nil {
+		p.stopTimer.Stop()
+	}
+	p.stopTimer = p.cfg.Clock.AfterFunc(d, func() {
+		p.ctrlMu.Lock()
+		p.stopTimer = nil
+		p.stopping = true
+		p.ctrlMu.Unlock()
+		if cut {
+			p.control(func(c *control) {
+				c.skip = ErrStopped
+			})
+		} else {
+			p.control(func(*control) {})
+		}
+	})
+	return nil
+}
 
-const (
-	nop control = iota
-	skip
-	pause
-)
+// CancelStop cancels the deadline set by StopAfter, reporting false if there was no deadline.
+// A deadline that has passed while the current item finishes playing can still be canceled.
+func (p *Player) CancelStop() bool {
+	p.ctrlMu.Lock()
+	defer p.ctrlMu.Unlock()
+	if p.stopTimer == nil && !p.stopping {
+		return false
+	}
+	if p.stopTimer != nil {
+		p.stopTimer.Stop()
+		p.stopTimer = nil
+	}
+	p.stopping = false
+	return true
+}
+
+func (p *Player) stopRequested() bool {
+	p.ctrlMu.Lock()
+	defer p.ctrlMu.Unlock()
+	return p.stopping
+}
+
+// stop ends every queued item once the deadline set by StopAfter has passed and nothing is playing.
+func (p *Player) stop() {
+	p.ctrlMu.Lock()
+	p.stopping = false
+	p.ctrlMu.Unlock()
+	p.mu.Lock()
+	p.clear(ErrStopped)
+	p.mu.Unlock()
+}
+
+// control holds requests for the currently playing item until playback gets around to them.
+// Requests made in between playback checks are coalesced,
+// e.g. two calls to TogglePause cancel each other and only the latest JumpTo applies.
+type control struct {
+	// reason to end the item, if it should end
+	skip error
+	// whether to toggle pause
+	pause bool
+	// whether to seek, and where to
+	seek   bool
+	seekTo time.Duration
+	// whether to fast-forward past the next silent section
+	skipSilence bool
+}
+
+// control updates the pending requests and wakes playback.
+func (p *Player) control(update func(*control)) {
+	p.ctrlMu.Lock()
+	update(&p.pending)
+	p.ctrlMu.Unlock()
+	p.wake()
+}
+
+// wake signals playback that there are pending requests.
+func (p *Player) wake() {
+	// ctrl channel is buffered to 1, one wake up covers every pending request
+	select {
+	case p.ctrl <- struct{}{}:
+	default:
+	}
+}
+
+// controlPlaying updates the pending requests for the current item and wakes playback.
+// Requests are rejected with ErrNotPlaying instead of lingering until the next item starts.
+func (p *Player) controlPlaying(update func(*control)) error {
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+	p.ctrlMu.Lock()
+	if !p.playing {
+		p.ctrlMu.Unlock()
+		return ErrNotPlaying
+	}
+	update(&p.pending)
+	p.ctrlMu.Unlock()
+	p.wake()
+	return nil
+}
+
+// setPlaying marks the start or end of an item, discarding any pending requests.
+func (p *Player) setPlaying(playing bool) {
+	p.ctrlMu.Lock()
+	defer p.ctrlMu.Unlock()
+	p.playing = playing
+	p.paused = false
+	p.pending = control{}
+	atomic.StoreInt64(&p.elapsed, 0)
+	if playing && p.skipStart != nil {
+		p.pending.skip = p.skipStart
+		p.wake()
+	}
+	p.skipStart = nil
+	if playing {
+		p.setStateLocked(StatePlaying)
+	} else {
+		p.setStateLocked(StateIdle)
+	}
+}
+
+// takeControl returns and resets the pending requests.
+func (p *Player) takeControl() control {
+	p.ctrlMu.Lock()
+	defer p.ctrlMu.Unlock()
+	c := p.pending
+	p.pending = control{}
+	return c
+}
@@ -3,11 +3,14 @@ package player
 import (
 	"io"
 	"io/ioutil"
+	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/jeffreymkabot/discordvoice/pcm"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -54,7 +57,7 @@ func TestEnqueuePoll(t *testing.T) {
 	// wait for it to be paused
 	var waitForPause sync.WaitGroup
 	waitForPause.Add(1)
-	err := p.Enqueue(pauseAndBlock, nopSongOpener, nopDeviceOpener,
+	_, err := p.Enqueue(pauseAndBlock, nopSongOpener, nopDeviceOpener,
 		OnStart(func() {
 			p.Pause()
 		}),
@@ -66,12 +69,12 @@ func TestEnqueuePoll(t *testing.T) {
 	require.Empty(t, p.queue, "expected queue to be empty after the only queued song has started")
 
 	// queue a song
-	err = p.Enqueue(enqueueOne, nil, nil)
+	_, err = p.Enqueue(enqueueOne, nil, nil)
 	require.NoError(t, err, "failed to queue a song into empty queue")
 	assert.Len(t, p.queue, 1)
 
 	// queue should be full
-	err = p.Enqueue(failToQueue, nil, nil)
+	_, err = p.Enqueue(failToQueue, nil, nil)
 	require.Error(t, err)
 	assert.Equal(t, ErrFull, err)
 
@@ -114,11 +117,11 @@ func TestEnqueuePoll(t *testing.T) {
 	// queue two songs and wait for poller goroutines to receive them
 	waitForPollers.Add(2)
 
-	err = p.Enqueue(passToFirstPoller, nil, nil)
+	_, err = p.Enqueue(passToFirstPoller, nil, nil)
 	require.NoError(t, err, "failed to queue into empty queue with two pollers")
 	require.Empty(t, p.queue, "expected to pass item directly to poller")
 
-	err = p.Enqueue(passToSecondPoller, nil, nil)
+	_, err = p.Enqueue(passToSecondPoller, nil, nil)
 	require.NoError(t, err, "failed to queue into empty queue with two pollers")
 	require.Empty(t, p.queue, "expected to pass item directly to poller")
 
@@ -127,7 +130,7 @@ func TestEnqueuePoll(t *testing.T) {
 	_, err = p.poll(1)
 	assert.Equal(t, errPollTimeout, err, "expected poll to timeout on empty queue")
 
-	err = p.Enqueue(ignoreDeadPoller, nil, nil)
+	_, err = p.Enqueue(ignoreDeadPoller, nil, nil)
 	require.NoError(t, err, "failed to queue into empty queue with one timed out poller")
 	require.Len(t, p.queue, 1, "expected to pass song into queue instead of timed out poller")
 
@@ -159,7 +162,7 @@ func TestClose(t *testing.T) {
 	wg.Wait()
 
 	// attempts to enqueue into closed player should fail
-	err := p.Enqueue("fail to queue into closed player", nil, nil)
+	_, err := p.Enqueue("fail to queue into closed player", nil, nil)
 	assert.Equal(t, ErrClosed, err, "enqueue should fail on a closed player")
 
 	// close as many times as you want
@@ -173,7 +176,7 @@ func TestClose(t *testing.T) {
 	require.Empty(t, p.queue)
 
 	wg.Add(1)
-	err = p.Enqueue("pause and block playback", nopSongOpener, nopDeviceOpener,
+	_, err = p.Enqueue("pause and block playback", nopSongOpener, nopDeviceOpener,
 		OnStart(func() {
 			p.Pause()
 		}),
@@ -188,7 +191,7 @@ func TestClose(t *testing.T) {
 	require.NoError(t, err)
 	wg.Wait()
 
-	err = p.Enqueue("", nil, nil)
+	_, err = p.Enqueue("", nil, nil)
 	require.NoError(t, err)
 	require.Len(t, p.queue, 1)
 
@@ -220,7 +223,7 @@ func TestPlaylistAndClear(t *testing.T) {
 	songEnded := false
 	var wg sync.WaitGroup
 	wg.Add(1)
-	err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
 		OnStart(func() {
 			p.Pause()
 		}),
@@ -235,7 +238,7 @@ func TestPlaylistAndClear(t *testing.T) {
 
 	require.Empty(t, p.queue)
 	for idx, title := range songs {
-		err := p.Enqueue(title, nil, nil)
+		_, err := p.Enqueue(title, nil, nil)
 		require.NoErrorf(t, err, "failed to queue song %v:%v", idx, title)
 		assert.Equal(t, songs[0:idx+1], p.Playlist())
 	}
@@ -250,7 +253,7 @@ func TestPlaylistAndClear(t *testing.T) {
 
 	require.Empty(t, p.queue)
 	for idx, title := range songs {
-		err := p.Enqueue(title, nil, nil)
+		_, err := p.Enqueue(title, nil, nil)
 		require.NoErrorf(t, err, "failed to queue song %v:%v", idx, title)
 		assert.Equal(t, songs[0:idx+1], p.Playlist())
 	}
@@ -261,3 +264,268 @@ func TestPlaylistAndClear(t *testing.T) {
 	assert.Empty(t, p.Playlist())
 	assert.False(t, songEnded)
 }
+
+func TestSubQueues(t *testing.T) {
+	t.Parallel()
+
+	enqueue := func(p *Player, items [][2]string) {
+		for _, item := range items {
+			_, err := p.Enqueue(item[0], nil, nil, SubQueue(item[1]))
+			require.NoErrorf(t, err, "failed to queue %v into %q", item[0], item[1])
+		}
+	}
+	items := [][2]string{
+		{"music 1", "music"},
+		{"other 1", ""},
+		{"music 2", "music"},
+		{"priority 1", "priority"},
+		{"music 3", "music"},
+		{"priority 2", "priority"},
+	}
+
+	// no playback so the queue is not consumed
+	p := newPlayer(SubQueues(MergeStrict, "priority", "music"))
+
+	_, err := p.Enqueue("", nil, nil, SubQueue("nope"))
+	assert.Equal(t, ErrUnknownQueue, err)
+
+	enqueue(p, items)
+	assert.Equal(t, []string{"priority 1", "priority 2", "music 1", "music 2", "music 3", "other 1"}, p.Playlist())
+
+	p = newPlayer(SubQueues(MergeInterleave, "priority", "music"))
+
+	enqueue(p, items)
+	assert.Equal(t, []string{"priority 1", "music 1", "other 1", "priority 2", "music 2", "music 3"}, p.Playlist())
+}
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	p := newPlayer()
+	_, err := p.Enqueue("music 1", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("music 2", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("announcement 1", nil, nil, Priority(10))
+	require.NoError(t, err)
+	_, err = p.Enqueue("music 3", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("announcement 2", nil, nil, Priority(10))
+	require.NoError(t, err)
+	_, err = p.Enqueue("request", nil, nil, Priority(5))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"announcement 1", "announcement 2", "request", "music 1", "music 2", "music 3"}, p.Playlist())
+
+	// priority orders items within their sub-queue
+	p = newPlayer(SubQueues(MergeInterleave, "priority", "music"))
+	_, err = p.Enqueue("music 1", nil, nil, SubQueue("music"))
+	require.NoError(t, err)
+	_, err = p.Enqueue("priority 1", nil, nil, SubQueue("priority"))
+	require.NoError(t, err)
+	_, err = p.Enqueue("music 2", nil, nil, SubQueue("music"))
+	require.NoError(t, err)
+	_, err = p.Enqueue("urgent music", nil, nil, SubQueue("music"), Priority(1))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"priority 1", "urgent music", "music 1", "music 2"}, p.Playlist())
+}
+
+func TestPromote(t *testing.T) {
+	t.Parallel()
+
+	p := newPlayer(SubQueues(MergeStrict, "priority"))
+	_, err := p.Enqueue("priority 1", nil, nil, SubQueue("priority"))
+	require.NoError(t, err)
+	_, err = p.Enqueue("music 1", nil, nil)
+	require.NoError(t, err)
+	track, err := p.Enqueue("music 2", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("music 3", nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Promote(track.ID))
+	assert.Equal(t, []string{"music 2", "priority 1", "music 1", "music 3"}, p.Playlist())
+	assert.Equal(t, ErrNotFound, p.Promote(track.ID+100))
+
+	// items enqueued later do not move ahead of the promoted item
+	_, err = p.Enqueue("priority 2", nil, nil, SubQueue("priority"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"music 2", "priority 1", "priority 2", "music 1", "music 3"}, p.Playlist())
+
+	// nor do items of a higher priority
+	p = newPlayer()
+	_, err = p.Enqueue("hi", nil, nil, Priority(1))
+	require.NoError(t, err)
+	lo, err := p.Enqueue("lo", nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, p.Promote(lo.ID))
+	assert.Equal(t, []string{"lo", "hi"}, p.Playlist())
+	_, err = p.Enqueue("another", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("urgent", nil, nil, Priority(2))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lo", "urgent", "hi", "another"}, p.Playlist())
+	require.NoError(t, p.Replace(lo.ID, "lo mirror", nil))
+	_, err = p.Enqueue("urgent 2", nil, nil, Priority(2))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lo mirror", "urgent", "urgent 2", "hi", "another"}, p.Playlist())
+
+	// nor do items of an earlier turn
+	p = newPlayer(SubQueues(MergeInterleave, "a", "b"))
+	_, err = p.Enqueue("a 1", nil, nil, SubQueue("a"))
+	require.NoError(t, err)
+	b, err := p.Enqueue("b 1", nil, nil, SubQueue("b"))
+	require.NoError(t, err)
+	_, err = p.Enqueue("a 2", nil, nil, SubQueue("a"))
+	require.NoError(t, err)
+	require.NoError(t, p.Promote(b.ID))
+	_, err = p.Enqueue("b 2", nil, nil, SubQueue("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b 1", "a 1", "b 2", "a 2"}, p.Playlist())
+}
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+
+	p := newPlayer()
+	_, err := p.Enqueue("first", nil, nil)
+	require.NoError(t, err)
+	track, err := p.Enqueue("broken link", nil, nopDeviceOpener, Duration(1*time.Minute))
+	require.NoError(t, err)
+	_, err = p.Enqueue("third", nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Replace(track.ID, "mirror", nopSongOpener, Priority(1)))
+	assert.Equal(t, []string{"first", "mirror", "third"}, p.Playlist(), "expected the item to keep its position")
+	entry := p.Queue()[1]
+	assert.Equal(t, track.ID, entry.ID)
+	assert.Equal(t, 1, entry.Priority)
+	assert.Zero(t, entry.Duration, "expected options to be applied from scratch")
+	assert.NotNil(t, p.queue[1].openSrc)
+	assert.NotNil(t, p.queue[1].openDst, "expected the item to keep its device")
+
+	assert.Equal(t, ErrNotFound, p.Replace(track.ID+100, "", nil))
+	assert.Equal(t, ErrUnknownQueue, p.Replace(track.ID, "", nil, SubQueue("nope")))
+}
+
+func TestConcurrentEnqueueOrder(t *testing.T) {
+	t.Parallel()
+	n := 100
+	p := newPlayer()
+
+	// one poller waiting so the first item bypasses the queue
+	first := make(chan *songItem, 1)
+	var waitForPoller sync.WaitGroup
+	waitForPoller.Add(1)
+	go func() {
+		waitForPoller.Done()
+		sng, err := p.poll(0)
+		assert.NoError(t, err)
+		first <- sng
+	}()
+	waitForPoller.Wait()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := p.Enqueue(strconv.Itoa(i), nil, nil)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	queue := p.Queue()
+	for i := 1; i < len(queue); i++ {
+		assert.True(t, queue[i-1].ID < queue[i].ID, "expected queue to be in ID order")
+	}
+	polled := make(chan *songItem, n)
+	polled <- <-first
+	for len(polled) < n {
+		sng, err := p.poll(1)
+		require.NoError(t, err)
+		polled <- sng
+	}
+	close(polled)
+
+	seen := make(map[string]bool)
+	var prev uint64
+	for sng := range polled {
+		assert.True(t, sng.seq > prev, "expected items to be polled in sequence order")
+		assert.False(t, seen[sng.title], "expected each item to be polled once")
+		seen[sng.title] = true
+		prev = sng.seq
+	}
+	assert.Len(t, seen, n)
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := newAnalyzer(8)
+	samples := make([]int16, 0, pcm.FrameLen)
+	for i := 0; i < pcm.FrameSamples; i++ {
+		v := int16(16384 * math.Sin(2*math.Pi*1000*float64(i)/pcm.SampleRate))
+		samples = append(samples, v, v)
+	}
+	frame := pcm.Encode(nil, samples)
+	for i := 0; i < 3; i++ {
+		a.add(frame)
+	}
+
+	levels := a.levels()
+	assert.InDelta(t, 0.5, levels.Peak, 0.01)
+	assert.InDelta(t, 0.5/math.Sqrt2, levels.RMS, 0.01)
+	require.Len(t, levels.Bands, 8)
+	loudest := 0
+	for i, b := range levels.Bands {
+		if b > levels.Bands[loudest] {
+			loudest = i
+		}
+	}
+	// 40Hz to 16kHz in 8 bands puts 1kHz in the fifth band
+	assert.Equal(t, 4, loudest, "expected a 1kHz tone in the band around 1kHz")
+	assert.InDelta(t, 0.5, levels.Bands[loudest], 0.1)
+
+	assert.Zero(t, a.levels().Peak, "expected levels to be reset after they are reported")
+}
+
+func TestCancelStopAfterDeadline(t *testing.T) {
+	t.Parallel()
+	p := New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	ends := make(chan error, 2)
+	onEnd := OnEnd(func(_ time.Duration, err error) {
+		ends <- errors.Cause(err)
+	})
+	// hold the queue
+	paused := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		OnStart(func() {
+			p.Pause()
+		}),
+		OnPause(func(time.Duration) {
+			close(paused)
+		}),
+		onEnd,
+	)
+	require.NoError(t, err)
+	<-paused
+	_, err = p.Enqueue("", nopSongOpener, nopDeviceOpener, onEnd)
+	require.NoError(t, err)
+
+	// the deadline passes while the current item still plays
+	require.NoError(t, p.StopAfter(time.Millisecond, false))
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.stopRequested() {
+		require.True(t, time.Now().Before(deadline), "expected the deadline to pass")
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, p.CancelStop(), "expected the passed deadline to be canceled")
+	assert.False(t, p.stopRequested())
+	assert.False(t, p.CancelStop(), "expected no stop to cancel")
+
+	require.NoError(t, p.Skip())
+	assert.Equal(t, ErrSkipped, <-ends)
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, <-ends, "expected the queued item to play instead of being stopped")
+}
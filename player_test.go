@@ -1,14 +1,20 @@
 package player_test
 
 import (
+	"encoding/json"
+	"expvar"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/clock"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -61,7 +67,7 @@ func TestCallbacks(t *testing.T) {
 	var pauseTime time.Duration
 	var resumeTime time.Duration
 	var endErr error
-	err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
 		player.OnStart(func() {
 			calledOnStart = true
 			p.Pause()
@@ -92,7 +98,7 @@ func TestCallbacks(t *testing.T) {
 
 	assert.True(t, calledOnStart, "did not call OnStart callback")
 	assert.True(t, calledOnPause, "did not call OnPause callback")
-	p.Pause()
+	p.Resume()
 
 	waitForEnd.Wait()
 
@@ -115,7 +121,7 @@ func TestSkip(t *testing.T) {
 	var waitForEnd sync.WaitGroup
 	waitForPause.Add(1)
 	waitForEnd.Add(1)
-	err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
 		player.OnStart(func() {
 			p.Pause()
 		}),
@@ -135,3 +141,1310 @@ func TestSkip(t *testing.T) {
 
 	assert.Equal(t, player.ErrSkipped, endErr, "skipping a paused song should end the song")
 }
+
+func TestPauseResume(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.QueueLength(1))
+	require.NotNil(t, p)
+	defer p.Close()
+
+	changed, err := p.Pause()
+	assert.Equal(t, player.ErrNotPlaying, err, "expected pause to be rejected when nothing is playing")
+	assert.False(t, changed)
+	assert.Equal(t, player.ErrNotPlaying, p.Skip(), "expected skip to be rejected when nothing is playing")
+
+	paused := make(chan struct{}, 1)
+	resumed := make(chan struct{}, 1)
+	end := make(chan struct{})
+	_, err = p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.Realtime(true),
+		player.OnStart(func() {
+			changed, err := p.Pause()
+			assert.NoError(t, err)
+			assert.True(t, changed, "expected playing item to pause")
+			changed, err = p.Pause()
+			assert.NoError(t, err)
+			assert.False(t, changed, "expected paused item to stay paused")
+		}),
+		player.OnPause(func(_ time.Duration) {
+			paused <- struct{}{}
+		}),
+		player.OnResume(func(_ time.Duration) {
+			resumed <- struct{}{}
+		}),
+		player.OnEnd(func(_ time.Duration, _ error) {
+			close(end)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	changed, err = p.Resume()
+	assert.NoError(t, err)
+	assert.True(t, changed, "expected paused item to resume")
+	changed, err = p.Resume()
+	assert.NoError(t, err)
+	assert.False(t, changed, "expected playing item to keep playing")
+	<-resumed
+
+	require.NoError(t, p.TogglePause())
+	<-paused
+	assert.Len(t, paused, 0, "expected one pause")
+	require.NoError(t, p.Skip())
+	<-end
+
+	p.Close()
+	_, err = p.Pause()
+	assert.Equal(t, player.ErrClosed, err)
+}
+
+func TestDurationCorrection(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	type correction struct {
+		declared, actual time.Duration
+	}
+	play := func(opts ...player.SongOption) *correction {
+		var c *correction
+		end := make(chan struct{})
+		opts = append(opts,
+			player.OnDurationCorrected(func(declared time.Duration, actual time.Duration) {
+				c = &correction{declared, actual}
+			}, 1*time.Second),
+			player.OnEnd(func(time.Duration, error) {
+				close(end)
+			}),
+		)
+		_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener, opts...)
+		require.NoError(t, err)
+		<-end
+		return c
+	}
+
+	// nopSongOpener plays 11 one second frames
+	assert.Equal(t, &correction{5 * time.Second, 11 * time.Second}, play(player.Duration(5*time.Second)))
+	assert.Nil(t, play(player.Duration(10500*time.Millisecond)), "expected no correction within the threshold")
+	assert.Nil(t, play(), "expected no correction without a declared duration")
+}
+
+func TestProgressQuantize(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	play := func(interval time.Duration, q player.Quantize) (effective time.Duration, progress []time.Duration) {
+		end := make(chan struct{})
+		_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+			player.OnProgress(func(elapsed time.Duration, _ []time.Duration) {
+				progress = append(progress, elapsed)
+			}, interval),
+			player.ProgressQuantize(q, func(d time.Duration) {
+				effective = d
+			}),
+			player.OnEnd(func(time.Duration, error) {
+				close(end)
+			}),
+		)
+		require.NoError(t, err)
+		<-end
+		return
+	}
+
+	// nopSongOpener plays 11 one second frames
+	effective, progress := play(2500*time.Millisecond, player.QuantizeDown)
+	assert.Equal(t, 2*time.Second, effective)
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 6 * time.Second, 8 * time.Second, 10 * time.Second}, progress)
+
+	effective, progress = play(2500*time.Millisecond, player.QuantizeUp)
+	assert.Equal(t, 3*time.Second, effective)
+	assert.Equal(t, []time.Duration{3 * time.Second, 6 * time.Second, 9 * time.Second}, progress)
+
+	effective, progress = play(500*time.Millisecond, player.QuantizeDown)
+	assert.Equal(t, 1*time.Second, effective, "expected an interval shorter than a frame to report every frame")
+	assert.Len(t, progress, 11)
+
+	effective, _ = play(500*time.Millisecond, player.QuantizeExact)
+	assert.Equal(t, 500*time.Millisecond, effective)
+}
+
+func TestState(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+	assert.Equal(t, player.StateIdle, p.State())
+
+	var states []player.State
+	paused := make(chan struct{})
+	end := make(chan struct{})
+	openSrc := func() (player.Source, error) {
+		states = append(states, p.State())
+		return nopSongOpener()
+	}
+	_, err := p.Enqueue("", openSrc, nopDeviceOpener,
+		player.OnStart(func() {
+			states = append(states, p.State())
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			states = append(states, p.State())
+			close(paused)
+		}),
+		player.OnResume(func(time.Duration) {
+			states = append(states, p.State())
+		}),
+		player.OnEnd(func(time.Duration, error) {
+			states = append(states, p.State())
+			close(end)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+	assert.Equal(t, player.StatePaused, p.State())
+	p.Resume()
+	<-end
+
+	expected := []player.State{player.StateOpening, player.StatePlaying, player.StatePaused, player.StatePlaying, player.StateIdle}
+	assert.Equal(t, expected, states)
+
+	p.Close()
+	assert.Equal(t, player.StateClosing, p.State())
+}
+
+func TestElapsed(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	_, ok := p.Elapsed()
+	assert.False(t, ok, "expected no elapsed time when nothing is playing")
+
+	paused := make(chan struct{})
+	end := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnProgress(func(elapsed time.Duration, _ []time.Duration) {
+			if elapsed == 3*time.Second {
+				p.Pause()
+			}
+		}, 1*time.Second),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+		player.OnEnd(func(time.Duration, error) {
+			close(end)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	elapsed, ok := p.Elapsed()
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, elapsed)
+
+	p.Skip()
+	<-end
+	_, ok = p.Elapsed()
+	assert.False(t, ok, "expected no elapsed time after the item ended")
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.Resolvers(2))
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// hold the queue while items resolve in the background
+	paused := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	_, err = p.Enqueue("lazy", nopSongOpener, nopDeviceOpener,
+		player.Resolve(func() (player.Metadata, error) {
+			return player.Metadata{Title: "resolved", URL: "https://example.com/stream"}, nil
+		}),
+	)
+	require.NoError(t, err)
+	failed := make(chan error, 1)
+	_, err = p.Enqueue("broken", nopSongOpener, nopDeviceOpener,
+		player.Resolve(func() (player.Metadata, error) {
+			return player.Metadata{}, errors.New("not found")
+		}),
+		player.OnEnd(func(_ time.Duration, err error) {
+			failed <- err
+		}),
+	)
+	require.NoError(t, err)
+	_, err = p.Enqueue("eager", nopSongOpener, nopDeviceOpener)
+	require.NoError(t, err)
+
+	var queue []player.QueueEntry
+	for i := 0; i < 100; i++ {
+		queue = p.Queue()
+		require.Len(t, queue, 3)
+		if queue[0].Resolution > player.ResolveRunning && queue[1].Resolution > player.ResolveRunning {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, "resolved", queue[0].Title)
+	assert.Equal(t, "https://example.com/stream", queue[0].URL)
+	assert.Equal(t, player.ResolveDone, queue[0].Resolution)
+	assert.Equal(t, "broken", queue[1].Title)
+	assert.Equal(t, player.ResolveFailed, queue[1].Resolution)
+	assert.Equal(t, player.ResolveNone, queue[2].Resolution)
+
+	p.Skip()
+	assert.EqualError(t, <-failed, "failed to resolve item: not found", "expected item that failed to resolve to end")
+}
+
+func TestQueueDuration(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	assert.Zero(t, p.QueueDuration())
+
+	// pause the current item 3 seconds in
+	paused := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.Duration(11*time.Second),
+		player.OnProgress(func(elapsed time.Duration, _ []time.Duration) {
+			if elapsed == 3*time.Second {
+				p.Pause()
+			}
+		}, 1*time.Second),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	_, err = p.Enqueue("", nil, nil, player.Duration(1*time.Minute))
+	require.NoError(t, err)
+	_, err = p.Enqueue("", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("", nil, nil, player.Duration(2*time.Minute))
+	require.NoError(t, err)
+
+	assert.Equal(t, 8*time.Second+3*time.Minute, p.QueueDuration())
+	queue := p.Queue()
+	require.Len(t, queue, 3)
+	assert.Equal(t, 1*time.Minute, queue[0].Duration)
+	assert.Equal(t, 8*time.Second, queue[0].Until)
+	assert.Zero(t, queue[1].Duration, "expected unknown duration")
+	assert.Equal(t, 8*time.Second+time.Minute, queue[1].Until)
+	assert.Equal(t, 8*time.Second+time.Minute, queue[2].Until, "expected unknown duration to count as 0")
+}
+
+func TestPlaylistPage(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// hold the queue
+	paused := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	for _, title := range []string{"a", "b", "c", "d", "e"} {
+		_, err = p.Enqueue(title, nil, nil, player.Duration(1*time.Minute))
+		require.NoError(t, err)
+	}
+
+	page, total := p.PlaylistPage(0, 2)
+	assert.Equal(t, 5, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, "a", page[0].Title)
+	assert.Equal(t, "b", page[1].Title)
+
+	page, total = p.PlaylistPage(4, 2)
+	assert.Equal(t, 5, total)
+	require.Len(t, page, 1, "expected a short last page")
+	assert.Equal(t, "e", page[0].Title)
+	assert.Equal(t, 4*time.Minute, page[0].Until, "expected the items before the page to count")
+
+	page, total = p.PlaylistPage(5, 2)
+	assert.Equal(t, 5, total)
+	assert.Empty(t, page)
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// hold the queue
+	paused := make(chan struct{})
+	_, err := p.Enqueue("intro", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	for _, title := range []string{"one more time", "around the world", "one", "digital love"} {
+		_, err = p.Enqueue(title, nil, nil)
+		require.NoError(t, err)
+	}
+
+	byTitle := func(term string) func(player.QueueEntry) bool {
+		return func(e player.QueueEntry) bool {
+			return strings.Contains(e.Title, term)
+		}
+	}
+	assert.Equal(t, []int{0, 2}, p.Find(byTitle("one")))
+	assert.Equal(t, []int{3}, p.Find(byTitle("love")))
+	assert.Empty(t, p.Find(byTitle("intro")), "expected the current item not to be found")
+}
+
+func TestSkipTo(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	ends := make(chan string, 4)
+	onEnd := func(title string) player.SongOption {
+		return player.OnEnd(func(_ time.Duration, err error) {
+			if err == player.ErrSkipped {
+				ends <- title
+			}
+		})
+	}
+
+	// hold the queue
+	paused := make(chan struct{})
+	first, err := p.Enqueue("first", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+		onEnd("first"),
+	)
+	require.NoError(t, err)
+	<-paused
+	current, ok := p.Current()
+	require.True(t, ok)
+	assert.Equal(t, first.ID, current.ID)
+
+	var ids []uint64
+	for _, title := range []string{"second", "third", "fourth"} {
+		track, err := p.Enqueue(title, nil, nil, onEnd(title))
+		require.NoError(t, err)
+		ids = append(ids, track.ID)
+	}
+	assert.True(t, first.ID < ids[0] && ids[0] < ids[1] && ids[1] < ids[2], "expected increasing IDs")
+	assert.Equal(t, ids[1], p.Queue()[1].ID)
+
+	assert.Equal(t, player.ErrNotFound, p.SkipTo(first.ID), "expected the current item not to be found")
+	require.NoError(t, p.SkipTo(ids[2]))
+	assert.Equal(t, "second", <-ends)
+	assert.Equal(t, "third", <-ends)
+	assert.Equal(t, "first", <-ends)
+}
+
+func TestSkipToBeforeStart(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// the first item is dequeued but still opening
+	opening := make(chan struct{})
+	opened := make(chan struct{})
+	firstEnd := make(chan error, 1)
+	_, err := p.Enqueue("first",
+		func() (player.Source, error) {
+			close(opening)
+			<-opened
+			return nopSongOpener()
+		},
+		nopDeviceOpener,
+		player.OnEnd(func(_ time.Duration, err error) {
+			firstEnd <- errors.Cause(err)
+		}),
+	)
+	require.NoError(t, err)
+	<-opening
+
+	started := make(chan struct{})
+	second, err := p.Enqueue("second", nopSongOpener, nopDeviceOpener, player.OnStart(func() {
+		close(started)
+	}))
+	require.NoError(t, err)
+	require.NoError(t, p.SkipTo(second.ID))
+	close(opened)
+	assert.Equal(t, player.ErrSkipped, <-firstEnd, "expected the item to be skipped as soon as it started")
+	<-started
+}
+
+func TestRemoveID(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	ends := make(chan string, 2)
+	onEnd := func(title string) player.SongOption {
+		return player.OnEnd(func(_ time.Duration, err error) {
+			if err == player.ErrRemoved {
+				ends <- title
+			}
+		})
+	}
+
+	// hold the queue
+	paused := make(chan struct{})
+	first, err := p.Enqueue("first", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+		onEnd("first"),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	second, err := p.Enqueue("second", nil, nil, onEnd("second"))
+	require.NoError(t, err)
+	_, err = p.Enqueue("third", nil, nil, onEnd("third"))
+	require.NoError(t, err)
+
+	require.NoError(t, p.RemoveID(second.ID))
+	assert.Equal(t, "second", <-ends)
+	assert.Equal(t, []string{"third"}, p.Playlist())
+	assert.Equal(t, player.ErrNotFound, p.RemoveID(second.ID))
+
+	require.NoError(t, p.RemoveID(first.ID))
+	assert.Equal(t, "first", <-ends, "expected the playing item to be skipped")
+}
+
+func TestTrack(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	calledOnEnd := false
+	track, err := p.Enqueue("", nopSongOpener, nopDeviceOpener, player.OnEnd(func(time.Duration, error) {
+		calledOnEnd = true
+	}))
+	require.NoError(t, err)
+	<-track.Done()
+	assert.True(t, calledOnEnd, "expected Done to close after OnEnd returns")
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, errors.Cause(track.Err()))
+
+	// hold the queue
+	paused := make(chan struct{})
+	_, err = p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	track, err = p.Enqueue("", nil, nil)
+	require.NoError(t, err)
+	assert.NoError(t, track.Err(), "expected no error before the item ends")
+	p.Clear()
+	<-track.Done()
+	assert.Equal(t, player.ErrCleared, track.Err())
+}
+
+func TestCallbackID(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	type end struct {
+		id  uint64
+		err error
+	}
+	ends := make(chan end, 2)
+	onEnd := player.OnEndID(func(id uint64, _ time.Duration, err error) {
+		ends <- end{id, errors.Cause(err)}
+	})
+
+	// hold the queue
+	started := make(chan uint64, 1)
+	paused := make(chan struct{})
+	first, err := p.Enqueue("first", nopSongOpener, nopDeviceOpener,
+		player.OnStartID(func(id uint64) {
+			started <- id
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+		onEnd,
+	)
+	require.NoError(t, err)
+	<-paused
+	assert.Equal(t, first.ID, <-started)
+
+	// items that never start receive their ID too
+	second, err := p.Enqueue("second", nil, nil, onEnd)
+	require.NoError(t, err)
+	require.NoError(t, p.RemoveID(second.ID))
+	assert.Equal(t, end{second.ID, player.ErrRemoved}, <-ends)
+
+	// the item is no longer Current by the time it ends
+	require.NoError(t, p.RemoveID(first.ID))
+	assert.Equal(t, end{first.ID, player.ErrRemoved}, <-ends)
+}
+
+func TestCallbackTimeout(t *testing.T) {
+	t.Parallel()
+	abandoned := make(chan string, 1)
+	p := player.New(player.CallbackTimeout(10*time.Millisecond, func(callback string) {
+		abandoned <- callback
+	}))
+	require.NotNil(t, p)
+
+	hang := make(chan struct{})
+	defer close(hang)
+	track, err := p.Enqueue("", nopSongOpener, nopDeviceOpener, player.OnEnd(func(time.Duration, error) {
+		<-hang
+	}))
+	require.NoError(t, err)
+	<-track.Done()
+	assert.Equal(t, "OnEnd", <-abandoned)
+
+	closed := make(chan error)
+	go func() {
+		closed <- p.Close()
+	}()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(1 * time.Second):
+		t.Fatal("expected a hung callback not to block Close")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+	var calls []string
+	logged := func(name string) player.Middleware {
+		return func(next player.PlayFunc) player.PlayFunc {
+			return func(req player.PlayRequest) (time.Duration, error) {
+				calls = append(calls, name+" "+req.Entry.Title)
+				elapsed, err := next(req)
+				calls = append(calls, name+" done")
+				return elapsed, err
+			}
+		}
+	}
+	limited := errors.New("rate limited")
+	limit := func(next player.PlayFunc) player.PlayFunc {
+		return func(req player.PlayRequest) (time.Duration, error) {
+			if req.Entry.Title == "spam" {
+				return 0, limited
+			}
+			// every item plays to the default device
+			req.OpenDst = nopDeviceOpener
+			return next(req)
+		}
+	}
+	p := player.New(player.Use(logged("outer"), logged("inner"), limit))
+	require.NotNil(t, p)
+	defer p.Close()
+
+	track, err := p.Enqueue("song", nopSongOpener, nil)
+	require.NoError(t, err)
+	<-track.Done()
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, errors.Cause(track.Err()), "expected middleware to provide the device")
+	assert.Equal(t, []string{"outer song", "inner song", "inner done", "outer done"}, calls)
+
+	track, err = p.Enqueue("spam", nopSongOpener, nopDeviceOpener)
+	require.NoError(t, err)
+	<-track.Done()
+	assert.Equal(t, limited, track.Err())
+}
+
+type recordingLogger struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *recordingLogger) Debugf(string, ...interface{}) {}
+func (l *recordingLogger) Infof(string, ...interface{})  {}
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func TestLog(t *testing.T) {
+	t.Parallel()
+	log := &recordingLogger{}
+	p := player.New(player.Log(log))
+	require.NotNil(t, p)
+	defer p.Close()
+
+	track, err := p.Enqueue("", nopSongOpener, func() (io.Writer, error) {
+		return nil, errors.New("no device")
+	})
+	require.NoError(t, err)
+	<-track.Done()
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	require.Len(t, log.errors, 1)
+	assert.Equal(t, fmt.Sprintf("item %v: failed to open device: no device", track.ID), log.errors[0])
+}
+
+type countingMetrics struct {
+	player.Metrics
+	mu          sync.Mutex
+	frames      int
+	bytes       int
+	depths      []int
+	played      []error
+	openFailure []string
+}
+
+func (m *countingMetrics) FrameWritten(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frames++
+	m.bytes += n
+}
+
+func (m *countingMetrics) QueueDepth(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depths = append(m.depths, n)
+}
+
+func (m *countingMetrics) TrackPlayed(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.played = append(m.played, errors.Cause(err))
+}
+
+func (m *countingMetrics) OpenFailed(what string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.openFailure = append(m.openFailure, what)
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	m := &countingMetrics{Metrics: player.NopMetrics}
+	p := player.New(player.Measure(m))
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// hold the queue
+	paused := make(chan struct{})
+	first, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+	second, err := p.Enqueue("", func() (player.Source, error) {
+		return nil, errors.New("no source")
+	}, nopDeviceOpener)
+	require.NoError(t, err)
+	p.Resume()
+	<-first.Done()
+	<-second.Done()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// nopSongOpener plays 11 one byte frames
+	assert.Equal(t, 11, m.frames)
+	assert.Equal(t, 11, m.bytes)
+	// the first item may or may not have been queued before playback polled for it
+	require.True(t, len(m.depths) >= 2)
+	assert.Equal(t, []int{1, 0}, m.depths[len(m.depths)-2:])
+	require.Len(t, m.played, 2)
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, m.played[0])
+	assert.EqualError(t, m.played[1], "no source")
+	assert.Equal(t, []string{"source"}, m.openFailure)
+}
+
+func TestExpvar(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.Expvar("player_test"))
+	require.NotNil(t, p)
+
+	track, err := p.Enqueue("", nopSongOpener, func() (io.Writer, error) {
+		return nil, errors.New("no device")
+	})
+	require.NoError(t, err)
+	<-track.Done()
+	track, err = p.Enqueue("", nopSongOpener, nopDeviceOpener)
+	require.NoError(t, err)
+	<-track.Done()
+
+	vars := func() map[string]interface{} {
+		v := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal([]byte(expvar.Get("player_test").String()), &v))
+		return v
+	}
+	v := vars()
+	assert.EqualValues(t, 2, v["tracks"])
+	assert.EqualValues(t, 1, v["errors"])
+	assert.EqualValues(t, 1, v["open_failures"])
+	assert.EqualValues(t, 11, v["frames"])
+	assert.EqualValues(t, 0, v["queue_length"])
+	p.Close()
+
+	// a new player reuses the map
+	p = player.New(player.Expvar("player_test"))
+	defer p.Close()
+	v = vars()
+	assert.EqualValues(t, 0, v["tracks"])
+	assert.Equal(t, "idle", v["state"])
+
+	// but not while the player that published it is open
+	log := &recordingLogger{}
+	other := player.New(player.Expvar("player_test"), player.Log(log))
+	defer other.Close()
+	track, err = other.Enqueue("", nopSongOpener, nopDeviceOpener)
+	require.NoError(t, err)
+	<-track.Done()
+	v = vars()
+	assert.EqualValues(t, 0, v["tracks"], "expected the other player not to take over the map")
+	assert.Len(t, log.errors, 1)
+}
+
+func TestExpvarConcurrent(t *testing.T) {
+	t.Parallel()
+	// players publishing under a new prefix at once must not publish it twice, which panics
+	var wg sync.WaitGroup
+	players := make(chan *player.Player, 8)
+	for i := 0; i < cap(players); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			players <- player.New(player.Expvar("player_test_concurrent"))
+		}()
+	}
+	wg.Wait()
+	close(players)
+	for p := range players {
+		p.Close()
+	}
+	assert.NotNil(t, expvar.Get("player_test_concurrent"))
+}
+
+func TestDebugHandler(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	track, err := p.Enqueue("broken", nopSongOpener, func() (io.Writer, error) {
+		return nil, errors.New("no device")
+	})
+	require.NoError(t, err)
+	<-track.Done()
+	track, err = p.Enqueue("hello", nopSongOpener, nopDeviceOpener)
+	require.NoError(t, err)
+	<-track.Done()
+
+	srv := httptest.NewServer(player.DebugHandler(p))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var info struct {
+		State        string `json:"state"`
+		RecentErrors []struct {
+			ID    uint64 `json:"id"`
+			Title string `json:"title"`
+		} `json:"recent_errors"`
+		Latencies []float64 `json:"frame_latencies_ms"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, "idle", info.State)
+	require.Len(t, info.RecentErrors, 1)
+	assert.Equal(t, "broken", info.RecentErrors[0].Title)
+	assert.Equal(t, 10, len(info.Latencies))
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/html")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	assert.Contains(t, string(body), "broken")
+}
+
+func TestRecentEvents(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.EventLog(4))
+	require.NotNil(t, p)
+
+	track, err := p.Enqueue("broken", nopSongOpener, func() (io.Writer, error) {
+		return nil, errors.New("no device")
+	})
+	require.NoError(t, err)
+	<-track.Done()
+	track, err = p.Enqueue("hello", nopSongOpener, nopDeviceOpener)
+	require.NoError(t, err)
+	<-track.Done()
+	p.Close()
+
+	// the oldest events fell out of the log
+	events := p.RecentEvents()
+	kinds := make([]player.EventKind, len(events))
+	for i, ev := range events {
+		kinds[i] = ev.Kind
+	}
+	assert.Equal(t, []player.EventKind{player.EventEnqueued, player.EventStarted, player.EventEnded, player.EventClosed}, kinds)
+	assert.Equal(t, track.ID, events[2].ID)
+	assert.Equal(t, "hello", events[2].Title)
+	assert.Equal(t, io.EOF, errors.Cause(events[2].Err))
+	for i := 1; i < len(events); i++ {
+		assert.False(t, events[i].Time.Before(events[i-1].Time))
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.QueueLength(5), player.Outage(player.OutagePark, 3, 0, nil))
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// hold the queue
+	paused := make(chan struct{})
+	current, err := p.Enqueue("current", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+	queued, err := p.Enqueue("queued", nil, nil, player.Duration(time.Minute))
+	require.NoError(t, err)
+
+	s := p.Snapshot()
+	assert.Equal(t, player.StatePaused, s.State)
+	require.NotNil(t, s.Current)
+	assert.Equal(t, current.ID, s.Current.ID)
+	require.Len(t, s.Queue, 1)
+	assert.Equal(t, queued.ID, s.Queue[0].ID)
+	assert.Equal(t, time.Minute, s.QueueDuration)
+	assert.Equal(t, 5, s.Config.QueueLength)
+	assert.Equal(t, player.OutagePark, s.Config.OutagePolicy)
+
+	b, err := json.Marshal(p)
+	require.NoError(t, err)
+	var v map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &v))
+	assert.Equal(t, "paused", v["State"])
+	assert.Equal(t, "current", v["Current"].(map[string]interface{})["Title"])
+	assert.Equal(t, "park", v["Config"].(map[string]interface{})["OutagePolicy"])
+}
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	idle := make(chan struct{}, 1)
+	p := player.New(player.Clock(clk), player.IdleFunc(func() { idle <- struct{}{} }, 1000))
+	require.NotNil(t, p)
+	defer p.Close()
+	<-idle
+
+	track, err := p.Enqueue("", nopSongOpener, nopDeviceOpener)
+	require.NoError(t, err)
+	<-track.Done()
+
+	// no time passes until the clock is advanced
+	clk.BlockUntil(1)
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case <-idle:
+		t.Fatal("player went idle before its timeout")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	select {
+	case <-idle:
+	case <-time.After(1 * time.Second):
+		t.Fatal("player did not go idle after its timeout")
+	}
+
+	paused := make(chan struct{})
+	track, err = p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+	require.NoError(t, p.StopAfter(time.Hour, true))
+	clk.Advance(time.Hour)
+	<-track.Done()
+	assert.Equal(t, player.ErrStopped, errors.Cause(track.Err()))
+	for _, ev := range p.RecentEvents() {
+		assert.False(t, ev.Time.After(time.Unix(0, 0).Add(time.Hour+time.Second)), "expected events at fake times")
+	}
+}
+
+func TestSetQueueLength(t *testing.T) {
+	t.Parallel()
+	p := player.New(player.QueueLength(3))
+	require.NotNil(t, p)
+	defer p.Close()
+
+	// hold the queue
+	paused := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	_, err = p.Enqueue("", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("", nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, p.SetQueueLength(1))
+	_, err = p.Enqueue("", nil, nil)
+	assert.Equal(t, player.ErrFull, err, "expected shrunk queue to reject items")
+	assert.Len(t, p.Queue(), 2, "expected shrunk queue to keep its items")
+
+	require.NoError(t, p.SetQueueLength(4))
+	_, err = p.Enqueue("", nil, nil)
+	assert.NoError(t, err)
+	_, err = p.Enqueue("", nil, nil)
+	assert.NoError(t, err)
+	_, err = p.Enqueue("", nil, nil)
+	assert.Equal(t, player.ErrFull, err)
+
+	require.NoError(t, p.SetQueueLength(0))
+	_, err = p.Enqueue("", nil, nil)
+	assert.NoError(t, err, "expected unbounded queue")
+}
+
+func TestExpiresAt(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	play := func(expiresAt time.Time) error {
+		end := make(chan error, 1)
+		_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+			player.ExpiresAt(expiresAt),
+			player.OnEnd(func(_ time.Duration, err error) {
+				end <- errors.Cause(err)
+			}),
+		)
+		require.NoError(t, err)
+		return <-end
+	}
+
+	assert.Equal(t, player.ErrExpired, play(time.Now().Add(-time.Second)), "expected stale item to be passed over")
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, play(time.Now().Add(time.Minute)), "expected fresh item to play")
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, play(time.Time{}), "expected item without expiry to play")
+}
+
+func TestDuplicates(t *testing.T) {
+	t.Parallel()
+
+	// holds the queue with a playing item whose dedupe key is "playing"
+	hold := func(p *player.Player) {
+		paused := make(chan struct{})
+		_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+			player.DedupeKey("playing"),
+			player.OnStart(func() {
+				p.Pause()
+			}),
+			player.OnPause(func(time.Duration) {
+				close(paused)
+			}),
+		)
+		require.NoError(t, err)
+		<-paused
+	}
+
+	p := player.New(player.Duplicates(player.DuplicatesReject))
+	defer p.Close()
+	hold(p)
+	_, err := p.Enqueue("", nil, nil, player.DedupeKey("playing"))
+	assert.Equal(t, player.ErrDuplicate, err, "expected duplicate of the playing item to be rejected")
+	_, err = p.Enqueue("", nil, nil, player.DedupeKey("queued"))
+	assert.NoError(t, err)
+	_, err = p.Enqueue("", nil, nil, player.DedupeKey("queued"))
+	assert.Equal(t, player.ErrDuplicate, err, "expected duplicate of a queued item to be rejected")
+	_, err = p.Enqueue("", nil, nil)
+	assert.NoError(t, err, "expected items without a key to never be duplicates")
+	_, err = p.Enqueue("", nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, p.Queue(), 3)
+
+	p = player.New(player.Duplicates(player.DuplicatesCoalesce))
+	defer p.Close()
+	hold(p)
+	var endErr error
+	track, err := p.Enqueue("", nil, nil, player.DedupeKey("playing"), player.OnEnd(func(_ time.Duration, err error) {
+		endErr = err
+	}))
+	assert.NoError(t, err, "expected duplicate to be coalesced")
+	current, ok := p.Current()
+	require.True(t, ok)
+	assert.Equal(t, current.ID, track.ID, "expected the track of the item it duplicates")
+	assert.Equal(t, player.ErrDuplicate, endErr)
+	assert.Empty(t, p.Queue())
+
+	p = player.New()
+	defer p.Close()
+	hold(p)
+	_, err = p.Enqueue("", nil, nil, player.DedupeKey("playing"))
+	assert.NoError(t, err, "expected duplicates to be allowed by default")
+}
+
+func TestOutage(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	failing := true
+	openDst := func() (io.Writer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return nil, errors.New("outage")
+		}
+		return ioutil.Discard, nil
+	}
+	ends := make(chan error, 3)
+	onEnd := player.OnEnd(func(_ time.Duration, err error) {
+		ends <- errors.Cause(err)
+	})
+	outages := make(chan error, 1)
+	notify := func(err error) {
+		outages <- errors.Cause(err)
+	}
+
+	p := player.New(player.Outage(player.OutagePark, 2, 0, notify))
+	defer p.Close()
+	require.NoError(t, p.Park())
+	for i := 0; i < 3; i++ {
+		_, err := p.Enqueue("", nopSongOpener, openDst, onEnd)
+		require.NoError(t, err)
+	}
+	assert.Len(t, p.Queue(), 3, "expected parked player to hold the queue")
+	require.NoError(t, p.Unpark())
+
+	assert.EqualError(t, <-ends, "outage", "expected first failure to end the item")
+	assert.EqualError(t, <-outages, "outage")
+	assert.True(t, p.Parked(), "expected player to park itself")
+	assert.Len(t, p.Queue(), 2, "expected second failure to go back in the queue")
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	require.NoError(t, p.Unpark())
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, <-ends, "expected queue to play after unparking")
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, <-ends)
+
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	p = player.New(player.Outage(player.OutageDrop, 1, 0, notify))
+	defer p.Close()
+	require.NoError(t, p.Park())
+	for i := 0; i < 3; i++ {
+		_, err := p.Enqueue("", nopSongOpener, openDst, onEnd)
+		require.NoError(t, err)
+	}
+	require.NoError(t, p.Unpark())
+	assert.Equal(t, player.ErrOutage, <-ends, "expected queued items to be dropped")
+	assert.Equal(t, player.ErrOutage, <-ends)
+	assert.EqualError(t, <-ends, "outage")
+	assert.Empty(t, p.Queue())
+}
+
+func TestSubscribeQueue(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+
+	// hold the queue
+	paused := make(chan struct{})
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener,
+		player.OnStart(func() {
+			p.Pause()
+		}),
+		player.OnPause(func(time.Duration) {
+			close(paused)
+		}),
+	)
+	require.NoError(t, err)
+	<-paused
+
+	updates, unsubscribe := p.SubscribeQueue()
+	other, _ := p.SubscribeQueue()
+	titles := func(entries []player.QueueEntry) []string {
+		var titles []string
+		for _, entry := range entries {
+			titles = append(titles, entry.Title)
+		}
+		return titles
+	}
+
+	_, err = p.Enqueue("first", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first"}, titles(<-updates))
+	_, err = p.Enqueue("second", nil, nil)
+	require.NoError(t, err)
+	_, err = p.Enqueue("third", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second", "third"}, titles(<-updates), "expected only the latest snapshot")
+	p.Clear()
+	assert.Empty(t, <-updates)
+
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-updates
+	assert.False(t, ok, "expected channel to close when unsubscribed")
+
+	assert.Empty(t, <-other)
+	p.Close()
+	for range other {
+		// drain the snapshot of the queue cleared by Close
+	}
+}
+
+func TestNilOpeners(t *testing.T) {
+	t.Parallel()
+
+	play := func(p *player.Player, openSrc player.SourceOpenerFunc, openDst player.DeviceOpenerFunc) error {
+		end := make(chan error, 1)
+		_, err := p.Enqueue("", openSrc, openDst, player.OnEnd(func(_ time.Duration, err error) {
+			end <- err
+		}))
+		require.NoError(t, err, "expected nil openers to be accepted into the queue")
+		return errors.Cause(<-end)
+	}
+
+	p := player.New()
+	defer p.Close()
+	assert.Equal(t, player.ErrNilOpener, play(p, nil, nopDeviceOpener), "expected item without a source to end")
+	assert.Equal(t, player.ErrNilOpener, play(p, nopSongOpener, nil), "expected item without a device to end")
+	assert.Equal(t, player.ErrNilOpener, play(p, nil, nil))
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, play(p, nopSongOpener, nopDeviceOpener), "expected player to keep playing")
+
+	usedDefault := false
+	p = player.New(player.DefaultDevice(func() (io.Writer, error) {
+		usedDefault = true
+		return ioutil.Discard, nil
+	}))
+	defer p.Close()
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, play(p, nopSongOpener, nil))
+	assert.True(t, usedDefault, "expected item without a device to play to the default device")
+	assert.Equal(t, player.ErrNilOpener, play(p, nil, nil), "expected item without a source to end")
+}
+
+func TestStopAfter(t *testing.T) {
+	t.Parallel()
+	p := player.New()
+	require.NotNil(t, p)
+	defer p.Close()
+
+	ends := make(chan error, 3)
+	onEnd := player.OnEnd(func(_ time.Duration, err error) {
+		ends <- errors.Cause(err)
+	})
+	// plays for several seconds in real time
+	_, err := p.Enqueue("", nopSongOpener, nopDeviceOpener, player.Realtime(true), onEnd)
+	require.NoError(t, err)
+	_, err = p.Enqueue("", nopSongOpener, nopDeviceOpener, onEnd)
+	require.NoError(t, err)
+	_, err = p.Enqueue("", nopSongOpener, nopDeviceOpener, onEnd)
+	require.NoError(t, err)
+
+	require.NoError(t, p.StopAfter(50*time.Millisecond, true))
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-ends:
+			assert.Equal(t, player.ErrStopped, err, "expected every item to end when the player stops")
+		case <-time.After(1 * time.Second):
+			t.Fatal("player did not stop")
+		}
+	}
+	assert.Empty(t, p.Playlist(), "expected stopped player to clear its queue")
+
+	require.NoError(t, p.StopAfter(time.Hour, false))
+	assert.True(t, p.CancelStop(), "expected pending stop to be canceled")
+	assert.False(t, p.CancelStop(), "expected no stop to cancel")
+
+	_, err = p.Enqueue("", nopSongOpener, nopDeviceOpener, onEnd)
+	require.NoError(t, err)
+	assert.Contains(t, []error{io.EOF, io.ErrUnexpectedEOF}, <-ends, "expected stopped player to keep playing new items")
+}
+
+func TestFormatProgress(t *testing.T) {
+	assert.Equal(t, "━━━━━●──── 01:00 / 02:00", player.FormatProgress(time.Minute, 2*time.Minute, 10))
+	assert.Equal(t, "●───────── 00:00 / 02:00", player.FormatProgress(0, 2*time.Minute, 10))
+	assert.Equal(t, "━━━━━━━━━● 02:00 / 02:00", player.FormatProgress(3*time.Minute, 2*time.Minute, 10), "expected elapsed to be clamped to total")
+	assert.Equal(t, "00:59 / 02:00", player.FormatProgress(59999*time.Millisecond, 2*time.Minute, 0), "expected timestamps to be truncated")
+	assert.Equal(t, "0:01:05 / 1:30:00", player.FormatProgress(65*time.Second, 90*time.Minute, 0))
+	assert.Equal(t, "01:05", player.FormatProgress(65*time.Second, 0, 10), "expected only elapsed time when total is unknown")
+}
@@ -0,0 +1,47 @@
+package player
+
+import "sync"
+
+// frame buffers shared by the play loop and pooled sources,
+// and spare pointers to put them in the pool with so that freeing a frame does not allocate
+var framePool, spares sync.Pool
+
+// PooledSource is implemented by sources whose frames are allocated with AllocFrame.
+// If PooledFrames reports true the player returns each frame to the pool with FreeFrame
+// once it has been written to the device, so the source must not retain its frames.
+type PooledSource interface {
+	Source
+	PooledFrames() bool
+}
+
+// AllocFrame returns a frame buffer of length n, reusing a pooled buffer if one is large enough.
+func AllocFrame(n int) []byte {
+	if b, ok := framePool.Get().(*[]byte); ok {
+		frame := *b
+		*b = nil
+		spares.Put(b)
+		if cap(frame) >= n {
+			return frame[:n]
+		}
+	}
+	return make([]byte, n)
+}
+
+// FreeFrame returns a frame buffer to the pool.
+// The frame must not be used after it is freed.
+func FreeFrame(frame []byte) {
+	if cap(frame) == 0 {
+		return
+	}
+	b, ok := spares.Get().(*[]byte)
+	if !ok {
+		b = new([]byte)
+	}
+	*b = frame
+	framePool.Put(b)
+}
+
+func isPooled(src Source) bool {
+	ps, ok := src.(PooledSource)
+	return ok && ps.PooledFrames()
+}
@@ -0,0 +1,153 @@
+// Package probe reads the metadata of media with ffprobe,
+// so items can be enqueued with their real titles and durations.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/pkg/errors"
+)
+
+// DefaultTimeout bounds how long Duration, Metadata, and Resolver wait for ffprobe,
+// which can take a while to open remote media.
+var DefaultTimeout = 15 * time.Second
+
+// FFprobe is the ffprobe executable, "ffprobe" in the PATH by default.
+var FFprobe = "ffprobe"
+
+// Info is what ffprobe reports about media and its first audio stream.
+type Info struct {
+	Title  string
+	Artist string
+	Album  string
+	// Duration is 0 if it is unknown, e.g. for live streams.
+	Duration time.Duration
+	// Format is the name of the container, e.g. "mov,mp4,m4a,3gp,3g2,mj2" or "ogg".
+	Format string
+	// Codec is the codec of the audio, e.g. "aac" or "opus".
+	Codec      string
+	SampleRate int
+	Channels   int
+	// Bitrate is the overall bitrate in b/s, or 0 if it is unknown.
+	Bitrate int
+	// Tags are the tags of the container followed by the tags of the audio stream, with lowercase keys.
+	Tags map[string]string
+}
+
+// output is the subset of the json that ffprobe prints.
+type output struct {
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecName  string            `json:"codec_name"`
+		SampleRate string            `json:"sample_rate"`
+		Channels   int               `json:"channels"`
+		Duration   string            `json:"duration"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+// Probe runs ffprobe on a file or a URL, requiring ffprobe, see FFprobe.
+// ffprobe is killed if ctx is done first.
+func Probe(ctx context.Context, pathOrURL string) (Info, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, FFprobe, "-v", "error", "-print_format", "json",
+		"-show_format", "-show_streams", "-select_streams", "a:0", pathOrURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return Info{}, errors.Wrapf(err, "failed to probe %v: %s", pathOrURL, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parse(stdout.Bytes())
+}
+
+func parse(data []byte) (Info, error) {
+	var out output
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Info{}, errors.Wrap(err, "failed to parse ffprobe output")
+	}
+	info := Info{
+		Format:   out.Format.FormatName,
+		Duration: seconds(out.Format.Duration),
+		Tags:     make(map[string]string),
+	}
+	info.Bitrate, _ = strconv.Atoi(out.Format.BitRate)
+	addTags(info.Tags, out.Format.Tags)
+	if len(out.Streams) > 0 {
+		stream := out.Streams[0]
+		info.Codec = stream.CodecName
+		info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+		info.Channels = stream.Channels
+		if info.Duration == 0 {
+			info.Duration = seconds(stream.Duration)
+		}
+		addTags(info.Tags, stream.Tags)
+	}
+	info.Title, info.Artist, info.Album = info.Tags["title"], info.Tags["artist"], info.Tags["album"]
+	return info, nil
+}
+
+// addTags adds tags that are not already set, the case of tag keys varies between containers.
+func addTags(dst, tags map[string]string) {
+	for k, v := range tags {
+		k = strings.ToLower(k)
+		if _, ok := dst[k]; !ok {
+			dst[k] = v
+		}
+	}
+}
+
+// seconds parses a duration in seconds, which is "N/A" if it is unknown.
+func seconds(s string) time.Duration {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return time.Duration(f * float64(time.Second))
+}
+
+// Metadata probes a file or a URL, giving up after DefaultTimeout.
+func Metadata(pathOrURL string) (Info, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return Probe(ctx, pathOrURL)
+}
+
+// Duration probes how long a file or a URL plays, giving up after DefaultTimeout.
+// Duration returns an error if the duration is unknown.
+func Duration(pathOrURL string) (time.Duration, error) {
+	info, err := Metadata(pathOrURL)
+	if err != nil {
+		return 0, err
+	}
+	if info.Duration == 0 {
+		return 0, errors.Errorf("duration of %v is unknown", pathOrURL)
+	}
+	return info.Duration, nil
+}
+
+// Resolver returns a player.ResolverFunc that probes a file or a URL,
+// so an item enqueued with the Resolve option gets the media's title, if it has one, and duration.
+func Resolver(pathOrURL string) player.ResolverFunc {
+	return func() (player.Metadata, error) {
+		info, err := Metadata(pathOrURL)
+		if err != nil {
+			return player.Metadata{}, err
+		}
+		return player.Metadata{Title: info.Title, Duration: info.Duration, URL: pathOrURL}, nil
+	}
+}
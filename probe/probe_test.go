@@ -0,0 +1,137 @@
+package probe_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/probe"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnv makes the test binary act as ffprobe, see TestMain.
+const fakeEnv = "PROBE_FAKE_FFPROBE"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEnv) == "1" {
+		os.Exit(runFakeFFprobe(os.Args[len(os.Args)-1]))
+	}
+	// the tests run the test binary as ffprobe
+	os.Setenv(fakeEnv, "1")
+	probe.FFprobe = os.Args[0]
+	os.Exit(m.Run())
+}
+
+// runFakeFFprobe prints the stored output of ffprobe in a file, or never finishes probing "hang".
+func runFakeFFprobe(path string) int {
+	if path == "hang" {
+		time.Sleep(time.Minute)
+		return 0
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: No such file or directory\n", path)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+	info, err := probe.Probe(context.Background(), "testdata/song.json")
+	require.NoError(t, err)
+	assert.Equal(t, probe.Info{
+		Title:      "Song",
+		Artist:     "Artist",
+		Album:      "Album",
+		Duration:   207677551 * time.Microsecond,
+		Format:     "mp3",
+		Codec:      "mp3",
+		SampleRate: 44100,
+		Channels:   2,
+		Bitrate:    192039,
+		Tags: map[string]string{
+			"title":   "Song",
+			"artist":  "Artist",
+			"album":   "Album",
+			"genre":   "Rock",
+			"encoder": "LAME3.99r",
+		},
+	}, info)
+}
+
+func TestProbeStreamTags(t *testing.T) {
+	t.Parallel()
+	info, err := probe.Probe(context.Background(), "testdata/vorbis.json")
+	require.NoError(t, err)
+	// the keys of tags are lowercase, and the container's tags come before the stream's
+	assert.Equal(t, "Stream Title", info.Title)
+	assert.Equal(t, "Container Artist", info.Artist)
+	assert.Equal(t, "", info.Album)
+	assert.Equal(t, "Lavf58.29.100", info.Tags["encoder"])
+	// the stream's duration is used if the container does not know its own
+	assert.Equal(t, 3*time.Second, info.Duration)
+	assert.Equal(t, "ogg", info.Format)
+	assert.Equal(t, "vorbis", info.Codec)
+	assert.Equal(t, 48000, info.SampleRate)
+	assert.Equal(t, 1, info.Channels)
+	assert.Equal(t, 0, info.Bitrate)
+}
+
+func TestProbeLive(t *testing.T) {
+	t.Parallel()
+	info, err := probe.Probe(context.Background(), "testdata/live.json")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), info.Duration)
+	assert.Equal(t, 0, info.Bitrate)
+	assert.Equal(t, "aac", info.Codec)
+	assert.Empty(t, info.Tags)
+
+	_, err = probe.Duration("testdata/live.json")
+	assert.Error(t, err, "expected the duration of a live stream to be unknown")
+}
+
+func TestProbeMalformed(t *testing.T) {
+	t.Parallel()
+	for _, path := range []string{"testdata/truncated.json", "testdata/number.json"} {
+		_, err := probe.Probe(context.Background(), path)
+		assert.Error(t, err, "expected %v to fail to parse", path)
+	}
+}
+
+func TestProbeFails(t *testing.T) {
+	t.Parallel()
+	_, err := probe.Probe(context.Background(), "testdata/missing.mp3")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "No such file or directory", "expected ffprobe's error")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = probe.Probe(ctx, "hang")
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+}
+
+func TestDuration(t *testing.T) {
+	t.Parallel()
+	d, err := probe.Duration("testdata/song.json")
+	require.NoError(t, err)
+	assert.Equal(t, 207677551*time.Microsecond, d)
+	_, err = probe.Duration("testdata/missing.mp3")
+	assert.Error(t, err)
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+	md, err := probe.Resolver("testdata/song.json")()
+	require.NoError(t, err)
+	assert.Equal(t, player.Metadata{Title: "Song", Duration: 207677551 * time.Microsecond, URL: "testdata/song.json"}, md)
+	_, err = probe.Resolver("testdata/truncated.json")()
+	assert.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package player
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FormatProgress renders a progress bar width characters wide followed by mm:ss timestamps,
+// e.g. "━━━━●─────── 01:23 / 04:56", for the elapsed time reported by OnProgress, OnEnd, or Player.Elapsed.
+// Timestamps switch to h:mm:ss for items an hour or longer, and are truncated to the second like a media player's.
+// Only the elapsed time is rendered if the total is unknown, i.e. not positive,
+// and only the timestamps if width is less than 1.
+func FormatProgress(elapsed time.Duration, total time.Duration, width int) string {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if total <= 0 {
+		return formatTimestamp(elapsed, elapsed >= time.Hour)
+	}
+	if elapsed > total {
+		elapsed = total
+	}
+	hours := total >= time.Hour
+	stamps := formatTimestamp(elapsed, hours) + " / " + formatTimestamp(total, hours)
+	if width < 1 {
+		return stamps
+	}
+
+	knob := int(int64(elapsed) * int64(width) / int64(total))
+	if knob >= width {
+		knob = width - 1
+	}
+	return strings.Repeat("━", knob) + "●" + strings.Repeat("─", width-knob-1) + " " + stamps
+}
+
+func formatTimestamp(d time.Duration, hours bool) string {
+	secs := int64(d / time.Second)
+	if hours {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%02d:%02d", secs/60, secs%60)
+}
@@ -0,0 +1,132 @@
+// Package resample converts PCM between sample rates, in particular to the 48kHz that discord requires,
+// e.g. so the 44.1kHz output of the mp3 package can be encoded for a voice channel without ffmpeg.
+package resample
+
+import (
+	"math"
+)
+
+// samples of each channel on either side of an output sample that contribute to it
+const taps = 16
+
+// Resampler converts interleaved samples from one sample rate to another with a windowed sinc filter,
+// keeping the samples it needs from earlier calls so a stream can be converted in chunks of any size.
+type Resampler struct {
+	channels int
+	// the ratio of the rates reduced to up/down, an output sample is every down/up input samples
+	up, down int
+	// coefficients of each of the up phases
+	filters [][]float32
+	// input samples of each channel that are still needed, and the output sample that is produced next
+	buf [][]float32
+	n   int64
+	// channel of the next input sample, chunks need not hold whole samples of every channel
+	ch int
+	// index into buf of input sample 0 of the stream
+	base int64
+}
+
+// New creates a Resampler from the rate to the rate in Hz for interleaved samples with the channels.
+func New(from int, to int, channels int) *Resampler {
+	g := gcd(from, to)
+	r := &Resampler{
+		channels: channels,
+		up:       to / g,
+		down:     from / g,
+		buf:      make([][]float32, channels),
+	}
+	// a filter below the lower of the rates' nyquist frequencies keeps the output from aliasing
+	cutoff := math.Min(1, float64(to)/float64(from))
+	r.filters = make([][]float32, r.up)
+	for p := range r.filters {
+		frac := float64(p) / float64(r.up)
+		coeffs := make([]float32, 2*taps)
+		for k := range coeffs {
+			x := float64(k-taps+1) - frac
+			coeffs[k] = float32(cutoff * sinc(cutoff*x) * blackman(x/taps))
+		}
+		r.filters[p] = coeffs
+	}
+	return r
+}
+
+// Process returns the converted samples that can be produced from the samples so far, appended to dst.
+func (r *Resampler) Process(dst []int16, samples []int16) []int16 {
+	for _, s := range samples {
+		r.buf[r.ch] = append(r.buf[r.ch], float32(s))
+		r.ch = (r.ch + 1) % r.channels
+	}
+	return r.produce(dst, 0)
+}
+
+// Flush returns the converted samples still held back for the samples after them, appended to dst,
+// as if the stream ended in silence.
+func (r *Resampler) Flush(dst []int16) []int16 {
+	return r.produce(dst, taps)
+}
+
+// produce converts samples as long as every sample they need has arrived, treating pad missing samples as silence.
+func (r *Resampler) produce(dst []int16, pad int) []int16 {
+	// the last channel holds only the samples that arrived for every channel
+	have := int64(len(r.buf[r.channels-1])) + r.base
+	for {
+		pos := r.n * int64(r.down) / int64(r.up)
+		phase := int(r.n * int64(r.down) % int64(r.up))
+		if pos+taps >= have+int64(pad) {
+			break
+		}
+		coeffs := r.filters[phase]
+		for c := 0; c < r.channels; c++ {
+			sum := float32(0)
+			for k, h := range coeffs {
+				j := pos - taps + 1 + int64(k) - r.base
+				if j >= 0 && j < int64(len(r.buf[c])) {
+					sum += r.buf[c][j] * h
+				}
+			}
+			dst = append(dst, clip(sum))
+		}
+		r.n++
+	}
+	// forget samples no longer needed
+	pos := r.n*int64(r.down)/int64(r.up) - taps + 1 - r.base
+	if pos > 0 && pad == 0 {
+		for c := range r.buf {
+			r.buf[c] = r.buf[c][:copy(r.buf[c], r.buf[c][pos:])]
+		}
+		r.base += pos
+	}
+	return dst
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman is the Blackman window over [-1, 1].
+func blackman(x float64) float64 {
+	if x < -1 || x > 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*x) + 0.08*math.Cos(2*math.Pi*x)
+}
+
+func clip(s float32) int16 {
+	if s > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if s < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(s)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
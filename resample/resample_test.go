@@ -0,0 +1,131 @@
+package resample_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jeffreymkabot/discordvoice/resample"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sine is n samples of each channel of a tone at the rate, the same in every channel.
+func sine(rate int, freq float64, n int, channels int, amplitude float64) []int16 {
+	samples := make([]int16, 0, n*channels)
+	for i := 0; i < n; i++ {
+		s := int16(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+		for c := 0; c < channels; c++ {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+func convert(from int, to int, channels int, samples []int16) []int16 {
+	r := resample.New(from, to, channels)
+	return r.Flush(r.Process(nil, samples))
+}
+
+func TestResampler(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		from, to int
+		freq     float64
+	}{
+		{from: 44100, to: 48000, freq: 1000},
+		{from: 44100, to: 48000, freq: 18000},
+		{from: 22050, to: 48000, freq: 1000},
+		{from: 8000, to: 48000, freq: 1000},
+		{from: 96000, to: 48000, freq: 1000},
+	}
+	for _, c := range cases {
+		// a second of the tone plays for a second at the new rate
+		out := convert(c.from, c.to, 1, sine(c.from, c.freq, c.from, 1, 10000))
+		require.Len(t, out, c.to)
+		// and is the same tone, but for the edges that the filter sees as following silence
+		maxErr := 0.0
+		for i := 100; i < len(out)-100; i++ {
+			want := 10000 * math.Sin(2*math.Pi*c.freq*float64(i)/float64(c.to))
+			maxErr = math.Max(maxErr, math.Abs(float64(out[i])-want))
+		}
+		assert.True(t, maxErr < 10, "expected %vHz at %vHz to convert to %vHz within 0.1%%, off by %v", c.freq, c.from, c.to, maxErr)
+	}
+}
+
+func TestResamplerAliasing(t *testing.T) {
+	t.Parallel()
+	// a tone above the nyquist frequency of the new rate is filtered out instead of folding back
+	out := convert(96000, 48000, 1, sine(96000, 30000, 96000, 1, 10000))
+	var power float64
+	for _, s := range out[100 : len(out)-100] {
+		power += float64(s) * float64(s)
+	}
+	rms := math.Sqrt(power / float64(len(out)-200))
+	assert.True(t, rms < 100, "expected a 30kHz tone to be attenuated below 1%%, not %v", rms)
+}
+
+func TestResamplerSameRate(t *testing.T) {
+	t.Parallel()
+	in := sine(48000, 1000, 4800, 2, 30000)
+	assert.Equal(t, in, convert(48000, 48000, 2, in))
+}
+
+func TestResamplerChunks(t *testing.T) {
+	t.Parallel()
+	in := sine(44100, 440, 4410, 2, 10000)
+	whole := convert(44100, 48000, 2, in)
+	require.Len(t, whole, 2*4800)
+
+	// a stream converts the same in chunks of any size, even an odd number of samples
+	for _, size := range []int{1, 3, 882, 2000} {
+		r := resample.New(44100, 48000, 2)
+		var out []int16
+		for i := 0; i < len(in); i += size {
+			end := i + size
+			if end > len(in) {
+				end = len(in)
+			}
+			out = r.Process(out, in[i:end])
+		}
+		out = r.Flush(out)
+		assert.Equal(t, whole, out, "expected chunks of %v samples to convert the same as the whole stream", size)
+	}
+}
+
+func TestResamplerChannels(t *testing.T) {
+	t.Parallel()
+	// a tone in the left channel and silence in the right
+	left := sine(44100, 1000, 4410, 1, 10000)
+	in := make([]int16, 2*len(left))
+	for i, s := range left {
+		in[2*i] = s
+	}
+	out := convert(44100, 48000, 2, in)
+	mono := convert(44100, 48000, 1, left)
+	require.Len(t, out, 2*len(mono))
+	for i, s := range mono {
+		assert.Equal(t, s, out[2*i])
+		assert.Equal(t, int16(0), out[2*i+1])
+	}
+}
+
+func TestResamplerClips(t *testing.T) {
+	t.Parallel()
+	// the filter's ripple overshoots a full scale signal, which clips instead of wrapping around
+	in := make([]int16, 4410)
+	for i := range in {
+		in[i] = math.MaxInt16
+		if i/10%2 == 1 {
+			in[i] = math.MinInt16
+		}
+	}
+	out := convert(44100, 48000, 1, in)
+	for i := 100; i < len(out)-100; i++ {
+		// the samples around each edge of the square wave are close to zero
+		if math.Abs(math.Mod(float64(i)*44100/48000+5, 10)-5) < 2 {
+			continue
+		}
+		wantPositive := int(float64(i)*44100/48000)/10%2 == 0
+		assert.Equal(t, wantPositive, out[i] > 0, "expected sample %v to keep its sign", i)
+	}
+}
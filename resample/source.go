@@ -0,0 +1,75 @@
+package resample
+
+import (
+	"io"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+)
+
+// Source provides standard PCM frames converted from a source of PCM in another format, see the pcm package.
+type Source struct {
+	src       player.Source
+	from      pcm.Format
+	resampler *Resampler
+	framer    *pcm.Framer
+	ended     bool
+}
+
+// NewSource produces a source of standard PCM frames from src, whose interleaved 16-bit little-endian PCM is in the format,
+// e.g. pcm.Format{SampleRate: 44100, Channels: 2} for the mp3 package.
+// Channels are remixed like pcm.Remix. If src implements io.Closer it will be closed when the source is closed.
+func NewSource(src player.Source, from pcm.Format) *Source {
+	return &Source{
+		src:       src,
+		from:      from,
+		resampler: New(from.SampleRate, pcm.SampleRate, pcm.Channels),
+		framer:    pcm.NewFramer(pcm.FrameLen),
+	}
+}
+
+// ReadFrame implements player.SourceCloser.
+func (s *Source) ReadFrame() ([]byte, error) {
+	samples, ok := s.framer.Next()
+	for !ok && !s.ended {
+		frame, err := s.src.ReadFrame()
+		in := pcm.Decode(make([]int16, 0, len(frame)/2), frame)
+		if ps, pooled := s.src.(player.PooledSource); pooled && ps.PooledFrames() {
+			player.FreeFrame(frame)
+		}
+		if s.from.Channels != pcm.Channels {
+			in = pcm.Remix(make([]int16, 0, len(in)/s.from.Channels*pcm.Channels), in, s.from.Channels, pcm.Channels)
+		}
+		s.framer.Write(s.resampler.Process(nil, in))
+		if err == io.EOF {
+			s.ended = true
+			s.framer.Write(s.resampler.Flush(nil))
+		} else if err != nil {
+			return nil, err
+		}
+		samples, ok = s.framer.Next()
+	}
+	if !ok {
+		if samples, ok = s.framer.Flush(); !ok {
+			return nil, io.EOF
+		}
+	}
+	return pcm.Encode(make([]byte, 0, pcm.FrameBytes), samples), nil
+}
+
+// FrameDuration implements player.SourceCloser.
+func (s *Source) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+// Close implements player.SourceCloser.
+func (s *Source) Close() error {
+	if c, ok := s.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// do not compile unless Source implements player.SourceCloser
+var _ player.SourceCloser = &Source{}
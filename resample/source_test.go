@@ -0,0 +1,112 @@
+package resample_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/jeffreymkabot/discordvoice/resample"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pcmSource plays samples in frames of size samples of each channel and then ends with err, or io.EOF if err is nil.
+type pcmSource struct {
+	frames [][]byte
+	err    error
+	closed bool
+}
+
+func newPCMSource(samples []int16, size int, channels int) *pcmSource {
+	s := &pcmSource{}
+	for len(samples) > 0 {
+		n := size * channels
+		if n > len(samples) {
+			n = len(samples)
+		}
+		s.frames = append(s.frames, pcm.Encode(nil, samples[:n]))
+		samples = samples[n:]
+	}
+	return s
+}
+
+func (s *pcmSource) ReadFrame() ([]byte, error) {
+	if len(s.frames) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame, nil
+}
+
+func (s *pcmSource) FrameDuration() time.Duration {
+	return 26 * time.Millisecond
+}
+
+func (s *pcmSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+// readAll reads the samples of every frame of a source until it ends or fails.
+func readAll(t *testing.T, src *resample.Source) ([]int16, error) {
+	var samples []int16
+	for {
+		frame, err := src.ReadFrame()
+		if err != nil {
+			return samples, err
+		}
+		require.Len(t, frame, pcm.FrameBytes)
+		samples = pcm.Decode(samples, frame)
+	}
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	// a second of the frames of an mp3 at 44.1kHz
+	in := sine(44100, 1000, 44100, 2, 10000)
+	mp3 := newPCMSource(in, 1152, 2)
+	src := resample.NewSource(mp3, pcm.Format{SampleRate: 44100, Channels: 2})
+	assert.Equal(t, pcm.FrameDuration, src.FrameDuration())
+
+	out, err := readAll(t, src)
+	assert.Equal(t, io.EOF, err)
+	assert.Len(t, out, 50*pcm.FrameLen)
+	assert.Equal(t, convert(44100, 48000, 2, in), out)
+
+	require.NoError(t, src.Close())
+	assert.True(t, mp3.closed)
+}
+
+func TestSourceRemix(t *testing.T) {
+	t.Parallel()
+	// 30ms of mono audio at 22.05kHz, whose last frame is padded with silence
+	in := sine(22050, 1000, 661, 1, 10000)
+	src := resample.NewSource(newPCMSource(in, 100, 1), pcm.Format{SampleRate: 22050, Channels: 1})
+	out, err := readAll(t, src)
+	assert.Equal(t, io.EOF, err)
+	require.Len(t, out, 2*pcm.FrameLen)
+
+	mono := convert(22050, 48000, 1, in)
+	require.Len(t, mono, 1439)
+	for i, s := range mono {
+		assert.Equal(t, s, out[2*i], "expected both channels to play the mono sample %v", i)
+		assert.Equal(t, s, out[2*i+1], "expected both channels to play the mono sample %v", i)
+	}
+	assert.Equal(t, make([]int16, 2*(2*pcm.FrameSamples-len(mono))), out[2*len(mono):])
+}
+
+func TestSourceFails(t *testing.T) {
+	t.Parallel()
+	failing := newPCMSource(sine(44100, 1000, 4410, 2, 10000), 1152, 2)
+	failing.err = errors.New("failed")
+	src := resample.NewSource(failing, pcm.Format{SampleRate: 44100, Channels: 2})
+	out, err := readAll(t, src)
+	assert.Equal(t, failing.err, err)
+	assert.True(t, len(out) < 4800*2, "expected the samples held back for the end of the stream not to play")
+}
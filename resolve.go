@@ -0,0 +1,157 @@
+package player
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata is what a ResolverFunc learns about an item before it plays.
+type Metadata struct {
+	// Title replaces the title the item was enqueued with, if not empty.
+	Title string
+	// Duration is used as if passed to the Duration option, if the item was enqueued without one.
+	Duration time.Duration
+	// URL locates the item's media, e.g. a stream URL extracted from a web page.
+	URL string
+}
+
+// ResolverFunc looks up the metadata of an item, e.g. by querying a web service or probing the media.
+// A ResolverFunc usually shares what it finds with the item's SourceOpenerFunc,
+// e.g. so that the source opens the stream URL that was resolved instead of resolving it again.
+type ResolverFunc func() (Metadata, error)
+
+// Resolution is the progress of resolving the metadata of a queued item.
+type Resolution int
+
+const (
+	// ResolveNone means the item was enqueued without a ResolverFunc.
+	ResolveNone Resolution = iota
+	// ResolvePending means the item is waiting for a resolver worker.
+	ResolvePending
+	// ResolveRunning means the item's ResolverFunc is running.
+	ResolveRunning
+	// ResolveDone means the item's metadata is resolved.
+	ResolveDone
+	// ResolveFailed means the item's ResolverFunc returned an error, the item ends with the error when it would start playing.
+	ResolveFailed
+)
+
+func (r Resolution) String() string {
+	switch r {
+	case ResolveNone:
+		return "none"
+	case ResolvePending:
+		return "pending"
+	case ResolveRunning:
+		return "running"
+	case ResolveDone:
+		return "done"
+	case ResolveFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the resolution by its name, e.g. in a Snapshot.
+func (r Resolution) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// resolution tracks the metadata of an item, guarded by the player's mu.
+type resolution struct {
+	resolver ResolverFunc
+	state    Resolution
+	url      string
+	err      error
+	// closed once the resolver returns
+	done chan struct{}
+}
+
+// resolve runs a resolver worker that resolves queued items in the order they will play.
+func (p *Player) resolve() {
+	for {
+		song := p.nextUnresolved()
+		if song == nil {
+			select {
+			case <-p.quit:
+				return
+			case <-p.resolveWake:
+			}
+			continue
+		}
+		// let another worker pick up the next item in the meantime
+		p.wakeResolvers()
+		p.runResolver(song)
+	}
+}
+
+func (p *Player) wakeResolvers() {
+	select {
+	case p.resolveWake <- struct{}{}:
+	default:
+	}
+}
+
+// nextUnresolved claims the first queued item that is waiting to be resolved.
+func (p *Player) nextUnresolved() *songItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, song := range p.queue {
+		if song.resolution.state == ResolvePending {
+			song.resolution.state = ResolveRunning
+			return song
+		}
+	}
+	return nil
+}
+
+func (p *Player) runResolver(song *songItem) {
+	md, err := song.resolver()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		song.resolution.state = ResolveFailed
+		song.resolution.err = errors.Wrap(err, "failed to resolve item")
+		p.cfg.Logger.Errorf("item %v: %v", song.seq, song.resolution.err)
+	} else {
+		song.resolution.state = ResolveDone
+		if md.Title != "" {
+			song.title = md.Title
+		}
+		if song.duration <= 0 {
+			song.duration = md.Duration
+		}
+		song.resolution.url = md.URL
+	}
+	close(song.resolution.done)
+	p.queueChanged()
+}
+
+// awaitResolved resolves an item that is about to play if no worker got to it first.
+func (p *Player) awaitResolved(song *songItem) error {
+	p.mu.Lock()
+	state := song.resolution.state
+	if state == ResolvePending {
+		song.resolution.state = ResolveRunning
+	}
+	p.mu.Unlock()
+
+	switch state {
+	case ResolveNone:
+		return nil
+	case ResolvePending:
+		p.runResolver(song)
+	default:
+		select {
+		case <-p.quit:
+			return ErrClosed
+		case <-song.resolution.done:
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return song.resolution.err
+}
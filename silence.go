@@ -0,0 +1,47 @@
+package player
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	// samples quieter than about -50dBFS are silent
+	silenceLevel = 100
+	// silent sections shorter than this are pauses within the sound, e.g. between words or notes
+	minSilence = 1 * time.Second
+	// how much of the source is scanned for the next sound before playback resumes anyway,
+	// e.g. so a live stream of silence cannot be fast-forwarded forever
+	maxFastForward = 10 * time.Minute
+)
+
+// SkipToNextSound fast-forwards the current item past its next silent section,
+// e.g. past a long silent intro or to a hidden track after a long outro, rather than skipping the whole item.
+// Playback resumes with the first frame of sound after at least a second of silence,
+// and the item ends as it would at the end of its source if there is no such sound.
+// At most ten minutes of the source are scanned, playback resumes where the scan stopped if no sound was found by then.
+// Skip, Pause, JumpTo, and other requests for the item stop the scan and are handled from where it stopped.
+// Silence detection requires that the source produces interleaved 16-bit little-endian PCM, e.g. sources from the mp3 package,
+// so SkipToNextSound returns ErrNotPCM if the playing item's source is not PCM, see PCMSource.
+func (p *Player) SkipToNextSound() error {
+	p.ctrlMu.Lock()
+	notPCM := p.playing && !p.sourcePCM
+	p.ctrlMu.Unlock()
+	if notPCM {
+		return ErrNotPCM
+	}
+	return p.controlPlaying(func(c *control) {
+		c.skipSilence = true
+	})
+}
+
+// silentPCM reports whether every interleaved 16-bit little-endian sample in the frame is below the silence level.
+func silentPCM(frame []byte) bool {
+	for i := 0; i+1 < len(frame); i += 2 {
+		s := int16(binary.LittleEndian.Uint16(frame[i:]))
+		if s > silenceLevel || s < -silenceLevel {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package player
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Snapshot is a machine-readable dump of a player, e.g. to attach to bug reports or inspect from support tooling.
+type Snapshot struct {
+	// Time is when the snapshot was taken.
+	Time   time.Time
+	State  State
+	Parked bool
+	// Current is the item that is playing or about to play, nil if there is none.
+	Current *QueueEntry `json:",omitempty"`
+	// Elapsed is how long the current item has played.
+	Elapsed       time.Duration
+	Queue         []QueueEntry
+	QueueDuration time.Duration
+	Config        ConfigSnapshot
+}
+
+// ConfigSnapshot describes the Options a player was created with.
+// Functions like the IdleFunc are only reported as set or not.
+type ConfigSnapshot struct {
+	QueueLength     int
+	IdleTimeout     time.Duration
+	SubQueues       []string
+	MergePolicy     MergePolicy
+	Duplicates      DuplicatePolicy
+	DefaultDevice   bool
+	Resolvers       int
+	AmbientDuck     float64
+	OutagePolicy    OutagePolicy
+	OutageThreshold int
+	OutageRetry     time.Duration
+	Transition      bool
+	TransitionGain  float64
+	TransitionLead  time.Duration
+	CallbackTimeout time.Duration
+	Middleware      int
+	ExpvarPrefix    string
+	EventLog        int
+}
+
+// Snapshot describes the player's configuration, state, current item, and queue at once.
+func (p *Player) Snapshot() Snapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s := Snapshot{
+		Time:   p.cfg.Clock.Now(),
+		State:  p.State(),
+		Parked: p.parked,
+		Queue:  p.entries(),
+		Config: ConfigSnapshot{
+			QueueLength:     p.cfg.QueueLength,
+			IdleTimeout:     time.Duration(p.cfg.IdleTimeout) * time.Millisecond,
+			SubQueues:       append([]string(nil), p.cfg.SubQueues...),
+			MergePolicy:     p.cfg.MergePolicy,
+			Duplicates:      p.cfg.Duplicates,
+			DefaultDevice:   p.cfg.DefaultDevice != nil,
+			Resolvers:       p.cfg.Resolvers,
+			AmbientDuck:     p.cfg.AmbientDuck,
+			OutagePolicy:    p.cfg.OutagePolicy,
+			OutageThreshold: p.cfg.OutageThreshold,
+			OutageRetry:     p.cfg.OutageRetry,
+			Transition:      p.cfg.TransitionSrc != nil,
+			TransitionGain:  p.cfg.TransitionGain,
+			TransitionLead:  p.cfg.TransitionLead,
+			CallbackTimeout: p.cfg.CallbackTimeout,
+			Middleware:      len(p.cfg.Middleware),
+			ExpvarPrefix:    p.cfg.ExpvarPrefix,
+			EventLog:        p.cfg.EventLog,
+		},
+	}
+	if p.current != nil {
+		current := p.current.entry()
+		s.Current = &current
+		s.Elapsed, _ = p.Elapsed()
+	}
+	for _, entry := range s.Queue {
+		s.QueueDuration += entry.Duration
+	}
+	s.QueueDuration += p.remaining()
+	return s
+}
+
+// MarshalJSON encodes a Snapshot of the player.
+func (p *Player) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Snapshot())
+}
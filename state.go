@@ -0,0 +1,78 @@
+package player
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// State is what the player is doing.
+type State int
+
+const (
+	// StateIdle is waiting for an item to be enqueued.
+	StateIdle State = iota
+	// StateOpening is opening the device and the source of the next item.
+	StateOpening
+	// StatePlaying is writing the current item to its device.
+	StatePlaying
+	// StatePaused has paused the current item.
+	StatePaused
+	// StateClosing has been closed and is ending the current and queued items.
+	StateClosing
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateOpening:
+		return "opening"
+	case StatePlaying:
+		return "playing"
+	case StatePaused:
+		return "paused"
+	case StateClosing:
+		return "closing"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the state by its name, e.g. in a Snapshot.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// State reports what the player is doing.
+// The state changes right before the callback of the current item that goes with it,
+// i.e. the player is StatePlaying in OnStart and OnResume, StatePaused in OnPause, and StateIdle in OnEnd
+// unless the player is closing.
+func (p *Player) State() State {
+	p.ctrlMu.Lock()
+	defer p.ctrlMu.Unlock()
+	return p.state
+}
+
+// Elapsed reports how long the current item has played, or false if nothing is playing.
+// Elapsed is cheap enough to poll from a UI, unlike an OnProgress callback with a short interval.
+func (p *Player) Elapsed() (time.Duration, bool) {
+	p.ctrlMu.Lock()
+	playing := p.playing
+	p.ctrlMu.Unlock()
+	if !playing {
+		return 0, false
+	}
+	return time.Duration(atomic.LoadInt64(&p.elapsed)), true
+}
+
+func (p *Player) setState(s State) {
+	p.ctrlMu.Lock()
+	defer p.ctrlMu.Unlock()
+	p.setStateLocked(s)
+}
+
+// setStateLocked changes the state while holding ctrlMu, a closing player never changes state again.
+func (p *Player) setStateLocked(s State) {
+	if p.state != StateClosing {
+		p.state = s
+	}
+}
@@ -0,0 +1,67 @@
+package player
+
+import "sync"
+
+// SubscribeQueue returns a channel that receives a snapshot of the queue, like the result of Queue,
+// whenever items are enqueued, resolved, start playing, or are removed from the queue.
+// Only the latest snapshot is kept for a slow receiver, so dashboards always catch up to the current queue.
+// Call the returned function to unsubscribe, the channel is closed once unsubscribed or when the player closes.
+func (p *Player) SubscribeQueue() (<-chan []QueueEntry, func()) {
+	ch := make(chan []QueueEntry, 1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.quit:
+		close(ch)
+		return ch, func() {}
+	default:
+	}
+	p.subscribers = append(p.subscribers, ch)
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			p.unsubscribe(ch)
+		})
+	}
+}
+
+func (p *Player) unsubscribe(ch chan []QueueEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, sub := range p.subscribers {
+		if sub == ch {
+			p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// unsubscribeAll closes every subscriber's channel, the caller must hold mu.
+func (p *Player) unsubscribeAll() {
+	for _, ch := range p.subscribers {
+		close(ch)
+	}
+	p.subscribers = nil
+}
+
+// queueChanged measures the queue depth and sends a snapshot of the queue to every subscriber,
+// replacing any snapshot they have not received.
+// The caller must hold mu, which keeps each channel's buffer free between draining it and sending.
+func (p *Player) queueChanged() {
+	p.cfg.Metrics.QueueDepth(len(p.queue))
+	if len(p.subscribers) == 0 {
+		return
+	}
+	entries := p.entries()
+	for _, ch := range p.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		snapshot := make([]QueueEntry, len(entries))
+		copy(snapshot, entries)
+		ch <- snapshot
+	}
+}
@@ -0,0 +1,285 @@
+package player
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrOpenTimeout is the cause an item ends with when its source or device takes longer to open than allowed.
+	ErrOpenTimeout = errors.New("timed out opening")
+	// ErrPlaybackTimeout is the error an item ends with when it plays longer than its PlaybackTimeout allows.
+	ErrPlaybackTimeout = errors.New("timed out playing")
+)
+
+// DefaultOpenTimeout sets how long the source of an item enqueued without the OpenTimeout option may take to open.
+// Sources may take any time by default.
+func DefaultOpenTimeout(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.OpenTimeout = d
+		}
+	}
+}
+
+// DeviceTimeout sets how long the device of an item may take to open, e.g. to join a voice channel,
+// before the item ends with ErrOpenTimeout and counts as a device failure for the Outage option.
+// A device that opens after the timeout is closed once the player opens the device of a later item or closes, if it is an io.Closer,
+// unless the player opened the same device again, since a DeviceOpenerFunc may hand the same writer to every item.
+// Devices may take any time by default.
+func DeviceTimeout(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.DeviceTimeout = d
+		}
+	}
+}
+
+// OpenTimeout sets how long the item's source may take to open, e.g. to download the start of a stream,
+// before the item ends with ErrOpenTimeout and the next item plays.
+// A source that opens after the timeout is closed as soon as it opens, if it is an io.Closer.
+func OpenTimeout(d time.Duration) SongOption {
+	return func(s *songItem) {
+		if d > 0 {
+			s.openTimeout = d
+		}
+	}
+}
+
+// PlaybackTimeout ends the item with ErrPlaybackTimeout once it has played for longer than its duration and the margin,
+// guarding against streams that stall without ending and would keep the player busy forever.
+// Time spent paused does not count, and seeking moves the deadline along with the position.
+// If the item times out while the player is waiting on a frame from the source, the source is closed right away
+// from another goroutine so that the read that is stuck returns, so a source that is an io.Closer
+// must allow Close to be called during ReadFrame, like closing an *os.File, a net.Conn, or a pipe to a process does.
+// Otherwise the player stops playing the item and closes the source itself.
+// The item's duration must be known, from the Duration option or a ResolverFunc, otherwise PlaybackTimeout does nothing.
+func PlaybackTimeout(margin time.Duration) SongOption {
+	return func(s *songItem) {
+		if margin > 0 {
+			s.playbackMargin = margin
+		}
+	}
+}
+
+// within calls open in its own goroutine, giving up after d or once the player closes, or calls open directly if d is 0.
+// open must assign what it opens only to variables the caller reads after within returns without an error,
+// since late is called with what open opened after within has given up.
+func (p *Player) within(d time.Duration, open func() error, late func()) error {
+	if d <= 0 {
+		return open()
+	}
+	var mu sync.Mutex
+	abandoned := false
+	done := make(chan error, 1)
+	go func() {
+		err := open()
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			if err == nil {
+				late()
+			}
+			return
+		}
+		done <- err
+	}()
+
+	timer := p.cfg.Clock.NewTimer(d)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+		return err
+	case <-timer.C():
+		err = ErrOpenTimeout
+	case <-p.quit:
+		err = ErrClosed
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// open may have finished while giving up
+	select {
+	case openErr := <-done:
+		return openErr
+	default:
+	}
+	abandoned = true
+	return err
+}
+
+// closeLate closes what opened after giving up on it, if it is an io.Closer.
+func closeLate(v interface{}) {
+	if c, ok := v.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// abandon keeps a device that opened after giving up on it until the playback goroutine knows whether it is in use,
+// since the device may be the writer the player already plays to or the one it opens next.
+// The device is closed right away if the player has already closed its devices.
+func (p *Player) abandon(w io.Writer) {
+	if _, ok := w.(io.Closer); !ok {
+		return
+	}
+	p.lateMu.Lock()
+	defer p.lateMu.Unlock()
+	if p.devicesClosed {
+		if !sameDevice(w, p.writer) {
+			closeLate(w)
+		}
+		return
+	}
+	p.late = append(p.late, w)
+}
+
+// closeAbandoned closes the devices that opened after giving up on them, except the device in use.
+// Only the playback goroutine calls closeAbandoned.
+func (p *Player) closeAbandoned(inUse io.Writer) {
+	p.lateMu.Lock()
+	late := p.late
+	p.late = nil
+	p.lateMu.Unlock()
+	for _, w := range late {
+		if !sameDevice(w, inUse) {
+			closeLate(w)
+		}
+	}
+}
+
+// closeDevices closes the device in use and the devices that opened after giving up on them once the player closes.
+func (p *Player) closeDevices() {
+	p.lateMu.Lock()
+	p.devicesClosed = true
+	p.lateMu.Unlock()
+	p.closeAbandoned(p.writer)
+	closeLate(p.writer)
+}
+
+// sameDevice compares writers without panicking on types that cannot be compared, e.g. structs holding slices.
+func sameDevice(a, b io.Writer) bool {
+	t := reflect.TypeOf(a)
+	return t != nil && t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// watchdog times an item against its PlaybackTimeout.
+// The methods of a nil watchdog do nothing, for items without a PlaybackTimeout.
+type watchdog struct {
+	clock    clock.Clock
+	timer    clock.Timer
+	duration time.Duration
+	margin   time.Duration
+	// time left when the timer was last started, and when it was started
+	left  time.Duration
+	since time.Time
+	// closed when the item times out
+	expired chan struct{}
+
+	mu sync.Mutex
+	// whether the play loop is reading a frame and cannot see expired
+	reading bool
+	abort   func()
+}
+
+// newWatchdog starts timing an item.
+// If the item times out during a read, abort is called in its own goroutine to unblock the read,
+// otherwise the play loop sees done and ends the item itself.
+func newWatchdog(c clock.Clock, duration, margin time.Duration, abort func()) *watchdog {
+	if duration <= 0 || margin <= 0 {
+		return nil
+	}
+	w := &watchdog{
+		clock:    c,
+		duration: duration,
+		margin:   margin,
+		left:     duration + margin,
+		since:    c.Now(),
+		expired:  make(chan struct{}),
+		abort:    abort,
+	}
+	w.timer = c.AfterFunc(w.left, w.expire)
+	return w
+}
+
+func (w *watchdog) expire() {
+	w.mu.Lock()
+	close(w.expired)
+	reading := w.reading
+	w.mu.Unlock()
+	if reading {
+		w.abort()
+	}
+}
+
+// read marks the span of a read from the source, during which the item can only time out by closing the source.
+func (w *watchdog) read(reading bool) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.reading = reading
+	w.mu.Unlock()
+}
+
+// done is closed when the item times out.
+func (w *watchdog) done() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.expired
+}
+
+// fired reports whether the item timed out.
+func (w *watchdog) fired() bool {
+	if w == nil {
+		return false
+	}
+	select {
+	case <-w.expired:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *watchdog) pause() {
+	if w == nil {
+		return
+	}
+	w.timer.Stop()
+	w.left -= w.clock.Now().Sub(w.since)
+}
+
+func (w *watchdog) resume() {
+	if w == nil {
+		return
+	}
+	w.since = w.clock.Now()
+	w.timer.Reset(w.left)
+}
+
+// seek restarts the timer for the rest of the item after pos.
+func (w *watchdog) seek(pos time.Duration, paused bool) {
+	if w == nil {
+		return
+	}
+	w.left = w.duration - pos + w.margin
+	if w.left < w.margin {
+		w.left = w.margin
+	}
+	if !paused {
+		w.timer.Stop()
+		w.resume()
+	}
+}
+
+func (w *watchdog) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
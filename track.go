@@ -0,0 +1,35 @@
+package player
+
+// Track is returned by Enqueue to follow an item until it ends,
+// e.g. to select on the end of the item instead of passing an OnEnd callback.
+type Track struct {
+	// ID identifies the item in the queue and to requests like SkipTo.
+	ID   uint64
+	done chan struct{}
+	err  error
+}
+
+func newTrack() *Track {
+	return &Track{done: make(chan struct{})}
+}
+
+// Done is closed when the item ends, once its OnEnd callback returns or is abandoned by the CallbackTimeout option.
+func (t *Track) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err reports why the item ended, the same error passed to its OnEnd callback.
+// Err returns nil until Done is closed.
+func (t *Track) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (t *Track) end(err error) {
+	t.err = err
+	close(t.done)
+}
@@ -0,0 +1,84 @@
+package tts
+
+import (
+	"io"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/pkg/errors"
+)
+
+// SourceCloser provides standard PCM frames of synthesized speech.
+type SourceCloser struct {
+	rc       io.ReadCloser
+	channels int
+	buf      []byte
+	ended    bool
+}
+
+// NewSource synthesizes the text and produces a source of standard PCM frames of the speech,
+// remixing the speech to stereo if it is not already.
+// The speech must be synthesized at the standard sample rate.
+func NewSource(synth Synthesizer, text string) (*SourceCloser, error) {
+	rc, format, err := synth.Synthesize(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to synthesize speech")
+	}
+	if format.SampleRate != pcm.SampleRate || format.Channels < 1 {
+		rc.Close()
+		return nil, errors.Errorf("unsupported speech format %v Hz %v channels", format.SampleRate, format.Channels)
+	}
+	return &SourceCloser{
+		rc:       rc,
+		channels: format.Channels,
+		buf:      make([]byte, pcm.FrameSamples*format.Channels*2),
+	}, nil
+}
+
+// Opener returns a player.SourceOpenerFunc that synthesizes the text when the item plays,
+// e.g. to enqueue an announcement.
+func Opener(synth Synthesizer, text string) player.SourceOpenerFunc {
+	return func() (player.Source, error) {
+		return NewSource(synth, text)
+	}
+}
+
+// ReadFrame implements player.SourceCloser.
+// The last frame of the speech is padded with silence.
+func (src *SourceCloser) ReadFrame() ([]byte, error) {
+	if src.ended {
+		return nil, io.EOF
+	}
+	n, err := io.ReadFull(src.rc, src.buf)
+	if err == io.EOF {
+		src.ended = true
+		return nil, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		src.ended = true
+		for i := n; i < len(src.buf); i++ {
+			src.buf[i] = 0
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	samples := pcm.Decode(make([]int16, 0, len(src.buf)/2), src.buf)
+	if src.channels != pcm.Channels {
+		samples = pcm.Remix(make([]int16, 0, pcm.FrameLen), samples, src.channels, pcm.Channels)
+	}
+	return pcm.Encode(make([]byte, 0, pcm.FrameBytes), samples), nil
+}
+
+// FrameDuration implements player.SourceCloser.
+func (src *SourceCloser) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+// Close implements player.SourceCloser.
+func (src *SourceCloser) Close() error {
+	return src.rc.Close()
+}
+
+// do not compile unless SourceCloser implements player.SourceCloser
+var _ player.SourceCloser = &SourceCloser{}
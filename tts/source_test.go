@@ -0,0 +1,150 @@
+package tts_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/jeffreymkabot/discordvoice/tts"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// speech is a synthesized stream that records whether it was closed and fails with err once it is read, if set.
+type speech struct {
+	io.Reader
+	err    error
+	closed bool
+}
+
+func (s *speech) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err == io.EOF && s.err != nil {
+		err = s.err
+	}
+	return n, err
+}
+
+func (s *speech) Close() error {
+	s.closed = true
+	return nil
+}
+
+// fakeSynth synthesizes the samples in the format for any text, recording the texts.
+type fakeSynth struct {
+	samples []int16
+	format  pcm.Format
+	err     error
+	readErr error
+	texts   []string
+	speech  *speech
+}
+
+func (f *fakeSynth) Synthesize(text string) (io.ReadCloser, pcm.Format, error) {
+	f.texts = append(f.texts, text)
+	if f.err != nil {
+		return nil, f.format, f.err
+	}
+	f.speech = &speech{Reader: bytes.NewReader(pcm.Encode(nil, f.samples)), err: f.readErr}
+	return f.speech, f.format, nil
+}
+
+// ramp is n samples counting up from 1.
+func ramp(n int) []int16 {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(i + 1)
+	}
+	return samples
+}
+
+func readSamples(t *testing.T, src *tts.SourceCloser) ([][]int16, error) {
+	var frames [][]int16
+	for {
+		frame, err := src.ReadFrame()
+		if err != nil {
+			return frames, err
+		}
+		require.Len(t, frame, pcm.FrameBytes)
+		frames = append(frames, pcm.Decode(nil, frame))
+	}
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	// two and a half frames of speech
+	samples := ramp(5 * pcm.FrameLen / 2)
+	synth := &fakeSynth{samples: samples, format: pcm.Standard}
+	src, err := tts.NewSource(synth, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello"}, synth.texts)
+	assert.Equal(t, pcm.FrameDuration, src.FrameDuration())
+
+	frames, err := readSamples(t, src)
+	assert.Equal(t, io.EOF, err)
+	require.Len(t, frames, 3)
+	assert.Equal(t, samples[:pcm.FrameLen], frames[0])
+	assert.Equal(t, samples[pcm.FrameLen:2*pcm.FrameLen], frames[1])
+	assert.Equal(t, samples[2*pcm.FrameLen:], frames[2][:pcm.FrameLen/2])
+	assert.Equal(t, make([]int16, pcm.FrameLen/2), frames[2][pcm.FrameLen/2:], "expected the last frame to be padded with silence")
+	_, err = src.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+
+	require.NoError(t, src.Close())
+	assert.True(t, synth.speech.closed)
+}
+
+func TestSourceMono(t *testing.T) {
+	t.Parallel()
+	samples := ramp(pcm.FrameSamples)
+	src, err := tts.NewSource(&fakeSynth{samples: samples, format: pcm.Format{SampleRate: pcm.SampleRate, Channels: 1}}, "hello")
+	require.NoError(t, err)
+	frames, err := readSamples(t, src)
+	assert.Equal(t, io.EOF, err)
+	require.Len(t, frames, 1)
+	for i, s := range samples {
+		assert.Equal(t, []int16{s, s}, frames[0][2*i:2*i+2], "expected mono speech in both channels")
+	}
+}
+
+func TestSourceFails(t *testing.T) {
+	t.Parallel()
+	failure := errors.New("voice not found")
+	synth := &fakeSynth{format: pcm.Standard, err: failure}
+	_, err := tts.NewSource(synth, "hello")
+	assert.Equal(t, failure, errors.Cause(err))
+
+	// speech at another sample rate is closed right away
+	synth = &fakeSynth{samples: ramp(10), format: pcm.Format{SampleRate: 22050, Channels: 1}}
+	_, err = tts.NewSource(synth, "hello")
+	assert.Error(t, err)
+	assert.True(t, synth.speech.closed)
+
+	// the stream failing partway through ends the source with its error
+	synth = &fakeSynth{samples: ramp(3 * pcm.FrameLen / 2), format: pcm.Standard, readErr: errors.New("connection reset")}
+	src, err := tts.NewSource(synth, "hello")
+	require.NoError(t, err)
+	frames, err := readSamples(t, src)
+	assert.Len(t, frames, 1)
+	assert.Equal(t, synth.readErr, err)
+}
+
+func TestOpener(t *testing.T) {
+	t.Parallel()
+	synth := &fakeSynth{samples: ramp(pcm.FrameLen), format: pcm.Standard}
+	open := tts.Opener(synth, "now playing")
+	assert.Empty(t, synth.texts, "expected the speech to be synthesized when the item plays")
+	src, err := open()
+	require.NoError(t, err)
+	defer src.(io.Closer).Close()
+	assert.Equal(t, []string{"now playing"}, synth.texts)
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, ramp(pcm.FrameLen), pcm.Decode(nil, frame))
+
+	synth.err = errors.New("quota exceeded")
+	_, err = open()
+	assert.Equal(t, synth.err, errors.Cause(err))
+}
@@ -0,0 +1,83 @@
+// Package tts provides sources of speech synthesized from text,
+// so spoken announcements can be enqueued alongside music or played over it with Player.Overlay.
+package tts
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/pkg/errors"
+)
+
+// Synthesizer is a speech synthesis backend, e.g. an external TTS binary or an HTTP API.
+type Synthesizer interface {
+	// Synthesize returns speech of the text as interleaved 16-bit little-endian PCM in the reported format.
+	Synthesize(text string) (io.ReadCloser, pcm.Format, error)
+}
+
+// SynthesizerFunc is an adapter to use an ordinary function as a Synthesizer.
+type SynthesizerFunc func(text string) (io.ReadCloser, pcm.Format, error)
+
+// Synthesize calls f(text).
+func (f SynthesizerFunc) Synthesize(text string) (io.ReadCloser, pcm.Format, error) {
+	return f(text)
+}
+
+// Command synthesizes speech by running a program with the text on its stdin,
+// which must write raw PCM in the format to its stdout,
+// e.g. a shell running "espeak-ng --stdout | ffmpeg -i - -f s16le -ar 48000 -ac 2 -" with pcm.Standard.
+// The program is killed if the source is closed before the speech ends.
+func Command(format pcm.Format, name string, args ...string) Synthesizer {
+	return SynthesizerFunc(func(text string) (io.ReadCloser, pcm.Format, error) {
+		cmd := exec.Command(name, args...)
+		cmd.Stdin = strings.NewReader(text)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, format, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, format, errors.Wrapf(err, "failed to start %v", name)
+		}
+		return &process{ReadCloser: stdout, cmd: cmd}, format, nil
+	})
+}
+
+// process closes the stdout of a command by stopping the command.
+type process struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (p *process) Close() error {
+	p.cmd.Process.Kill()
+	p.ReadCloser.Close()
+	// the command was killed, exiting with an error is expected
+	p.cmd.Wait()
+	return nil
+}
+
+// HTTP synthesizes speech by posting the text as text/plain to the url,
+// which must respond with raw PCM in the format.
+// The http.DefaultClient is used if client is nil.
+func HTTP(url string, format pcm.Format, client *http.Client) Synthesizer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return SynthesizerFunc(func(text string) (io.ReadCloser, pcm.Format, error) {
+		resp, err := client.Post(url, "text/plain; charset=utf-8", strings.NewReader(text))
+		if err != nil {
+			return nil, format, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, format, errors.Errorf("speech synthesis failed with %v: %s", resp.Status, bytes.TrimSpace(msg))
+		}
+		return resp.Body, format, nil
+	})
+}
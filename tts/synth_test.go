@@ -0,0 +1,81 @@
+package tts_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/jeffreymkabot/discordvoice/tts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoEnv makes the test binary echo its stdin to its stdout, see TestMain.
+const echoEnv = "TTS_ECHO"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(echoEnv) == "1" {
+		io.Copy(os.Stdout, os.Stdin)
+		os.Exit(0)
+	}
+	// the tests run the test binary as a program that echoes
+	os.Setenv(echoEnv, "1")
+	os.Exit(m.Run())
+}
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+	// the program reads the text and "synthesizes" it as its bytes
+	synth := tts.Command(pcm.Standard, os.Args[0])
+	rc, format, err := synth.Synthesize("hello")
+	require.NoError(t, err)
+	assert.Equal(t, pcm.Standard, format)
+	b, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	require.NoError(t, rc.Close())
+
+	// closing the speech early stops the program
+	rc, _, err = tts.Command(pcm.Standard, os.Args[0]).Synthesize(strings.Repeat("a", 1<<20))
+	require.NoError(t, err)
+	assert.NoError(t, rc.Close())
+
+	_, _, err = tts.Command(pcm.Standard, "not a program").Synthesize("hello")
+	assert.Error(t, err)
+}
+
+func TestHTTP(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, "text/plain; charset=utf-8", req.Header.Get("Content-Type"))
+		text, _ := ioutil.ReadAll(req.Body)
+		if string(text) == "" {
+			http.Error(w, "  no text  ", http.StatusBadRequest)
+			return
+		}
+		w.Write(text)
+	}))
+	defer server.Close()
+
+	synth := tts.HTTP(server.URL, pcm.Standard, nil)
+	rc, format, err := synth.Synthesize("hello")
+	require.NoError(t, err)
+	assert.Equal(t, pcm.Standard, format)
+	b, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	rc.Close()
+
+	_, _, err = synth.Synthesize("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request: no text")
+
+	_, _, err = tts.HTTP(server.URL+"\x7f", pcm.Standard, server.Client()).Synthesize("hello")
+	assert.Error(t, err)
+}
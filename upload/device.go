@@ -0,0 +1,229 @@
+// Package upload provides a device that streams recorded frames to object storage with a multipart upload,
+// so long recordings never need to fit on local disk.
+package upload
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/pkg/errors"
+)
+
+// minimum size of every part but the last of an S3 multipart upload
+const defaultPartSize = 5 << 20
+
+// Multipart is one multipart upload to an object store, e.g. an S3 multipart upload or a GCS XML API multipart upload.
+// Implementations wrap the client of the store so this package does not depend on any of them.
+type Multipart interface {
+	// UploadPart uploads a part numbered from 1 and returns the tag identifying it, e.g. its ETag.
+	// UploadPart may be called again for the same part if it returned an error.
+	UploadPart(n int, data []byte) (tag string, err error)
+	// Complete assembles the uploaded parts, listed by their tags in order, into the object.
+	Complete(tags []string) error
+	// Abort discards the uploaded parts.
+	Abort() error
+}
+
+type config struct {
+	PartSize int
+	Pending  int
+	Retries  int
+	Backoff  time.Duration
+	Clock    clock.Clock
+}
+
+// Option functions configure a Writer.
+// Pass Options to the New function.
+type Option func(*config)
+
+// PartSize sets the size in bytes of every part but the last, 5MiB by default.
+func PartSize(n int) Option {
+	return func(cfg *config) {
+		if n > 0 {
+			cfg.PartSize = n
+		}
+	}
+}
+
+// Pending sets how many full parts can wait to be uploaded before Write blocks, 4 by default.
+func Pending(n int) Option {
+	return func(cfg *config) {
+		if n > 0 {
+			cfg.Pending = n
+		}
+	}
+}
+
+// Retry sets how many times a failed part is uploaded again, waiting backoff and then twice as long each time.
+// Parts are retried 3 times starting after 1 second by default.
+func Retry(n int, backoff time.Duration) Option {
+	return func(cfg *config) {
+		if n >= 0 {
+			cfg.Retries = n
+		}
+		if backoff > 0 {
+			cfg.Backoff = backoff
+		}
+	}
+}
+
+// Clock sets the clock that backs off between attempts to upload a part, clock.Real by default.
+// Tests can pass a clock.Fake to retry parts without waiting.
+func Clock(c clock.Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.Clock = c
+		}
+	}
+}
+
+// Writer uploads the frames written to it in parts as they are played.
+// Frames are recorded back to back without any framing, which suits PCM frames.
+type Writer struct {
+	mp  Multipart
+	cfg config
+	// part being filled by Write
+	part  []byte
+	parts chan []byte
+	done  chan struct{}
+
+	mu   sync.Mutex
+	tags []string
+	err  error
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var errClosed = errors.New("upload writer is closed")
+
+// New produces a Writer that uploads frames to a multipart upload that was already started.
+// The upload is completed when the Writer closes, or aborted if any part fails to upload.
+func New(mp Multipart, opts ...Option) *Writer {
+	cfg := config{
+		PartSize: defaultPartSize,
+		Pending:  4,
+		Retries:  3,
+		Backoff:  1 * time.Second,
+		Clock:    clock.Real,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	w := &Writer{
+		mp:    mp,
+		cfg:   cfg,
+		part:  make([]byte, 0, cfg.PartSize),
+		parts: make(chan []byte, cfg.Pending),
+		done:  make(chan struct{}),
+	}
+	go w.upload()
+	return w
+}
+
+// Write implements io.Writer.
+// Write fails once a part has failed to upload or the Writer is closed.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.failed(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := copy(w.part[len(w.part):cap(w.part)], p)
+		w.part = w.part[:len(w.part)+m]
+		p = p[m:]
+		if len(w.part) == cap(w.part) {
+			w.parts <- w.part
+			w.part = make([]byte, 0, w.cfg.PartSize)
+		}
+	}
+	return n, nil
+}
+
+// DeviceInfo implements player.DeviceInfo.
+// Uploads happen in the background so the player paces writes unless the item is not Realtime.
+func (w *Writer) DeviceInfo() player.DeviceCaps {
+	return player.DeviceCaps{
+		Opus:     true,
+		PCM:      true,
+		Realtime: false,
+	}
+}
+
+// Close uploads the last part and completes the upload once every part is uploaded.
+// If any part failed to upload the upload is aborted instead and Close returns why the part failed.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		w.closeErr = w.close()
+	})
+	return w.closeErr
+}
+
+func (w *Writer) close() error {
+	if len(w.part) > 0 {
+		w.parts <- w.part
+	}
+	w.part = nil
+	close(w.parts)
+	<-w.done
+
+	// fail any later writes, keeping the reason a part failed if one did
+	w.mu.Lock()
+	err, tags := w.err, w.tags
+	if w.err == nil {
+		w.err = errClosed
+	}
+	w.mu.Unlock()
+	if err != nil {
+		w.mp.Abort()
+		return err
+	}
+	if err := w.mp.Complete(tags); err != nil {
+		w.mp.Abort()
+		return errors.Wrap(err, "failed to complete upload")
+	}
+	return nil
+}
+
+func (w *Writer) upload() {
+	defer close(w.done)
+	n := 0
+	for part := range w.parts {
+		// keep draining so Write and Close never block on a failed upload
+		if w.failed() != nil {
+			continue
+		}
+		n++
+		tag, err := w.uploadPart(n, part)
+		w.mu.Lock()
+		if err != nil {
+			w.err = errors.Wrapf(err, "failed to upload part %v", n)
+		} else {
+			w.tags = append(w.tags, tag)
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *Writer) uploadPart(n int, part []byte) (tag string, err error) {
+	backoff := w.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		tag, err = w.mp.UploadPart(n, part)
+		if err == nil || attempt == w.cfg.Retries {
+			return
+		}
+		<-w.cfg.Clock.NewTimer(backoff).C()
+		backoff *= 2
+	}
+}
+
+func (w *Writer) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// do not compile unless Writer implements player.DeviceInfo
+var _ player.DeviceInfo = &Writer{}
@@ -0,0 +1,181 @@
+package upload_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/clock"
+	"github.com/jeffreymkabot/discordvoice/upload"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multipart records the parts uploaded to it, failing the attempts that fail returns an error for.
+type multipart struct {
+	fail        func(n int, attempt int) error
+	completeErr error
+
+	mu        sync.Mutex
+	attempts  map[int]int
+	parts     map[int]string
+	completed []string
+	aborted   bool
+}
+
+func newMultipart() *multipart {
+	return &multipart{attempts: make(map[int]int), parts: make(map[int]string)}
+}
+
+func (m *multipart) UploadPart(n int, data []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts[n]++
+	if m.fail != nil {
+		if err := m.fail(n, m.attempts[n]); err != nil {
+			return "", err
+		}
+	}
+	m.parts[n] = string(data)
+	return "tag" + strconv.Itoa(n), nil
+}
+
+func (m *multipart) Complete(tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.completeErr != nil {
+		return m.completeErr
+	}
+	m.completed = tags
+	return nil
+}
+
+func (m *multipart) Abort() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aborted = true
+	return nil
+}
+
+func (m *multipart) attempted(n int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.attempts[n]
+}
+
+func write(t *testing.T, w *upload.Writer, frames ...string) {
+	for _, frame := range frames {
+		n, err := w.Write([]byte(frame))
+		require.NoError(t, err)
+		assert.Equal(t, len(frame), n)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+	mp := newMultipart()
+	w := upload.New(mp, upload.PartSize(4))
+	caps := w.DeviceInfo()
+	assert.True(t, caps.Opus)
+	assert.True(t, caps.PCM)
+	assert.False(t, caps.Realtime)
+
+	// frames are split across parts of the part size, and the last part is uploaded on Close
+	write(t, w, "abc", "defghijk", "l")
+	require.NoError(t, w.Close())
+	assert.Equal(t, map[int]string{1: "abcd", 2: "efgh", 3: "ijkl"}, mp.parts)
+	assert.Equal(t, []string{"tag1", "tag2", "tag3"}, mp.completed)
+	assert.False(t, mp.aborted)
+
+	_, err := w.Write([]byte("m"))
+	assert.Error(t, err, "expected writes to fail once the Writer is closed")
+	assert.NoError(t, w.Close())
+
+	// a short last part
+	mp = newMultipart()
+	w = upload.New(mp, upload.PartSize(4))
+	write(t, w, "abcde")
+	require.NoError(t, w.Close())
+	assert.Equal(t, map[int]string{1: "abcd", 2: "e"}, mp.parts)
+	assert.Equal(t, []string{"tag1", "tag2"}, mp.completed)
+}
+
+func TestWriterRetry(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	mp := newMultipart()
+	mp.fail = func(n int, attempt int) error {
+		if n == 1 && attempt <= 2 {
+			return errors.New("service unavailable")
+		}
+		return nil
+	}
+	w := upload.New(mp, upload.PartSize(2), upload.Retry(2, time.Second), upload.Clock(clk))
+	write(t, w, "ab")
+
+	// the part is uploaded again after backing off 1s and then 2s
+	clk.BlockUntil(1)
+	assert.Equal(t, 1, mp.attempted(1))
+	clk.Advance(999 * time.Millisecond)
+	assert.Equal(t, 1, mp.attempted(1))
+	clk.Advance(time.Millisecond)
+	clk.BlockUntil(1)
+	assert.Equal(t, 2, mp.attempted(1))
+	clk.Advance(2 * time.Second)
+
+	write(t, w, "cd")
+	require.NoError(t, w.Close())
+	assert.Equal(t, 3, mp.attempted(1))
+	assert.Equal(t, map[int]string{1: "ab", 2: "cd"}, mp.parts)
+	assert.Equal(t, []string{"tag1", "tag2"}, mp.completed)
+}
+
+func TestWriterFails(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Unix(0, 0))
+	mp := newMultipart()
+	failure := errors.New("access denied")
+	mp.fail = func(n int, attempt int) error {
+		if n == 2 {
+			return failure
+		}
+		return nil
+	}
+	w := upload.New(mp, upload.PartSize(2), upload.Retry(1, time.Second), upload.Clock(clk))
+	write(t, w, "abcd")
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+
+	// writes fail once the part has failed its retries
+	deadline := time.Now().Add(5 * time.Second)
+	var err error
+	for err == nil && time.Now().Before(deadline) {
+		_, err = w.Write([]byte("e"))
+		time.Sleep(time.Millisecond)
+	}
+	require.Error(t, err)
+	assert.Equal(t, failure, errors.Cause(err))
+	assert.Equal(t, 2, mp.attempted(2))
+
+	err = w.Close()
+	require.Error(t, err)
+	assert.Equal(t, failure, errors.Cause(err))
+	assert.Equal(t, err, w.Close())
+	assert.True(t, mp.aborted, "expected an upload with a failed part to be aborted")
+	assert.Nil(t, mp.completed)
+	assert.Equal(t, 0, mp.attempted(3), "expected no parts to be uploaded after a part failed")
+}
+
+func TestWriterCompleteFails(t *testing.T) {
+	t.Parallel()
+	mp := newMultipart()
+	mp.completeErr = errors.New("invalid part order")
+	w := upload.New(mp)
+	write(t, w, "a")
+	err := w.Close()
+	require.Error(t, err)
+	assert.Equal(t, mp.completeErr, errors.Cause(err))
+	assert.True(t, mp.aborted)
+}
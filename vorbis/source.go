@@ -0,0 +1,123 @@
+// Package vorbis provides a source of decoded PCM frames from an Ogg Vorbis stream.
+package vorbis
+
+import (
+	"io"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/pkg/errors"
+)
+
+// ErrNotSeekable is returned by SeekTo if the source was not created from an io.Seeker.
+var ErrNotSeekable = errors.New("vorbis source is not seekable")
+
+// SourceCloser provides a source of decoded PCM frames from an Ogg Vorbis stream,
+// interleaved 16-bit little-endian samples with the stream's sample rate and channels in 20ms frames.
+type SourceCloser struct {
+	r   io.Reader
+	dec *oggvorbis.Reader
+	// interleaved samples of a frame
+	buf []float32
+}
+
+// NewSource produces a source of decoded PCM frames from an Ogg Vorbis stream, reading its headers right away.
+// The source can seek and report its duration if r implements io.Seeker.
+// If the reader implements io.Closer the reader will be closed when the source is closed.
+func NewSource(r io.Reader) (*SourceCloser, error) {
+	dec, err := oggvorbis.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read vorbis headers")
+	}
+	format := pcm.Format{SampleRate: dec.SampleRate(), Channels: dec.Channels()}
+	return &SourceCloser{
+		r:   r,
+		dec: dec,
+		buf: make([]float32, format.Samples(pcm.FrameDuration)),
+	}, nil
+}
+
+// SampleRate reports the sample rate of the stream, e.g. to open a native.Writer.
+func (src *SourceCloser) SampleRate() int {
+	return src.dec.SampleRate()
+}
+
+// Channels reports the number of channels of the stream, e.g. to open a native.Writer.
+func (src *SourceCloser) Channels() int {
+	return src.dec.Channels()
+}
+
+// Comments reports the user comments of the stream, e.g. "TITLE=...", and the vendor of its encoder.
+func (src *SourceCloser) Comments() (comments []string, vendor string) {
+	header := src.dec.CommentHeader()
+	return header.Comments, header.Vendor
+}
+
+// Duration reports how long the stream plays, or false if the source cannot seek.
+func (src *SourceCloser) Duration() (time.Duration, bool) {
+	if _, ok := src.r.(io.Seeker); !ok {
+		return 0, false
+	}
+	return time.Duration(src.dec.Length()) * time.Second / time.Duration(src.dec.SampleRate()), true
+}
+
+// ReadFrame implements player.SourceCloser.
+// Frames are allocated from the player's frame pool.
+func (src *SourceCloser) ReadFrame() ([]byte, error) {
+	n, err := src.dec.Read(src.buf)
+	// the decoder returns whatever is left of a packet, fill the whole frame
+	for n < len(src.buf) && err == nil {
+		var m int
+		m, err = src.dec.Read(src.buf[n:])
+		n += m
+	}
+	if n == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	samples := pcm.ToInt(make([]int16, 0, n), src.buf[:n])
+	frame := player.AllocFrame(n * 2)
+	pcm.Encode(frame[:0], samples)
+	// the last frame is returned before the end of the stream is reported
+	return frame, nil
+}
+
+// PooledFrames implements player.PooledSource.
+func (src *SourceCloser) PooledFrames() bool {
+	return true
+}
+
+// FrameDuration implements player.SourceCloser.
+func (src *SourceCloser) FrameDuration() time.Duration {
+	return pcm.FrameDuration
+}
+
+// SeekTo implements player.SeekableSource.
+// SeekTo returns ErrNotSeekable if the source was not created from an io.Seeker.
+func (src *SourceCloser) SeekTo(d time.Duration) (time.Duration, error) {
+	if _, ok := src.r.(io.Seeker); !ok {
+		return 0, ErrNotSeekable
+	}
+	pos := int64(d) * int64(src.dec.SampleRate()) / int64(time.Second)
+	if err := src.dec.SetPosition(pos); err != nil {
+		return 0, err
+	}
+	return time.Duration(pos) * time.Second / time.Duration(src.dec.SampleRate()), nil
+}
+
+// Close implements player.SourceCloser.
+func (src *SourceCloser) Close() error {
+	if c, ok := src.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// do not compile unless SourceCloser implements player.SourceCloser, player.PooledSource, and player.SeekableSource
+var _ player.SourceCloser = &SourceCloser{}
+var _ player.PooledSource = &SourceCloser{}
+var _ player.SeekableSource = &SourceCloser{}
@@ -0,0 +1,124 @@
+package vorbis_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/pcm"
+	"github.com/jeffreymkabot/discordvoice/vorbis"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 20ms of the fixture's mono samples at 44.1kHz
+const frameLen = 882
+
+func fixture(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("testdata/test.ogg")
+	require.NoError(t, err)
+	return data
+}
+
+// reference decodes the fixture with the decoder itself.
+func reference(t *testing.T, data []byte) []int16 {
+	samples, _, err := oggvorbis.ReadAll(bytes.NewReader(data))
+	require.NoError(t, err)
+	return pcm.ToInt(nil, samples)
+}
+
+// stream hides whether a reader can seek or close.
+type stream struct {
+	io.Reader
+}
+
+type closer struct {
+	io.ReadSeeker
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	data := fixture(t)
+	r := &closer{ReadSeeker: bytes.NewReader(data)}
+	src, err := vorbis.NewSource(r)
+	require.NoError(t, err)
+	assert.Equal(t, 44100, src.SampleRate())
+	assert.Equal(t, 1, src.Channels())
+	d, ok := src.Duration()
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, d)
+	comments, vendor := src.Comments()
+	assert.Empty(t, comments)
+	assert.Contains(t, vendor, "libVorbis")
+	assert.Equal(t, pcm.FrameDuration, src.FrameDuration())
+	assert.True(t, src.PooledFrames())
+
+	// a second of whole 20ms frames
+	var samples []int16
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Len(t, frame, 2*frameLen, "expected frame %v to be 20ms of mono samples", len(samples)/frameLen)
+		samples = pcm.Decode(samples, frame)
+	}
+	assert.Len(t, samples, 50*frameLen)
+	assert.Equal(t, reference(t, data), samples)
+
+	require.NoError(t, src.Close())
+	assert.True(t, r.closed)
+}
+
+func TestSourceSeek(t *testing.T) {
+	t.Parallel()
+	data := fixture(t)
+	want := reference(t, data)
+	src, err := vorbis.NewSource(bytes.NewReader(data))
+	require.NoError(t, err)
+	pos, err := src.SeekTo(500 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, pos)
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	samples := pcm.Decode(nil, frame)
+	require.Len(t, samples, frameLen)
+	for i, s := range samples {
+		require.InDelta(t, want[22050+i], s, 1, "expected sample %v after the seek", 22050+i)
+	}
+}
+
+func TestSourceNotSeekable(t *testing.T) {
+	t.Parallel()
+	data := fixture(t)
+	src, err := vorbis.NewSource(stream{bytes.NewReader(data)})
+	require.NoError(t, err)
+	_, ok := src.Duration()
+	assert.False(t, ok)
+	_, err = src.SeekTo(time.Second)
+	assert.Equal(t, vorbis.ErrNotSeekable, err)
+	frame, err := src.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, reference(t, data)[:frameLen], pcm.Decode(nil, frame), "expected the stream to play from its start")
+	assert.NoError(t, src.Close())
+}
+
+func TestSourceInvalid(t *testing.T) {
+	t.Parallel()
+	_, err := vorbis.NewSource(bytes.NewReader([]byte("OggS not really")))
+	assert.Error(t, err)
+	// a stream cut off in its headers
+	data := fixture(t)
+	_, err = vorbis.NewSource(bytes.NewReader(data[:64]))
+	assert.Error(t, err)
+}
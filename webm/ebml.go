@@ -0,0 +1,133 @@
+package webm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// matroska element IDs, with their length markers
+const (
+	idEBML           = 0x1A45DFA3
+	idSegment        = 0x18538067
+	idInfo           = 0x1549A966
+	idTimecodeScale  = 0x2AD7B1
+	idDuration       = 0x4489
+	idTracks         = 0x1654AE6B
+	idTrackEntry     = 0xAE
+	idTrackNumber    = 0xD7
+	idTrackType      = 0x83
+	idCodecID        = 0x86
+	idCodecDelay     = 0x56AA
+	idCluster        = 0x1F43B675
+	idTimecode       = 0xE7
+	idSimpleBlock    = 0xA3
+	idBlockGroup     = 0xA0
+	idBlock          = 0xA1
+	trackTypeAudio   = 2
+	unknownSize      = -1
+	maxElementLength = 8
+)
+
+// readVint reads a variable length integer, keeping its length marker if it is an ID.
+func readVint(r *bufio.Reader, id bool) (int64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	length := 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		length++
+		if length > maxElementLength {
+			return 0, errors.New("invalid ebml variable length integer")
+		}
+	}
+	v := int64(first)
+	if !id {
+		v &= int64(0xFF >> uint(length))
+	}
+	allOnes := v == int64(0xFF>>uint(length))
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		v = v<<8 | int64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !id && allOnes {
+		return unknownSize, nil
+	}
+	return v, nil
+}
+
+// readHeader reads the ID and the size of the next element.
+func readHeader(r *bufio.Reader) (id int64, size int64, err error) {
+	if id, err = readVint(r, true); err != nil {
+		return
+	}
+	size, err = readVint(r, false)
+	return id, size, noEOF(err)
+}
+
+// children parses the elements of a master element's data.
+func children(data []byte, f func(id int64, data []byte) error) error {
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		id, size, err := readHeader(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if size < 0 {
+			return errors.New("element of unknown size in a master element of known size")
+		}
+		child, err := readData(r, size)
+		if err != nil {
+			return err
+		}
+		if err := f(id, child); err != nil {
+			return err
+		}
+	}
+}
+
+func readUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func readFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// readData reads the data of an element, growing the buffer as the data arrives
+// so a corrupt size cannot make it allocate more than the stream holds.
+func readData(r io.Reader, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, size)
+	return buf.Bytes(), noEOF(err)
+}
+
+// noEOF reports an unexpected EOF if the stream ends in the middle of an element.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
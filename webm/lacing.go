@@ -0,0 +1,157 @@
+package webm
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// block lacing in the flags of a block
+const (
+	lacingMask  = 0x06
+	lacingNone  = 0x00
+	lacingXiph  = 0x02
+	lacingFixed = 0x04
+	lacingEBML  = 0x06
+)
+
+var errLacing = errors.New("invalid block lacing")
+
+// unlace splits the data of a block into its packets.
+func unlace(flags byte, data []byte) ([][]byte, error) {
+	lacing := flags & lacingMask
+	if lacing == lacingNone {
+		return [][]byte{data}, nil
+	}
+	if len(data) < 1 {
+		return nil, errLacing
+	}
+	count := int(data[0]) + 1
+	data = data[1:]
+
+	sizes := make([]int, 0, count)
+	switch lacing {
+	case lacingFixed:
+		if len(data)%count != 0 {
+			return nil, errLacing
+		}
+		for i := 0; i < count; i++ {
+			sizes = append(sizes, len(data)/count)
+		}
+		return split(data, sizes)
+	case lacingXiph:
+		for i := 0; i < count-1; i++ {
+			size := 0
+			for {
+				if len(data) == 0 {
+					return nil, errLacing
+				}
+				b := data[0]
+				data = data[1:]
+				size += int(b)
+				if b != 0xFF {
+					break
+				}
+			}
+			sizes = append(sizes, size)
+		}
+	case lacingEBML:
+		size := 0
+		for i := 0; i < count-1; i++ {
+			v, n, ok := vint(data)
+			if !ok {
+				return nil, errLacing
+			}
+			data = data[n:]
+			if i == 0 {
+				size = int(v)
+			} else {
+				// later sizes are signed differences from the previous size
+				size += int(v - (int64(1)<<uint(7*n-1) - 1))
+			}
+			sizes = append(sizes, size)
+		}
+	}
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	if total > len(data) {
+		return nil, errLacing
+	}
+	// the last packet is whatever is left
+	return split(data, append(sizes, len(data)-total))
+}
+
+func split(data []byte, sizes []int) ([][]byte, error) {
+	packets := make([][]byte, 0, len(sizes))
+	for _, size := range sizes {
+		if size < 0 || size > len(data) {
+			return nil, errLacing
+		}
+		packets = append(packets, data[:size])
+		data = data[size:]
+	}
+	return packets, nil
+}
+
+// vint decodes the variable length integer at the start of b, reporting its length.
+func vint(b []byte) (int64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	length := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		length++
+		if length > maxElementLength {
+			return 0, 0, false
+		}
+	}
+	if len(b) < length {
+		return 0, 0, false
+	}
+	v := int64(b[0] & (0xFF >> uint(length)))
+	for _, c := range b[1:length] {
+		v = v<<8 | int64(c)
+	}
+	return v, length, true
+}
+
+// opusSamples reports how many 48kHz samples of each channel an opus packet holds, from its TOC byte (RFC 6716).
+func opusSamples(packet []byte) (int, error) {
+	if len(packet) < 1 {
+		return 0, errors.New("empty opus packet")
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+	var frameSize int
+	switch {
+	case config < 12:
+		// SILK 10, 20, 40, 60ms
+		frameSize = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		// hybrid 10, 20ms
+		frameSize = []int{480, 960}[config%2]
+	default:
+		// CELT 2.5, 5, 10, 20ms
+		frameSize = []int{120, 240, 480, 960}[config%4]
+	}
+	var frames int
+	switch toc & 0x03 {
+	case 0:
+		frames = 1
+	case 1, 2:
+		frames = 2
+	default:
+		if len(packet) < 2 {
+			return 0, errors.New("opus packet is missing its frame count")
+		}
+		frames = int(packet[1] & 0x3f)
+	}
+	return frames * frameSize, nil
+}
+
+// duration of a packet of opus samples
+func samplesDuration(samples int) time.Duration {
+	return time.Duration(samples) * time.Second / 48000
+}
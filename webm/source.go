@@ -0,0 +1,260 @@
+// Package webm provides a source of the opus packets of a WebM or Matroska stream,
+// e.g. the audio of most YouTube videos, demuxed without decoding them,
+// so they can be sent to discord without the cost of an ffmpeg encoder.
+package webm
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/pkg/errors"
+)
+
+// ErrNoOpus is returned by NewSource if the stream has no opus audio track.
+var ErrNoOpus = errors.New("no opus track")
+
+// default timecode scale, timecodes are in milliseconds
+const defaultTimecodeScale = 1000000
+
+// blocks larger than this are read like other elements instead of into a pooled buffer,
+// which would be allocated at its full size before the stream is known to hold that much
+const maxPooledBlock = 64 << 10
+
+// SourceCloser provides the opus packets of the first opus track of a WebM or Matroska stream.
+// Discord expects 48kHz stereo opus, the audio of WebM files usually is.
+type SourceCloser struct {
+	r       *bufio.Reader
+	closer  io.Closer
+	track   int64
+	scale   int64
+	delay   time.Duration
+	length  time.Duration
+	cluster int64
+	// packets of a laced block that have not been read yet, and when the next one plays
+	pending [][]byte
+	// backs pending so queuing the packets of a block does not allocate
+	packets [][]byte
+	pts     time.Duration
+	// duration of the first packet
+	frameDur time.Duration
+}
+
+// NewSource produces a source of the opus packets of a WebM or Matroska stream,
+// reading up to its first packet right away.
+// If the reader implements io.Closer the reader will be closed when the source is closed.
+func NewSource(r io.Reader) (*SourceCloser, error) {
+	src := &SourceCloser{r: bufio.NewReader(r), scale: defaultTimecodeScale}
+	if c, ok := r.(io.Closer); ok {
+		src.closer = c
+	}
+	if err := src.fill(); err != nil {
+		if err == io.EOF {
+			err = ErrNoOpus
+		}
+		return nil, err
+	}
+	samples, err := opusSamples(src.pending[0])
+	if err != nil {
+		return nil, err
+	}
+	src.frameDur = samplesDuration(samples)
+	return src, nil
+}
+
+// Duration reports how long the stream plays, or false if its header does not say.
+func (src *SourceCloser) Duration() (time.Duration, bool) {
+	return src.length, src.length > 0
+}
+
+// ReadFrame implements player.SourceCloser.
+func (src *SourceCloser) ReadFrame() ([]byte, error) {
+	frame, _, err := src.ReadTimestampedFrame()
+	return frame, err
+}
+
+// ReadTimestampedFrame implements player.TimestampedSource,
+// timestamping each packet with its block's timecode less the codec delay.
+func (src *SourceCloser) ReadTimestampedFrame() ([]byte, time.Duration, error) {
+	if len(src.pending) == 0 {
+		if err := src.fill(); err != nil {
+			return nil, 0, err
+		}
+	}
+	frame := src.pending[0]
+	src.pending = src.pending[1:]
+	pts := src.pts
+	if samples, err := opusSamples(frame); err == nil {
+		src.pts += samplesDuration(samples)
+	}
+	return frame, pts, nil
+}
+
+// FrameDuration implements player.SourceCloser, the duration of the first packet.
+func (src *SourceCloser) FrameDuration() time.Duration {
+	return src.frameDur
+}
+
+// PooledFrames implements player.PooledSource, packets are read into frame buffers from the player's pool.
+func (src *SourceCloser) PooledFrames() bool {
+	return true
+}
+
+// PCM implements player.PCMSource, the source produces opus packets.
+func (src *SourceCloser) PCM() bool {
+	return false
+}
+
+// Close implements player.SourceCloser.
+func (src *SourceCloser) Close() error {
+	if src.closer != nil {
+		return src.closer.Close()
+	}
+	return nil
+}
+
+// fill reads elements until it finds a block of the opus track.
+func (src *SourceCloser) fill() error {
+	for {
+		id, size, err := readHeader(src.r)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idSegment, idCluster, idBlockGroup:
+			// descend into the master elements that may be too big to hold, or of unknown size when live
+			continue
+		}
+		if size < 0 {
+			return errors.Errorf("element %x of unknown size", id)
+		}
+		var data []byte
+		if (id == idSimpleBlock || id == idBlock) && size <= maxPooledBlock {
+			// blocks are read straight into frame buffers from the player's pool
+			data = player.AllocFrame(int(size))
+			if _, err = io.ReadFull(src.r, data); err != nil {
+				player.FreeFrame(data)
+				return noEOF(err)
+			}
+		} else if data, err = readData(src.r, size); err != nil {
+			return err
+		}
+		switch id {
+		case idInfo:
+			err = src.parseInfo(data)
+		case idTracks:
+			err = src.parseTracks(data)
+		case idTimecode:
+			src.cluster = int64(readUint(data))
+		case idSimpleBlock, idBlock:
+			var ok bool
+			ok, err = src.parseBlock(data)
+			if ok {
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (src *SourceCloser) parseInfo(data []byte) error {
+	var length float64
+	err := children(data, func(id int64, data []byte) error {
+		switch id {
+		case idTimecodeScale:
+			src.scale = int64(readUint(data))
+		case idDuration:
+			length = readFloat(data)
+		}
+		return nil
+	})
+	// the duration is in timecodes of the scale that may come after it
+	src.length = time.Duration(length * float64(src.scale))
+	return err
+}
+
+func (src *SourceCloser) parseTracks(data []byte) error {
+	err := children(data, func(id int64, data []byte) error {
+		if id != idTrackEntry || src.track != 0 {
+			return nil
+		}
+		var number int64
+		var codec string
+		var trackType uint64
+		var delay time.Duration
+		err := children(data, func(id int64, data []byte) error {
+			switch id {
+			case idTrackNumber:
+				number = int64(readUint(data))
+			case idTrackType:
+				trackType = readUint(data)
+			case idCodecID:
+				codec = string(data)
+			case idCodecDelay:
+				delay = time.Duration(readUint(data))
+			}
+			return nil
+		})
+		if err == nil && trackType == trackTypeAudio && codec == "A_OPUS" {
+			src.track = number
+			src.delay = delay
+		}
+		return err
+	})
+	if err == nil && src.track == 0 {
+		err = ErrNoOpus
+	}
+	return err
+}
+
+// parseBlock queues the packets of a block of the opus track, reporting false if the block is of another track.
+// The packet of a block that is not laced is moved to the front of the block's buffer and becomes its frame,
+// the packets of a laced block are copied to frames of their own, and buffers that are not a frame go back to the pool.
+func (src *SourceCloser) parseBlock(data []byte) (bool, error) {
+	track, n, ok := vint(data)
+	if !ok {
+		player.FreeFrame(data)
+		return false, errors.New("invalid block")
+	}
+	if track != src.track || src.track == 0 {
+		player.FreeFrame(data)
+		return false, nil
+	}
+	header := data[n:]
+	if len(header) < 3 {
+		player.FreeFrame(data)
+		return false, errors.New("invalid block")
+	}
+	timecode := src.cluster + int64(int16(uint16(header[0])<<8|uint16(header[1])))
+	src.pts = time.Duration(timecode*src.scale) - src.delay
+	if src.pts < 0 {
+		src.pts = 0
+	}
+	flags, rest := header[2], header[3:]
+	src.pending = src.packets[:0]
+	if flags&lacingMask == lacingNone {
+		src.pending = append(src.pending, data[:copy(data, rest)])
+	} else {
+		packets, err := unlace(flags, rest)
+		if err != nil {
+			player.FreeFrame(data)
+			return false, err
+		}
+		for _, packet := range packets {
+			frame := player.AllocFrame(len(packet))
+			copy(frame, packet)
+			src.pending = append(src.pending, frame)
+		}
+		player.FreeFrame(data)
+	}
+	src.packets = src.pending
+	return len(src.pending) > 0, nil
+}
+
+// do not compile unless SourceCloser implements player.SourceCloser, player.TimestampedSource, and player.PooledSource
+var _ player.SourceCloser = &SourceCloser{}
+var _ player.TimestampedSource = &SourceCloser{}
+var _ player.PooledSource = &SourceCloser{}
@@ -0,0 +1,266 @@
+package webm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/webm"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// element IDs with their length markers
+const (
+	idEBML          = 0x1A45DFA3
+	idSegment       = 0x18538067
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackNumber   = 0xD7
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idCodecDelay    = 0x56AA
+	idCluster       = 0x1F43B675
+	idTimecode      = 0xE7
+	idSimpleBlock   = 0xA3
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+)
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// id encodes an element ID without its leading zero bytes.
+func id(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// el encodes an element of the parts with an 8 byte size.
+func el(v uint32, parts ...[]byte) []byte {
+	data := cat(parts...)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	size[0] = 0x01
+	return cat(id(v), size, data)
+}
+
+// unknown starts an element that lasts until the end of the stream, like a live stream's segment and clusters.
+func unknown(v uint32) []byte {
+	return cat(id(v), []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// block encodes the data of a block of track 1 at the timecode.
+func block(timecode int16, flags byte, laced ...[]byte) []byte {
+	return cat([]byte{0x81, byte(uint16(timecode) >> 8), byte(timecode), flags}, cat(laced...))
+}
+
+// packet is a 20ms opus packet that ends with the bytes.
+func packet(s string) []byte {
+	return append([]byte{0xFC}, s...)
+}
+
+// header is the start of a stream with an opus track, whose codec is delayed by 6.5ms, lasting 200ms.
+func header(codec string) []byte {
+	return cat(
+		el(idEBML, el(0x4282, []byte("webm"))),
+		unknown(idSegment),
+		el(idInfo,
+			el(idTimecodeScale, u64(1000000)),
+			el(idDuration, u64(math.Float64bits(200))),
+		),
+		el(idTracks,
+			// a video track comes first
+			el(idTrackEntry, el(idTrackNumber, []byte{2}), el(idTrackType, []byte{1}), el(idCodecID, []byte("V_VP9"))),
+			el(idTrackEntry,
+				el(idTrackNumber, []byte{1}),
+				el(idTrackType, []byte{2}),
+				el(idCodecID, []byte(codec)),
+				el(idCodecDelay, u64(6500000)),
+			),
+		),
+	)
+}
+
+// stream is a live stream of clusters of unknown size with blocks of every kind of lacing.
+var stream = cat(
+	header("A_OPUS"),
+	unknown(idCluster),
+	el(idTimecode, []byte{0}),
+	el(idSimpleBlock, block(0, 0x80, packet("a"))),
+	// packets of the video track are skipped
+	el(idSimpleBlock, []byte{0x82, 0, 10, 0x80, 0xFF}),
+	// xiph lacing, two sizes of 2 bytes
+	el(idSimpleBlock, block(20, 0x82, []byte{2, 2, 2}, packet("b"), packet("c"), packet("d"))),
+	// ebml lacing, a size of 3 bytes and a difference of -1
+	el(idSimpleBlock, block(80, 0x86, []byte{2, 0x83, 0xBE}, packet("ee"), packet("f"), packet("g"))),
+	// fixed lacing
+	el(idSimpleBlock, block(140, 0x84, []byte{1}, packet("h"), packet("i"))),
+	el(idBlockGroup, el(idBlock, block(180, 0, packet("j")))),
+	unknown(idCluster),
+	el(idTimecode, []byte{0x03, 0xE8}),
+	// block timecodes are signed
+	el(idSimpleBlock, block(-10, 0x80, packet("k"))),
+)
+
+type frame struct {
+	data string
+	pts  time.Duration
+}
+
+// readAll reads the timestamped frames of a source until it ends or fails,
+// returning each to the frame pool once it is read like the player does.
+func readAll(src *webm.SourceCloser) ([]frame, error) {
+	var frames []frame
+	for {
+		data, pts, err := src.ReadTimestampedFrame()
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, frame{string(data), pts})
+		player.FreeFrame(data)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+	r := &closeRecorder{Reader: bytes.NewReader(stream)}
+	src, err := webm.NewSource(r)
+	require.NoError(t, err)
+	duration, ok := src.Duration()
+	assert.True(t, ok)
+	assert.Equal(t, 200*time.Millisecond, duration)
+	assert.Equal(t, 20*time.Millisecond, src.FrameDuration())
+	assert.True(t, src.PooledFrames(), "expected frames from the player's frame pool")
+
+	ms := func(f float64) time.Duration {
+		return time.Duration(f * float64(time.Millisecond))
+	}
+	frames, err := readAll(src)
+	assert.Equal(t, io.EOF, err)
+	// packets play after their block's timecode less the codec delay, one after another
+	assert.Equal(t, []frame{
+		{"\xFCa", 0},
+		{"\xFCb", ms(13.5)}, {"\xFCc", ms(33.5)}, {"\xFCd", ms(53.5)},
+		{"\xFCee", ms(73.5)}, {"\xFCf", ms(93.5)}, {"\xFCg", ms(113.5)},
+		{"\xFCh", ms(133.5)}, {"\xFCi", ms(153.5)},
+		{"\xFCj", ms(173.5)},
+		{"\xFCk", ms(983.5)},
+	}, frames)
+
+	require.NoError(t, src.Close())
+	assert.True(t, r.closed)
+}
+
+func TestSourceAllocs(t *testing.T) {
+	blocks := make([][]byte, 0, 1000)
+	for i := 0; i < cap(blocks); i++ {
+		blocks = append(blocks, el(idSimpleBlock, block(int16(i), 0x80, packet("frame"))))
+	}
+	src, err := webm.NewSource(bytes.NewReader(cat(header("A_OPUS"), unknown(idCluster), el(idTimecode, []byte{0}), cat(blocks...))))
+	require.NoError(t, err)
+
+	// blocks that are not laced are read into pooled buffers that become their frames
+	allocs := testing.AllocsPerRun(500, func() {
+		frame, err := src.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		player.FreeFrame(frame)
+	})
+	assert.True(t, allocs < 1, "expected frames to reuse the buffers of frames that were freed, %v allocations per frame", allocs)
+}
+
+func TestSourceMalformed(t *testing.T) {
+	t.Parallel()
+	withBlock := func(data []byte) []byte {
+		return cat(header("A_OPUS"), unknown(idCluster), el(idTimecode, []byte{0}), el(idSimpleBlock, data))
+	}
+	cases := []struct {
+		name   string
+		stream []byte
+		err    error
+	}{
+		{name: "empty", stream: nil, err: webm.ErrNoOpus},
+		{name: "header only", stream: header("A_OPUS"), err: webm.ErrNoOpus},
+		{name: "no opus track", stream: header("A_VORBIS"), err: webm.ErrNoOpus},
+		{name: "invalid id", stream: []byte{0x00, 0x81, 0x00}},
+		{name: "truncated id", stream: []byte{0x1A, 0x45}, err: io.ErrUnexpectedEOF},
+		{name: "truncated size", stream: cat(id(idEBML), []byte{0x01, 0x00}), err: io.ErrUnexpectedEOF},
+		{name: "element of unknown size", stream: cat(unknown(idSegment), unknown(idInfo))},
+		{name: "truncated element", stream: el(idEBML, el(0x4282, []byte("webm")))[:10], err: io.ErrUnexpectedEOF},
+		{name: "huge element", stream: cat(id(idEBML), []byte{0x01, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE}), err: io.ErrUnexpectedEOF},
+		{name: "child overruns its master", stream: cat(unknown(idSegment), el(idTracks, id(idTrackEntry), []byte{0x88}))},
+		{name: "child of unknown size", stream: cat(unknown(idSegment), el(idTracks, unknown(idTrackEntry)))},
+		{name: "empty block", stream: withBlock(nil)},
+		{name: "truncated block header", stream: withBlock([]byte{0x81, 0})},
+		{name: "empty packet", stream: withBlock(block(0, 0x80))},
+		{name: "empty laced block", stream: withBlock(block(0, 0x82))},
+		{name: "xiph sizes overrun the block", stream: withBlock(block(0, 0x82, []byte{1, 100}, packet("a"), packet("b")))},
+		{name: "truncated xiph size", stream: withBlock(block(0, 0x82, []byte{1, 0xFF}))},
+		{name: "uneven fixed lacing", stream: withBlock(block(0, 0x84, []byte{1}, packet("aa"), packet("b")))},
+		{name: "truncated ebml size", stream: withBlock(block(0, 0x86, []byte{1, 0x40}))},
+		{name: "negative ebml size", stream: withBlock(block(0, 0x86, []byte{2, 0x81, 0x80}, packet("a"), packet("b")))},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := webm.NewSource(bytes.NewReader(c.stream))
+			require.Error(t, err)
+			if c.err != nil {
+				assert.Equal(t, c.err, errors.Cause(err))
+			}
+		})
+	}
+}
+
+func TestSourceTruncated(t *testing.T) {
+	t.Parallel()
+	// a block cut off after the first
+	src, err := webm.NewSource(bytes.NewReader(stream[:len(stream)-2]))
+	require.NoError(t, err)
+	frames, err := readAll(src)
+	assert.Len(t, frames, 10)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// streams cut off anywhere end or fail to read instead of panicking,
+	// live streams cut off in between elements end like any other
+	for n := 0; n < len(stream); n++ {
+		src, err := webm.NewSource(bytes.NewReader(stream[:n]))
+		if err != nil {
+			continue
+		}
+		frames, _ := readAll(src)
+		assert.True(t, len(frames) <= 10, "expected no more frames than the stream cut off after %v bytes holds", n)
+	}
+}
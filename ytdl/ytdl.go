@@ -0,0 +1,177 @@
+// Package ytdl resolves video and playlist URLs with yt-dlp, or youtube-dl,
+// into the metadata and the best audio stream of each video, ready for Enqueue.
+package ytdl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/discordvoice"
+	"github.com/jeffreymkabot/discordvoice/httpsource"
+	"github.com/jeffreymkabot/discordvoice/webm"
+	"github.com/jonas747/dca"
+	"github.com/pkg/errors"
+)
+
+// default format selection, opus in webm can be played without an encoder
+const defaultFormat = "bestaudio[acodec=opus][ext=webm]/bestaudio/best"
+
+// Client runs yt-dlp.
+type Client struct {
+	// Binary is the yt-dlp executable, "yt-dlp" if empty. youtube-dl understands the same arguments.
+	Binary string
+	// Args are passed to every run before the URL, e.g. "--cookies" and a file, or "--proxy" and a URL.
+	Args []string
+	// Format selects the audio stream, by default opus in webm if there is one, otherwise the best audio.
+	Format string
+	// HTTPClient streams the audio, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Video is a video resolved by yt-dlp.
+type Video struct {
+	ID         string
+	Title      string
+	WebpageURL string
+	Thumbnail  string
+	// Duration is 0 if unknown, e.g. for live streams.
+	Duration time.Duration
+
+	// StreamURL is the URL of the selected audio stream, with the Headers it must be requested with.
+	// Videos of a playlist have no stream until they are resolved on their own.
+	StreamURL string
+	Headers   map[string]string
+	// Codec and Container are the audio codec and the container of the stream, e.g. "opus" and "webm".
+	Codec     string
+	Container string
+}
+
+// info is the subset of the json that yt-dlp prints with -J.
+type info struct {
+	Type       string            `json:"_type"`
+	ID         string            `json:"id"`
+	Title      string            `json:"title"`
+	WebpageURL string            `json:"webpage_url"`
+	URL        string            `json:"url"`
+	Thumbnail  string            `json:"thumbnail"`
+	Duration   float64           `json:"duration"`
+	ACodec     string            `json:"acodec"`
+	Ext        string            `json:"ext"`
+	Headers    map[string]string `json:"http_headers"`
+	Entries    []info            `json:"entries"`
+}
+
+func (i info) video() Video {
+	v := Video{
+		ID:         i.ID,
+		Title:      i.Title,
+		WebpageURL: i.WebpageURL,
+		Thumbnail:  i.Thumbnail,
+		Duration:   time.Duration(i.Duration * float64(time.Second)),
+	}
+	if v.WebpageURL == "" {
+		// the url of a playlist entry is the url of its page
+		v.WebpageURL = i.URL
+		return v
+	}
+	v.StreamURL, v.Headers, v.Codec, v.Container = i.URL, i.Headers, i.ACodec, i.Ext
+	return v
+}
+
+// Resolve lists the videos at the url, the video itself or the videos of a playlist.
+// Videos of a playlist are only listed, resolve their WebpageURL on its own to find its stream,
+// e.g. with Resolver once the video is about to play.
+func (c *Client) Resolve(ctx context.Context, url string) ([]Video, error) {
+	binary := c.Binary
+	if binary == "" {
+		binary = "yt-dlp"
+	}
+	format := c.Format
+	if format == "" {
+		format = defaultFormat
+	}
+	args := append([]string{"-J", "--no-warnings", "--flat-playlist", "-f", format}, c.Args...)
+	args = append(args, "--", url)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %v: %s", url, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var i info
+	if err := json.Unmarshal(stdout.Bytes(), &i); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v output", binary)
+	}
+	if i.Type != "playlist" {
+		return []Video{i.video()}, nil
+	}
+	videos := make([]Video, 0, len(i.Entries))
+	for _, entry := range i.Entries {
+		videos = append(videos, entry.video())
+	}
+	return videos, nil
+}
+
+// Resolver returns a player.ResolverFunc that resolves the stream of the video if it has none yet,
+// e.g. for videos of a playlist that are enqueued before their streams are known.
+// Stream URLs expire, so resolve videos shortly before they play rather than long before.
+func (c *Client) Resolver(v *Video) player.ResolverFunc {
+	return func() (player.Metadata, error) {
+		if v.StreamURL == "" {
+			videos, err := c.Resolve(context.Background(), v.WebpageURL)
+			if err != nil {
+				return player.Metadata{}, err
+			}
+			if len(videos) != 1 || videos[0].StreamURL == "" {
+				return player.Metadata{}, errors.Errorf("%v is not a single video", v.WebpageURL)
+			}
+			*v = videos[0]
+		}
+		return player.Metadata{Title: v.Title, Duration: v.Duration, URL: v.StreamURL}, nil
+	}
+}
+
+// Open streams the audio of the video, resuming the stream if the connection drops.
+func (c *Client) Open(v *Video) (io.ReadCloser, error) {
+	if v.StreamURL == "" {
+		return nil, errors.Errorf("%v has not been resolved", v.WebpageURL)
+	}
+	opts := []httpsource.Option{httpsource.Client(c.HTTPClient)}
+	for k, val := range v.Headers {
+		opts = append(opts, httpsource.Header(k, val))
+	}
+	return httpsource.Open(context.Background(), v.StreamURL, opts...)
+}
+
+// Opener returns a player.SourceOpenerFunc of opus frames of the video for a discord voice channel.
+// Opus in webm is demuxed as it is, other streams are encoded with opts by ffmpeg, see discordvoice.NewSource.
+func (c *Client) Opener(v *Video, opts *dca.EncodeOptions) player.SourceOpenerFunc {
+	return func() (player.Source, error) {
+		body, err := c.Open(v)
+		if err != nil {
+			return nil, err
+		}
+		if v.Codec == "opus" && v.Container == "webm" {
+			src, err := webm.NewSource(body)
+			if err != nil {
+				body.Close()
+				return nil, err
+			}
+			return src, nil
+		}
+		src, err := discordvoice.NewSource(body, opts)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		return src, nil
+	}
+}
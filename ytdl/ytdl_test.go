@@ -0,0 +1,172 @@
+package ytdl_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffreymkabot/discordvoice/ytdl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnv makes the test binary act as yt-dlp, see TestMain.
+const fakeEnv = "YTDL_FAKE"
+
+const videoJSON = `{
+	"_type": "video",
+	"id": "abc",
+	"title": "%v",
+	"webpage_url": "https://example.com/watch?v=abc",
+	"url": "https://cdn.example.com/abc.webm",
+	"thumbnail": "https://example.com/abc.jpg",
+	"duration": 212.5,
+	"acodec": "opus",
+	"ext": "webm",
+	"http_headers": {"User-Agent": "Mozilla/5.0"}
+}`
+
+const playlistJSON = `{
+	"_type": "playlist",
+	"id": "list",
+	"title": "playlist",
+	"entries": [
+		{"_type": "url", "id": "abc", "title": "first", "url": "https://example.com/watch?v=abc", "duration": 60},
+		{"_type": "url", "id": "def", "title": "second", "url": "https://example.com/watch?v=def"}
+	]
+}`
+
+// runFakeYTDL resolves the URL, the last argument, the way the tests expect.
+// The title of a video is the arguments it was resolved with.
+func runFakeYTDL(args []string) int {
+	switch url := args[len(args)-1]; {
+	case strings.HasSuffix(url, "playlist"):
+		fmt.Print(playlistJSON)
+	case strings.HasSuffix(url, "fail"):
+		fmt.Fprintln(os.Stderr, "ERROR: Unsupported URL: "+url)
+		return 1
+	case strings.HasSuffix(url, "garbage"):
+		fmt.Print("[youtube] abc: Downloading webpage")
+	case strings.HasSuffix(url, "slow"):
+		time.Sleep(time.Minute)
+	default:
+		fmt.Printf(videoJSON, strings.Join(args, " "))
+	}
+	return 0
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEnv) == "1" {
+		os.Exit(runFakeYTDL(os.Args[1:]))
+	}
+	// the tests run the test binary as yt-dlp
+	os.Setenv(fakeEnv, "1")
+	os.Exit(m.Run())
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+	c := &ytdl.Client{Binary: os.Args[0]}
+	videos, err := c.Resolve(context.Background(), "https://example.com/watch?v=abc")
+	require.NoError(t, err)
+	require.Len(t, videos, 1)
+	assert.Equal(t, ytdl.Video{
+		ID:         "abc",
+		Title:      "-J --no-warnings --flat-playlist -f bestaudio[acodec=opus][ext=webm]/bestaudio/best -- https://example.com/watch?v=abc",
+		WebpageURL: "https://example.com/watch?v=abc",
+		Thumbnail:  "https://example.com/abc.jpg",
+		Duration:   212500 * time.Millisecond,
+		StreamURL:  "https://cdn.example.com/abc.webm",
+		Headers:    map[string]string{"User-Agent": "Mozilla/5.0"},
+		Codec:      "opus",
+		Container:  "webm",
+	}, videos[0])
+
+	// the client's arguments go before the URL, which cannot be mistaken for an option
+	c = &ytdl.Client{Binary: os.Args[0], Args: []string{"--proxy", "socks5://localhost"}, Format: "bestaudio"}
+	videos, err = c.Resolve(context.Background(), "-abc")
+	require.NoError(t, err)
+	require.Len(t, videos, 1)
+	assert.Equal(t, "-J --no-warnings --flat-playlist -f bestaudio --proxy socks5://localhost -- -abc", videos[0].Title)
+}
+
+func TestResolvePlaylist(t *testing.T) {
+	t.Parallel()
+	c := &ytdl.Client{Binary: os.Args[0]}
+	videos, err := c.Resolve(context.Background(), "https://example.com/playlist")
+	require.NoError(t, err)
+	assert.Equal(t, []ytdl.Video{
+		{ID: "abc", Title: "first", WebpageURL: "https://example.com/watch?v=abc", Duration: time.Minute},
+		{ID: "def", Title: "second", WebpageURL: "https://example.com/watch?v=def"},
+	}, videos, "expected the videos of a playlist to be listed without their streams")
+}
+
+func TestResolveFails(t *testing.T) {
+	t.Parallel()
+	c := &ytdl.Client{Binary: os.Args[0]}
+	_, err := c.Resolve(context.Background(), "https://example.com/fail")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to resolve https://example.com/fail: ERROR: Unsupported URL: https://example.com/fail")
+	assert.Contains(t, err.Error(), "exit status 1")
+
+	_, err = c.Resolve(context.Background(), "https://example.com/garbage")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Resolve(ctx, "https://example.com/slow")
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 30*time.Second, "expected yt-dlp to be stopped with the context")
+
+	_, err = (&ytdl.Client{Binary: "not a program"}).Resolve(context.Background(), "https://example.com/watch?v=abc")
+	assert.Error(t, err)
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+	c := &ytdl.Client{Binary: os.Args[0]}
+	v := &ytdl.Video{ID: "abc", Title: "first", WebpageURL: "https://example.com/watch?v=abc"}
+	md, err := c.Resolver(v)()
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/abc.webm", v.StreamURL, "expected the video to be resolved on its own")
+	assert.Equal(t, v.StreamURL, md.URL)
+	assert.Equal(t, v.Title, md.Title)
+	assert.Equal(t, v.Duration, md.Duration)
+
+	// a resolved video is not resolved again
+	c.Binary = "not a program"
+	_, err = c.Resolver(v)()
+	assert.NoError(t, err)
+
+	c.Binary = os.Args[0]
+	_, err = c.Resolver(&ytdl.Video{WebpageURL: "https://example.com/playlist"})()
+	assert.Error(t, err, "expected a playlist not to resolve as a video")
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "Mozilla/5.0", req.Header.Get("User-Agent"))
+		w.Write([]byte("audio"))
+	}))
+	defer server.Close()
+
+	c := &ytdl.Client{HTTPClient: server.Client()}
+	body, err := c.Open(&ytdl.Video{StreamURL: server.URL, Headers: map[string]string{"User-Agent": "Mozilla/5.0"}})
+	require.NoError(t, err)
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "audio", string(b))
+
+	_, err = c.Open(&ytdl.Video{WebpageURL: "https://example.com/watch?v=abc"})
+	assert.Error(t, err, "expected a video without a stream not to open")
+}